@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ActualConfig configures transaction imports into Actual Budget through
+// actual-http-api (https://github.com/jhonderson/actual-http-api), since
+// Actual itself only offers a Node.js API.
+type ActualConfig struct {
+	URL          string `yaml:"url"`
+	APIKey       string `yaml:"api_key"`
+	BudgetSyncID string `yaml:"budget_sync_id"`
+	// BudgetPassword is only needed for end-to-end encrypted budgets.
+	BudgetPassword string `yaml:"budget_password"`
+	AccountID      string `yaml:"account_id"`
+	CategoryID     string `yaml:"category_id"`
+	Payee          string `yaml:"payee"`
+}
+
+// actualSink imports each invoice as an outflow. Actual has no attachments;
+// the notes name the PDF so it can be found in the archive (e.g. output.dir).
+type actualSink struct {
+	cfg    ActualConfig
+	client *http.Client
+}
+
+// newActualSink applies defaults and checks required settings.
+func newActualSink(cfg ActualConfig) (*actualSink, error) {
+	if cfg.BudgetSyncID == "" || cfg.AccountID == "" {
+		return nil, fmt.Errorf("actual: budget_sync_id and account_id are required")
+	}
+	cfg.URL = strings.TrimRight(cfg.URL, "/")
+	return &actualSink{cfg: cfg, client: newAPIClient()}, nil
+}
+
+func (s *actualSink) Name() string { return "actual" }
+
+// actualTransaction is a transaction in Actual's import format; amounts are
+// in cents, negative for outflows.
+type actualTransaction struct {
+	Account    string `json:"account"`
+	Date       string `json:"date"`
+	Amount     int64  `json:"amount"`
+	PayeeName  string `json:"payee_name"`
+	Category   string `json:"category,omitempty"`
+	Notes      string `json:"notes"`
+	ImportedID string `json:"imported_id,omitempty"`
+	Cleared    bool   `json:"cleared"`
+}
+
+// newActualTransaction builds the outflow for an invoice. The imported ID
+// lets Actual skip invoices that were already imported.
+func newActualTransaction(cfg ActualConfig, inv ProcessedInvoice) actualTransaction {
+	tx := actualTransaction{
+		Account:   cfg.AccountID,
+		Date:      inv.Email.Date.Format("2006-01-02"),
+		Amount:    -inv.Total.Cents,
+		PayeeName: counterparty(cfg.Payee, inv),
+		Category:  cfg.CategoryID,
+		Notes:     strings.Join(strings.Fields(inv.vendor().Title+" "+inv.OrderNumber+" "+inv.Filename+".pdf"), " "),
+		Cleared:   true,
+	}
+	if inv.OrderNumber != "" {
+		tx.ImportedID = "apple:" + inv.OrderNumber
+	}
+	return tx
+}
+
+func (s *actualSink) Deliver(ctx context.Context, d *Delivery) error {
+	var txs []actualTransaction
+	for _, inv := range d.Invoices {
+		if inv.Total.IsZero() {
+			slog.Info("Skipping invoice for Actual: no amount found", "file", inv.Filename+".pdf")
+			continue
+		}
+		txs = append(txs, newActualTransaction(s.cfg, inv))
+	}
+	if len(txs) == 0 {
+		return nil
+	}
+	header := http.Header{"X-Api-Key": {s.cfg.APIKey}}
+	if s.cfg.BudgetPassword != "" {
+		header.Set("Budget-Encryption-Password", s.cfg.BudgetPassword)
+	}
+	u := fmt.Sprintf("%s/v1/budgets/%s/accounts/%s/transactions/import",
+		s.cfg.URL, url.PathEscape(s.cfg.BudgetSyncID), url.PathEscape(s.cfg.AccountID))
+	var resp struct {
+		Data struct {
+			Added   []string `json:"added"`
+			Updated []string `json:"updated"`
+		} `json:"data"`
+	}
+	if err := doJSON(ctx, s.client, http.MethodPost, u, header, map[string]any{"transactions": txs}, &resp); err != nil {
+		return fmt.Errorf("actual: %w", err)
+	}
+	slog.Info("Imported transactions into Actual", "count", len(txs), "added", len(resp.Data.Added))
+	return nil
+}
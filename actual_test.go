@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestActualSink_Deliver(t *testing.T) {
+	var path, password string
+	var payload struct {
+		Transactions []actualTransaction `json:"transactions"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		path, password = r.URL.Path, r.Header.Get("Budget-Encryption-Password")
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.Write([]byte(`{"data":{"added":["t1"],"updated":[]}}`))
+	}))
+	defer srv.Close()
+
+	sink, err := newActualSink(ActualConfig{URL: srv.URL, APIKey: "key", BudgetSyncID: "budget", BudgetPassword: "pw", AccountID: "acc"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	inv := testProcessedInvoice()
+	inv.Filename = "05_2024_Rechnung_Apple_MXYZ123"
+	if err := sink.Deliver(context.Background(), &Delivery{Invoices: []ProcessedInvoice{inv}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/v1/budgets/budget/accounts/acc/transactions/import" || password != "pw" {
+		t.Errorf("path/password = %q/%q", path, password)
+	}
+	want := actualTransaction{
+		Account: "acc", Date: "2024-05-14", Amount: -999, PayeeName: "Apple",
+		Notes: "Apple MXYZ123 05_2024_Rechnung_Apple_MXYZ123.pdf", ImportedID: "apple:MXYZ123", Cleared: true,
+	}
+	if len(payload.Transactions) != 1 || payload.Transactions[0] != want {
+		t.Errorf("transactions = %+v, want %+v", payload.Transactions, want)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// AuditConfig configures the append-only audit log of deliveries.
+type AuditConfig struct {
+	File string `yaml:"file"`
+}
+
+// AuditEntry is a line of the audit log: the outcome of delivering one
+// invoice PDF to one destination.
+type AuditEntry struct {
+	Time        time.Time `json:"time"`
+	MessageID   string    `json:"message_id,omitempty"`
+	OrderNumber string    `json:"order_number,omitempty"`
+	Filename    string    `json:"filename"`
+	SHA256      string    `json:"sha256"`
+	Destination string    `json:"destination"`
+	Result      string    `json:"result"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// auditDelivery appends the results of a delivery to sinks to audit.file,
+// if set. Errors are logged: the files were delivered (or not) regardless,
+// and failing the run would deliver them again.
+func auditDelivery(cfg *Config, d *Delivery, sinks []Sink, results []SinkResult) {
+	if cfg.Audit.File == "" {
+		return
+	}
+	status := deliveryStatus(d.Invoices, sinks, results, false)
+	if err := appendAudit(cfg.Audit.File, d, results, status, time.Now()); err != nil {
+		slog.Error("Writing audit log failed", "path", cfg.Audit.File, "err", err)
+	}
+}
+
+// appendAudit writes one JSON line per invoice and sink to the file at
+// path; status returns the result of d.Invoices[i] by sink name, see
+// deliveryStatus, so invoices a failed sink got before failing count as
+// delivered. The file is only ever appended to and synced before returning.
+func appendAudit(path string, d *Delivery, results []SinkResult, status func(i int) map[string]string, now time.Time) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i, inv := range d.Invoices {
+		statuses := status(i)
+		data, err := inv.readPDF()
+		if err != nil {
+			return fmt.Errorf("reading %s.pdf: %w", inv.Filename, err)
+		}
+		sum := sha256.Sum256(data)
+		for _, res := range results {
+			entry := AuditEntry{
+				Time:        now,
+				MessageID:   inv.Email.MessageID,
+				OrderNumber: inv.OrderNumber,
+				Filename:    inv.Filename + ".pdf",
+				SHA256:      hex.EncodeToString(sum[:]),
+				Destination: res.Sink,
+				Result:      stateDelivered,
+			}
+			if statuses[res.Sink] != stateDelivered {
+				entry.Result, entry.Error = stateFailed, redactError(res.Err)
+			}
+			if err := enc.Encode(entry); err != nil {
+				return fmt.Errorf("encoding audit entry: %w", err)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating audit log directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("opening audit log: %w", err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("writing audit log: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("writing audit log: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAppendAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "deliveries.jsonl")
+	inv := testProcessedInvoice()
+	d := &Delivery{Invoices: []ProcessedInvoice{inv}}
+	now := time.Date(2024, 4, 1, 7, 0, 0, 0, time.UTC)
+
+	email, s3 := &fakeSink{name: "email"}, &fakeSink{name: "s3"}
+	results := []SinkResult{{Sink: "email"}, {Sink: "s3", Err: errors.New("503 Slow Down")}}
+	if err := appendAudit(path, d, results, deliveryStatus(d.Invoices, []Sink{email, s3}, results, false), now); err != nil {
+		t.Fatal(err)
+	}
+	results = []SinkResult{{Sink: "s3"}}
+	if err := appendAudit(path, d, results, deliveryStatus(d.Invoices, []Sink{s3}, results, false), now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readAudit(t, path)
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3: %+v", len(entries), entries)
+	}
+
+	sum := sha256.Sum256(inv.PDF)
+	want := AuditEntry{
+		Time:        now,
+		MessageID:   inv.Email.MessageID,
+		OrderNumber: inv.OrderNumber,
+		Filename:    inv.Filename + ".pdf",
+		SHA256:      hex.EncodeToString(sum[:]),
+		Destination: "email",
+		Result:      "delivered",
+	}
+	if entries[0] != want {
+		t.Errorf("entry = %+v, want %+v", entries[0], want)
+	}
+	if e := entries[1]; e.Destination != "s3" || e.Result != "failed" || e.Error != "503 Slow Down" {
+		t.Errorf("failed entry = %+v", e)
+	}
+	if e := entries[2]; e.Destination != "s3" || e.Result != "delivered" || !e.Time.Equal(now.Add(time.Hour)) {
+		t.Errorf("retried entry = %+v", e)
+	}
+}
+
+// readAudit returns the entries of the audit log at path.
+func readAudit(t *testing.T, path string) []AuditEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestAuditDelivery_Progress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deliveries.jsonl")
+	a, b := testProcessedInvoice(), testProcessedInvoice()
+	a.Email.MessageID, b.Email.MessageID = "<a@apple.com>", "<b@apple.com>"
+	d := &Delivery{Invoices: []ProcessedInvoice{a, b}}
+
+	// The sink got the first invoice, then failed
+	sink := &paperlessSink{}
+	sink.markDone(invoiceKey(a))
+	results := []SinkResult{{Sink: sink.Name(), Err: errors.New("unavailable")}}
+	auditDelivery(&Config{Audit: AuditConfig{File: path}}, d, []Sink{sink}, results)
+
+	entries := readAudit(t, path)
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2: %+v", len(entries), entries)
+	}
+	if e := entries[0]; e.MessageID != "<a@apple.com>" || e.Result != stateDelivered || e.Error != "" {
+		t.Errorf("delivered invoice = %+v", e)
+	}
+	if e := entries[1]; e.MessageID != "<b@apple.com>" || e.Result != stateFailed || e.Error != "unavailable" {
+		t.Errorf("missed invoice = %+v", e)
+	}
+}
+
+func TestAuditDelivery_Disabled(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	auditDelivery(&Config{}, &Delivery{Invoices: []ProcessedInvoice{testProcessedInvoice()}}, []Sink{&fakeSink{name: "email"}}, []SinkResult{{Sink: "email"}})
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("wrote %v without audit.file", files)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// runBackfill processes every matching invoice received since the month
+// from, delivering one batch per month in chronological order. A failed
+// month is logged and the remaining months are still processed.
+func runBackfill(ctx context.Context, cfg *Config, from time.Time) (err error) {
+	ctx, span := startSpan(ctx, "backfill", "from", from.Format("2006-01"))
+	defer func() { span.finish(err) }()
+	ctx, done := startRunReport(ctx, cfg, "backfill", false)
+	defer func() { done(err) }()
+	ctx, timings := withStageTimings(ctx)
+	defer timings.log(time.Now())
+
+	// Backfilling scans the mailbox for all months at once
+	if source := cfg.Pipeline.Source; source != "" && source != "imap" {
+		return withExitCode(exitConfig, fmt.Errorf("--backfill requires the imap source, got %q", source))
+	}
+	store, err := loadState(cfg.State.File)
+	if err != nil {
+		return err
+	}
+	c, err := dialIMAP(ctx, cfg)
+	if err != nil {
+		return withExitCode(exitIMAP, fmt.Errorf("fetching invoices: %w", err))
+	}
+	months, err := scanMailbox(ctx, c, cfg, from)
+	c.Logout()
+	if err != nil {
+		return withExitCode(exitIMAP, fmt.Errorf("fetching invoices: %w", err))
+	}
+	if len(months) == 0 {
+		return errNoInvoices
+	}
+
+	keys := make([]time.Time, 0, len(months))
+	for month := range months {
+		keys = append(keys, month)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Before(keys[j]) })
+
+	var errs []error
+	for _, month := range keys {
+		slog.Info("Backfilling month", "month", month.Format("2006-01"), "invoices", len(months[month]))
+		if err := backfillMonth(ctx, cfg, store, months[month]); err != nil {
+			slog.Error("Backfilling month failed", "month", month.Format("2006-01"), "err", err)
+			errs = append(errs, fmt.Errorf("%s: %w", month.Format("2006-01"), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// scanMailbox walks the whole INBOX in batches of envelopes and returns the
+// UIDs of matching invoices received since from, keyed by the first day of
+// their month. filter.count is ignored.
+func scanMailbox(ctx context.Context, c *client.Client, cfg *Config, from time.Time) (months map[time.Time][]uint32, err error) {
+	_, span := startSpan(ctx, "scan")
+	defer func() { span.finish(err) }()
+	defer timingsFrom(ctx).since("imap_fetch", time.Now())
+
+	slog.Debug("IMAP command", "command", "EXAMINE", "mailbox", "INBOX")
+	mbox, err := c.Select("INBOX", true)
+	if err != nil {
+		return nil, fmt.Errorf("selecting INBOX: %w", err)
+	}
+	slog.Info("Scanning INBOX", "messages", mbox.Messages)
+	span.set("messages", mbox.Messages)
+
+	months = make(map[time.Time][]uint32)
+	matches := 0
+	if mbox.Messages > 0 {
+		err = fetchEnvelopes(ctx, c, cfg, 1, mbox.Messages, func(msg *imap.Message) {
+			date := messageDate(msg, cfg)
+			if date.Before(from) || !matchesInvoice(msg.Envelope, cfg) {
+				return
+			}
+			month := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.Local)
+			months[month] = append(months[month], msg.Uid)
+			matches++
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	runReportFrom(ctx).scanned(int(mbox.Messages), matches)
+	return months, nil
+}
+
+// backfillMonth fetches, converts and delivers the invoices of one month
+// on a fresh IMAP connection, since converting may take a while.
+func backfillMonth(ctx context.Context, cfg *Config, store *stateStore, uids []uint32) error {
+	pipeline, err := buildPipeline(cfg)
+	if err != nil {
+		return err
+	}
+	ctx, cleanup, err := withSpool(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	invoices, err := fetchUIDs(ctx, cfg, uids)
+	if err != nil {
+		return withExitCode(exitIMAP, fmt.Errorf("fetching invoices: %w", err))
+	}
+	return convertAndDeliver(ctx, cfg, pipeline, invoices, store)
+}
+
+// fetchUIDs fetches the invoices with the given UIDs from INBOX.
+func fetchUIDs(ctx context.Context, cfg *Config, uids []uint32) (invoices []InvoiceEmail, err error) {
+	ctx, span := startSpan(ctx, "fetch", "invoices", len(uids))
+	defer func() { span.finish(err) }()
+	defer timingsFrom(ctx).since("imap_fetch", time.Now())
+
+	c, err := dialIMAP(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Logout()
+	slog.Debug("IMAP command", "command", "EXAMINE", "mailbox", "INBOX")
+	if _, err := c.Select("INBOX", true); err != nil {
+		return nil, fmt.Errorf("selecting INBOX: %w", err)
+	}
+	return fetchBodies(ctx, c, cfg, uids)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+)
+
+func TestScanMailbox(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server.New(memory.New())
+	srv.AllowInsecureAuth = true
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	c, err := client.Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Logout()
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := []struct {
+		date    string
+		subject string
+		from    string
+	}{
+		{"Mon, 14 Dec 2020 10:00:00 +0000", "Deine Rechnung von Apple", "no_reply@email.apple.com"},
+		{"Thu, 14 Jan 2021 10:00:00 +0000", "Deine Rechnung von Apple", "no_reply@email.apple.com"},
+		{"Fri, 15 Jan 2021 10:00:00 +0000", "Newsletter", "no_reply@email.apple.com"},
+		{"Sun, 14 Mar 2021 10:00:00 +0000", "Deine Rechnung von Apple", "no_reply@email.apple.com"},
+		{"Mon, 15 Mar 2021 10:00:00 +0000", "Deine Rechnung von Apple", "phish@example.com"},
+		{"Tue, 16 Mar 2021 10:00:00 +0000", "Deine Rechnung von Apple", "no_reply@email.apple.com"},
+	}
+	for _, m := range messages {
+		raw := fmt.Sprintf("From: %s\r\nTo: jane@example.com\r\nSubject: %s\r\nDate: %s\r\n\r\nHi\r\n", m.from, m.subject, m.date)
+		// Delivered when sent, as INTERNALDATE
+		received, _ := time.Parse(time.RFC1123Z, m.date)
+		if err := c.Append("INBOX", nil, received, bytes.NewBufferString(raw)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &Config{}
+	cfg.Filter.Subject = "Deine Rechnung von Apple"
+	cfg.Filter.From = "apple.com"
+	cfg.Filter.Count = 1 // ignored when backfilling
+	from := time.Date(2021, 1, 1, 0, 0, 0, 0, time.Local)
+
+	// A batch size of 2 makes the scan span several fetches
+	cfg.IMAP.Batch = 2
+	months, err := scanMailbox(context.Background(), c, cfg, from)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	jan := time.Date(2021, 1, 1, 0, 0, 0, 0, time.Local)
+	mar := time.Date(2021, 3, 1, 0, 0, 0, 0, time.Local)
+	if len(months) != 2 || len(months[jan]) != 1 || len(months[mar]) != 2 {
+		t.Fatalf("months = %v, want 1 invoice in 2021-01 and 2 in 2021-03", months)
+	}
+}
+
+func TestListMatches(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server.New(memory.New())
+	srv.AllowInsecureAuth = true
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	c, err := client.Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Logout()
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []struct{ date, subject, from string }{
+		{"Sun, 14 Mar 2021 10:00:00 +0000", "Deine Rechnung von Apple", "no_reply@email.apple.com"},
+		{"Mon, 15 Mar 2021 10:00:00 +0000", "Deine Rechnung von Apple", "phish@example.com"},
+		{"Tue, 16 Mar 2021 10:00:00 +0000", "Newsletter", "no_reply@email.apple.com"},
+		{"Wed, 14 Apr 2021 10:00:00 +0000", "Deine Rechnung von Apple", "no_reply@email.apple.com"},
+	} {
+		raw := fmt.Sprintf("From: %s\r\nTo: jane@example.com\r\nSubject: %s\r\nDate: %s\r\n\r\nHi\r\n", m.from, m.subject, m.date)
+		// Delivered when sent, as INTERNALDATE
+		received, _ := time.Parse(time.RFC1123Z, m.date)
+		if err := c.Append("INBOX", nil, received, bytes.NewBufferString(raw)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &Config{}
+	cfg.Filter.Subject = "Deine Rechnung von Apple"
+	cfg.Filter.From = "apple.com"
+	cfg.IMAP.Batch = 2
+	matches, err := listMatches(context.Background(), c, cfg, time.Date(2021, 3, 1, 0, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].Uid == 0 || matches[0].Envelope.From[0].Address() != "no_reply@email.apple.com" {
+		t.Fatalf("matches = %v, want the Apple invoice of 2021-03", matches)
+	}
+
+	// Like a run, only the last filter.count messages are scanned
+	cfg.Filter.Count = 3
+	if matches, _ := listMatches(context.Background(), c, cfg, time.Date(2021, 3, 1, 0, 0, 0, 0, time.Local)); len(matches) != 0 {
+		t.Errorf("with filter.count: matches = %v, want none", matches)
+	}
+}
+
+func TestFetchMatchingUIDs_Error(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server.New(memory.New())
+	srv.AllowInsecureAuth = true
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	c, err := client.Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Select("INBOX", true); err != nil {
+		t.Fatal(err)
+	}
+	c.Logout()
+
+	// A failed scan must not look like a month without invoices
+	uids, err := fetchMatchingUIDs(context.Background(), c, &Config{}, time.Now(), 1, 1)
+	if err == nil || uids != nil {
+		t.Errorf("fetchMatchingUIDs = %v, %v, want error", uids, err)
+	}
+}
+
+func TestFetchBodies_Forwards(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server.New(memory.New())
+	srv.AllowInsecureAuth = true
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	c, err := client.Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Logout()
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []struct{ subject, from string }{
+		{"Deine Rechnung von Apple", "no_reply@email.apple.com"},
+		{"Fwd: Deine Rechnung von Apple", "phish@example.com"},
+	} {
+		raw := fmt.Sprintf("From: %s\r\nTo: jane@example.com\r\nSubject: %s\r\nDate: Sun, 14 Mar 2021 10:00:00 +0000\r\n"+
+			"Content-Type: text/html\r\n\r\n<p>Bestellnummer: MX1</p>\r\n", m.from, m.subject)
+		if err := c.Append("INBOX", nil, time.Now(), bytes.NewBufferString(raw)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mbox, err := c.Select("INBOX", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The UIDs a backfill selected by subject, the last two messages
+	uids := []uint32{mbox.UidNext - 2, mbox.UidNext - 1}
+
+	invoices, err := fetchBodies(context.Background(), c, defaultCfg(), uids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invoices) != 1 || invoices[0].From != "no_reply@email.apple.com" {
+		t.Errorf("invoices = %+v, want only Apple's, not the forward", invoices)
+	}
+}
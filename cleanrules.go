@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/htmlclean"
+)
+
+// CleanConfig adds HTML cleanup rules to those of the vendor profiles, so
+// a changed template can be handled without a new release.
+type CleanConfig struct {
+	// Rules apply to every invoice after the vendor's.
+	Rules []CleanRule `yaml:"rules"`
+	// Replace drops the vendor's remove selectors and rules, leaving only
+	// Rules.
+	Replace bool `yaml:"replace"`
+	// Links is htmlclean.LinksKeep (if empty), LinksStrip or
+	// LinksFootnotes.
+	Links string `yaml:"links"`
+	rules []htmlclean.Rule
+}
+
+// CleanRule is the config form of an htmlclean.Rule: exactly one of
+// Remove, Unwrap, Style, Attr, Replace and Wrap holds the selector.
+type CleanRule struct {
+	Remove   string `yaml:"remove"`
+	Unwrap   string `yaml:"unwrap"`
+	Style    string `yaml:"style"`
+	CSS      string `yaml:"css"`
+	Attr     string `yaml:"attr"`
+	Name     string `yaml:"name"`
+	Value    string `yaml:"value"`
+	Replace  string `yaml:"replace"`
+	Pattern  string `yaml:"pattern"`
+	With     string `yaml:"with"`
+	Wrap     string `yaml:"wrap"`
+	HTML     string `yaml:"html"`
+	Contains string `yaml:"contains"`
+}
+
+// cleanRules converts and validates rules.
+func cleanRules(rules []CleanRule) ([]htmlclean.Rule, error) {
+	var out []htmlclean.Rule
+	for i, r := range rules {
+		rule := htmlclean.Rule{Contains: r.Contains, CSS: r.CSS, Attr: r.Name, Value: r.Value, Pattern: r.Pattern, HTML: r.HTML}
+		n := 0
+		for action, selector := range map[string]string{
+			htmlclean.ActionRemove:  r.Remove,
+			htmlclean.ActionUnwrap:  r.Unwrap,
+			htmlclean.ActionStyle:   r.Style,
+			htmlclean.ActionAttr:    r.Attr,
+			htmlclean.ActionReplace: r.Replace,
+			htmlclean.ActionWrap:    r.Wrap,
+		} {
+			if selector != "" {
+				rule.Action, rule.Selector = action, selector
+				n++
+			}
+		}
+		if n != 1 {
+			return nil, fmt.Errorf("rule %d: set exactly one of remove, unwrap, style, attr, replace and wrap", i+1)
+		}
+		if rule.Action == htmlclean.ActionReplace {
+			rule.Value = r.With
+		}
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		out = append(out, rule)
+	}
+	return out, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/htmlclean"
+)
+
+func TestCleanRules(t *testing.T) {
+	rules, err := cleanRules([]CleanRule{
+		{Remove: ".promo"},
+		{Style: "td.total", CSS: "font-size:14pt"},
+		{Replace: "td.price", Pattern: "EUR", With: "€"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []htmlclean.Rule{
+		{Action: htmlclean.ActionRemove, Selector: ".promo"},
+		{Action: htmlclean.ActionStyle, Selector: "td.total", CSS: "font-size:14pt"},
+		{Action: htmlclean.ActionReplace, Selector: "td.price", Pattern: "EUR", Value: "€"},
+	}
+	if !slices.Equal(rules, want) {
+		t.Errorf("rules = %+v", rules)
+	}
+	for _, bad := range []CleanRule{{}, {Remove: "a", Unwrap: "b"}, {Unwrap: "a["}} {
+		if _, err := cleanRules([]CleanRule{bad}); err == nil {
+			t.Errorf("%+v: want error", bad)
+		}
+	}
+}
+
+func TestCleanTransformer_Rules(t *testing.T) {
+	html := `<div class="action-button-cell">Apple</div><div class="promo">Jetzt testen</div>`
+	for _, tc := range []struct {
+		replace     bool
+		wantButton  bool
+		description string
+	}{
+		{false, false, "added to Apple's"},
+		{true, true, "replacing Apple's"},
+	} {
+		tr := cleanTransformer{clean: CleanConfig{Replace: tc.replace, rules: []htmlclean.Rule{{Action: htmlclean.ActionRemove, Selector: ".promo"}}}}
+		p := &ProcessedInvoice{HTML: html, Vendor: appleVendor}
+		if err := tr.Transform(context.Background(), p); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(p.HTML, "Jetzt testen") || strings.Contains(p.HTML, "action-button-cell") != tc.wantButton {
+			t.Errorf("%s: HTML = %s", tc.description, p.HTML)
+		}
+	}
+}
@@ -0,0 +1,782 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/deliver"
+	"github.com/rummeyer/apple-invoice-pdf/internal/imapsource"
+	"github.com/rummeyer/apple-invoice-pdf/internal/pdf"
+	"github.com/rummeyer/apple-invoice-pdf/pkg/invoice"
+)
+
+// cliOptions holds the command line flags of all commands and where
+// commands print their results.
+type cliOptions struct {
+	// Shared by all commands
+	config    string
+	logFormat string
+	verbose   bool
+	quiet     bool
+
+	// Command specific, see the flags of each command
+	month    string
+	dryRun   bool
+	daemon   bool
+	backfill bool
+	from     string
+	output   string
+	keepHTML bool
+	status   string
+	json     bool
+
+	out io.Writer
+}
+
+func newCLIOptions() *cliOptions {
+	return &cliOptions{config: "config.yaml", logFormat: "text", output: ".", out: os.Stdout}
+}
+
+// shared registers the flags accepted by every command, before or after
+// its name. The current values are the defaults, so registering them again
+// for the flags after the name keeps those given before it.
+func (o *cliOptions) shared(fs *flag.FlagSet) {
+	fs.StringVar(&o.config, "config", o.config, "read the configuration from `file`")
+	fs.StringVar(&o.logFormat, "log-format", o.logFormat, "log as key=value `text` or as json")
+	fs.BoolVar(&o.verbose, "v", o.verbose, "also log debug details: IMAP commands, cleanHTML selector matches, Chrome timings")
+	fs.BoolVar(&o.quiet, "q", o.quiet, "only log warnings and errors")
+}
+
+func (o *cliOptions) monthFlag(fs *flag.FlagSet, usage string) {
+	fs.StringVar(&o.month, "month", o.month, usage)
+}
+
+func (o *cliOptions) dryRunFlag(fs *flag.FlagSet, usage string) {
+	fs.BoolVar(&o.dryRun, "dry-run", o.dryRun, usage)
+}
+
+func (o *cliOptions) outputFlag(fs *flag.FlagSet, usage string) {
+	fs.StringVar(&o.output, "o", o.output, usage)
+}
+
+// parsedMonth returns --month, or the zero time if not given.
+func (o *cliOptions) parsedMonth() (time.Time, error) {
+	if o.month == "" {
+		return time.Time{}, nil
+	}
+	month, err := parseMonth(o.month)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --month: %w", err)
+	}
+	return month, nil
+}
+
+// command is a subcommand of the CLI.
+type command struct {
+	name string
+	// args describes the positional arguments in the usage text.
+	args    string
+	summary string
+	// flags registers the command's own flags like cliOptions.shared.
+	flags func(o *cliOptions, fs *flag.FlagSet)
+	// validate checks flags and arguments before the config is loaded;
+	// errors are usage errors.
+	validate func(o *cliOptions, args []string) error
+	run      func(ctx context.Context, cfg *Config, o *cliOptions, args []string) error
+	// locks holds lock.file while running, unless --dry-run is given, for
+	// commands that deliver.
+	locks bool
+}
+
+// mode names the run in run reports and error reports.
+func (c *command) mode(o *cliOptions) string {
+	switch {
+	case c.name == "run" && o.daemon:
+		return "daemon"
+	case c.name == "run" && o.backfill:
+		return "backfill"
+	}
+	return c.name
+}
+
+// commands lists the subcommands; the first is run without a command name.
+var commands = []*command{
+	{
+		name:    "run",
+		summary: "fetch, convert and deliver the invoices of a month (the default)",
+		flags: func(o *cliOptions, fs *flag.FlagSet) {
+			o.monthFlag(fs, "process invoices of this month (YYYY-MM or previous) instead of the current one")
+			o.dryRunFlag(fs, "generate the PDFs into a temporary directory and print what would be sent, without delivering")
+			fs.BoolVar(&o.daemon, "daemon", o.daemon, "stay running and process invoices on daemon.schedule")
+			fs.BoolVar(&o.backfill, "backfill", o.backfill, "process all invoices since --from, one delivery per month")
+			fs.StringVar(&o.from, "from", o.from, "first month (YYYY-MM) to process with --backfill")
+		},
+		validate: validateRun,
+		run:      runCommand,
+		locks:    true,
+	},
+	{
+		name:    "fetch",
+		summary: "save the matching invoice emails of a month as .eml files",
+		flags: func(o *cliOptions, fs *flag.FlagSet) {
+			o.monthFlag(fs, "fetch invoices of this month (YYYY-MM or previous) instead of the current one")
+			o.outputFlag(fs, "write the files to `dir`")
+		},
+		validate: noArgs,
+		run:      runFetch,
+	},
+	{
+		name:    "list",
+		summary: "list the emails a run would process, without processing them",
+		flags: func(o *cliOptions, fs *flag.FlagSet) {
+			o.monthFlag(fs, "list invoices of this month (YYYY-MM or previous) instead of the current one")
+		},
+		validate: noArgs,
+		run:      runList,
+	},
+	{
+		name:    "convert",
+		args:    "file.eml|file.html|URL...",
+		summary: "convert saved invoice emails, HTML files or pages to PDF with JSON sidecars",
+		flags: func(o *cliOptions, fs *flag.FlagSet) {
+			o.outputFlag(fs, "write the files to `dir`, or the PDF of a single input to a file ending in .pdf")
+			fs.BoolVar(&o.keepHTML, "html", o.keepHTML, "also write the HTML as rendered, after cleaning")
+		},
+		validate: someArgs,
+		run:      runConvert,
+	},
+	{
+		name:    "parse",
+		args:    "file.eml|file.html...",
+		summary: "print the order number, total, date and line items found in invoices",
+		flags: func(o *cliOptions, fs *flag.FlagSet) {
+			fs.BoolVar(&o.json, "json", o.json, "print JSON instead of text")
+		},
+		validate: someArgs,
+		run:      runParse,
+	},
+	{
+		name:    "send",
+		args:    "file.pdf...",
+		summary: "deliver PDFs (with their JSON sidecars, if any) to all destinations",
+		flags: func(o *cliOptions, fs *flag.FlagSet) {
+			o.dryRunFlag(fs, "copy the files to a temporary directory and print what would be sent, without delivering")
+		},
+		validate: someArgs,
+		run:      runSend,
+		locks:    true,
+	},
+	{
+		name:    "query",
+		summary: "list the invoices recorded in state.file",
+		flags: func(o *cliOptions, fs *flag.FlagSet) {
+			o.monthFlag(fs, "only invoices of this month (YYYY-MM)")
+			fs.StringVar(&o.status, "status", o.status, "only invoices with this `status` (delivered, queued, failed)")
+			fs.BoolVar(&o.json, "json", o.json, "print JSON instead of a table")
+		},
+		validate: noArgs,
+		run:      runQuery,
+	},
+	{
+		name:     "check",
+		summary:  "validate the config and test the mailbox login and Chrome",
+		validate: noArgs,
+		run:      runCheck,
+	},
+	{
+		name:     "resume",
+		summary:  "retry the deliveries saved in outbox.dir",
+		validate: noArgs,
+		run: func(ctx context.Context, cfg *Config, _ *cliOptions, _ []string) error {
+			return runResume(ctx, cfg)
+		},
+		locks: true,
+	},
+	{
+		name:    "install-service",
+		summary: "install a systemd timer or launchd agent running on daemon.schedule",
+		flags: func(o *cliOptions, fs *flag.FlagSet) {
+			o.dryRunFlag(fs, "only print the files")
+		},
+		validate: noArgs,
+		run: func(_ context.Context, cfg *Config, o *cliOptions, _ []string) error {
+			return installService(cfg, o.dryRun, o.out)
+		},
+	},
+}
+
+// findCommand returns the command called name, or nil.
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// printUsage writes the synopsis, the commands and the shared flags.
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: apple-invoice-pdf [flags] [command] [command flags] [args]\n\nCommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.name, c.args, c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nFlags for all commands:\n")
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(w)
+	newCLIOptions().shared(fs)
+	fs.PrintDefaults()
+	fmt.Fprintf(w, "\nRun apple-invoice-pdf <command> -h for the flags of a command.\n")
+}
+
+// parseCommandLine splits args into the command and its positional
+// arguments and sets o from the flags. Flags of the default command may
+// precede it, as before there were subcommands; flags given before a
+// command that does not accept them are an error.
+func parseCommandLine(o *cliOptions, args []string) (*command, []string, error) {
+	global := flag.NewFlagSet("apple-invoice-pdf", flag.ExitOnError)
+	global.Usage = func() { printUsage(global.Output()) }
+	o.shared(global)
+	commands[0].flags(o, global)
+	global.Parse(args)
+
+	cmd, rest := commands[0], global.Args()
+	if len(rest) > 0 {
+		if cmd = findCommand(rest[0]); cmd == nil {
+			return nil, nil, fmt.Errorf("unknown command %q", rest[0])
+		}
+		fs := flag.NewFlagSet("apple-invoice-pdf "+cmd.name, flag.ExitOnError)
+		o.shared(fs)
+		if cmd.flags != nil {
+			cmd.flags(o, fs)
+		}
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "Usage: apple-invoice-pdf %s [flags] %s\n\n%s\n\nFlags:\n", cmd.name, cmd.args, cmd.summary)
+			fs.PrintDefaults()
+		}
+		fs.Parse(rest[1:])
+		rest = fs.Args()
+
+		var err error
+		global.Visit(func(f *flag.Flag) {
+			if fs.Lookup(f.Name) == nil && err == nil {
+				err = fmt.Errorf("-%s cannot be used with %s", f.Name, cmd.name)
+			}
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if cmd.validate != nil {
+		if err := cmd.validate(o, rest); err != nil {
+			return nil, nil, err
+		}
+	}
+	return cmd, rest, nil
+}
+
+func noArgs(_ *cliOptions, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	return nil
+}
+
+func someArgs(_ *cliOptions, args []string) error {
+	if len(args) == 0 {
+		return errors.New("no files given")
+	}
+	return nil
+}
+
+// validateRun checks the combinations of run's mode flags.
+func validateRun(o *cliOptions, args []string) error {
+	if err := noArgs(o, args); err != nil {
+		return err
+	}
+	if _, err := o.parsedMonth(); err != nil {
+		return err
+	}
+	switch {
+	case o.dryRun && (o.daemon || o.backfill):
+		return errors.New("--dry-run cannot be combined with --daemon or --backfill")
+	case o.month != "" && o.daemon:
+		return errors.New("--month cannot be combined with --daemon")
+	case o.backfill && (o.daemon || o.month != ""):
+		return errors.New("--backfill cannot be combined with --daemon or --month")
+	case o.backfill && o.from == "":
+		return errors.New("--backfill requires --from")
+	case !o.backfill && o.from != "":
+		return errors.New("--from requires --backfill")
+	}
+	if o.from != "" {
+		if _, err := parseMonth(o.from); err != nil {
+			return fmt.Errorf("invalid --from: %w", err)
+		}
+	}
+	return nil
+}
+
+// runCommand runs once, as daemon or as backfill.
+func runCommand(ctx context.Context, cfg *Config, o *cliOptions, _ []string) error {
+	switch {
+	case o.daemon:
+		return runDaemon(ctx, cfg)
+	case o.backfill:
+		from, _ := parseMonth(o.from)
+		return runBackfill(ctx, cfg, from)
+	}
+	month, _ := o.parsedMonth()
+	return run(ctx, cfg, RunOptions{Month: month, DryRun: o.dryRun})
+}
+
+// runFetch saves the invoice emails the source returns for --month to
+// --o, as .eml, or as .html for sources without the RFC822 source. It
+// prints the paths; the state file is not consulted.
+func runFetch(ctx context.Context, cfg *Config, o *cliOptions, _ []string) error {
+	pipeline, err := buildProcessing(cfg, nil)
+	if err != nil {
+		return err
+	}
+	month, _ := o.parsedMonth()
+	if month.IsZero() {
+		month = time.Now()
+	}
+	invoices, err := pipeline.Source.Fetch(ctx, month)
+	if err != nil {
+		return withExitCode(exitIMAP, fmt.Errorf("fetching invoices: %w", err))
+	}
+	if len(invoices) == 0 {
+		return errNoInvoices
+	}
+	if err := os.MkdirAll(o.output, 0755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+	for _, inv := range invoices {
+		data, err := inv.Source()
+		if err != nil {
+			return fmt.Errorf("reading email source: %w", err)
+		}
+		ext := ".eml"
+		if len(data) == 0 {
+			data, ext = []byte(inv.HTMLBody), ".html"
+		}
+		path := filepath.Join(o.output, fmt.Sprintf("%s_%d%s", inv.Date.Format("2006-01-02"), inv.UID, ext))
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+		fmt.Fprintln(o.out, path)
+	}
+	return nil
+}
+
+// runList prints the emails in INBOX matching filter.* for --month, as a
+// run would select them, from their envelopes only.
+func runList(ctx context.Context, cfg *Config, o *cliOptions, _ []string) error {
+	if source := cfg.Pipeline.Source; source != "" && source != "imap" {
+		return withExitCode(exitConfig, fmt.Errorf("list requires the imap source, got %q", source))
+	}
+	month, _ := o.parsedMonth()
+	if month.IsZero() {
+		month = time.Now()
+	}
+	c, err := dialIMAP(ctx, cfg)
+	if err != nil {
+		return withExitCode(exitIMAP, fmt.Errorf("listing invoices: %w", err))
+	}
+	defer c.Logout()
+	matches, err := listMatches(ctx, c, cfg, month)
+	if err != nil {
+		return withExitCode(exitIMAP, fmt.Errorf("listing invoices: %w", err))
+	}
+
+	tw := tabwriter.NewWriter(o.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATE\tUID\tFROM\tSUBJECT")
+	for _, msg := range matches {
+		env := msg.Envelope
+		var from []string
+		for _, addr := range env.From {
+			from = append(from, addr.Address())
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", messageDate(msg, cfg).Format("2006-01-02 15:04"), msg.Uid, strings.Join(from, ", "), env.Subject)
+	}
+	return tw.Flush()
+}
+
+// listMatches returns the messages of INBOX, with envelope and UID, that a
+// run for month would process.
+func listMatches(ctx context.Context, c *client.Client, cfg *Config, month time.Time) ([]*imap.Message, error) {
+	slog.Debug("IMAP command", "command", "EXAMINE", "mailbox", "INBOX")
+	mbox, err := c.Select("INBOX", true)
+	if err != nil {
+		return nil, fmt.Errorf("selecting INBOX: %w", err)
+	}
+	if mbox.Messages == 0 {
+		return nil, nil
+	}
+	from, to := scanRange(cfg, mbox.Messages)
+	var matches []*imap.Message
+	err = fetchEnvelopes(ctx, c, cfg, from, to, func(msg *imap.Message) {
+		if matchesFilter(msg, cfg, month) {
+			matches = append(matches, msg)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// runConvert runs the configured transformers on saved invoice emails,
+// HTML files or web pages and writes the PDFs, named like in a run, with
+// JSON sidecars to --o. With a single input and --o ending in .pdf, only
+// the PDF is written, to that file.
+func runConvert(ctx context.Context, cfg *Config, o *cliOptions, args []string) error {
+	pipeline, err := buildProcessing(cfg, nil)
+	if err != nil {
+		return err
+	}
+	var invoices []InvoiceEmail
+	for i, arg := range args {
+		inv, err := readConvertInput(ctx, arg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", arg, err)
+		}
+		// Tells the invoices apart in log output and failures
+		inv.UID = uint32(i + 1)
+		invoices = append(invoices, inv)
+	}
+	processed, failures := processInvoices(ctx, cfg, pipeline.Transformers, invoices)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if len(args) == 1 && strings.EqualFold(filepath.Ext(o.output), ".pdf") {
+		if len(processed) == 0 {
+			return withExitCode(exitPDF, fmt.Errorf("converting %s: %w", args[0], failures[0].Err))
+		}
+		if err := os.MkdirAll(filepath.Dir(o.output), 0755); err != nil {
+			return fmt.Errorf("creating output directory: %w", err)
+		}
+		if err := os.WriteFile(o.output, processed[0].PDF, 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", o.output, err)
+		}
+		fmt.Fprintln(o.out, o.output)
+		if o.keepHTML {
+			return writeRenderedHTML(o, strings.TrimSuffix(o.output, filepath.Ext(o.output))+".html", processed[0])
+		}
+		return nil
+	}
+
+	d := &Delivery{Invoices: processed}
+	for i := range d.Invoices {
+		inv := &d.Invoices[i]
+		d.Attachments = append(d.Attachments, PDFAttachment{Filename: inv.Filename + ".pdf", Data: inv.PDF, Invoice: inv})
+	}
+	if err := (&dirSink{dir: o.output, sidecars: true}).Deliver(ctx, d); err != nil {
+		return err
+	}
+	for _, att := range d.Attachments {
+		fmt.Fprintln(o.out, filepath.Join(o.output, att.Filename))
+	}
+	if o.keepHTML {
+		for _, p := range processed {
+			if err := writeRenderedHTML(o, filepath.Join(o.output, p.Filename+".html"), p); err != nil {
+				return err
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return withExitCode(exitPDF, fmt.Errorf("%d of %d invoice(s) could not be converted to PDF", len(failures), len(invoices)))
+	}
+	return nil
+}
+
+// readConvertInput reads an input of convert: an http(s) URL, an RFC822
+// message (.eml) or an HTML file. Pages and HTML files are named after
+// their file name unless the extract transformer finds an order number.
+func readConvertInput(ctx context.Context, arg string) (InvoiceEmail, error) {
+	if strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, arg, nil)
+		if err != nil {
+			return InvoiceEmail{}, err
+		}
+		resp, err := newAPIClient().Do(req)
+		if err != nil {
+			return InvoiceEmail{}, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return InvoiceEmail{}, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return InvoiceEmail{}, err
+		}
+		name := path.Base(req.URL.Path)
+		if name == "/" || name == "." {
+			name = req.URL.Host
+		}
+		name = strings.TrimSuffix(name, path.Ext(name))
+		return InvoiceEmail{Subject: name, Date: time.Now(), HTMLBody: string(body)}, nil
+	}
+
+	data, err := os.ReadFile(arg)
+	if err != nil {
+		return InvoiceEmail{}, err
+	}
+	inv := InvoiceEmail{Subject: strings.TrimSuffix(filepath.Base(arg), filepath.Ext(arg)), HTMLBody: string(data)}
+	if strings.EqualFold(filepath.Ext(arg), ".eml") {
+		if inv, err = imapsource.ParseMessage(data); err != nil {
+			return InvoiceEmail{}, err
+		}
+	}
+	// Messages without a Date header are dated like HTML files
+	if fi, err := os.Stat(arg); err == nil && inv.Date.IsZero() {
+		inv.Date = fi.ModTime()
+	}
+	return inv, nil
+}
+
+// writeRenderedHTML writes the HTML the pdf transformer rendered for p to
+// path, for checking the cleaning steps.
+func writeRenderedHTML(o *cliOptions, path string, p ProcessedInvoice) error {
+	if err := os.WriteFile(path, []byte(p.HTML), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	fmt.Fprintln(o.out, path)
+	return nil
+}
+
+// ParsedInvoice is what parse prints for an input, in the field names of
+// the JSON sidecars.
+type ParsedInvoice struct {
+	File          string           `json:"file"`
+	Subject       string           `json:"subject"`
+	Date          time.Time        `json:"date,omitzero"`
+	MessageID     string           `json:"message_id,omitempty"`
+	OrderNumber   string           `json:"order_number,omitempty"`
+	InvoiceNumber string           `json:"invoice_number,omitempty"`
+	AppleID       string           `json:"apple_id,omitempty"`
+	Total         string           `json:"total,omitempty"`
+	TotalCents    int64            `json:"total_cents,omitempty"`
+	Currency      string           `json:"currency,omitempty"`
+	LineItems     []ParsedLineItem `json:"line_items"`
+}
+
+// ParsedLineItem is a line item of a ParsedInvoice.
+type ParsedLineItem struct {
+	Description string `json:"description"`
+	Amount      string `json:"amount"`
+	Cents       int64  `json:"cents"`
+	Currency    string `json:"currency"`
+}
+
+// runParse prints the values the extract transformer finds in each input,
+// plus the line items, without rendering or delivering anything.
+func runParse(ctx context.Context, cfg *Config, o *cliOptions, args []string) error {
+	parsed := []ParsedInvoice{}
+	for _, arg := range args {
+		inv, err := readConvertInput(ctx, arg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", arg, err)
+		}
+		p := ProcessedInvoice{Email: inv, Vendor: cfg.detectVendor(inv)}
+		extractTransformer{}.Transform(ctx, &p)
+		result := ParsedInvoice{
+			File:          arg,
+			Subject:       inv.Subject,
+			Date:          inv.Date,
+			MessageID:     inv.MessageID,
+			OrderNumber:   p.OrderNumber,
+			InvoiceNumber: p.InvoiceNumber,
+			AppleID:       p.AppleID,
+			Total:         p.Total.String(),
+			TotalCents:    p.Total.Cents,
+			Currency:      p.Total.Currency,
+			LineItems:     []ParsedLineItem{},
+		}
+		for _, item := range invoice.LineItems(inv.HTMLBody) {
+			result.LineItems = append(result.LineItems, ParsedLineItem{
+				Description: item.Description,
+				Amount:      item.Amount.String(),
+				Cents:       item.Amount.Cents,
+				Currency:    item.Amount.Currency,
+			})
+		}
+		parsed = append(parsed, result)
+	}
+
+	if o.json {
+		enc := json.NewEncoder(o.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(parsed)
+	}
+	tw := tabwriter.NewWriter(o.out, 0, 0, 2, ' ', 0)
+	for i, p := range parsed {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		date := ""
+		if !p.Date.IsZero() {
+			date = p.Date.Local().Format("2006-01-02")
+		}
+		fmt.Fprintf(tw, "file\t%s\n", p.File)
+		fmt.Fprintf(tw, "subject\t%s\n", p.Subject)
+		fmt.Fprintf(tw, "date\t%s\n", date)
+		fmt.Fprintf(tw, "order number\t%s\n", p.OrderNumber)
+		fmt.Fprintf(tw, "invoice number\t%s\n", p.InvoiceNumber)
+		fmt.Fprintf(tw, "apple id\t%s\n", p.AppleID)
+		fmt.Fprintf(tw, "total\t%s\n", p.Total)
+		for _, item := range p.LineItems {
+			fmt.Fprintf(tw, "item\t%s\t%s\n", item.Amount, item.Description)
+		}
+	}
+	return tw.Flush()
+}
+
+// runSend delivers files to all configured sinks. A PDF with a JSON
+// sidecar next to it, as written by convert or output.sidecars, is
+// delivered as that invoice; other files as an invoice named after the
+// file. Nothing is recorded in the state file.
+func runSend(ctx context.Context, cfg *Config, o *cliOptions, args []string) error {
+	sinks, err := buildSinks(cfg)
+	if err != nil {
+		return err
+	}
+	if o.dryRun {
+		if cfg, sinks, err = dryRunSinks(cfg, sinks); err != nil {
+			return err
+		}
+	}
+
+	d := &Delivery{Invoices: make([]ProcessedInvoice, len(args))}
+	for i, path := range args {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		inv := &d.Invoices[i]
+		base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		sidecar, err := os.ReadFile(strings.TrimSuffix(path, filepath.Ext(path)) + ".json")
+		switch {
+		case err == nil:
+			var meta InvoiceMetadata
+			if err := json.Unmarshal(sidecar, &meta); err != nil {
+				return fmt.Errorf("parsing sidecar of %s: %w", path, err)
+			}
+			*inv = invoiceFromMetadata(meta)
+		case errors.Is(err, os.ErrNotExist):
+			*inv = ProcessedInvoice{Email: InvoiceEmail{Subject: base}, Filename: base}
+			if fi, err := os.Stat(path); err == nil {
+				inv.Email.Date = fi.ModTime()
+			}
+		default:
+			return err
+		}
+		inv.PDF = data
+		d.Attachments = append(d.Attachments, PDFAttachment{Filename: filepath.Base(path), Data: data, Invoice: inv})
+	}
+
+	results := deliverAll(ctx, sinks, d, cfg.Delivery)
+	auditDelivery(cfg, d, sinks, results)
+	return deliver.Failed(results)
+}
+
+// runQuery prints the entries of the state file, most recently updated
+// first.
+func runQuery(_ context.Context, cfg *Config, o *cliOptions, _ []string) error {
+	if cfg.State.File == "" {
+		return withExitCode(exitConfig, errors.New("query: state.file is not configured"))
+	}
+	store, err := loadState(cfg.State.File)
+	if err != nil {
+		return err
+	}
+	month, _ := o.parsedMonth()
+	entries := []StateEntry{}
+	for _, e := range store.recent() {
+		if !month.IsZero() && e.month().Format("2006-01") != month.Format("2006-01") {
+			continue
+		}
+		if o.status != "" && e.Status != o.status {
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	if o.json {
+		enc := json.NewEncoder(o.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	tw := tabwriter.NewWriter(o.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATE\tORDER\tTOTAL\tSTATUS\tUPDATED\tFILE")
+	for _, e := range entries {
+		date := ""
+		if !e.Date.IsZero() {
+			date = e.Date.Local().Format("2006-01-02")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", date, e.OrderNumber, e.Total, e.Status, e.Updated.Local().Format("2006-01-02 15:04"), e.Filename)
+	}
+	return tw.Flush()
+}
+
+// runCheck tests what a run needs beyond a valid config, which main has
+// already loaded: logging in to the IMAP server and rendering a PDF. It
+// prints one line per check and the configured destinations.
+func runCheck(ctx context.Context, cfg *Config, o *cliOptions, _ []string) error {
+	pipeline, err := buildPipeline(cfg)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(o.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "config\tok\t%s\n", o.config)
+	var failed []string
+	check := func(name string, fn func() error) {
+		if err := fn(); err != nil {
+			fmt.Fprintf(tw, "%s\tFAILED\t%s\n", name, redactError(err))
+			failed = append(failed, name)
+			return
+		}
+		fmt.Fprintf(tw, "%s\tok\t\n", name)
+	}
+	if _, ok := pipeline.Source.(*imapSource); ok {
+		check("imap", func() error {
+			c, err := dialIMAP(ctx, cfg)
+			if err != nil {
+				return err
+			}
+			return c.Logout()
+		})
+	}
+	check("chrome", func() error {
+		_, err := pdf.Convert(ctx, "<!DOCTYPE html><p>check</p>", cfg.PDF)
+		return err
+	})
+	for _, sink := range pipeline.Sinks {
+		fmt.Fprintf(tw, "destination\t%s\t\n", sink.Name())
+	}
+	tw.Flush()
+	if len(failed) > 0 {
+		return fmt.Errorf("check failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCommandLine(t *testing.T) {
+	for _, tc := range []struct {
+		args    []string
+		command string
+		check   func(o *cliOptions, args []string) bool
+	}{
+		{nil, "run", func(o *cliOptions, _ []string) bool { return o.config == "config.yaml" }},
+		{[]string{"--dry-run", "--month", "2024-03"}, "run", func(o *cliOptions, _ []string) bool { return o.dryRun && o.month == "2024-03" }},
+		{[]string{"run", "--daemon"}, "run", func(o *cliOptions, _ []string) bool { return o.daemon }},
+		{[]string{"--backfill", "--from", "2021-01"}, "run", func(o *cliOptions, _ []string) bool { return o.backfill }},
+		{[]string{"resume"}, "resume", nil},
+		{[]string{"--dry-run", "install-service"}, "install-service", func(o *cliOptions, _ []string) bool { return o.dryRun }},
+		{[]string{"-v", "--config", "/etc/a.yaml", "fetch", "-o", "mail", "-q"}, "fetch", func(o *cliOptions, _ []string) bool {
+			return o.verbose && o.quiet && o.config == "/etc/a.yaml" && o.output == "mail"
+		}},
+		{[]string{"convert", "a.eml", "b.eml"}, "convert", func(_ *cliOptions, args []string) bool { return len(args) == 2 }},
+		{[]string{"query", "--status", "failed", "--json"}, "query", func(o *cliOptions, _ []string) bool { return o.status == "failed" && o.json }},
+	} {
+		o := newCLIOptions()
+		cmd, args, err := parseCommandLine(o, tc.args)
+		if err != nil {
+			t.Errorf("%v: %v", tc.args, err)
+			continue
+		}
+		if cmd.name != tc.command {
+			t.Errorf("%v: command = %s, want %s", tc.args, cmd.name, tc.command)
+		}
+		if tc.check != nil && !tc.check(o, args) {
+			t.Errorf("%v: options = %+v, args %v", tc.args, o, args)
+		}
+	}
+}
+
+func TestParseCommandLine_Errors(t *testing.T) {
+	for _, args := range [][]string{
+		{"frobnicate"},
+		{"--daemon", "fetch"},
+		{"--dry-run", "--daemon"},
+		{"--month", "2024-03", "--daemon"},
+		{"--month", "March"},
+		{"--backfill"},
+		{"--from", "2021-01"},
+		{"resume", "now"},
+		{"send"},
+	} {
+		if _, _, err := parseCommandLine(newCLIOptions(), args); err == nil {
+			t.Errorf("%v: want error", args)
+		}
+	}
+}
+
+func TestCommandMode(t *testing.T) {
+	o := newCLIOptions()
+	o.daemon = true
+	if mode := findCommand("run").mode(o); mode != "daemon" {
+		t.Errorf("mode = %s, want daemon", mode)
+	}
+	if mode := findCommand("resume").mode(o); mode != "resume" {
+		t.Errorf("mode = %s, want resume", mode)
+	}
+}
+
+func TestRunFetch(t *testing.T) {
+	raw := "Subject: Deine Rechnung von Apple\r\nContent-Type: text/html\r\n\r\n<p>Bestellnummer: MX2</p>\r\n"
+	resp, _ := json.Marshal(pluginResponse{Invoices: []pluginEmail{
+		{UID: 7, Date: time.Date(2024, 5, 3, 12, 0, 0, 0, time.Local), Raw: []byte(raw)},
+		{UID: 8, Date: time.Date(2024, 5, 4, 12, 0, 0, 0, time.Local), HTML: "<p>Bestellnummer: MX3</p>"},
+	}})
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "resp.json"), resp, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Plugins: []PluginConfig{{Name: "archive", Type: "source", Command: writePlugin(t, `cat "$DIR/resp.json"`), Env: map[string]string{"DIR": dir}}}}
+	cfg.Pipeline.Source = "archive"
+
+	var out bytes.Buffer
+	o := newCLIOptions()
+	o.out, o.output, o.month = &out, filepath.Join(dir, "mail"), "2024-05"
+	if err := runFetch(context.Background(), cfg, o, nil); err != nil {
+		t.Fatal(err)
+	}
+	eml := filepath.Join(o.output, "2024-05-03_7.eml")
+	if data, err := os.ReadFile(eml); err != nil || string(data) != raw {
+		t.Errorf("%s = %q, %v", eml, data, err)
+	}
+	if _, err := os.Stat(filepath.Join(o.output, "2024-05-04_8.html")); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(out.String(), eml) {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+func TestRunSend(t *testing.T) {
+	in, outDir := t.TempDir(), t.TempDir()
+	meta := InvoiceMetadata{Filename: "2024-05-03_Apple_MX1.pdf", Subject: "Deine Rechnung von Apple", OrderNumber: "MX1", TotalCents: 1299, Currency: "EUR"}
+	sidecar, _ := json.Marshal(meta)
+	os.WriteFile(filepath.Join(in, "2024-05-03_Apple_MX1.pdf"), []byte("%PDF-1"), 0644)
+	os.WriteFile(filepath.Join(in, "2024-05-03_Apple_MX1.json"), sidecar, 0644)
+	os.WriteFile(filepath.Join(in, "scan.pdf"), []byte("%PDF-2"), 0644)
+
+	cfg := &Config{}
+	cfg.Output.Dir, cfg.Output.Sidecars = outDir, true
+	cfg.Delivery.Attempts = 1
+	args := []string{filepath.Join(in, "2024-05-03_Apple_MX1.pdf"), filepath.Join(in, "scan.pdf")}
+	if err := runSend(context.Background(), cfg, newCLIOptions(), args); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(outDir, "scan.pdf")); string(data) != "%PDF-2" {
+		t.Errorf("scan.pdf = %q", data)
+	}
+	var got InvoiceMetadata
+	data, _ := os.ReadFile(filepath.Join(outDir, "2024-05-03_Apple_MX1.json"))
+	if err := json.Unmarshal(data, &got); err != nil || got.OrderNumber != "MX1" || got.Total == "" {
+		t.Errorf("sidecar = %s, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "scan.json")); err != nil {
+		t.Errorf("sidecar for a file without one: %v", err)
+	}
+}
+
+func TestRunQuery(t *testing.T) {
+	cfg := &Config{State: StateConfig{File: filepath.Join(t.TempDir(), "processed.json")}}
+	store, _ := loadState(cfg.State.File)
+	march := time.Date(2024, 3, 15, 10, 0, 0, 0, time.Local)
+	store.record([]ProcessedInvoice{{Email: InvoiceEmail{MessageID: "<a@apple.com>", Date: march}, OrderNumber: "A1", Filename: "a", Total: Amount{Cents: 1299, Currency: "EUR"}}}, sinkStatus(stateDelivered), march)
+	store.record([]ProcessedInvoice{{Email: InvoiceEmail{MessageID: "<b@apple.com>", Date: march}, OrderNumber: "B2", Filename: "b"}}, sinkStatus(stateFailed), march)
+
+	var out bytes.Buffer
+	o := newCLIOptions()
+	o.out = &out
+	if err := runQuery(context.Background(), cfg, o, nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := out.String(); !strings.HasPrefix(s, "DATE") || !strings.Contains(s, "2024-03-15") || !strings.Contains(s, "A1") || !strings.Contains(s, "B2") {
+		t.Errorf("table = %q", s)
+	}
+
+	out.Reset()
+	o.status, o.json = stateFailed, true
+	if err := runQuery(context.Background(), cfg, o, nil); err != nil {
+		t.Fatal(err)
+	}
+	var entries []StateEntry
+	if err := json.Unmarshal(out.Bytes(), &entries); err != nil || len(entries) != 1 || entries[0].OrderNumber != "B2" {
+		t.Errorf("json = %s, %v", out.String(), err)
+	}
+
+	if err := runQuery(context.Background(), &Config{}, o, nil); exitCode(err) != exitConfig {
+		t.Errorf("without state.file: %v, want config error", err)
+	}
+}
+
+func TestReadConvertInput(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rechnung.html" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("<p>Bestellnummer: MX1</p>"))
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	htmlFile := filepath.Join(dir, "saved.html")
+	os.WriteFile(htmlFile, []byte("<p>Bestellnummer: MX2</p>"), 0644)
+	emlFile := filepath.Join(dir, "mail.eml")
+	os.WriteFile(emlFile, []byte("Subject: Deine Rechnung von Apple\r\nContent-Type: text/html\r\n\r\n<p>Bestellnummer: MX3</p>\r\n"), 0644)
+
+	for _, tc := range []struct {
+		arg, subject, order string
+	}{
+		{srv.URL + "/rechnung.html", "rechnung", "MX1"},
+		{htmlFile, "saved", "MX2"},
+		{emlFile, "Deine Rechnung von Apple", "MX3"},
+	} {
+		inv, err := readConvertInput(context.Background(), tc.arg)
+		if err != nil {
+			t.Errorf("%s: %v", tc.arg, err)
+			continue
+		}
+		if inv.Subject != tc.subject || !strings.Contains(inv.HTMLBody, tc.order) || inv.Date.IsZero() {
+			t.Errorf("%s: got %+v", tc.arg, inv)
+		}
+	}
+	if _, err := readConvertInput(context.Background(), srv.URL+"/nope"); err == nil {
+		t.Error("404: want error")
+	}
+}
+
+func TestRunParse(t *testing.T) {
+	eml := filepath.Join(t.TempDir(), "mail.eml")
+	os.WriteFile(eml, []byte("Subject: Deine Rechnung von Apple\r\nDate: Fri, 03 May 2024 12:00:00 +0200\r\nContent-Type: text/html\r\n\r\n"+
+		"<table>\r\n<tr><td>Bestellnummer: MX1</td></tr>\r\n<tr><td>iCloud+ 200 GB</td><td>2,99 &euro;</td></tr>\r\n<tr><td>Gesamt</td><td>2,99 &euro;</td></tr>\r\n</table>\r\n"), 0644)
+
+	var out bytes.Buffer
+	o := newCLIOptions()
+	o.out, o.json = &out, true
+	if err := runParse(context.Background(), &Config{}, o, []string{eml}); err != nil {
+		t.Fatal(err)
+	}
+	var got []ParsedInvoice
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil || len(got) != 1 {
+		t.Fatalf("json = %s, %v", out.String(), err)
+	}
+	if p := got[0]; p.OrderNumber != "MX1" || p.TotalCents != 299 || p.Date.IsZero() || len(p.LineItems) != 1 || p.LineItems[0].Description != "iCloud+ 200 GB" {
+		t.Errorf("parsed = %+v", p)
+	}
+
+	out.Reset()
+	o.json = false
+	if err := runParse(context.Background(), &Config{}, o, []string{eml}); err != nil {
+		t.Fatal(err)
+	}
+	if s := out.String(); !strings.Contains(s, "MX1") || !strings.Contains(s, "2,99 €  iCloud+ 200 GB") {
+		t.Errorf("text = %q", s)
+	}
+}
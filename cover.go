@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"os"
+	"time"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/pdf"
+	"github.com/rummeyer/apple-invoice-pdf/pkg/invoice"
+)
+
+// defaultCoverTemplate renders a simple A4 overview table of all invoices.
+const defaultCoverTemplate = `<!DOCTYPE html>
+<html><head><meta charset="utf-8">
+<style>
+body { font-family: -apple-system, Helvetica, Arial, sans-serif; font-size: 11pt; margin: 2cm; color: #1d1d1f; }
+h1 { font-size: 18pt; font-weight: 600; }
+table { width: 100%; border-collapse: collapse; margin-top: 1em; }
+th, td { text-align: left; padding: 6px 8px; border-bottom: 1px solid #d2d2d7; }
+td.amount, th.amount { text-align: right; }
+tfoot td { font-weight: 600; border-bottom: none; }
+</style></head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Count}} Rechnung(en), erstellt am {{.Generated.Format "02.01.2006"}}</p>
+<table>
+<thead><tr><th>Datum</th><th>Bestellnummer</th><th class="amount">Betrag</th><th>Datei</th></tr></thead>
+<tbody>
+{{range .Invoices}}<tr><td>{{.Date.Format "02.01.2006"}}</td><td>{{.OrderNumber}}</td><td class="amount">{{.Amount}}</td><td>{{.Filename}}</td></tr>
+{{end}}</tbody>
+{{if .Total}}<tfoot><tr><td colspan="2">Summe</td><td class="amount">{{.Total}}</td><td></td></tr></tfoot>{{end}}
+</table>
+</body></html>
+`
+
+// CoverData is passed to the cover page and HTML email templates.
+type CoverData struct {
+	Title     string
+	Count     int
+	Generated time.Time
+	Invoices  []CoverRow
+	Total     string // empty if amounts are unknown or in mixed currencies
+}
+
+// CoverRow is a single invoice line on the cover page.
+type CoverRow struct {
+	Date        time.Time
+	OrderNumber string
+	Amount      string
+	Filename    string
+}
+
+// renderCoverHTML builds the cover page HTML from the default template or
+// the custom template file at tmplPath.
+func renderCoverHTML(tmplPath, title string, invoices []ProcessedInvoice, now time.Time) (string, error) {
+	return renderSummaryHTML("cover", defaultCoverTemplate, tmplPath, title, invoices, now)
+}
+
+// renderSummaryHTML renders an invoice overview (cover page, email body)
+// from defaultSrc or the custom template file at tmplPath.
+func renderSummaryHTML(name, defaultSrc, tmplPath, title string, invoices []ProcessedInvoice, now time.Time) (string, error) {
+	src := defaultSrc
+	if tmplPath != "" {
+		data, err := os.ReadFile(tmplPath)
+		if err != nil {
+			return "", fmt.Errorf("reading %s template: %w", name, err)
+		}
+		src = string(data)
+	}
+	tmpl, err := template.New(name).Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("parsing %s template: %w", name, err)
+	}
+
+	data := CoverData{Title: title, Count: len(invoices), Generated: now}
+	var amounts []Amount
+	for _, inv := range invoices {
+		data.Invoices = append(data.Invoices, CoverRow{
+			Date:        inv.Email.Date,
+			OrderNumber: inv.OrderNumber,
+			Amount:      inv.Total.String(),
+			Filename:    inv.Filename + ".pdf",
+		})
+		amounts = append(amounts, inv.Total)
+	}
+	if total, ok := invoice.SumAmounts(amounts); ok {
+		data.Total = total.String()
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("executing %s template: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// buildCoverPage renders the cover page to a PDF attachment named after the
+// month of the first invoice.
+func buildCoverPage(ctx context.Context, cfg *Config, invoices []ProcessedInvoice) (*PDFAttachment, error) {
+	title, err := emailSubject(cfg, invoices)
+	if err != nil {
+		return nil, err
+	}
+	html, err := renderCoverHTML(cfg.Cover.Template, title, invoices, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	data, err := pdf.Convert(ctx, html, cfg.PDF)
+	if err != nil {
+		return nil, err
+	}
+	date := invoices[0].Email.Date
+	return &PDFAttachment{Filename: monthFilename(date, invoices, "_Uebersicht.pdf"), Data: data}, nil
+}
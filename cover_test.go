@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderCoverHTML_Default(t *testing.T) {
+	date := time.Date(2026, 2, 13, 0, 0, 0, 0, time.UTC)
+	invoices := []ProcessedInvoice{
+		{Email: InvoiceEmail{Date: date}, OrderNumber: "MX1", Total: Amount{Cents: 999, Currency: "EUR"}, Filename: "02_2026_Rechnung_Apple_MX1"},
+		{Email: InvoiceEmail{Date: date}, OrderNumber: "MX2", Total: Amount{Cents: 1998, Currency: "EUR"}, Filename: "02_2026_Rechnung_Apple_MX2"},
+	}
+
+	html, err := renderCoverHTML("", "Übersicht", invoices, date)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"Übersicht", "13.02.2026", "MX1", "9,99 €", "02_2026_Rechnung_Apple_MX2.pdf", "29,97 €"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("cover HTML missing %q", want)
+		}
+	}
+}
+
+func TestRenderCoverHTML_NoTotalForUnknownAmount(t *testing.T) {
+	invoices := []ProcessedInvoice{
+		{Total: Amount{Cents: 999, Currency: "EUR"}},
+		{},
+	}
+	html, err := renderCoverHTML("", "", invoices, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(html, "<tfoot>") {
+		t.Error("expected no total row when an amount is unknown")
+	}
+}
+
+func TestRenderCoverHTML_CustomTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cover.html")
+	os.WriteFile(path, []byte(`{{.Count}} invoices, total {{.Total}}`), 0644)
+
+	html, err := renderCoverHTML(path, "", []ProcessedInvoice{{Total: Amount{Cents: 500, Currency: "EUR"}}}, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if html != "1 invoices, total 5,00 €" {
+		t.Errorf("got %q", html)
+	}
+}
+
+func TestRenderCoverHTML_MissingTemplate(t *testing.T) {
+	if _, err := renderCoverHTML("/nonexistent/cover.html", "", nil, time.Now()); err == nil {
+		t.Fatal("expected error for missing template")
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronSchedule_Next(t *testing.T) {
+	base := time.Date(2024, 5, 14, 10, 30, 0, 0, time.UTC) // Tuesday
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"0 7 1 * *", base, time.Date(2024, 6, 1, 7, 0, 0, 0, time.UTC)},
+		{"@monthly", base, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", base, time.Date(2024, 5, 14, 10, 45, 0, 0, time.UTC)},
+		{"30 10 * * *", base, time.Date(2024, 5, 15, 10, 30, 0, 0, time.UTC)},
+		{"0 8-18/2 * * 1-5", base, time.Date(2024, 5, 14, 12, 0, 0, 0, time.UTC)},
+		{"0 9 * * 6,7", base, time.Date(2024, 5, 18, 9, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", base, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1 1 *", time.Date(2024, 12, 31, 23, 59, 30, 0, time.UTC), time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: the 13th or any Friday
+		{"0 0 13 * 5", base, time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", base, time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.expr, err)
+		}
+		if got := s.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q: Next(%s) = %s, want %s", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "0 7 1 *", "60 * * * *", "* 24 * * *", "0 0 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@reboot"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q): expected error", expr)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// DaemonConfig configures the long-running --daemon mode.
+type DaemonConfig struct {
+	// Schedule is a cron expression in local time, e.g. "0 7 1 * *" for
+	// 07:00 on the first of every month.
+	Schedule string `yaml:"schedule"`
+	// Month is the month a scheduled run processes, "previous" or
+	// "current". Empty means previous for schedules running on fixed days
+	// of the month, since its invoices are complete then, else current.
+	Month string `yaml:"month"`
+	// Listen is the address (e.g. ":8080") for the /healthz, /readyz and
+	// /metrics endpoints; empty disables them.
+	Listen string `yaml:"listen"`
+	// Dashboard serves a web UI on Listen.
+	Dashboard DashboardConfig `yaml:"dashboard"`
+	// GRPC serves the gRPC service on its own address.
+	GRPC GRPCConfig `yaml:"grpc"`
+}
+
+// runRequest asks the daemon for an unscheduled run, from the dashboard or
+// the gRPC service. A resend sets MessageID or OrderNumber of the invoice
+// to deliver again and runs over its month instead of Options.Month.
+type runRequest struct {
+	Options     RunOptions
+	Resend      bool
+	MessageID   string
+	OrderNumber string
+	// Vendor scopes OrderNumber, any vendor if empty.
+	Vendor string
+	// started is closed when the run starts and done receives its report,
+	// or nil if it never ran; both are optional.
+	started chan struct{}
+	done    chan *RunReport
+}
+
+// start signals that the run of r has started.
+func (r runRequest) start() {
+	if r.started != nil {
+		close(r.started)
+	}
+}
+
+// finish hands the report of the run to the requester. done is buffered,
+// so this never blocks if the requester gave up waiting.
+func (r runRequest) finish(report *RunReport) {
+	if r.done != nil {
+		r.done <- report
+	}
+}
+
+// runDaemon runs on the configured schedule until ctx is cancelled. Failed
+// runs are logged and do not stop the daemon. Under systemd with
+// Type=notify, readiness and status are reported and WatchdogSec= is
+// honored.
+func runDaemon(ctx context.Context, cfg *Config) error {
+	if cfg.Daemon.Schedule == "" {
+		return withExitCode(exitConfig, errors.New("--daemon requires daemon.schedule in the config"))
+	}
+	sched, err := parseCron(cfg.Daemon.Schedule)
+	if err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	month, err := cfg.Daemon.runMonth(sched)
+	if err != nil {
+		return withExitCode(exitConfig, err)
+	}
+
+	metrics := newDaemonMetrics()
+	ctx = withMetrics(ctx, metrics)
+
+	health := newDaemonHealth(time.Now())
+	if cfg.Daemon.Dashboard.Enabled && cfg.Daemon.Listen == "" {
+		return withExitCode(exitConfig, errors.New("daemon.dashboard requires daemon.listen"))
+	}
+	// Requests from the dashboard and gRPC are handled by the scheduler
+	// loop below, so runs never overlap
+	var requests chan runRequest
+	var history *runHistory
+	if cfg.Daemon.Dashboard.Enabled || cfg.Daemon.GRPC.Listen != "" {
+		requests = make(chan runRequest, 1)
+		history = &runHistory{}
+		ctx = withRunHistory(ctx, history)
+	}
+	if cfg.Daemon.Listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", health)
+		mux.Handle("/readyz", health)
+		mux.Handle("/metrics", metrics)
+		if cfg.Daemon.Dashboard.Enabled {
+			mux.Handle("/", newDashboard(cfg, health, history, requests))
+		}
+		if err := serveHealth(ctx, cfg.Daemon.Listen, mux); err != nil {
+			return err
+		}
+	}
+	if cfg.Daemon.GRPC.Listen != "" {
+		if err := serveGRPC(ctx, cfg, requests); err != nil {
+			return withExitCode(exitConfig, err)
+		}
+	}
+
+	var wd *watchdog
+	if timeout := watchdogTimeout(); timeout > 0 {
+		wd = newWatchdog(timeout)
+		ctx = withHeartbeat(ctx, wd.beat)
+		go wd.run(ctx)
+	}
+	sdNotify("READY=1")
+	health.setReady(true)
+	defer func() {
+		health.setReady(false)
+		sdNotify("STOPPING=1")
+	}()
+
+	var lastErr error
+	for {
+		next := sched.Next(time.Now())
+		if next.IsZero() {
+			return withExitCode(exitConfig, errors.New("daemon.schedule never matches"))
+		}
+		health.scheduled(next)
+		status := "Next run at " + next.Format("2006-01-02 15:04 MST")
+		slog.Info("Waiting for next run", "next_run", next, "month", month)
+		if lastErr != nil {
+			status = "Last run failed: " + redactError(lastErr) + "; " + status
+		}
+		sdNotify("STATUS=" + status)
+		req, ok := waitForRun(ctx, cfg, next, month, requests)
+		if !ok {
+			return nil
+		}
+
+		sdNotify("STATUS=Running")
+		health.runStarted()
+		if wd != nil {
+			wd.beat()
+			wd.busy.Store(true)
+		}
+		req.start()
+		lastErr = run(ctx, cfg, req.Options)
+		if wd != nil {
+			wd.busy.Store(false)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(lastErr, errNoInvoices) {
+			slog.Info("No invoices to process")
+			lastErr = nil
+		}
+		if lastErr != nil {
+			slog.Error("Run failed", "err", lastErr)
+			sentryFrom(ctx).captureError(lastErr, map[string]string{"mode": "daemon"})
+		}
+		health.runFinished(time.Now(), lastErr)
+		metrics.runFinished(time.Now())
+		req.finish(history.latest())
+	}
+}
+
+// waitForRun waits until next or a request and returns the request to
+// run; a scheduled run processes month as resolved by runMonth. It
+// reports false if ctx was cancelled first.
+func waitForRun(ctx context.Context, cfg *Config, next time.Time, month string, requests <-chan runRequest) (runRequest, bool) {
+	t := time.NewTimer(time.Until(next))
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			return runRequest{Options: RunOptions{Month: scheduledMonth(month, time.Now())}}, true
+		case <-ctx.Done():
+			return runRequest{}, false
+		case req := <-requests:
+			if !req.Resend {
+				slog.Info("Running on request", "month", req.Options.Month.Format("2006-01"), "dry_run", req.Options.DryRun)
+				return req, true
+			}
+			opts, err := resendOptions(cfg, req)
+			if err != nil {
+				slog.Error("Preparing resend failed", "err", err)
+				req.finish(nil)
+				continue
+			}
+			slog.Info("Resending on request", "message_id", req.MessageID, "order_number", req.OrderNumber, "month", opts.Month.Format("2006-01"))
+			req.Options = opts
+			return req, true
+		}
+	}
+}
+
+// Values of daemon.month.
+const (
+	monthPrevious = "previous"
+	monthCurrent  = "current"
+)
+
+// runMonth validates daemon.month and resolves an empty value for sched.
+func (c DaemonConfig) runMonth(sched *cronSchedule) (string, error) {
+	switch c.Month {
+	case monthPrevious, monthCurrent:
+		return c.Month, nil
+	case "":
+		if sched.monthly() {
+			return monthPrevious, nil
+		}
+		return monthCurrent, nil
+	}
+	return "", fmt.Errorf("daemon.month must be previous or current, not %q", c.Month)
+}
+
+// scheduledMonth returns the month a scheduled run at now processes, the
+// zero time for the current one.
+func scheduledMonth(month string, now time.Time) time.Time {
+	if month != monthPrevious {
+		return time.Time{}
+	}
+	return time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, now.Location())
+}
+
+// resendOptions forgets the invoice of req in the state file so it is
+// delivered again, and returns the options for a run over its month.
+func resendOptions(cfg *Config, req runRequest) (RunOptions, error) {
+	store, err := loadState(cfg.State.File)
+	if err != nil {
+		return RunOptions{}, err
+	}
+	entry, ok := store.lookup(req.MessageID, req.Vendor, req.OrderNumber)
+	if !ok {
+		return RunOptions{}, errors.New("invoice not in the state file")
+	}
+	if _, err := store.forget(req.MessageID, req.Vendor, req.OrderNumber); err != nil {
+		return RunOptions{}, err
+	}
+	return RunOptions{Month: entry.month()}, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRunDaemon_Config(t *testing.T) {
+	if err := runDaemon(context.Background(), &Config{}); err == nil {
+		t.Error("expected error without schedule")
+	}
+	cfg := &Config{Daemon: DaemonConfig{Schedule: "0 0 30 2 *"}}
+	if err := runDaemon(context.Background(), cfg); err == nil {
+		t.Error("expected error for a schedule that never matches")
+	}
+}
+
+func TestRunDaemon_StopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cfg := &Config{Daemon: DaemonConfig{Schedule: "@yearly"}}
+	if err := runDaemon(ctx, cfg); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDaemonConfig_RunMonth(t *testing.T) {
+	tests := []struct {
+		month, schedule, want string
+	}{
+		{"", "0 7 1 * *", "previous"},
+		{"", "@monthly", "previous"},
+		{"", "0 7 * * 1", "current"},
+		{"", "0 0 13 * 5", "current"},
+		{"", "*/15 * * * *", "current"},
+		{"current", "0 7 1 * *", "current"},
+		{"previous", "0 7 * * *", "previous"},
+	}
+	for _, tt := range tests {
+		sched, err := parseCron(tt.schedule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := DaemonConfig{Month: tt.month}.runMonth(sched)
+		if err != nil || got != tt.want {
+			t.Errorf("runMonth(%q, %q) = %q, %v, want %q", tt.month, tt.schedule, got, err, tt.want)
+		}
+	}
+	sched, _ := parseCron("@monthly")
+	if _, err := (DaemonConfig{Month: "last"}).runMonth(sched); err == nil {
+		t.Error("expected error for an unknown month")
+	}
+}
+
+func TestScheduledMonth(t *testing.T) {
+	now := time.Date(2025, 1, 1, 7, 0, 0, 0, time.UTC)
+	if got := scheduledMonth("previous", now); !got.Equal(time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("previous = %s", got)
+	}
+	if got := scheduledMonth("current", now); !got.IsZero() {
+		t.Errorf("current = %s, want zero", got)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DashboardConfig configures the web dashboard of daemon mode, served on
+// daemon.listen.
+type DashboardConfig struct {
+	// Enabled serves the dashboard at /.
+	Enabled bool `yaml:"enabled"`
+	// User and Password enable HTTP basic authentication if both are set.
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+}
+
+// dashboardTemplate renders the dashboard page.
+const dashboardTemplate = `<!DOCTYPE html>
+<html lang="de"><head><meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, Helvetica, Arial, sans-serif; font-size: 11pt; margin: 2em auto; max-width: 60em; padding: 0 1em; color: #1d1d1f; }
+h1 { font-size: 18pt; font-weight: 600; }
+h2 { font-size: 13pt; font-weight: 600; margin-top: 2em; }
+table { width: 100%; border-collapse: collapse; }
+th, td { text-align: left; padding: 6px 8px; border-bottom: 1px solid #d2d2d7; }
+td.amount, th.amount { text-align: right; }
+.failed { color: #d70015; }
+.notice { background: #f5f5f7; padding: 8px 12px; border-radius: 6px; }
+form { display: inline; }
+button { font: inherit; padding: 2px 10px; }
+</style></head>
+<body>
+<h1>{{.Title}}</h1>
+{{if eq .Notice "queued"}}<p class="notice">Auftrag angenommen, er wird gleich ausgeführt.</p>{{end}}
+{{if eq .Notice "busy"}}<p class="notice">Es wartet bereits ein Auftrag, bitte später erneut versuchen.</p>{{end}}
+<p>{{if .Health.Running}}Läuft gerade.{{else if .Health.NextRun}}Nächster Lauf am {{.Health.NextRun.Format "02.01.2006 15:04"}}.{{end}}
+{{if .Health.LastError}}<span class="failed">Letzter Lauf fehlgeschlagen: {{.Health.LastError}}</span>{{end}}</p>
+<form method="post" action="run"><button type="submit">Jetzt ausführen</button></form>
+
+<h2>Letzte Läufe</h2>
+{{if .Runs}}<table>
+<thead><tr><th>Start</th><th>Dauer</th><th>Status</th><th class="amount">Rechnungen</th><th>Fehler</th></tr></thead>
+<tbody>
+{{range .Runs}}<tr><td>{{.Started.Format "02.01.2006 15:04"}}</td><td>{{duration .Started .Finished}}</td><td{{if eq .Status "failed"}} class="failed"{{end}}>{{.Status}}</td><td class="amount">{{len .Invoices}}</td><td>{{.Error}}</td></tr>
+{{end}}</tbody>
+</table>{{else}}<p>Seit dem Start wurde noch nichts ausgeführt.</p>{{end}}
+
+<h2>Rechnungen</h2>
+{{if .Invoices}}<table>
+<thead><tr><th>Datum</th><th>Bestellnummer</th><th class="amount">Betrag</th><th>Datei</th><th>Status</th><th></th></tr></thead>
+<tbody>
+{{range .Invoices}}<tr><td>{{if not .Date.IsZero}}{{.Date.Format "02.01.2006"}}{{end}}</td><td>{{.OrderNumber}}</td><td class="amount">{{.Total}}</td><td>{{.Filename}}</td><td{{if eq .Status "failed"}} class="failed"{{end}}>{{.Status}}</td>
+<td><form method="post" action="resend"><input type="hidden" name="message_id" value="{{.MessageID}}"><input type="hidden" name="order_number" value="{{.OrderNumber}}"><input type="hidden" name="vendor" value="{{.Vendor}}"><button type="submit">Erneut senden</button></form></td></tr>
+{{end}}</tbody>
+</table>{{else}}<p>Noch keine Rechnungen verarbeitet{{if not $.StateFile}} (state.file ist nicht gesetzt){{end}}.</p>{{end}}
+</body></html>
+`
+
+// dashboardPage is passed to dashboardTemplate.
+type dashboardPage struct {
+	Title     string
+	Notice    string
+	Health    HealthStatus
+	Runs      []*RunReport
+	Invoices  []StateEntry
+	StateFile string
+}
+
+var dashboardTmpl = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"duration": func(start, end time.Time) string { return end.Sub(start).Round(time.Second).String() },
+}).Parse(dashboardTemplate))
+
+// maxRunHistory is the number of runs shown on the dashboard.
+const maxRunHistory = 20
+
+// runHistory keeps the reports of the most recent runs for the dashboard.
+// A nil history records nothing.
+type runHistory struct {
+	mu   sync.Mutex
+	runs []*RunReport
+}
+
+// runHistoryKey carries the run history of the daemon in the context.
+type runHistoryKey struct{}
+
+// withRunHistory returns a context whose runs are added to h.
+func withRunHistory(ctx context.Context, h *runHistory) context.Context {
+	return context.WithValue(ctx, runHistoryKey{}, h)
+}
+
+// runHistoryFrom returns the history in ctx, or nil if the dashboard is
+// disabled.
+func runHistoryFrom(ctx context.Context) *runHistory {
+	h, _ := ctx.Value(runHistoryKey{}).(*runHistory)
+	return h
+}
+
+// add records a finished run, dropping the oldest beyond maxRunHistory.
+func (h *runHistory) add(r *RunReport) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.runs = append(h.runs, r)
+	if len(h.runs) > maxRunHistory {
+		h.runs = h.runs[len(h.runs)-maxRunHistory:]
+	}
+}
+
+// latest returns the most recent run, or nil.
+func (h *runHistory) latest() *RunReport {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.runs) == 0 {
+		return nil
+	}
+	return h.runs[len(h.runs)-1]
+}
+
+// list returns the recorded runs, newest first.
+func (h *runHistory) list() []*RunReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	runs := slices.Clone(h.runs)
+	slices.Reverse(runs)
+	return runs
+}
+
+// dashboard serves the web UI of daemon mode. Buttons queue requests on
+// requests, which the scheduler loop picks up so they never overlap with
+// scheduled runs. Cross-origin POSTs are rejected, so other sites open in
+// the same browser cannot trigger runs or resends.
+type dashboard struct {
+	cfg      *Config
+	health   *daemonHealth
+	history  *runHistory
+	requests chan<- runRequest
+	csrf     *http.CrossOriginProtection
+}
+
+func newDashboard(cfg *Config, health *daemonHealth, history *runHistory, requests chan<- runRequest) *dashboard {
+	return &dashboard{cfg: cfg, health: health, history: history, requests: requests, csrf: http.NewCrossOriginProtection()}
+}
+
+func (d *dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := d.cfg.Daemon.Dashboard
+	if auth.User != "" && auth.Password != "" {
+		user, pass, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(auth.User)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(auth.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="apple-invoice-pdf"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if err := d.csrf.Check(r); err != nil {
+		slog.Warn("Rejected cross-origin dashboard request", "path", r.URL.Path, "origin", r.Header.Get("Origin"))
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	switch {
+	case r.URL.Path == "/" && r.Method == http.MethodGet:
+		d.render(w, r)
+	case r.URL.Path == "/run" && r.Method == http.MethodPost:
+		d.enqueue(w, r, runRequest{})
+	case r.URL.Path == "/resend" && r.Method == http.MethodPost:
+		req := runRequest{Resend: true, MessageID: r.FormValue("message_id"), OrderNumber: r.FormValue("order_number"), Vendor: r.FormValue("vendor")}
+		if req.MessageID == "" && req.OrderNumber == "" {
+			http.Error(w, "message_id or order_number is required", http.StatusBadRequest)
+			return
+		}
+		d.enqueue(w, r, req)
+	case r.URL.Path == "/" || r.URL.Path == "/run" || r.URL.Path == "/resend":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// render writes the dashboard page. Invoices come from the state file,
+// most recently updated first.
+func (d *dashboard) render(w http.ResponseWriter, r *http.Request) {
+	page := dashboardPage{
+		Title:     invoiceNoun(d.cfg.vendorTitle()) + "en",
+		Notice:    r.URL.Query().Get("status"),
+		Health:    d.health.snapshot(),
+		Runs:      d.history.list(),
+		StateFile: d.cfg.State.File,
+	}
+	store, err := loadState(d.cfg.State.File)
+	if err != nil {
+		slog.Error("Reading state for dashboard failed", "err", err)
+	}
+	page.Invoices = store.recent()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTmpl.Execute(w, page); err != nil {
+		slog.Error("Rendering dashboard failed", "err", err)
+	}
+}
+
+// enqueue hands req to the scheduler unless a request is already waiting
+// and redirects back to the page.
+func (d *dashboard) enqueue(w http.ResponseWriter, r *http.Request, req runRequest) {
+	status := "queued"
+	select {
+	case d.requests <- req:
+		slog.Info("Dashboard request queued", "resend", req.Resend, "message_id", req.MessageID, "order_number", req.OrderNumber)
+	default:
+		status = "busy"
+	}
+	http.Redirect(w, r, "./?status="+status, http.StatusSeeOther)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dashboardRecord(d *dashboard, method, target string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDashboard_Render(t *testing.T) {
+	cfg := &Config{State: StateConfig{File: filepath.Join(t.TempDir(), "processed.json")}}
+	store, _ := loadState(cfg.State.File)
+	date := time.Date(2024, 5, 3, 10, 0, 0, 0, time.Local)
+	p := ProcessedInvoice{Email: InvoiceEmail{MessageID: "<a@apple.com>", Date: date}, OrderNumber: "MXKL1234", Filename: "2024-05-03_Apple_MXKL1234", Total: Amount{Cents: 1299, Currency: "EUR"}}
+	if err := store.record([]ProcessedInvoice{p}, sinkStatus(stateDelivered), date); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newDashboard(cfg, newDaemonHealth(date), &runHistory{}, nil)
+	ctx := withRunHistory(context.Background(), d.history)
+	_, done := startRunReport(ctx, cfg, "run", false)
+	done(nil)
+
+	rec := dashboardRecord(d, http.MethodGet, "/", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET / = %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{"MXKL1234", "12,99", "03.05.2024", "delivered", "Erneut senden", "Letzte Läufe"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page does not contain %q", want)
+		}
+	}
+	if runs := d.history.list(); len(runs) != 1 || runs[0].Status != "ok" {
+		t.Errorf("history = %+v, want one ok run", runs)
+	}
+
+	if rec := dashboardRecord(d, http.MethodGet, "/run", nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /run = %d, want 405", rec.Code)
+	}
+	if rec := dashboardRecord(d, http.MethodGet, "/nope", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("GET /nope = %d, want 404", rec.Code)
+	}
+}
+
+func TestDashboard_Requests(t *testing.T) {
+	requests := make(chan runRequest, 1)
+	d := newDashboard(&Config{}, newDaemonHealth(time.Now()), &runHistory{}, requests)
+
+	rec := dashboardRecord(d, http.MethodPost, "/run", url.Values{})
+	if rec.Code != http.StatusSeeOther || !strings.HasSuffix(rec.Header().Get("Location"), "status=queued") {
+		t.Fatalf("POST /run = %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	rec = dashboardRecord(d, http.MethodPost, "/resend", url.Values{"order_number": {"MXKL1234"}})
+	if !strings.HasSuffix(rec.Header().Get("Location"), "status=busy") {
+		t.Errorf("second request: Location = %s, want busy", rec.Header().Get("Location"))
+	}
+	if req := <-requests; req.Resend {
+		t.Errorf("queued request = %+v, want run", req)
+	}
+
+	if rec := dashboardRecord(d, http.MethodPost, "/resend", url.Values{}); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /resend without invoice = %d, want 400", rec.Code)
+	}
+	dashboardRecord(d, http.MethodPost, "/resend", url.Values{"order_number": {"MXKL1234"}})
+	if req := <-requests; !req.Resend || req.OrderNumber != "MXKL1234" {
+		t.Errorf("queued request = %+v, want resend of MXKL1234", req)
+	}
+}
+
+func TestDashboard_CrossOrigin(t *testing.T) {
+	requests := make(chan runRequest, 1)
+	d := newDashboard(&Config{}, newDaemonHealth(time.Now()), &runHistory{}, requests)
+
+	for name, header := range map[string][2]string{
+		"fetch metadata": {"Sec-Fetch-Site", "cross-site"},
+		"origin":         {"Origin", "https://evil.example"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "http://nas.local:8080/run", nil)
+		req.Header.Set(header[0], header[1])
+		rec := httptest.NewRecorder()
+		d.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: POST /run = %d, want 403", name, rec.Code)
+		}
+	}
+	if len(requests) != 0 {
+		t.Fatal("cross-origin request was queued")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "http://nas.local:8080/run", nil)
+	req.Header.Set("Origin", "http://nas.local:8080")
+	req.Header.Set("Sec-Fetch-Site", "same-origin")
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Errorf("same-origin POST /run = %d, want 303", rec.Code)
+	}
+}
+
+func TestDashboard_BasicAuth(t *testing.T) {
+	cfg := &Config{Daemon: DaemonConfig{Dashboard: DashboardConfig{Enabled: true, User: "anna", Password: "geheim"}}}
+	d := newDashboard(cfg, newDaemonHealth(time.Now()), &runHistory{}, nil)
+
+	if rec := dashboardRecord(d, http.MethodGet, "/", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials = %d, want 401", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("anna", "geheim")
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("with credentials = %d, want 200", rec.Code)
+	}
+}
+
+func TestResendOptions(t *testing.T) {
+	cfg := &Config{State: StateConfig{File: filepath.Join(t.TempDir(), "processed.json")}}
+	store, _ := loadState(cfg.State.File)
+	date := time.Date(2024, 3, 15, 10, 0, 0, 0, time.Local)
+	invoices := []ProcessedInvoice{
+		{Email: InvoiceEmail{MessageID: "<a@apple.com>", Date: date}, OrderNumber: "A1", Filename: "a"},
+		{Email: InvoiceEmail{MessageID: "<b@apple.com>", Date: date}, OrderNumber: "B2", Filename: "b"},
+	}
+	if err := store.record(invoices, sinkStatus(stateDelivered), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := resendOptions(cfg, runRequest{Resend: true, MessageID: "<a@apple.com>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Month.Format("2006-01") != "2024-03" {
+		t.Errorf("month = %s, want 2024-03", opts.Month.Format("2006-01"))
+	}
+	store, _ = loadState(cfg.State.File)
+	if store.delivered("<a@apple.com>", "", "") || !store.delivered("<b@apple.com>", "", "") {
+		t.Errorf("entries after resend = %+v, want only B2", store.entries)
+	}
+
+	if _, err := resendOptions(cfg, runRequest{Resend: true, OrderNumber: "C3"}); err == nil {
+		t.Error("expected error for an invoice not in the state file")
+	}
+}
+
+func TestRunDaemon_DashboardRequiresListen(t *testing.T) {
+	cfg := &Config{Daemon: DaemonConfig{Schedule: "0 7 1 * *", Dashboard: DashboardConfig{Enabled: true}}}
+	if err := runDaemon(context.Background(), cfg); exitCode(err) != exitConfig {
+		t.Errorf("runDaemon = %v, want config error", err)
+	}
+}
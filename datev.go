@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DATEVConfig configures the DATEV Unternehmen online import package: a ZIP
+// with the invoice PDFs, a document.xml index and one ledger file per
+// invoice carrying date, amount and invoice number.
+type DATEVConfig struct {
+	Dir      string  `yaml:"dir"`
+	Attach   bool    `yaml:"attach"`
+	Supplier string  `yaml:"supplier"`
+	TaxRate  float64 `yaml:"tax_rate"`
+}
+
+// DATEV XML namespaces for the document (v6.0) and ledger (v5.0) formats.
+const (
+	datevDocumentNS = "http://xml.datev.de/bedi/tps/document/v06.0"
+	datevLedgerNS   = "http://xml.datev.de/bedi/tps/ledger/v050"
+	xsiNS           = "http://www.w3.org/2001/XMLSchema-instance"
+)
+
+type datevArchive struct {
+	XMLName          xml.Name        `xml:"archive"`
+	Xmlns            string          `xml:"xmlns,attr"`
+	XSI              string          `xml:"xmlns:xsi,attr"`
+	SchemaLocation   string          `xml:"xsi:schemaLocation,attr"`
+	Version          string          `xml:"version,attr"`
+	GeneratingSystem string          `xml:"generatingSystem,attr"`
+	Date             string          `xml:"header>date"`
+	Description      string          `xml:"header>description"`
+	Documents        []datevDocument `xml:"content>document"`
+}
+
+type datevDocument struct {
+	GUID        string           `xml:"guid,attr"`
+	Description string           `xml:"description"`
+	Extensions  []datevExtension `xml:"extension"`
+	Repository  []datevLevel     `xml:"repository>level"`
+}
+
+type datevExtension struct {
+	Type     string `xml:"xsi:type,attr"`
+	Name     string `xml:"name,attr,omitempty"`
+	Datafile string `xml:"datafile,attr,omitempty"`
+}
+
+type datevLevel struct {
+	ID   int    `xml:"id,attr"`
+	Name string `xml:"name,attr"`
+}
+
+type datevLedgerImport struct {
+	XMLName          xml.Name         `xml:"LedgerImport"`
+	Xmlns            string           `xml:"xmlns,attr"`
+	XSI              string           `xml:"xmlns:xsi,attr"`
+	SchemaLocation   string           `xml:"xsi:schemaLocation,attr"`
+	Version          string           `xml:"version,attr"`
+	GeneratorInfo    string           `xml:"generator_info,attr"`
+	GeneratingSystem string           `xml:"generating_system,attr"`
+	Consolidate      datevConsolidate `xml:"consolidate"`
+}
+
+type datevConsolidate struct {
+	Amount    string             `xml:"consolidatedAmount,attr"`
+	Date      string             `xml:"consolidatedDate,attr"`
+	InvoiceID string             `xml:"consolidatedInvoiceId,attr,omitempty"`
+	Currency  string             `xml:"consolidatedCurrencyCode,attr"`
+	Ledger    datevPayableLedger `xml:"accountsPayableLedger"`
+}
+
+type datevPayableLedger struct {
+	Date         string `xml:"date"`
+	Amount       string `xml:"amount"`
+	Tax          string `xml:"tax,omitempty"`
+	CurrencyCode string `xml:"currencyCode"`
+	InvoiceID    string `xml:"invoiceId,omitempty"`
+	BookingText  string `xml:"bookingText"`
+	SupplierName string `xml:"supplierName"`
+}
+
+// buildDATEVPackage returns the ZIP import package for the invoices.
+// Invoices without a parsed amount are included as plain documents.
+func buildDATEVPackage(cfg DATEVConfig, invoices []ProcessedInvoice, now time.Time) ([]byte, error) {
+	if cfg.TaxRate == 0 {
+		cfg.TaxRate = 19
+	}
+	archive := datevArchive{
+		Xmlns:            datevDocumentNS,
+		XSI:              xsiNS,
+		SchemaLocation:   datevDocumentNS + " Document_v060.xsd",
+		Version:          "6.0",
+		GeneratingSystem: "apple-invoice-pdf",
+		Date:             now.Format("2006-01-02T15:04:05"),
+		Description:      fmt.Sprintf("%d %s(en)", len(invoices), invoiceNoun(vendorTitle(invoices))),
+	}
+	var files []PDFAttachment
+	for i, inv := range invoices {
+		pdfName := inv.Filename + ".pdf"
+		doc := datevDocument{
+			GUID:        newUUID(),
+			Description: strings.TrimSpace(counterparty(cfg.Supplier, inv) + " " + inv.OrderNumber),
+			Extensions:  []datevExtension{{Type: "File", Name: pdfName}},
+			Repository: []datevLevel{
+				{ID: 1, Name: "Belege"},
+				{ID: 2, Name: "Eingangsrechnungen"},
+				{ID: 3, Name: inv.Email.Date.Format("2006/01")},
+			},
+		}
+		files = append(files, PDFAttachment{Filename: pdfName, Data: inv.PDF, File: inv.PDFFile, Invoice: &invoices[i]})
+
+		if !inv.Total.IsZero() {
+			ledgerName := fmt.Sprintf("ledger-%03d.xml", i+1)
+			data, err := encodeXML(newDATEVLedger(cfg, inv))
+			if err != nil {
+				return nil, err
+			}
+			doc.Extensions = append(doc.Extensions, datevExtension{Type: "accountsPayableLedger", Datafile: ledgerName})
+			files = append(files, PDFAttachment{Filename: ledgerName, Data: data})
+		}
+		archive.Documents = append(archive.Documents, doc)
+	}
+	index, err := encodeXML(archive)
+	if err != nil {
+		return nil, err
+	}
+	files = append([]PDFAttachment{{Filename: "document.xml", Data: index}}, files...)
+	return buildZIP(files, "", now)
+}
+
+// newDATEVLedger describes an invoice as an accounts payable booking proposal.
+func newDATEVLedger(cfg DATEVConfig, inv ProcessedInvoice) datevLedgerImport {
+	amount := fmt.Sprintf("%.2f", inv.Total.Decimal())
+	date := inv.Email.Date.Format("2006-01-02")
+	supplier := counterparty(cfg.Supplier, inv)
+	invoiceID := inv.InvoiceNumber
+	if invoiceID == "" {
+		invoiceID = inv.OrderNumber
+	}
+	return datevLedgerImport{
+		Xmlns:            datevLedgerNS,
+		XSI:              xsiNS,
+		SchemaLocation:   datevLedgerNS + " Belegverwaltung_online_ledger_import_v050.xsd",
+		Version:          "5.0",
+		GeneratorInfo:    "apple-invoice-pdf",
+		GeneratingSystem: "apple-invoice-pdf",
+		Consolidate: datevConsolidate{
+			Amount:    amount,
+			Date:      date,
+			InvoiceID: invoiceID,
+			Currency:  inv.Total.Currency,
+			Ledger: datevPayableLedger{
+				Date:         date,
+				Amount:       amount,
+				Tax:          fmt.Sprintf("%.2f", cfg.TaxRate),
+				CurrencyCode: inv.Total.Currency,
+				InvoiceID:    invoiceID,
+				BookingText:  strings.TrimSpace(supplier + " " + inv.OrderNumber),
+				SupplierName: supplier,
+			},
+		},
+	}
+}
+
+// encodeXML returns v as an indented UTF-8 XML document.
+func encodeXML(v any) ([]byte, error) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding XML: %w", err)
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// writeDATEVPackage stores the package as datev-YYYYMMDD-HHMMSS.zip in dir,
+// creating the directory if needed, and returns the file path.
+func writeDATEVPackage(dir string, generated time.Time, data []byte) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("creating DATEV directory: %w", err)
+	}
+	path := filepath.Join(dir, "datev-"+generated.Format("20060102-150405")+".zip")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("writing DATEV package: %w", err)
+	}
+	return path, nil
+}
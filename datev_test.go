@@ -0,0 +1,90 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildDATEVPackage(t *testing.T) {
+	inv := testProcessedInvoice()
+	inv.Filename = "invoice"
+	inv.PDF = []byte("%PDF")
+	unknown := testProcessedInvoice()
+	unknown.Filename = "unknown"
+	unknown.Total = Amount{}
+	now := time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC)
+
+	data, err := buildDATEVPackage(DATEVConfig{}, []ProcessedInvoice{inv, unknown}, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("reading ZIP: %v", err)
+	}
+	files := map[string]string{}
+	var names []string
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		b, _ := io.ReadAll(rc)
+		files[f.Name] = string(b)
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, ","); got != "document.xml,invoice.pdf,ledger-001.xml,unknown.pdf" {
+		t.Fatalf("files = %s", got)
+	}
+
+	index := files["document.xml"]
+	for _, want := range []string{
+		`<archive xmlns="http://xml.datev.de/bedi/tps/document/v06.0"`,
+		`version="6.0"`,
+		`<date>2024-05-31T12:00:00</date>`,
+		`<extension xsi:type="File" name="invoice.pdf"></extension>`,
+		`<extension xsi:type="accountsPayableLedger" datafile="ledger-001.xml"></extension>`,
+		`<level id="3" name="2024/05"></level>`,
+	} {
+		if !strings.Contains(index, want) {
+			t.Errorf("document.xml missing %s\n%s", want, index)
+		}
+	}
+	if strings.Count(index, "<document ") != 2 || strings.Count(index, "accountsPayableLedger") != 1 {
+		t.Errorf("unexpected documents in index:\n%s", index)
+	}
+
+	var ledger datevLedgerImport
+	if err := xml.Unmarshal([]byte(files["ledger-001.xml"]), &ledger); err != nil {
+		t.Fatalf("parsing ledger: %v", err)
+	}
+	l := ledger.Consolidate.Ledger
+	if l.Amount != "9.99" || l.Date != "2024-05-14" || l.InvoiceID != "DE/2024/0815" || l.CurrencyCode != "EUR" || l.SupplierName != "Apple" || l.Tax != "19.00" {
+		t.Errorf("ledger = %+v", l)
+	}
+}
+
+func TestWriteDATEVPackage(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "datev")
+	path, err := writeDATEVPackage(dir, time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC), []byte("zip"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if filepath.Base(path) != "datev-20240531-120000.zip" {
+		t.Errorf("path = %s", path)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "zip" {
+		t.Errorf("content = %q", data)
+	}
+}
+
+func TestNewUUID(t *testing.T) {
+	id := newUUID()
+	if len(id) != 36 || id[14] != '4' || id == newUUID() {
+		t.Errorf("newUUID() = %q", id)
+	}
+}
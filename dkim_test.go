@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDKIMRelaxedCanonicalization(t *testing.T) {
+	// Example from RFC 6376 section 3.4.5
+	fields := splitHeaderFields([]byte("A: X\r\nB : Y\t\r\n\tZ  \r\n"))
+	var got string
+	for _, f := range fields {
+		got += dkimRelaxedHeader(f)
+	}
+	if got != "a:X\r\nb:Y Z\r\n" {
+		t.Errorf("relaxed header = %q", got)
+	}
+	if body := dkimRelaxedBody([]byte(" C \r\nD \t E\r\n\r\n\r\n")); string(body) != " C\r\nD E\r\n" {
+		t.Errorf("relaxed body = %q", body)
+	}
+	if body := dkimRelaxedBody([]byte("\r\n\r\n")); len(body) != 0 {
+		t.Errorf("empty body = %q", body)
+	}
+}
+
+// verifyDKIM checks the first DKIM-Signature of msg against pub, the way a
+// receiving MTA would.
+func verifyDKIM(t *testing.T, msg []byte, pub crypto.PublicKey) {
+	t.Helper()
+	header, body, _ := bytes.Cut(msg, []byte("\r\n\r\n"))
+	fields := splitHeaderFields(append(header, "\r\n"...))
+	sigField := fields[0]
+	if fieldName(sigField) != "DKIM-Signature" {
+		t.Fatalf("first header is %q", fieldName(sigField))
+	}
+	tags := map[string]string{}
+	_, value, _ := strings.Cut(sigField, ":")
+	for _, tag := range strings.Split(value, ";") {
+		k, v, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(k)] = strings.Join(strings.Fields(v), "")
+	}
+
+	bh := sha256.Sum256(dkimRelaxedBody(body))
+	if tags["bh"] != base64.StdEncoding.EncodeToString(bh[:]) {
+		t.Errorf("body hash mismatch")
+	}
+	var signed strings.Builder
+	for _, name := range strings.Split(tags["h"], ":") {
+		for i := len(fields) - 1; i > 0; i-- {
+			if strings.EqualFold(fieldName(fields[i]), name) {
+				signed.WriteString(dkimRelaxedHeader(fields[i]))
+				break
+			}
+		}
+	}
+	unsigned := regexp.MustCompile(`b=[A-Za-z0-9+/=\s]+$`).ReplaceAllString(sigField, "b=")
+	signed.WriteString(strings.TrimSuffix(dkimRelaxedHeader(unsigned), "\r\n"))
+	hash := sha256.Sum256([]byte(signed.String()))
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		t.Fatalf("decoding b=: %v", err)
+	}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], sig); err != nil {
+			t.Errorf("signature does not verify: %v", err)
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, hash[:], sig) {
+			t.Error("signature does not verify")
+		}
+	}
+}
+
+func TestDKIMSigner_Sign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	msg := []byte("From: Jane <jane@example.com>\r\nTo: books@example.org\r\nSubject: Deine\r\n  PDF-Rechnungen\r\nX-Other: ignored\r\n\r\nDokumente  anbei.\r\n\r\n")
+
+	for name, key := range map[string]crypto.Signer{"rsa": rsaKey, "ed25519": edKey} {
+		t.Run(name, func(t *testing.T) {
+			s := &dkimSigner{domain: "example.com", selector: "mail", key: key, now: func() time.Time { return time.Unix(1715680800, 0) }}
+			out, err := s.Sign(msg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.HasSuffix(out, msg) {
+				t.Error("signed message must end with the original message")
+			}
+			for _, want := range []string{"d=example.com", "s=mail", "t=1715680800", "h=from:to:subject;", "a=" + map[string]string{"rsa": "rsa-sha256", "ed25519": "ed25519-sha256"}[name]} {
+				if !bytes.Contains(out, []byte(want)) {
+					t.Errorf("signature missing %q", want)
+				}
+			}
+			verifyDKIM(t, out, key.Public())
+		})
+	}
+}
+
+func TestNewDKIMSigner_KeyFormats(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"pkcs1.pem": pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+		"pkcs8.pem": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, data, 0600)
+		if _, err := newDKIMSigner(DKIMConfig{Domain: "example.com", Selector: "mail", KeyFile: path}); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	bad := filepath.Join(dir, "bad.pem")
+	os.WriteFile(bad, []byte("nope"), 0600)
+	if _, err := newDKIMSigner(DKIMConfig{Domain: "example.com", Selector: "mail", KeyFile: bad}); err == nil {
+		t.Error("expected error for invalid key file")
+	}
+	if s, err := newDKIMSigner(DKIMConfig{}); s != nil || err != nil {
+		t.Errorf("disabled DKIM = %v, %v", s, err)
+	}
+}
+
+func TestDKIMConfig_Validate(t *testing.T) {
+	if err := (DKIMConfig{}).validate(); err != nil {
+		t.Errorf("empty config: %v", err)
+	}
+	if err := (DKIMConfig{Domain: "example.com"}).validate(); err == nil {
+		t.Error("expected error for incomplete config")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dryRunSink stands in for all configured sinks with --dry-run: it writes
+// the files to a local directory and prints what would have been delivered.
+type dryRunSink struct {
+	dir     string
+	cfg     *Config
+	targets []Sink
+	out     io.Writer
+}
+
+// dryRunSinks returns a copy of cfg whose DATEV and manifest directories
+// point to a new temporary directory and which sends no failure report,
+// writes no audit log and saves failed deliveries to no outbox, plus a
+// dryRunSink writing there in place of targets, the configured sinks.
+func dryRunSinks(cfg *Config, targets []Sink) (*Config, []Sink, error) {
+	dir, err := os.MkdirTemp("", "apple-invoice-pdf-dry-run-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating dry run directory: %w", err)
+	}
+	dry := *cfg
+	if dry.DATEV.Dir != "" {
+		dry.DATEV.Dir = dir
+	}
+	if dry.Manifest.Dir != "" {
+		dry.Manifest.Dir = dir
+	}
+	dry.Report.To = ""
+	dry.Audit.File = ""
+	dry.Outbox.Dir = ""
+	slog.Info("Dry run: nothing will be delivered", "dir", dir)
+	return &dry, []Sink{&dryRunSink{dir: dir, cfg: &dry, targets: targets, out: os.Stdout}}, nil
+}
+
+func (s *dryRunSink) Name() string { return "dry run" }
+
+func (s *dryRunSink) Deliver(_ context.Context, d *Delivery) error {
+	for _, att := range d.Attachments {
+		data, err := att.content()
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", att.Filename, err)
+		}
+		if err := os.WriteFile(filepath.Join(s.dir, att.Filename), data, 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", att.Filename, err)
+		}
+	}
+
+	names := make([]string, 0, len(s.targets))
+	for _, sink := range s.targets {
+		names = append(names, sink.Name())
+	}
+	if len(names) == 0 {
+		names = append(names, "no destination configured")
+	}
+	fmt.Fprintf(s.out, "Would deliver %d file(s) to: %s\n", len(d.Attachments), strings.Join(names, ", "))
+	for _, att := range d.Attachments {
+		fmt.Fprintf(s.out, "  %s (%d bytes)\n", filepath.Join(s.dir, att.Filename), att.size())
+	}
+	if s.cfg.Email.To != "" {
+		subject, err := emailSubject(s.cfg, d.Invoices)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(s.out, "Email to %s: %q\n", s.cfg.Email.To, subject)
+	}
+	return nil
+}
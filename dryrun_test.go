@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDryRunSink_Deliver(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{}
+	cfg.Email.To = "books@example.com"
+	cfg.Email.Subject = "Apple Rechnungen {{.Month}}"
+	var out bytes.Buffer
+	sink := &dryRunSink{dir: dir, cfg: cfg, targets: []Sink{&dirSink{dir: "/archive"}}, out: &out}
+
+	inv := testProcessedInvoice()
+	d := &Delivery{
+		Invoices:    []ProcessedInvoice{inv},
+		Attachments: []PDFAttachment{{Filename: "05_2024_Rechnung_Apple_MXYZ123.pdf", Data: []byte("%PDF-1.4")}},
+	}
+	if err := sink.Deliver(context.Background(), d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "05_2024_Rechnung_Apple_MXYZ123.pdf"))
+	if err != nil || string(data) != "%PDF-1.4" {
+		t.Errorf("file = %q, %v", data, err)
+	}
+	for _, want := range []string{
+		"Would deliver 1 file(s) to: output directory",
+		"05_2024_Rechnung_Apple_MXYZ123.pdf (8 bytes)",
+		`Email to books@example.com: "Apple Rechnungen Mai 2024"`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestDryRunSinks(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	cfg := &Config{}
+	cfg.Outbox.Dir = "/var/lib/apple-invoice-pdf/outbox"
+	cfg.Audit.File = "/var/log/apple-invoice-pdf/audit.jsonl"
+	cfg.Report.To = "admin@example.com"
+	dry, sinks, err := dryRunSinks(cfg, []Sink{&dirSink{dir: "/archive"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dry.Outbox.Dir != "" || dry.Audit.File != "" || dry.Report.To != "" {
+		t.Errorf("dry run config keeps outbox %q, audit log %q, report to %q", dry.Outbox.Dir, dry.Audit.File, dry.Report.To)
+	}
+	if cfg.Outbox.Dir == "" {
+		t.Error("dry run cleared the outbox of the original config")
+	}
+	if len(sinks) != 1 || len(sinks[0].(*dryRunSink).targets) != 1 {
+		t.Errorf("sinks = %v", sinks)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/rummeyer/apple-invoice-pdf/pkg/invoice"
+)
+
+// defaultEmailTemplate renders the HTML email body: a summary table of all
+// invoices. Styles are inline since many mail clients drop <style> blocks.
+const defaultEmailTemplate = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"></head>
+<body style="font-family: -apple-system, Helvetica, Arial, sans-serif; font-size: 14px; color: #1d1d1f;">
+<p>{{.Count}} Rechnung(en) anbei:</p>
+<table style="border-collapse: collapse;">
+<thead><tr>
+<th style="text-align: left; padding: 4px 12px 4px 0; border-bottom: 1px solid #d2d2d7;">Datum</th>
+<th style="text-align: left; padding: 4px 12px 4px 0; border-bottom: 1px solid #d2d2d7;">Bestellnummer</th>
+<th style="text-align: right; padding: 4px 0; border-bottom: 1px solid #d2d2d7;">Betrag</th>
+</tr></thead>
+<tbody>
+{{range .Invoices}}<tr>
+<td style="padding: 4px 12px 4px 0;">{{.Date.Format "02.01.2006"}}</td>
+<td style="padding: 4px 12px 4px 0;">{{.OrderNumber}}</td>
+<td style="padding: 4px 0; text-align: right;">{{.Amount}}</td>
+</tr>
+{{end}}</tbody>
+{{if .Total}}<tfoot><tr>
+<td colspan="2" style="padding: 4px 12px 4px 0; font-weight: 600;">Summe</td>
+<td style="padding: 4px 0; text-align: right; font-weight: 600;">{{.Total}}</td>
+</tr></tfoot>{{end}}
+</table>
+</body></html>
+`
+
+// germanMonths holds the month names used in the {{.Month}} placeholder.
+var germanMonths = [...]string{
+	"Januar", "Februar", "März", "April", "Mai", "Juni",
+	"Juli", "August", "September", "Oktober", "November", "Dezember",
+}
+
+// MessageData holds the placeholders available in the email subject and
+// body templates.
+type MessageData struct {
+	Count    int
+	Month    string // month of the first invoice, e.g. "Mai 2024"
+	Total    string // e.g. "29,97 €", empty if amounts are unknown or in mixed currencies
+	Invoices []FilenameData
+}
+
+// newMessageData collects the run data for the subject and body templates.
+func newMessageData(invoices []ProcessedInvoice) MessageData {
+	data := MessageData{Count: len(invoices)}
+	if len(invoices) > 0 {
+		date := invoices[0].Email.Date
+		data.Month = fmt.Sprintf("%s %d", germanMonths[date.Month()-1], date.Year())
+	}
+	amounts := make([]Amount, 0, len(invoices))
+	for _, inv := range invoices {
+		data.Invoices = append(data.Invoices, newTemplateData(inv))
+		amounts = append(amounts, inv.Total)
+	}
+	if total, ok := invoice.SumAmounts(amounts); ok {
+		data.Total = total.String()
+	}
+	return data
+}
+
+// renderMessageText executes a subject or body template.
+func renderMessageText(name, src string, data MessageData) (string, error) {
+	tmpl, err := template.New(name).Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("parsing email %s template: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("executing email %s template: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// emailSubject renders the configured subject for the given invoices.
+func emailSubject(cfg *Config, invoices []ProcessedInvoice) (string, error) {
+	return renderMessageText("subject", cfg.Email.Subject, newMessageData(invoices))
+}
+
+// headerNamePattern matches valid header field names (RFC 5322 section 3.6.8).
+var headerNamePattern = regexp.MustCompile(`^[!-9;-~]+$`)
+
+// reservedHeaders are set from other options or by the MIME encoder and
+// cannot be overridden with email.headers.
+var reservedHeaders = map[string]bool{
+	"From": true, "To": true, "Cc": true, "Bcc": true, "Subject": true,
+	"Date": true, "Message-Id": true, "Mime-Version": true,
+}
+
+// validateHeaders checks custom header names and values.
+func validateHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		key := textproto.CanonicalMIMEHeaderKey(name)
+		if !headerNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if reservedHeaders[key] || strings.HasPrefix(key, "Content-") {
+			return fmt.Errorf("header %s cannot be set in headers", key)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("header %s contains a line break", key)
+		}
+	}
+	return nil
+}
+
+// messageIDRef returns a Message-Id in angle brackets for use in
+// In-Reply-To and References.
+func messageIDRef(id string) string {
+	id = strings.TrimSpace(id)
+	if !strings.HasPrefix(id, "<") {
+		id = "<" + id + ">"
+	}
+	return id
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// messageParts returns the content of the text/plain and text/html parts of
+// a multipart/alternative body inside a message.
+func messageParts(t *testing.T, raw []byte) map[string]string {
+	t.Helper()
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("parsing message: %v", err)
+	}
+	parts := map[string]string{}
+	var walk func(contentType string, body []byte)
+	walk = func(contentType string, body []byte) {
+		mediaType, params, _ := mime.ParseMediaType(contentType)
+		if !strings.HasPrefix(mediaType, "multipart/") {
+			parts[mediaType] += string(body)
+			return
+		}
+		mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				return
+			}
+			var buf bytes.Buffer
+			buf.ReadFrom(p)
+			walk(p.Header.Get("Content-Type"), buf.Bytes())
+		}
+	}
+	var body bytes.Buffer
+	body.ReadFrom(msg.Body)
+	walk(msg.Header.Get("Content-Type"), body.Bytes())
+	return parts
+}
+
+func TestNewPDFMessage_HTMLSummary(t *testing.T) {
+	cfg := &Config{}
+	cfg.Email.Subject = "Rechnungen"
+	cfg.Email.Body = "Dokumente anbei.\n"
+	cfg.Email.HTML = true
+	invoices := []ProcessedInvoice{testProcessedInvoice(), testProcessedInvoice()}
+	m, err := newPDFMessage(cfg, "a@example.com", "b@example.com", invoices, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	m.WriteTo(&buf)
+	parts := messageParts(t, buf.Bytes())
+
+	if strings.TrimSpace(parts["text/plain"]) != "Dokumente anbei." {
+		t.Errorf("text/plain = %q", parts["text/plain"])
+	}
+	html := parts["text/html"]
+	for _, want := range []string{"2 Rechnung(en)", "14.05.2024", "MXYZ123", "9,99 €", "19,98 €"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("HTML body missing %q", want)
+		}
+	}
+}
+
+func TestNewPDFMessage_CustomHTMLTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "body.html")
+	os.WriteFile(path, []byte(`<p>{{.Title}}: {{.Total}}</p>`), 0644)
+	cfg := &Config{}
+	cfg.Email.Subject = "Rechnungen"
+	cfg.Email.HTML = true
+	cfg.Email.HTMLTemplate = path
+	m, err := newPDFMessage(cfg, "a@example.com", "b@example.com", []ProcessedInvoice{testProcessedInvoice()}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	m.WriteTo(&buf)
+	if got := messageParts(t, buf.Bytes())["text/html"]; got != "<p>Rechnungen: 9,99 €</p>" {
+		t.Errorf("text/html = %q", got)
+	}
+}
+
+func TestNewPDFMessage_PlainByDefault(t *testing.T) {
+	m, _ := newPDFMessage(&Config{}, "a@example.com", "b@example.com", []ProcessedInvoice{testProcessedInvoice()}, nil)
+	var buf bytes.Buffer
+	m.WriteTo(&buf)
+	if _, ok := messageParts(t, buf.Bytes())["text/html"]; ok {
+		t.Error("unexpected HTML part")
+	}
+}
+
+func TestNewPDFMessage_TemplatedSubjectAndBody(t *testing.T) {
+	cfg := &Config{}
+	cfg.Email.Subject = "{{.Count}} Apple-Rechnungen für {{.Month}} ({{.Total}})"
+	cfg.Email.Body = "{{range .Invoices}}{{.OrderNumber}} {{.Amount}}\n{{end}}"
+	invoices := []ProcessedInvoice{testProcessedInvoice(), testProcessedInvoice(), testProcessedInvoice()}
+	m, err := newPDFMessage(cfg, "a@example.com", "b@example.com", invoices, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	m.WriteTo(&buf)
+	msg, _ := mail.ReadMessage(bytes.NewReader(buf.Bytes()))
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if subject != "3 Apple-Rechnungen für Mai 2024 (29,97 €)" {
+		t.Errorf("Subject = %q", subject)
+	}
+	if got := messageParts(t, buf.Bytes())["text/plain"]; strings.Count(got, "MXYZ123 9,99 EUR") != 3 {
+		t.Errorf("text/plain = %q", got)
+	}
+}
+
+func TestNewMessageData_MixedCurrencies(t *testing.T) {
+	usd := testProcessedInvoice()
+	usd.Total = Amount{Cents: 500, Currency: "USD"}
+	data := newMessageData([]ProcessedInvoice{testProcessedInvoice(), usd})
+	if data.Total != "" {
+		t.Errorf("Total = %q, want empty", data.Total)
+	}
+	if data.Count != 2 || data.Month != "Mai 2024" {
+		t.Errorf("data = %+v", data)
+	}
+}
+
+func TestNewPDFMessage_CustomHeaders(t *testing.T) {
+	cfg := &Config{}
+	cfg.Email.ReplyTo = "buchhaltung@example.com"
+	cfg.Email.Headers = map[string]string{"x-auto-response-suppress": "All", "X-Org": "Finance"}
+	m, err := newPDFMessage(cfg, "a@example.com", "b@example.com", []ProcessedInvoice{testProcessedInvoice()}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{"Reply-To": "buchhaltung@example.com", "X-Auto-Response-Suppress": "All", "X-Org": "Finance"}
+	for name, value := range want {
+		if got := m.GetHeader(name); len(got) != 1 || got[0] != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestValidateHeaders(t *testing.T) {
+	tests := []struct {
+		headers map[string]string
+		wantErr bool
+	}{
+		{map[string]string{"X-Auto-Response-Suppress": "All"}, false},
+		{map[string]string{"Organization": "ACME"}, false},
+		{map[string]string{"X Org": "ACME"}, true},
+		{map[string]string{"X-Org:": "ACME"}, true},
+		{map[string]string{"subject": "override"}, true},
+		{map[string]string{"Content-Type": "text/html"}, true},
+		{map[string]string{"X-Org": "ACME\r\nBcc: evil@example.com"}, true},
+	}
+	for _, tt := range tests {
+		if err := validateHeaders(tt.headers); (err != nil) != tt.wantErr {
+			t.Errorf("validateHeaders(%v) error = %v, wantErr %v", tt.headers, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNewPDFMessage_Thread(t *testing.T) {
+	cfg := &Config{}
+	cfg.Email.Thread = true
+	inv := testProcessedInvoice()
+	inv.Email.MessageID = "abc123@apple.com"
+
+	m, _ := newPDFMessage(cfg, "a@example.com", "b@example.com", []ProcessedInvoice{inv}, nil)
+	for _, name := range []string{"In-Reply-To", "References"} {
+		if got := m.GetHeader(name); len(got) != 1 || got[0] != "<abc123@apple.com>" {
+			t.Errorf("%s = %q", name, got)
+		}
+	}
+
+	// A message covering several invoices cannot reply to one of them
+	m, _ = newPDFMessage(cfg, "a@example.com", "b@example.com", []ProcessedInvoice{inv, inv}, nil)
+	if got := m.GetHeader("In-Reply-To"); len(got) != 0 {
+		t.Errorf("In-Reply-To = %q, want none", got)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import "errors"
+
+// Exit statuses, so wrapper scripts and monitoring can tell failure
+// classes apart. Anything not classified below exits with 1.
+const (
+	// exitUsage is used for invalid command line arguments, as by the
+	// flag package.
+	exitUsage = 2
+	// exitLocked means another instance holds the lock; nothing was done.
+	exitLocked = 3
+	// exitNoInvoices means no invoice email matched the filter.
+	exitNoInvoices = 4
+	// exitIMAP means the mailbox could not be read.
+	exitIMAP = 5
+	// exitPDF means converting at least one invoice to PDF failed. The
+	// others were still delivered.
+	exitPDF = 6
+	// exitDelivery means a destination failed permanently, e.g. rejected
+	// the files; retrying will not help.
+	exitDelivery = 7
+	// exitTempFail is used when only temporary delivery failures occurred
+	// (EX_TEMPFAIL from sysexits.h), so wrappers can simply retry later.
+	exitTempFail = 75
+	// exitConfig means the configuration is invalid (EX_CONFIG).
+	exitConfig = 78
+	// exitInterrupted is used after SIGINT or SIGTERM, as set by shells
+	// for SIGINT.
+	exitInterrupted = 130
+)
+
+// exitError assigns an exit status to an error.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// withExitCode wraps err to exit with code, keeping nil errors nil.
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// errNoInvoices is returned by runs that found no matching invoice.
+var errNoInvoices = withExitCode(exitNoInvoices, errors.New("no invoices to process"))
+
+// exitCode maps the error of a run to the process exit status.
+func exitCode(err error) int {
+	var de *DeliveryError
+	var ee *exitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &de):
+		return deliveryExitCode(de.Results)
+	case errors.As(err, &ee):
+		return ee.code
+	}
+	return 1
+}
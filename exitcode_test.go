@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/deliver"
+)
+
+func TestExitCode(t *testing.T) {
+	temporary := deliver.Failed([]SinkResult{{Sink: "email", Err: errors.New("421 try again")}})
+	permanent := deliver.Failed([]SinkResult{{Sink: "email", Err: deliver.Permanent(errors.New("550 rejected"))}})
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, 0},
+		{"temporary delivery failure", fmt.Errorf("run: %w", temporary), exitTempFail},
+		{"permanent delivery failure", permanent, exitDelivery},
+		{"no invoices", errNoInvoices, exitNoInvoices},
+		{"imap", withExitCode(exitIMAP, errors.New("fetching invoices: timeout")), exitIMAP},
+		{"pdf", fmt.Errorf("2024-05: %w", withExitCode(exitPDF, errors.New("1 of 2 invoice(s) could not be converted"))), exitPDF},
+		{"config", withExitCode(exitConfig, errors.New("resume: outbox.dir is not configured")), exitConfig},
+		{"other", errors.New("writing state file: disk full"), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithExitCode_Nil(t *testing.T) {
+	if err := withExitCode(exitIMAP, nil); err != nil {
+		t.Errorf("withExitCode(nil) = %v, want nil", err)
+	}
+}
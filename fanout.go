@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/deliver"
+)
+
+// DeliveryConfig controls how failing sinks are retried.
+type DeliveryConfig = deliver.Config
+
+// SinkResult records the outcome of delivering to one sink.
+type SinkResult = deliver.Result
+
+// DeliveryError reports the sinks that still failed after all retries.
+type DeliveryError = deliver.Error
+
+// retryPolicy is implemented by sinks with their own retry settings.
+type retryPolicy interface {
+	retryConfig(defaults DeliveryConfig) DeliveryConfig
+}
+
+// deliverAll hands the delivery to every sink independently: a failing sink
+// is retried with exponential backoff unless the error is permanent, and
+// never prevents the remaining sinks from running. It returns one result per
+// sink in order.
+func deliverAll(ctx context.Context, sinks []Sink, d *Delivery, defaults DeliveryConfig) []SinkResult {
+	results := make([]SinkResult, 0, len(sinks))
+	for _, sink := range sinks {
+		cfg := defaults
+		if p, ok := sink.(retryPolicy); ok {
+			cfg = p.retryConfig(defaults)
+		}
+		if err := ctx.Err(); err != nil {
+			// Shutting down: leave the sink to the outbox or the next run
+			results = append(results, SinkResult{Sink: sink.Name(), Err: err})
+			continue
+		}
+		ctx, span := startSpan(ctx, "sink", "sink", sink.Name())
+		start := time.Now()
+		res := deliver.Retry(ctx, sink.Name(), cfg, func(ctx context.Context) error {
+			heartbeat(ctx)
+			return sink.Deliver(ctx, d)
+		})
+		if res.Err != nil {
+			metricsFrom(ctx).deliveryFailed(sink.Name())
+		}
+		timingsFrom(ctx).since("deliver_"+strings.ReplaceAll(sink.Name(), " ", "_"), start)
+		span.set("attempts", res.Attempts)
+		span.finish(res.Err)
+		results = append(results, res)
+	}
+	return results
+}
+
+// deliveryExitCode returns the exit status for the results: 0 if all sinks
+// succeeded, exitTempFail if all failures were temporary, and exitDelivery
+// otherwise.
+func deliveryExitCode(results []SinkResult) int {
+	code := 0
+	for _, res := range results {
+		switch {
+		case res.Err == nil:
+		case deliver.IsPermanent(res.Err):
+			return exitDelivery
+		default:
+			code = exitTempFail
+		}
+	}
+	return code
+}
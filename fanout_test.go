@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/deliver"
+)
+
+// fakeSink fails the first failures calls to Deliver.
+type fakeSink struct {
+	name     string
+	failures int
+	calls    int
+}
+
+func (s *fakeSink) Name() string { return s.name }
+
+func (s *fakeSink) Deliver(context.Context, *Delivery) error {
+	s.calls++
+	if s.calls <= s.failures {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func TestDeliverAll(t *testing.T) {
+	flaky := &fakeSink{name: "flaky", failures: 1}
+	broken := &fakeSink{name: "broken", failures: 100}
+	ok := &fakeSink{name: "ok"}
+	cfg := DeliveryConfig{Attempts: 3, RetryDelay: time.Millisecond}
+
+	results := deliverAll(context.Background(), []Sink{flaky, broken, ok}, &Delivery{}, cfg)
+
+	want := []struct {
+		attempts int
+		failed   bool
+	}{{2, false}, {3, true}, {1, false}}
+	for i, w := range want {
+		if results[i].Attempts != w.attempts || (results[i].Err != nil) != w.failed {
+			t.Errorf("%s: attempts=%d err=%v", results[i].Sink, results[i].Attempts, results[i].Err)
+		}
+	}
+	if ok.calls != 1 {
+		t.Error("failing sink prevented later sinks from running")
+	}
+	err := deliver.Failed(results)
+	if err == nil || !strings.Contains(err.Error(), "1 of 3") || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("deliveryError = %v", err)
+	}
+}
+
+// cancelSink cancels the run while delivering, like a signal would.
+type cancelSink struct {
+	fakeSink
+	cancel context.CancelFunc
+}
+
+func (s *cancelSink) Deliver(ctx context.Context, d *Delivery) error {
+	s.cancel()
+	return s.fakeSink.Deliver(ctx, d)
+}
+
+func TestDeliverAll_CancelStopsRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broken := &cancelSink{fakeSink: fakeSink{name: "broken", failures: 100}, cancel: cancel}
+	next := &fakeSink{name: "next"}
+	results := deliverAll(ctx, []Sink{broken, next}, &Delivery{}, DeliveryConfig{Attempts: 5, RetryDelay: time.Hour})
+	if broken.calls != 1 || results[0].Err == nil {
+		t.Errorf("calls=%d err=%v", broken.calls, results[0].Err)
+	}
+	// Remaining sinks are skipped once cancelled
+	if next.calls != 0 || !errors.Is(results[1].Err, context.Canceled) {
+		t.Errorf("next: calls=%d err=%v", next.calls, results[1].Err)
+	}
+}
+
+func TestDeliveryError_AllSucceeded(t *testing.T) {
+	if err := deliver.Failed([]SinkResult{{Sink: "email", Attempts: 1}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// permanentSink always fails with a permanent error.
+type permanentSink struct{ calls int }
+
+func (s *permanentSink) Name() string { return "rejected" }
+
+func (s *permanentSink) Deliver(context.Context, *Delivery) error {
+	s.calls++
+	return deliver.Permanent(errors.New("550 mailbox unavailable"))
+}
+
+func TestDeliverAll_PermanentErrorNotRetried(t *testing.T) {
+	sink := &permanentSink{}
+	results := deliverAll(context.Background(), []Sink{sink}, &Delivery{}, DeliveryConfig{Attempts: 3, RetryDelay: time.Millisecond})
+	if sink.calls != 1 || results[0].Attempts != 1 {
+		t.Errorf("calls=%d attempts=%d, want a single attempt", sink.calls, results[0].Attempts)
+	}
+}
+
+func TestDeliverAll_SinkRetryPolicy(t *testing.T) {
+	cfg := &Config{}
+	cfg.SMTP.Retry = DeliveryConfig{Attempts: 5}
+	got := (&emailSink{cfg: cfg}).retryConfig(DeliveryConfig{Attempts: 3, RetryDelay: time.Second})
+	if got.Attempts != 5 || got.RetryDelay != time.Second {
+		t.Errorf("retryConfig = %+v", got)
+	}
+}
+
+func TestDeliveryExitCode(t *testing.T) {
+	temporary := errors.New("421 try again later")
+	permanent := deliver.Permanent(errors.New("550 rejected"))
+	tests := []struct {
+		name    string
+		results []SinkResult
+		want    int
+	}{
+		{"success", []SinkResult{{Sink: "email"}}, 0},
+		{"temporary", []SinkResult{{Sink: "email", Err: temporary}, {Sink: "s3"}}, exitTempFail},
+		{"permanent", []SinkResult{{Sink: "email", Err: permanent}}, exitDelivery},
+		{"mixed", []SinkResult{{Sink: "s3", Err: temporary}, {Sink: "email", Err: permanent}}, exitDelivery},
+	}
+	for _, tt := range tests {
+		if got := deliveryExitCode(tt.results); got != tt.want {
+			t.Errorf("%s: deliveryExitCode = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
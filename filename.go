@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// defaultFilenameTemplate reproduces the MM_YYYY_Rechnung_Apple_BESTELLNUMMER naming.
+const defaultFilenameTemplate = "{{.Month}}_{{.Year}}_Rechnung_{{.Vendor}}_{{.OrderNumber}}"
+
+// filenamePresets maps preset names to filename templates producing names
+// that common archiving systems parse automatically.
+var filenamePresets = map[string]string{
+	"default": defaultFilenameTemplate,
+	// ISO 8601 date prefix, sorts chronologically in any file listing
+	"iso": "{{.Year}}-{{.Month}}-{{.Day}}_Rechnung_{{.Vendor}}_{{.OrderNumber}}",
+	// Matches paperless-ngx's default title/date parsing, e.g.
+	// "2024-05-14 Apple Rechnung MXXXXX 9,99 EUR"
+	"paperless": "{{.Year}}-{{.Month}}-{{.Day}} {{.Vendor}} Rechnung {{.OrderNumber}} {{.Amount}}",
+	// DATEV Belegbilderservice: YYYYMMDD_Lieferant_Belegnummer
+	"datev": "{{.Year}}{{.Month}}{{.Day}}_{{.Vendor}}_{{.OrderNumber}}",
+}
+
+// amountSuffix is appended to the filename template by filename.include_amount.
+const amountSuffix = "{{if .Amount}}_{{.AmountValue}}_{{.Currency}}{{end}}"
+
+// withAmount appends the invoice total to a filename template unless the
+// template already references it.
+func withAmount(tmpl string) string {
+	if strings.Contains(tmpl, ".Amount") {
+		return tmpl
+	}
+	return tmpl + amountSuffix
+}
+
+// FilenameData holds the placeholders available in filename templates.
+// Text fields are already sanitized for use in filenames.
+type FilenameData struct {
+	Year          string
+	Month         string
+	Day           string
+	OrderNumber   string
+	InvoiceNumber string
+	Amount        string // e.g. "9,99 EUR", empty if unknown
+	AmountValue   string // e.g. "9,99", empty if unknown
+	Currency      string // ISO code, e.g. "EUR", empty if unknown
+	AppleID       string
+	Subject       string
+	Vendor        string // e.g. "Apple", see VendorProfile.Title
+}
+
+// SanitizePolicy controls which characters survive in generated filenames.
+type SanitizePolicy struct {
+	// Charset is "german" (ASCII plus umlauts, the default), "unicode"
+	// (all letters and digits) or "ascii" (transliterated to plain ASCII).
+	Charset string `yaml:"charset"`
+	// MaxLength limits the filename (without extension) to this many
+	// characters; 0 means no limit.
+	MaxLength int `yaml:"max_length"`
+}
+
+var (
+	unicodeUnsafeChars = regexp.MustCompile(`[^\p{L}\p{M}\p{N}\-_ ]+`)
+	asciiUnsafeChars   = regexp.MustCompile(`[^a-zA-Z0-9\-_ ]+`)
+)
+
+// transliterations covers letters that do not decompose into an ASCII base
+// letter plus combining marks.
+var transliterations = strings.NewReplacer(
+	"ä", "ae", "ö", "oe", "ü", "ue", "Ä", "Ae", "Ö", "Oe", "Ü", "Ue", "ß", "ss",
+	"æ", "ae", "Æ", "Ae", "œ", "oe", "Œ", "Oe", "ø", "o", "Ø", "O",
+	"ł", "l", "Ł", "L", "đ", "d", "Đ", "D", "þ", "th", "Þ", "Th",
+)
+
+// validate checks the policy for unknown charsets and negative lengths.
+func (sp SanitizePolicy) validate() error {
+	switch sp.Charset {
+	case "", "german", "unicode", "ascii":
+	default:
+		return fmt.Errorf("unknown sanitize charset %q", sp.Charset)
+	}
+	if sp.MaxLength < 0 {
+		return fmt.Errorf("sanitize max_length must not be negative")
+	}
+	return nil
+}
+
+// override returns the policy of a delivery target, target if set and sp
+// otherwise.
+func (sp SanitizePolicy) override(target *SanitizePolicy) SanitizePolicy {
+	if target != nil {
+		return *target
+	}
+	return sp
+}
+
+// Sanitize replaces characters not allowed by the policy with underscores.
+// Returns "invoice" if nothing usable remains.
+func (sp SanitizePolicy) Sanitize(s string) string {
+	switch sp.Charset {
+	case "unicode":
+		s = unicodeUnsafeChars.ReplaceAllString(norm.NFC.String(s), "_")
+	case "ascii":
+		s = transliterate(s)
+		s = asciiUnsafeChars.ReplaceAllString(s, "_")
+	default:
+		return sanitizeFilename(s)
+	}
+	if s = strings.TrimSpace(s); s == "" {
+		s = "invoice"
+	}
+	return s
+}
+
+// Truncate shortens name to MaxLength characters, trimming trailing
+// separators left over from the cut.
+func (sp SanitizePolicy) Truncate(name string) string {
+	runes := []rune(name)
+	if sp.MaxLength == 0 || len(runes) <= sp.MaxLength {
+		return name
+	}
+	return strings.TrimRight(string(runes[:sp.MaxLength]), " _-")
+}
+
+// transliterate maps s to ASCII: known ligatures and umlauts are spelled
+// out, other accented letters lose their diacritics (é → e).
+func transliterate(s string) string {
+	s = transliterations.Replace(norm.NFC.String(s))
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// newTemplateData builds the placeholders for a processed invoice with the
+// values as parsed, for templates that do not end up in filenames
+// (titles, tags, messages).
+func newTemplateData(p ProcessedInvoice) FilenameData {
+	d := FilenameData{
+		Year:          fmt.Sprintf("%04d", p.Email.Date.Year()),
+		Month:         fmt.Sprintf("%02d", p.Email.Date.Month()),
+		Day:           fmt.Sprintf("%02d", p.Email.Date.Day()),
+		OrderNumber:   p.OrderNumber,
+		InvoiceNumber: p.InvoiceNumber,
+		// Use the ISO code since currency symbols are not filename-safe
+		Amount:   strings.Replace(p.Total.String(), "€", "EUR", 1),
+		Currency: p.Total.Currency,
+		AppleID:  p.AppleID,
+		Subject:  normalizeSubject(p.Email.Subject),
+		Vendor:   p.vendor().Title,
+	}
+	if !p.Total.IsZero() {
+		d.AmountValue, _, _ = strings.Cut(p.Total.String(), " ")
+	}
+	return d
+}
+
+// newFilenameData builds the template placeholders for a processed invoice,
+// sanitizing all text fields with policy.
+func newFilenameData(p ProcessedInvoice, policy SanitizePolicy) FilenameData {
+	d := newTemplateData(p)
+	d.Subject = policy.Sanitize(d.Subject)
+	d.Vendor = policy.Sanitize(d.Vendor)
+	for _, field := range []*string{&d.OrderNumber, &d.InvoiceNumber, &d.AppleID} {
+		if *field != "" {
+			*field = policy.Sanitize(*field)
+		}
+	}
+	return d
+}
+
+// renderFilename executes the filename template for an invoice and returns
+// the base name without extension. Path separators are replaced so the
+// template cannot escape the target directory.
+func renderFilename(tmplText string, p ProcessedInvoice, policy SanitizePolicy) (string, error) {
+	tmpl, err := template.New("filename").Option("missingkey=error").Parse(tmplText)
+	if err != nil {
+		return "", fmt.Errorf("parsing filename template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, newFilenameData(p, policy)); err != nil {
+		return "", fmt.Errorf("executing filename template: %w", err)
+	}
+	name := strings.NewReplacer("/", "_", "\\", "_").Replace(strings.TrimSpace(buf.String()))
+	if name = policy.Truncate(name); name == "" {
+		return "", fmt.Errorf("filename template produced an empty name")
+	}
+	return name, nil
+}
+
+// executeTemplate renders tmpl with data into a string.
+func executeTemplate(tmpl *template.Template, data any) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("executing %s template: %w", tmpl.Name(), err)
+	}
+	return buf.String(), nil
+}
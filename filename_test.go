@@ -0,0 +1,168 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func testProcessedInvoice() ProcessedInvoice {
+	return ProcessedInvoice{
+		Email: InvoiceEmail{
+			Subject: "Deine Rechnung von Apple",
+			Date:    time.Date(2024, 5, 14, 10, 0, 0, 0, time.UTC),
+		},
+		OrderNumber:   "MXYZ123",
+		InvoiceNumber: "DE/2024/0815",
+		AppleID:       "jane@example.com",
+		Total:         Amount{Cents: 999, Currency: "EUR"},
+	}
+}
+
+func TestRenderFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		tmpl string
+		want string
+	}{
+		{"default", defaultFilenameTemplate, "05_2024_Rechnung_Apple_MXYZ123"},
+		{"iso date", "{{.Year}}-{{.Month}}-{{.Day}} {{.OrderNumber}}", "2024-05-14 MXYZ123"},
+		{"amount", "{{.OrderNumber}} {{.Amount}}", "MXYZ123 9,99 EUR"},
+		{"amount parts", "{{.AmountValue}}{{.Currency}}", "9,99EUR"},
+		{"invoice number sanitized", "{{.InvoiceNumber}}", "DE_2024_0815"},
+		{"apple id", "{{.AppleID}}", "jane_example_com"},
+		{"subject", "{{.Subject}}", "Deine Rechnung von Apple"},
+		{"path separators", "{{.Year}}/{{.Month}}", "2024_05"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderFilename(tt.tmpl, testProcessedInvoice(), SanitizePolicy{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("renderFilename(%q) = %q, want %q", tt.tmpl, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderFilename_Errors(t *testing.T) {
+	for _, tmpl := range []string{"{{.Unknown}}", "{{.Year", "  "} {
+		if _, err := renderFilename(tmpl, testProcessedInvoice(), SanitizePolicy{}); err == nil {
+			t.Errorf("renderFilename(%q): expected error", tmpl)
+		}
+	}
+}
+
+func TestRenderFilename_Presets(t *testing.T) {
+	tests := map[string]string{
+		"default":   "05_2024_Rechnung_Apple_MXYZ123",
+		"iso":       "2024-05-14_Rechnung_Apple_MXYZ123",
+		"paperless": "2024-05-14 Apple Rechnung MXYZ123 9,99 EUR",
+		"datev":     "20240514_Apple_MXYZ123",
+	}
+	for preset, want := range tests {
+		t.Run(preset, func(t *testing.T) {
+			got, err := renderFilename(filenamePresets[preset], testProcessedInvoice(), SanitizePolicy{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != want {
+				t.Errorf("preset %q = %q, want %q", preset, got, want)
+			}
+		})
+	}
+	if len(filenamePresets) != len(tests) {
+		t.Errorf("%d presets defined, %d tested", len(filenamePresets), len(tests))
+	}
+}
+
+func TestWithAmount(t *testing.T) {
+	got, err := renderFilename(withAmount(defaultFilenameTemplate), testProcessedInvoice(), SanitizePolicy{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "05_2024_Rechnung_Apple_MXYZ123_9,99_EUR"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Unknown amounts leave the name untouched
+	inv := testProcessedInvoice()
+	inv.Total = Amount{}
+	got, err = renderFilename(withAmount(defaultFilenameTemplate), inv, SanitizePolicy{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "05_2024_Rechnung_Apple_MXYZ123"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Templates already containing the amount are not changed
+	if tmpl := filenamePresets["paperless"]; withAmount(tmpl) != tmpl {
+		t.Errorf("withAmount changed template already containing the amount")
+	}
+}
+
+func TestSanitizePolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy SanitizePolicy
+		input  string
+		want   string
+	}{
+		{"german default", SanitizePolicy{}, "Facture Noël für Łukasz", "Facture No_l für _ukasz"},
+		{"german explicit", SanitizePolicy{Charset: "german"}, "Rechnung März", "Rechnung März"},
+		{"unicode", SanitizePolicy{Charset: "unicode"}, "Facture Noël für Łukasz #1", "Facture Noël für Łukasz _1"},
+		{"ascii", SanitizePolicy{Charset: "ascii"}, "Facture Noël für Łukasz", "Facture Noel fuer Lukasz"},
+		{"ascii ligatures", SanitizePolicy{Charset: "ascii"}, "Œuvre Straße ø", "Oeuvre Strasse o"},
+		{"ascii empty", SanitizePolicy{Charset: "ascii"}, "!!!", "_"},
+		{"unicode blank", SanitizePolicy{Charset: "unicode"}, "  ", "invoice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Sanitize(tt.input); got != tt.want {
+				t.Errorf("Sanitize(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizePolicy_Truncate(t *testing.T) {
+	policy := SanitizePolicy{MaxLength: 10}
+	if got := policy.Truncate("Rechnung März 2024"); got != "Rechnung M" {
+		t.Errorf("Truncate() = %q, want %q", got, "Rechnung M")
+	}
+	if got := policy.Truncate("Rechnung_ März"); got != "Rechnung" {
+		t.Errorf("Truncate() = %q, want trailing separators trimmed", got)
+	}
+	if got := (SanitizePolicy{}).Truncate("unlimited name"); got != "unlimited name" {
+		t.Errorf("Truncate() without limit = %q", got)
+	}
+	if got := (SanitizePolicy{MaxLength: 4}).Truncate("äöüß-x"); got != "äöüß" {
+		t.Errorf("Truncate() = %q, want rune-based cut", got)
+	}
+}
+
+func TestSanitizePolicy_Validate(t *testing.T) {
+	if err := (SanitizePolicy{Charset: "klingon"}).validate(); err == nil {
+		t.Error("expected error for unknown charset")
+	}
+	if err := (SanitizePolicy{MaxLength: -1}).validate(); err == nil {
+		t.Error("expected error for negative max length")
+	}
+	if err := (SanitizePolicy{Charset: "ascii", MaxLength: 80}).validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRenderFilename_Policy(t *testing.T) {
+	inv := testProcessedInvoice()
+	inv.OrderNumber = "Noël-42"
+	got, err := renderFilename("{{.OrderNumber}}_{{.Subject}}", inv, SanitizePolicy{Charset: "ascii", MaxLength: 15})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "Noel-42_Deine R"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
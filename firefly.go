@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// FireflyConfig configures transactions in Firefly III.
+type FireflyConfig struct {
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+	// SourceAccountID is the asset account the invoices are paid from.
+	SourceAccountID string   `yaml:"source_account_id"`
+	Destination     string   `yaml:"destination"`
+	Category        string   `yaml:"category"`
+	Tags            []string `yaml:"tags"`
+}
+
+// fireflySink creates a withdrawal per invoice and attaches the PDF to it.
+// Firefly III rejects duplicates by hash, so retried runs do not book twice.
+type fireflySink struct {
+	cfg    FireflyConfig
+	client *http.Client
+}
+
+// newFireflySink applies defaults and checks required settings.
+func newFireflySink(cfg FireflyConfig) (*fireflySink, error) {
+	if cfg.SourceAccountID == "" {
+		return nil, fmt.Errorf("firefly: source_account_id is required")
+	}
+	cfg.URL = strings.TrimRight(cfg.URL, "/")
+	return &fireflySink{cfg: cfg, client: newAPIClient()}, nil
+}
+
+func (s *fireflySink) Name() string { return "firefly" }
+
+func (s *fireflySink) Deliver(ctx context.Context, d *Delivery) error {
+	for _, inv := range d.Invoices {
+		if inv.Total.IsZero() {
+			slog.Info("Skipping invoice for Firefly III: no amount found", "file", inv.Filename+".pdf")
+			continue
+		}
+		journalID, err := s.createTransaction(ctx, inv)
+		if err != nil {
+			if strings.Contains(err.Error(), "Duplicate of transaction") {
+				slog.Info("Skipping invoice for Firefly III: transaction already exists", "file", inv.Filename+".pdf")
+				continue
+			}
+			return fmt.Errorf("firefly: creating transaction for %s: %w", inv.Filename, err)
+		}
+		if err := s.attach(ctx, journalID, inv); err != nil {
+			return fmt.Errorf("firefly: attaching %s: %w", inv.Filename, err)
+		}
+		slog.Info("Created Firefly III transaction", "file", inv.Filename+".pdf")
+	}
+	return nil
+}
+
+// newFireflyTransaction builds the withdrawal split for an invoice.
+func newFireflyTransaction(cfg FireflyConfig, inv ProcessedInvoice) map[string]any {
+	split := map[string]any{
+		"type":             "withdrawal",
+		"date":             inv.Email.Date.Format("2006-01-02"),
+		"amount":           fmt.Sprintf("%.2f", inv.Total.Decimal()),
+		"currency_code":    inv.Total.Currency,
+		"description":      strings.TrimSpace(inv.vendor().Title + " " + inv.OrderNumber),
+		"source_id":        cfg.SourceAccountID,
+		"destination_name": counterparty(cfg.Destination, inv),
+		"external_id":      inv.Email.MessageID,
+		"notes":            inv.Email.Subject,
+	}
+	if inv.InvoiceNumber != "" {
+		split["internal_reference"] = inv.InvoiceNumber
+	}
+	if cfg.Category != "" {
+		split["category_name"] = cfg.Category
+	}
+	if len(cfg.Tags) > 0 {
+		split["tags"] = cfg.Tags
+	}
+	return map[string]any{
+		"error_if_duplicate_hash": true,
+		"apply_rules":             true,
+		"transactions":            []map[string]any{split},
+	}
+}
+
+// createTransaction returns the ID of the created transaction journal,
+// which attachments are linked to.
+func (s *fireflySink) createTransaction(ctx context.Context, inv ProcessedInvoice) (string, error) {
+	var resp struct {
+		Data struct {
+			Attributes struct {
+				Transactions []struct {
+					JournalID string `json:"transaction_journal_id"`
+				} `json:"transactions"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := doJSON(ctx, s.client, http.MethodPost, s.cfg.URL+"/api/v1/transactions", s.header(), newFireflyTransaction(s.cfg, inv), &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Data.Attributes.Transactions) == 0 {
+		return "", fmt.Errorf("no transaction journal in response")
+	}
+	return resp.Data.Attributes.Transactions[0].JournalID, nil
+}
+
+// attach creates an attachment record for the journal and uploads the PDF.
+func (s *fireflySink) attach(ctx context.Context, journalID string, inv ProcessedInvoice) error {
+	meta := map[string]string{
+		"filename":        inv.Filename + ".pdf",
+		"attachable_type": "TransactionJournal",
+		"attachable_id":   journalID,
+	}
+	var created struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := doJSON(ctx, s.client, http.MethodPost, s.cfg.URL+"/api/v1/attachments", s.header(), meta, &created); err != nil {
+		return err
+	}
+	data, err := inv.readPDF()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL+"/api/v1/attachments/"+created.Data.ID+"/upload", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header = s.header()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return doRequest(s.client, req, nil)
+}
+
+func (s *fireflySink) header() http.Header {
+	return http.Header{"Authorization": {"Bearer " + s.cfg.Token}}
+}
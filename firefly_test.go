@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFireflySink_Deliver(t *testing.T) {
+	var tx struct {
+		ErrorIfDuplicate bool             `json:"error_if_duplicate_hash"`
+		Transactions     []map[string]any `json:"transactions"`
+	}
+	var meta map[string]string
+	var upload string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer pat" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/transactions":
+			json.NewDecoder(r.Body).Decode(&tx)
+			w.Write([]byte(`{"data":{"id":"1","attributes":{"transactions":[{"transaction_journal_id":"11"}]}}}`))
+		case "/api/v1/attachments":
+			json.NewDecoder(r.Body).Decode(&meta)
+			w.Write([]byte(`{"data":{"id":"5"}}`))
+		case "/api/v1/attachments/5/upload":
+			data, _ := io.ReadAll(r.Body)
+			upload = string(data)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	sink, err := newFireflySink(FireflyConfig{URL: srv.URL, Token: "pat", SourceAccountID: "3", Category: "Software", Tags: []string{"apple"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	inv := testProcessedInvoice()
+	inv.Filename = "invoice"
+	inv.PDF = []byte("%PDF")
+	if err := sink.Deliver(context.Background(), &Delivery{Invoices: []ProcessedInvoice{inv}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	split := tx.Transactions[0]
+	if !tx.ErrorIfDuplicate || split["type"] != "withdrawal" || split["amount"] != "9.99" || split["date"] != "2024-05-14" {
+		t.Errorf("transaction = %v", split)
+	}
+	if split["source_id"] != "3" || split["destination_name"] != "Apple" || split["category_name"] != "Software" {
+		t.Errorf("accounts = %v", split)
+	}
+	if meta["attachable_id"] != "11" || meta["attachable_type"] != "TransactionJournal" || meta["filename"] != "invoice.pdf" {
+		t.Errorf("attachment = %v", meta)
+	}
+	if upload != "%PDF" {
+		t.Errorf("upload = %q", upload)
+	}
+}
+
+func TestFireflySink_SkipsDuplicates(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/transactions" {
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"message":"Duplicate of transaction #1."}`))
+	}))
+	defer srv.Close()
+
+	sink, _ := newFireflySink(FireflyConfig{URL: srv.URL, SourceAccountID: "3"})
+	if err := sink.Deliver(context.Background(), &Delivery{Invoices: []ProcessedInvoice{testProcessedInvoice()}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
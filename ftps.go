@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// FTPSConfig configures uploads to a legacy FTP server over TLS.
+type FTPSConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	// Implicit uses TLS from the first byte (usually port 990) instead of
+	// upgrading with AUTH TLS.
+	Implicit           bool   `yaml:"implicit"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	Path               string `yaml:"path"`
+	// Sanitize replaces filename.sanitize for the remote paths if set.
+	Sanitize *SanitizePolicy `yaml:"sanitize"`
+}
+
+// ftpsSink uploads every attachment below a templated remote directory.
+type ftpsSink struct {
+	cfg      FTPSConfig
+	dir      *template.Template
+	sanitize SanitizePolicy
+	now      func() time.Time
+}
+
+// newFTPSSink applies defaults and parses the remote path template.
+func newFTPSSink(cfg FTPSConfig, sanitize SanitizePolicy) (*ftpsSink, error) {
+	if cfg.Port == 0 {
+		cfg.Port = 21
+		if cfg.Implicit {
+			cfg.Port = 990
+		}
+	}
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("ftps: parsing path template: %w", err)
+	}
+	return &ftpsSink{cfg: cfg, dir: tmpl, sanitize: sanitize, now: time.Now}, nil
+}
+
+func (s *ftpsSink) Name() string { return "ftps" }
+
+func (s *ftpsSink) Deliver(ctx context.Context, d *Delivery) error {
+	c, err := dialFTPS(ctx, s.cfg)
+	if err != nil {
+		return fmt.Errorf("ftps: %w", err)
+	}
+	defer c.quit()
+
+	for _, att := range d.Attachments {
+		remote, err := remotePath(s.dir, att, s.now(), s.sanitize)
+		if err != nil {
+			return fmt.Errorf("ftps: %w", err)
+		}
+		data, err := att.content()
+		if err != nil {
+			return fmt.Errorf("ftps: reading %s: %w", att.Filename, err)
+		}
+		c.mkdirAll(path.Dir(remote))
+		if err := c.store(ctx, remote, data); err != nil {
+			return fmt.Errorf("ftps: uploading %s: %w", remote, err)
+		}
+		slog.Info("Uploaded file via FTPS", "path", remote, "host", s.cfg.Host)
+	}
+	return nil
+}
+
+// ftpsConn is a minimal FTP-over-TLS client for binary uploads.
+type ftpsConn struct {
+	text    *textproto.Conn
+	host    string
+	tlsConf *tls.Config
+}
+
+// dialFTPS connects, secures the control channel, logs in, and switches to
+// binary mode with a protected data channel.
+func dialFTPS(ctx context.Context, cfg FTPSConfig) (*ftpsConn, error) {
+	tlsConf := &tls.Config{
+		ServerName:         cfg.Host,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		// Many servers require the data connection to resume the control
+		// connection's TLS session
+		ClientSessionCache: tls.NewLRUClientSessionCache(4),
+	}
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	if cfg.Implicit {
+		conn = tls.Client(conn, tlsConf)
+	}
+	c := &ftpsConn{text: textproto.NewConn(conn), host: cfg.Host, tlsConf: tlsConf}
+	if _, _, err := c.text.ReadResponse(220); err != nil {
+		c.text.Close()
+		return nil, fmt.Errorf("greeting: %w", err)
+	}
+	if !cfg.Implicit {
+		if _, err := c.cmd(234, "AUTH TLS"); err != nil {
+			c.text.Close()
+			return nil, err
+		}
+		c.text = textproto.NewConn(tls.Client(conn, tlsConf))
+	}
+
+	code, err := c.cmd(0, "USER %s", cfg.User)
+	if err == nil && code == 331 {
+		_, err = c.cmd(230, "PASS %s", cfg.Password)
+	} else if err == nil && code != 230 {
+		err = fmt.Errorf("USER: unexpected response %d", code)
+	}
+	for _, cmd := range []string{"PBSZ 0", "PROT P", "TYPE I"} {
+		if err != nil {
+			break
+		}
+		_, err = c.cmd(200, "%s", cmd)
+	}
+	if err != nil {
+		c.text.Close()
+		return nil, fmt.Errorf("login: %w", err)
+	}
+	return c, nil
+}
+
+// cmd sends a command and reads the response, checking it against
+// expectCode (0 accepts any reply).
+func (c *ftpsConn) cmd(expectCode int, format string, args ...any) (int, error) {
+	line := fmt.Sprintf(format, args...)
+	if err := c.text.PrintfLine("%s", line); err != nil {
+		return 0, err
+	}
+	code, _, err := c.text.ReadResponse(expectCode)
+	if err != nil {
+		// Only name the verb so PASS arguments never end up in errors
+		verb, _, _ := strings.Cut(line, " ")
+		return code, fmt.Errorf("%s: %w", verb, err)
+	}
+	return code, nil
+}
+
+// epsvPattern extracts the port from an EPSV reply like "(|||6446|)".
+var epsvPattern = regexp.MustCompile(`\(\|\|\|(\d+)\|\)`)
+
+// openData enters extended passive mode and opens the TLS data connection.
+func (c *ftpsConn) openData(ctx context.Context) (net.Conn, error) {
+	if err := c.text.PrintfLine("EPSV"); err != nil {
+		return nil, err
+	}
+	_, msg, err := c.text.ReadResponse(229)
+	if err != nil {
+		return nil, fmt.Errorf("EPSV: %w", err)
+	}
+	m := epsvPattern.FindStringSubmatch(msg)
+	if m == nil {
+		return nil, fmt.Errorf("EPSV: cannot parse %q", msg)
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(c.host, m[1]))
+	if err != nil {
+		return nil, fmt.Errorf("opening data connection: %w", err)
+	}
+	return tls.Client(conn, c.tlsConf), nil
+}
+
+// store uploads data to the remote file name.
+func (c *ftpsConn) store(ctx context.Context, name string, data []byte) error {
+	dataConn, err := c.openData(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.text.PrintfLine("STOR %s", name); err != nil {
+		dataConn.Close()
+		return err
+	}
+	if _, _, err := c.text.ReadResponse(1); err != nil {
+		dataConn.Close()
+		return fmt.Errorf("STOR: %w", err)
+	}
+	_, err = dataConn.Write(data)
+	if closeErr := dataConn.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing data: %w", err)
+	}
+	if _, _, err := c.text.ReadResponse(2); err != nil {
+		return fmt.Errorf("STOR: %w", err)
+	}
+	return nil
+}
+
+// mkdirAll creates dir and its parents, ignoring errors for directories
+// that already exist.
+func (c *ftpsConn) mkdirAll(dir string) {
+	if dir == "." || dir == "/" || dir == "" {
+		return
+	}
+	var current string
+	if strings.HasPrefix(dir, "/") {
+		current = "/"
+	}
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		current = path.Join(current, part)
+		c.cmd(257, "MKD %s", current)
+	}
+}
+
+// quit ends the session and closes the control connection.
+func (c *ftpsConn) quit() {
+	c.cmd(221, "QUIT")
+	c.text.Close()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed certificate for 127.0.0.1.
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// fakeFTPS is an implicit-TLS FTP server accepting a single session.
+type fakeFTPS struct {
+	ln    net.Listener
+	tls   *tls.Config
+	mu    sync.Mutex
+	dirs  []string
+	files map[string]string
+}
+
+func (f *fakeFTPS) serve(t *testing.T) {
+	conn, err := f.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...any) { fmt.Fprintf(conn, format+"\r\n", args...) }
+	reply("220 ready")
+
+	var dataLn net.Listener
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch verb {
+		case "USER":
+			reply("331 password required")
+		case "PASS":
+			if arg != "secret" {
+				reply("530 login incorrect")
+				continue
+			}
+			reply("230 logged in")
+		case "PBSZ", "PROT", "TYPE":
+			reply("200 ok")
+		case "MKD":
+			f.mu.Lock()
+			f.dirs = append(f.dirs, arg)
+			f.mu.Unlock()
+			reply("257 created")
+		case "EPSV":
+			dataLn, _ = tls.Listen("tcp", "127.0.0.1:0", f.tls)
+			reply("229 Entering Extended Passive Mode (|||%d|)", dataLn.Addr().(*net.TCPAddr).Port)
+		case "STOR":
+			reply("150 opening data connection")
+			dc, err := dataLn.Accept()
+			if err != nil {
+				t.Errorf("accepting data connection: %v", err)
+				return
+			}
+			data, _ := io.ReadAll(dc)
+			dc.Close()
+			dataLn.Close()
+			f.mu.Lock()
+			f.files[arg] = string(data)
+			f.mu.Unlock()
+			reply("226 transfer complete")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func startFakeFTPS(t *testing.T) (*fakeFTPS, int) {
+	t.Helper()
+	tlsConf := &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", tlsConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeFTPS{ln: ln, tls: tlsConf, files: map[string]string{}}
+	go f.serve(t)
+	return f, ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestFTPSSink_Deliver(t *testing.T) {
+	server, port := startFakeFTPS(t)
+	sink, err := newFTPSSink(FTPSConfig{
+		Host: "127.0.0.1", Port: port, User: "u", Password: "secret",
+		Implicit: true, InsecureSkipVerify: true, Path: "/in/{{.Year}}",
+	}, SanitizePolicy{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	inv := testProcessedInvoice()
+	d := &Delivery{Attachments: []PDFAttachment{{Filename: "a.pdf", Data: []byte("%PDF"), Invoice: &inv}}}
+	if err := sink.Deliver(context.Background(), d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if got := server.files["/in/2024/a.pdf"]; got != "%PDF" {
+		t.Errorf("uploaded files = %v", server.files)
+	}
+	if strings.Join(server.dirs, ",") != "/in,/in/2024" {
+		t.Errorf("dirs = %v, want [/in /in/2024]", server.dirs)
+	}
+}
+
+func TestFTPSSink_LoginFailure(t *testing.T) {
+	_, port := startFakeFTPS(t)
+	sink, _ := newFTPSSink(FTPSConfig{Host: "127.0.0.1", Port: port, Password: "wrong", Implicit: true, InsecureSkipVerify: true}, SanitizePolicy{})
+	err := sink.Deliver(context.Background(), &Delivery{})
+	if err == nil || !strings.Contains(err.Error(), "PASS") || strings.Contains(err.Error(), "wrong") {
+		t.Errorf("expected PASS error without the password, got %v", err)
+	}
+}
+
+func TestFTPSSink_RejectsUntrustedCertificate(t *testing.T) {
+	_, port := startFakeFTPS(t)
+	sink, _ := newFTPSSink(FTPSConfig{Host: "127.0.0.1", Port: port, Password: "secret", Implicit: true}, SanitizePolicy{})
+	if err := sink.Deliver(context.Background(), &Delivery{}); err == nil {
+		t.Error("expected certificate verification error")
+	}
+}
+
+func TestNewFTPSSink_DefaultPort(t *testing.T) {
+	explicit, _ := newFTPSSink(FTPSConfig{Host: "h"}, SanitizePolicy{})
+	implicit, _ := newFTPSSink(FTPSConfig{Host: "h", Implicit: true}, SanitizePolicy{})
+	if explicit.cfg.Port != 21 || implicit.cfg.Port != 990 {
+		t.Errorf("ports = %d/%d, want 21/990", explicit.cfg.Port, implicit.cfg.Port)
+	}
+}
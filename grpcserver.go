@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/rummeyer/apple-invoice-pdf/pkg/invoicepb"
+)
+
+// GRPCConfig configures the gRPC service of daemon mode, see
+// pkg/invoicepb/invoice.proto.
+type GRPCConfig struct {
+	// Listen is the address (e.g. ":9090"); empty disables the service.
+	Listen string `yaml:"listen"`
+	// Token, if set, must be sent as "authorization: Bearer <token>"
+	// metadata with every call.
+	Token string `yaml:"token"`
+	// CertFile and KeyFile enable TLS if both are set.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// pdfChunkSize is the size of the chunks GetPDF streams.
+const pdfChunkSize = 64 << 10
+
+// invoiceService implements invoicepb.InvoiceServiceServer. Runs are
+// handed to the scheduler loop through requests.
+type invoiceService struct {
+	invoicepb.UnimplementedInvoiceServiceServer
+	cfg      *Config
+	requests chan<- runRequest
+}
+
+// serveGRPC listens on cfg.Daemon.GRPC.Listen and serves the invoice
+// service until ctx is cancelled. Listen and TLS errors are returned
+// immediately.
+func serveGRPC(ctx context.Context, cfg *Config, requests chan<- runRequest) error {
+	srv, err := newGRPCServer(cfg, requests)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", cfg.Daemon.GRPC.Listen)
+	if err != nil {
+		return fmt.Errorf("grpc: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Stop()
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			slog.Error("gRPC service failed", "err", err)
+		}
+	}()
+	slog.Info("Serving gRPC", "addr", ln.Addr().String(), "tls", cfg.Daemon.GRPC.CertFile != "")
+	return nil
+}
+
+// newGRPCServer returns a server with the invoice service registered and
+// TLS and token authentication set up as configured.
+func newGRPCServer(cfg *Config, requests chan<- runRequest) (*grpc.Server, error) {
+	gc := cfg.Daemon.GRPC
+	var opts []grpc.ServerOption
+	if gc.CertFile != "" && gc.KeyFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(gc.CertFile, gc.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("grpc: %w", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	if gc.Token != "" {
+		opts = append(opts,
+			grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+				if err := checkToken(ctx, gc.Token); err != nil {
+					return nil, err
+				}
+				return handler(ctx, req)
+			}),
+			grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				if err := checkToken(ss.Context(), gc.Token); err != nil {
+					return err
+				}
+				return handler(srv, ss)
+			}),
+		)
+	}
+	srv := grpc.NewServer(opts...)
+	invoicepb.RegisterInvoiceServiceServer(srv, &invoiceService{cfg: cfg, requests: requests})
+	return srv, nil
+}
+
+// checkToken verifies the bearer token in the metadata of ctx.
+func checkToken(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		got, ok := strings.CutPrefix(v, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid token")
+}
+
+// grpcMonth parses an optional YYYY-MM month of a request.
+func grpcMonth(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	month, err := parseMonth(s)
+	if err != nil {
+		return time.Time{}, status.Errorf(codes.InvalidArgument, "month: %v", err)
+	}
+	return month, nil
+}
+
+// grpcTime converts t to a timestamp, nil for the zero time.
+func grpcTime(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// grpcContextError converts the error of a cancelled stream context.
+func grpcContextError(ctx context.Context) error {
+	return status.FromContextError(ctx.Err()).Err()
+}
+
+func (s *invoiceService) TriggerRun(req *invoicepb.TriggerRunRequest, stream grpc.ServerStreamingServer[invoicepb.RunEvent]) error {
+	month, err := grpcMonth(req.GetMonth())
+	if err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	r := runRequest{
+		Options: RunOptions{Month: month, DryRun: req.GetDryRun()},
+		started: make(chan struct{}),
+		done:    make(chan *RunReport, 1),
+	}
+	select {
+	case s.requests <- r:
+	case <-ctx.Done():
+		return grpcContextError(ctx)
+	}
+	if err := stream.Send(&invoicepb.RunEvent{Type: invoicepb.RunEvent_TYPE_QUEUED}); err != nil {
+		return err
+	}
+	select {
+	case <-r.started:
+	case <-ctx.Done():
+		return grpcContextError(ctx)
+	}
+	if err := stream.Send(&invoicepb.RunEvent{Type: invoicepb.RunEvent_TYPE_STARTED}); err != nil {
+		return err
+	}
+	var report *RunReport
+	select {
+	case report = <-r.done:
+	case <-ctx.Done():
+		return grpcContextError(ctx)
+	}
+	if report == nil {
+		return status.Error(codes.Internal, "run finished without a report")
+	}
+	for _, inv := range report.Invoices {
+		event := &invoicepb.RunEvent{
+			Type: invoicepb.RunEvent_TYPE_INVOICE,
+			Invoice: &invoicepb.Invoice{
+				MessageId:   inv.MessageID,
+				OrderNumber: inv.OrderNumber,
+				Subject:     inv.Subject,
+				Date:        grpcTime(inv.Date),
+				Filename:    inv.Filename,
+				Total:       inv.Total,
+				Status:      inv.Status,
+				Error:       inv.Error,
+			},
+		}
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+	}
+	return stream.Send(&invoicepb.RunEvent{
+		Type:     invoicepb.RunEvent_TYPE_FINISHED,
+		Status:   report.Status,
+		ExitCode: int32(report.ExitCode),
+		Error:    report.Error,
+	})
+}
+
+func (s *invoiceService) ListInvoices(_ context.Context, req *invoicepb.ListInvoicesRequest) (*invoicepb.ListInvoicesResponse, error) {
+	month, err := grpcMonth(req.GetMonth())
+	if err != nil {
+		return nil, err
+	}
+	store, err := loadState(s.cfg.State.File)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &invoicepb.ListInvoicesResponse{}
+	for _, e := range store.recent() {
+		if !month.IsZero() && e.month().Format("2006-01") != month.Format("2006-01") {
+			continue
+		}
+		if req.GetStatus() != "" && e.Status != req.GetStatus() {
+			continue
+		}
+		resp.Invoices = append(resp.Invoices, &invoicepb.Invoice{
+			MessageId:   e.MessageID,
+			OrderNumber: e.OrderNumber,
+			Date:        grpcTime(e.Date),
+			Filename:    e.Filename,
+			Total:       e.Total,
+			Status:      e.Status,
+			Updated:     grpcTime(e.Updated),
+		})
+	}
+	return resp, nil
+}
+
+func (s *invoiceService) GetPDF(req *invoicepb.GetPDFRequest, stream grpc.ServerStreamingServer[invoicepb.PDFChunk]) error {
+	if req.GetMessageId() == "" && req.GetOrderNumber() == "" {
+		return status.Error(codes.InvalidArgument, "message_id or order_number is required")
+	}
+	month, err := grpcMonth(req.GetMonth())
+	if err != nil {
+		return err
+	}
+	if month.IsZero() {
+		store, err := loadState(s.cfg.State.File)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		entry, ok := store.lookup(req.GetMessageId(), "", req.GetOrderNumber())
+		if !ok {
+			return status.Error(codes.NotFound, "invoice not in the state file, set month")
+		}
+		month = entry.month()
+	}
+
+	ctx := stream.Context()
+	pipeline, err := buildProcessing(s.cfg, nil)
+	if err != nil {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	emails, err := pipeline.Source.Fetch(ctx, month)
+	if err != nil {
+		return status.Error(codes.Unavailable, redactError(err))
+	}
+	var matches []InvoiceEmail
+	for _, e := range emails {
+		if (req.GetMessageId() != "" && e.MessageID == req.GetMessageId()) ||
+			(req.GetOrderNumber() != "" && s.cfg.detectVendor(e).orderNumber(e.HTMLBody) == req.GetOrderNumber()) {
+			matches = append(matches, e)
+			break
+		}
+	}
+	if len(matches) == 0 {
+		return status.Errorf(codes.NotFound, "invoice not found in %s", month.Format("2006-01"))
+	}
+	processed, failures := processInvoices(ctx, s.cfg, pipeline.Transformers, matches)
+	if len(failures) > 0 {
+		return status.Error(codes.Internal, redactError(failures[0].Err))
+	}
+	if len(processed) == 0 {
+		return grpcContextError(ctx)
+	}
+
+	filename, data := processed[0].Filename+".pdf", processed[0].PDF
+	for {
+		n := min(len(data), pdfChunkSize)
+		if err := stream.Send(&invoicepb.PDFChunk{Filename: filename, Data: data[:n]}); err != nil {
+			return err
+		}
+		filename, data = "", data[n:]
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/rummeyer/apple-invoice-pdf/pkg/invoicepb"
+)
+
+// grpcClient serves the invoice service in memory and returns a client.
+func grpcClient(t *testing.T, cfg *Config, requests chan<- runRequest) invoicepb.InvoiceServiceClient {
+	t.Helper()
+	srv, err := newGRPCServer(cfg, requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := bufconn.Listen(1 << 20)
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return invoicepb.NewInvoiceServiceClient(conn)
+}
+
+func TestGRPC_ListInvoices(t *testing.T) {
+	cfg := &Config{State: StateConfig{File: filepath.Join(t.TempDir(), "processed.json")}}
+	store, _ := loadState(cfg.State.File)
+	march := time.Date(2024, 3, 15, 10, 0, 0, 0, time.Local)
+	err := store.record([]ProcessedInvoice{
+		{Email: InvoiceEmail{MessageID: "<a@apple.com>", Date: march}, OrderNumber: "A1", Filename: "a", Total: Amount{Cents: 1299, Currency: "EUR"}},
+		{Email: InvoiceEmail{MessageID: "<b@apple.com>", Date: march.AddDate(0, 1, 0)}, OrderNumber: "B2", Filename: "b"},
+	}, sinkStatus(stateDelivered), march)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := grpcClient(t, cfg, nil)
+
+	resp, err := client.ListInvoices(context.Background(), &invoicepb.ListInvoicesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Invoices) != 2 {
+		t.Fatalf("got %d invoices, want 2", len(resp.Invoices))
+	}
+	resp, err = client.ListInvoices(context.Background(), &invoicepb.ListInvoicesRequest{Month: "2024-03"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Invoices) != 1 || resp.Invoices[0].OrderNumber != "A1" || resp.Invoices[0].Total == "" || !resp.Invoices[0].Date.AsTime().Equal(march) {
+		t.Errorf("March invoices = %v", resp.Invoices)
+	}
+
+	_, err = client.ListInvoices(context.Background(), &invoicepb.ListInvoicesRequest{Month: "March"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("invalid month: err = %v, want InvalidArgument", err)
+	}
+}
+
+func TestGRPC_TriggerRun(t *testing.T) {
+	requests := make(chan runRequest, 1)
+	client := grpcClient(t, &Config{}, requests)
+
+	// Play the scheduler loop of runDaemon
+	go func() {
+		req := <-requests
+		if req.Options.Month.Format("2006-01") != "2024-03" || !req.Options.DryRun {
+			t.Errorf("request options = %+v", req.Options)
+		}
+		req.start()
+		req.finish(&RunReport{
+			Status:   "failed",
+			ExitCode: exitTempFail,
+			Error:    "1 of 1 delivery target(s) failed",
+			Invoices: []RunReportInvoice{{MessageID: "<a@apple.com>", OrderNumber: "A1", Status: stateFailed}},
+		})
+	}()
+
+	stream, err := client.TriggerRun(context.Background(), &invoicepb.TriggerRunRequest{Month: "2024-03", DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []*invoicepb.RunEvent
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	want := []invoicepb.RunEvent_Type{
+		invoicepb.RunEvent_TYPE_QUEUED,
+		invoicepb.RunEvent_TYPE_STARTED,
+		invoicepb.RunEvent_TYPE_INVOICE,
+		invoicepb.RunEvent_TYPE_FINISHED,
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %v", len(events), len(want), events)
+	}
+	for i, event := range events {
+		if event.Type != want[i] {
+			t.Errorf("event %d = %v, want %v", i, event.Type, want[i])
+		}
+	}
+	if events[2].Invoice.GetOrderNumber() != "A1" {
+		t.Errorf("invoice event = %v", events[2])
+	}
+	if last := events[3]; last.Status != "failed" || last.ExitCode != exitTempFail || last.Error == "" {
+		t.Errorf("finished event = %v", last)
+	}
+}
+
+func TestGRPC_GetPDFRequiresInvoice(t *testing.T) {
+	cfg := &Config{State: StateConfig{File: filepath.Join(t.TempDir(), "processed.json")}}
+	client := grpcClient(t, cfg, nil)
+
+	stream, _ := client.GetPDF(context.Background(), &invoicepb.GetPDFRequest{})
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("without invoice: err = %v, want InvalidArgument", err)
+	}
+	stream, _ = client.GetPDF(context.Background(), &invoicepb.GetPDFRequest{OrderNumber: "A1"})
+	if _, err := stream.Recv(); status.Code(err) != codes.NotFound {
+		t.Errorf("unknown invoice: err = %v, want NotFound", err)
+	}
+}
+
+func TestGRPC_Token(t *testing.T) {
+	cfg := &Config{Daemon: DaemonConfig{GRPC: GRPCConfig{Token: "s3cret-token"}}}
+	client := grpcClient(t, cfg, nil)
+
+	if _, err := client.ListInvoices(context.Background(), &invoicepb.ListInvoicesRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("without token: err = %v, want Unauthenticated", err)
+	}
+	stream, _ := client.TriggerRun(context.Background(), &invoicepb.TriggerRunRequest{})
+	if _, err := stream.Recv(); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("stream without token: err = %v, want Unauthenticated", err)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer s3cret-token")
+	if _, err := client.ListInvoices(ctx, &invoicepb.ListInvoicesRequest{}); err != nil {
+		t.Errorf("with token: %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// daemonHealth tracks scheduled runs for the /healthz and /readyz
+// endpoints of daemon mode.
+type daemonHealth struct {
+	mu          sync.Mutex
+	ready       bool
+	running     bool
+	started     time.Time
+	nextRun     time.Time
+	lastRun     time.Time
+	lastSuccess time.Time
+	lastError   string
+}
+
+// HealthStatus is the JSON body of the health endpoints.
+type HealthStatus struct {
+	Status      string     `json:"status"`
+	Started     time.Time  `json:"started"`
+	Running     bool       `json:"running"`
+	NextRun     *time.Time `json:"next_run,omitempty"`
+	LastRun     *time.Time `json:"last_run,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+func newDaemonHealth(now time.Time) *daemonHealth {
+	return &daemonHealth{started: now}
+}
+
+// setReady marks the daemon as (not) accepting scheduled runs.
+func (h *daemonHealth) setReady(ready bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ready = ready
+}
+
+// scheduled records the time of the next run.
+func (h *daemonHealth) scheduled(next time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextRun = next
+}
+
+// runStarted marks a run as in progress.
+func (h *daemonHealth) runStarted() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = true
+}
+
+// runFinished records the outcome of a run; err is nil on success. The
+// error is redacted since it is served over HTTP.
+func (h *daemonHealth) runFinished(now time.Time, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = false
+	h.lastRun = now
+	if err != nil {
+		h.lastError = redactError(err)
+		return
+	}
+	h.lastSuccess = now
+	h.lastError = ""
+}
+
+// snapshot returns the current status. Status is "failing" if the last run
+// failed and "ok" otherwise, including before the first run.
+func (h *daemonHealth) snapshot() HealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := HealthStatus{
+		Status:      "ok",
+		Started:     h.started,
+		Running:     h.running,
+		NextRun:     optionalTime(h.nextRun),
+		LastRun:     optionalTime(h.lastRun),
+		LastSuccess: optionalTime(h.lastSuccess),
+		LastError:   h.lastError,
+	}
+	if h.lastError != "" {
+		s.Status = "failing"
+	}
+	return s
+}
+
+// optionalTime returns nil for the zero time so it is omitted from JSON.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// ServeHTTP answers /healthz with 503 while the last run failed, and
+// /readyz with 503 until the scheduler runs and again while shutting down.
+func (h *daemonHealth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := h.snapshot()
+	code := http.StatusOK
+	switch r.URL.Path {
+	case "/healthz":
+		if status.Status != "ok" {
+			code = http.StatusServiceUnavailable
+		}
+	case "/readyz":
+		h.mu.Lock()
+		ready := h.ready
+		h.mu.Unlock()
+		if !ready {
+			code = http.StatusServiceUnavailable
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// serveHealth listens on addr and serves the health and metrics endpoints
+// and the dashboard of h until ctx is cancelled. Listen errors are returned immediately.
+func serveHealth(ctx context.Context, addr string, h http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("health endpoint: %w", err)
+	}
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Health endpoint failed", "err", err)
+		}
+	}()
+	slog.Info("Serving HTTP endpoints", "addr", ln.Addr().String())
+	return nil
+}
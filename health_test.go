@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func healthRequest(t *testing.T, h *daemonHealth, path string) (int, HealthStatus) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	var status HealthStatus
+	if rec.Code != http.StatusNotFound {
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatalf("%s: decoding body: %v", path, err)
+		}
+	}
+	return rec.Code, status
+}
+
+func TestDaemonHealth(t *testing.T) {
+	start := time.Date(2024, 5, 1, 6, 0, 0, 0, time.UTC)
+	h := newDaemonHealth(start)
+
+	if code, _ := healthRequest(t, h, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz before start = %d, want 503", code)
+	}
+	if code, status := healthRequest(t, h, "/healthz"); code != http.StatusOK || status.LastRun != nil {
+		t.Errorf("/healthz before first run = %d %+v", code, status)
+	}
+
+	h.setReady(true)
+	h.scheduled(start.Add(time.Hour))
+	if code, status := healthRequest(t, h, "/readyz"); code != http.StatusOK || !status.NextRun.Equal(start.Add(time.Hour)) {
+		t.Errorf("/readyz = %d %+v", code, status)
+	}
+
+	h.runStarted()
+	h.runFinished(start.Add(time.Hour), errors.New(`fetching invoices: Get "https://imap.example.com/?token=s3cr3t": timeout`))
+	code, status := healthRequest(t, h, "/healthz")
+	if code != http.StatusServiceUnavailable || status.Status != "failing" || status.LastError != `fetching invoices: Get "https://imap.example.com/?token=[REDACTED]": timeout` || status.LastSuccess != nil {
+		t.Errorf("/healthz after failure = %d %+v", code, status)
+	}
+
+	h.runFinished(start.Add(2*time.Hour), nil)
+	code, status = healthRequest(t, h, "/healthz")
+	if code != http.StatusOK || status.LastError != "" || !status.LastSuccess.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("/healthz after success = %d %+v", code, status)
+	}
+
+	if code, _ := healthRequest(t, h, "/other"); code != http.StatusNotFound {
+		t.Errorf("/other = %d, want 404", code)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/htmlclean"
+)
+
+// defaultImageUserAgent is sent when downloading images without
+// images.user_agent; some CDNs reject Go's default.
+const defaultImageUserAgent = "Mozilla/5.0 (compatible; apple-invoice-pdf)"
+
+// ImagesConfig controls how the external images of invoices are downloaded
+// for embedding.
+type ImagesConfig struct {
+	// Timeout bounds each download, htmlclean.DefaultImageTimeout if zero.
+	Timeout time.Duration `yaml:"timeout"`
+	// Attempts is the maximum number of tries per image; network errors,
+	// 429 and 5xx responses are retried.
+	Attempts int `yaml:"attempts"`
+	// MaxSize is the largest image in bytes that is embedded.
+	MaxSize int64 `yaml:"max_size"`
+	// UserAgent replaces defaultImageUserAgent.
+	UserAgent string `yaml:"user_agent"`
+	// CacheDir keeps downloaded images across runs for CacheTTL,
+	// htmlclean.DefaultCacheTTL if zero; empty disables the cache.
+	CacheDir string        `yaml:"cache_dir"`
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// Tracking lists URL parts of further tracking images to remove
+	// besides htmlclean.TrackingURLs.
+	Tracking []string `yaml:"tracking"`
+	// Allow, if set, lists the only hosts images are embedded or loaded
+	// from, and Block hosts whose images are removed, see
+	// htmlclean.MatchHost.
+	Allow []string `yaml:"allow"`
+	Block []string `yaml:"block"`
+	// Workers is the number of images of an invoice downloaded at a time,
+	// htmlclean.DefaultWorkers if zero.
+	Workers int `yaml:"workers"`
+	// External leaves external images in the HTML for Chrome to load
+	// while rendering instead of embedding them.
+	External bool `yaml:"external"`
+}
+
+// trackingURLs returns the URL parts of the tracking images to remove.
+func (c ImagesConfig) trackingURLs() []string {
+	return slices.Concat(htmlclean.TrackingURLs, c.Tracking)
+}
+
+// newImageFetcher returns the fetcher, with its own HTTP client, shared by
+// all image downloads of a pipeline, or nil with images.external.
+func newImageFetcher(cfg ImagesConfig) *htmlclean.ImageFetcher {
+	if cfg.External {
+		return nil
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = htmlclean.DefaultImageTimeout
+	}
+	userAgent := cfg.UserAgent
+	if userAgent == "" {
+		userAgent = defaultImageUserAgent
+	}
+	return &htmlclean.ImageFetcher{
+		// Redirects must not lead to hosts the image itself could not be
+		// loaded from
+		Client:    &http.Client{Timeout: timeout, CheckRedirect: htmlclean.CheckRedirect(cfg.Allow, cfg.Block)},
+		UserAgent: userAgent,
+		Attempts:  cfg.Attempts,
+		MaxSize:   cfg.MaxSize,
+		CacheDir:  cfg.CacheDir,
+		CacheTTL:  cfg.CacheTTL,
+	}
+}
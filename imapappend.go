@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// IMAPAppendConfig configures storing the generated message in a folder of
+// the IMAP account instead of (or in addition to) sending it via SMTP.
+type IMAPAppendConfig struct {
+	Folder string `yaml:"folder"`
+	// Seen marks the stored message as read.
+	Seen bool `yaml:"seen"`
+}
+
+// imapAppendSink APPENDs one message with all attachments to a folder,
+// creating the folder if needed.
+type imapAppendSink struct {
+	cfg  *Config
+	dial func(ctx context.Context) (*client.Client, error)
+	now  func() time.Time
+}
+
+func newIMAPAppendSink(cfg *Config) *imapAppendSink {
+	return &imapAppendSink{
+		cfg:  cfg,
+		dial: func(ctx context.Context) (*client.Client, error) { return dialIMAP(ctx, cfg) },
+		now:  time.Now,
+	}
+}
+
+func (s *imapAppendSink) Name() string { return "imap_append" }
+
+func (s *imapAppendSink) Deliver(ctx context.Context, d *Delivery) error {
+	// Without SMTP the addresses are optional; fall back to the account itself
+	from, to := s.cfg.Email.From, s.cfg.Email.To
+	if from == "" {
+		from = s.cfg.User
+	}
+	if to == "" {
+		to = s.cfg.User
+	}
+	attachments, err := emailAttachments(s.cfg, d)
+	if err != nil {
+		return err
+	}
+	m, err := newPDFMessage(s.cfg, from, to, d.Invoices, attachments)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		return fmt.Errorf("building message: %w", err)
+	}
+
+	c, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+
+	folder := s.cfg.IMAPAppend.Folder
+	// CREATE fails if the folder exists; a real problem surfaces on APPEND
+	slog.Debug("IMAP command", "command", "CREATE", "mailbox", folder)
+	c.Create(folder)
+	var flags []string
+	if s.cfg.IMAPAppend.Seen {
+		flags = append(flags, imap.SeenFlag)
+	}
+	slog.Debug("IMAP command", "command", "APPEND", "mailbox", folder, "bytes", buf.Len())
+	if err := c.Append(folder, flags, s.now(), &buf); err != nil {
+		return fmt.Errorf("appending to %s: %w", folder, err)
+	}
+	slog.Info("Stored message in IMAP folder", "attachments", len(attachments), "folder", folder)
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+)
+
+func TestIMAPAppendSink_Deliver(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server.New(memory.New())
+	srv.AllowInsecureAuth = true
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	dial := func(context.Context) (*client.Client, error) {
+		c, err := client.Dial(ln.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		return c, c.Login("username", "password")
+	}
+	cfg := &Config{User: "jane@example.com"}
+	cfg.Email.Subject = "Apple Rechnungen"
+	cfg.IMAPAppend = IMAPAppendConfig{Folder: "Archive/Rechnungen", Seen: true}
+	sink := newIMAPAppendSink(cfg)
+	sink.dial = dial
+	sink.now = func() time.Time { return time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC) }
+
+	d := &Delivery{Attachments: []PDFAttachment{{Filename: "invoice.pdf", Data: []byte("%PDF")}}}
+	if err := sink.Deliver(context.Background(), d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c, err := dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Logout()
+	mbox, err := c.Select("Archive/Rechnungen", true)
+	if err != nil {
+		t.Fatalf("selecting folder: %v", err)
+	}
+	if mbox.Messages != 1 {
+		t.Fatalf("folder has %d messages, want 1", mbox.Messages)
+	}
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(1)
+	section := &imap.BodySectionName{}
+	messages := make(chan *imap.Message, 1)
+	if err := c.Fetch(seqSet, []imap.FetchItem{imap.FetchFlags, section.FetchItem()}, messages); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-messages
+	if len(msg.Flags) != 1 || msg.Flags[0] != imap.SeenFlag {
+		t.Errorf("flags = %v", msg.Flags)
+	}
+	raw, _ := io.ReadAll(msg.GetBody(section))
+	for _, want := range []string{"Subject: Apple Rechnungen", "From: jane@example.com", `filename="invoice.pdf"`} {
+		if !strings.Contains(string(raw), want) {
+			t.Errorf("message missing %q", want)
+		}
+	}
+}
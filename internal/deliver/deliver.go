@@ -0,0 +1,119 @@
+// Package deliver retries deliveries to a destination and reports their
+// outcome.
+package deliver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Config controls how failing deliveries are retried.
+type Config struct {
+	// Attempts is the maximum number of tries per sink (1 disables retries).
+	Attempts int `yaml:"attempts"`
+	// RetryDelay is the wait before the first retry; it doubles after
+	// every further failure.
+	RetryDelay time.Duration `yaml:"retry_delay"`
+}
+
+// Result records the outcome of delivering to one sink.
+type Result struct {
+	Sink     string
+	Attempts int
+	Err      error
+}
+
+// permanentError marks a failure that retrying cannot fix, such as a
+// recipient rejected by the mail server.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as a failure that is not retried.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err is marked as permanent.
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// Retry calls deliver until it succeeds, up to cfg.Attempts times, with
+// exponential backoff. Permanent errors and cancelling ctx stop retrying.
+// sink names the destination in log output and the result.
+func Retry(ctx context.Context, sink string, cfg Config, deliver func(context.Context) error) Result {
+	attempts := max(cfg.Attempts, 1)
+	res := Result{Sink: sink}
+	delay := cfg.RetryDelay
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			slog.Info("Retrying delivery", "sink", sink, "delay", delay, "attempt", attempt, "attempts", attempts)
+			if !Sleep(ctx, delay) {
+				break
+			}
+			delay *= 2
+		}
+		res.Attempts = attempt
+		res.Err = deliver(ctx)
+		if res.Err == nil {
+			break
+		}
+		if IsPermanent(res.Err) {
+			slog.Error("Delivery failed permanently, not retrying", "sink", sink, "err", res.Err)
+			break
+		}
+		slog.Error("Delivery failed", "sink", sink, "attempt", attempt, "err", res.Err)
+	}
+	return res
+}
+
+// Sleep waits for d and reports false if ctx was cancelled first.
+func Sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Error reports the sinks that still failed after all retries.
+type Error struct {
+	Results []Result
+	// Outbox is the outbox entry holding the undelivered files, if saved.
+	Outbox string
+}
+
+func (e *Error) Error() string {
+	var failed []string
+	for _, res := range e.Results {
+		if res.Err != nil {
+			failed = append(failed, res.Sink)
+		}
+	}
+	msg := fmt.Sprintf("%d of %d delivery target(s) failed: %v", len(failed), len(e.Results), failed)
+	if e.Outbox != "" {
+		msg += ", saved to outbox " + e.Outbox
+	}
+	return msg
+}
+
+// Failed returns an *Error if any sink failed, nil otherwise.
+func Failed(results []Result) error {
+	for _, res := range results {
+		if res.Err != nil {
+			return &Error{Results: results}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package deliver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRetry_StopsOnPermanentError(t *testing.T) {
+	calls := 0
+	res := Retry(context.Background(), "email", Config{Attempts: 3}, func(context.Context) error {
+		calls++
+		return fmt.Errorf("sending: %w", Permanent(errors.New("550 rejected")))
+	})
+	if calls != 1 || res.Attempts != 1 || !IsPermanent(res.Err) {
+		t.Errorf("calls = %d, result = %+v", calls, res)
+	}
+}
+
+func TestFailed(t *testing.T) {
+	if err := Failed([]Result{{Sink: "email", Attempts: 1}}); err != nil {
+		t.Errorf("Failed = %v, want nil", err)
+	}
+	err := Failed([]Result{{Sink: "email"}, {Sink: "s3", Err: errors.New("timeout")}})
+	if err == nil || err.Error() != "1 of 2 delivery target(s) failed: [s3]" {
+		t.Errorf("Failed = %v", err)
+	}
+}
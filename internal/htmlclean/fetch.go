@@ -0,0 +1,218 @@
+package htmlclean
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Defaults of ImageFetcher.
+const (
+	DefaultImageTimeout = 30 * time.Second
+	DefaultMaxImageSize = 10 << 20
+	DefaultRetryDelay   = time.Second
+	DefaultCacheTTL     = 30 * 24 * time.Hour
+)
+
+// ErrHostNotAllowed is returned, wrapped, by clients refusing to follow a
+// redirect to a host that is not allowed, see CheckRedirect. Downloads
+// failing with it are not retried.
+var ErrHostNotAllowed = errors.New("host is not allowed")
+
+// CheckRedirect returns an http.Client.CheckRedirect function that follows
+// up to 10 redirects, like the default, but only to the hosts AllowedURL
+// accepts for allow and block.
+func CheckRedirect(allow, block []string) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if !AllowedURL(req.URL.String(), allow, block) {
+			return fmt.Errorf("redirect to %s: %w", req.URL.Host, ErrHostNotAllowed)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+}
+
+// defaultImageClient is the client of fetchers without one.
+var defaultImageClient = &http.Client{Timeout: DefaultImageTimeout}
+
+// ImageFetcher downloads external images for embedding. The zero value,
+// and a nil *ImageFetcher, tries once with DefaultImageTimeout and
+// DefaultMaxImageSize.
+type ImageFetcher struct {
+	// Client is shared by all downloads; nil means a client with
+	// DefaultImageTimeout.
+	Client *http.Client
+	// UserAgent is sent with every request if set.
+	UserAgent string
+	// Attempts is the maximum number of tries per image; network errors,
+	// 429 and 5xx responses are retried.
+	Attempts int
+	// RetryDelay is the wait before the second try, growing linearly
+	// with each further one; DefaultRetryDelay if zero.
+	RetryDelay time.Duration
+	// MaxSize is the largest image in bytes; DefaultMaxImageSize if zero.
+	MaxSize int64
+	// CacheDir, if set, keeps downloaded images as files named by the
+	// SHA-256 of their URL. Entries younger than CacheTTL (DefaultCacheTTL
+	// if zero) are used without a request, older ones when the download
+	// fails.
+	CacheDir string
+	CacheTTL time.Duration
+}
+
+// DataURI downloads an image URL with client, or a client with
+// DefaultImageTimeout if nil, and returns it as a base64 data URI.
+func DataURI(ctx context.Context, client *http.Client, imgURL string) (string, error) {
+	return (&ImageFetcher{Client: client}).DataURI(ctx, imgURL)
+}
+
+// DataURI downloads an image URL, retrying as configured, or takes it from
+// the cache, and returns it as a base64 data URI.
+func (f *ImageFetcher) DataURI(ctx context.Context, imgURL string) (string, error) {
+	if f == nil {
+		f = &ImageFetcher{}
+	}
+	cached, fresh := f.cached(imgURL)
+	if fresh {
+		return cached, nil
+	}
+	uri, err := f.download(ctx, imgURL)
+	if err != nil && cached != "" {
+		slog.Warn("Downloading image failed, using the cached copy", "url", imgURL, "err", err)
+		return cached, nil
+	}
+	if err == nil && f.CacheDir != "" {
+		if err := f.store(imgURL, uri); err != nil {
+			slog.Warn("Caching image failed", "url", imgURL, "err", err)
+		}
+	}
+	return uri, err
+}
+
+// download fetches imgURL, retrying as configured.
+func (f *ImageFetcher) download(ctx context.Context, imgURL string) (string, error) {
+	delay := f.RetryDelay
+	if delay == 0 {
+		delay = DefaultRetryDelay
+	}
+	for attempt := 1; ; attempt++ {
+		uri, retry, err := f.fetch(ctx, imgURL)
+		if err == nil || !retry || attempt >= f.Attempts || ctx.Err() != nil {
+			return uri, err
+		}
+		slog.Debug("Retrying image download", "url", imgURL, "attempt", attempt, "err", err)
+		select {
+		case <-time.After(delay * time.Duration(attempt)):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// fetch downloads imgURL once and reports whether a failure is worth
+// retrying.
+func (f *ImageFetcher) fetch(ctx context.Context, imgURL string) (uri string, retry bool, err error) {
+	client := f.Client
+	if client == nil {
+		client = defaultImageClient
+	}
+	maxSize := f.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxImageSize
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imgURL, nil)
+	if err != nil {
+		return "", false, err
+	}
+	if f.UserAgent != "" {
+		req.Header.Set("User-Agent", f.UserAgent)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", !errors.Is(err, ErrHostNotAllowed), err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return "", retry, fmt.Errorf("GET %s: %s", imgURL, resp.Status)
+	}
+	if resp.ContentLength > maxSize {
+		return "", false, fmt.Errorf("GET %s: image of %d bytes exceeds %d", imgURL, resp.ContentLength, maxSize)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return "", true, err
+	}
+	if int64(len(data)) > maxSize {
+		return "", false, fmt.Errorf("GET %s: image exceeds %d bytes", imgURL, maxSize)
+	}
+	mime := resp.Header.Get("Content-Type")
+	if mime == "" {
+		mime = "image/png"
+	}
+	return fmt.Sprintf("data:%s;base64,%s", mime, base64.StdEncoding.EncodeToString(data)), false, nil
+}
+
+// cachePath returns the cache file of imgURL, or "" without CacheDir.
+func (f *ImageFetcher) cachePath(imgURL string) string {
+	if f.CacheDir == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(imgURL))
+	return filepath.Join(f.CacheDir, hex.EncodeToString(sum[:]))
+}
+
+// cached returns the cached data URI of imgURL, if any, and whether it is
+// younger than the TTL.
+func (f *ImageFetcher) cached(imgURL string) (uri string, fresh bool) {
+	path := f.cachePath(imgURL)
+	if path == "" {
+		return "", false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("Reading cached image failed", "url", imgURL, "err", err)
+		return "", false
+	}
+	ttl := f.CacheTTL
+	if ttl == 0 {
+		ttl = DefaultCacheTTL
+	}
+	return string(data), time.Since(info.ModTime()) < ttl
+}
+
+// store writes the data URI of imgURL to the cache, replacing the file
+// atomically so concurrent runs never read half an entry.
+func (f *ImageFetcher) store(imgURL, uri string) error {
+	if err := os.MkdirAll(f.CacheDir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(f.CacheDir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(uri)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.cachePath(imgURL))
+}
@@ -0,0 +1,92 @@
+package htmlclean
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImageFetcher_DataURI(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch {
+		case r.URL.Path == "/flaky.png" && calls == 1:
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		case r.URL.Path == "/flaky.png":
+			if r.UserAgent() != "invoice-test" {
+				t.Errorf("User-Agent = %q", r.UserAgent())
+			}
+			w.Header().Set("Content-Type", "image/gif")
+			w.Write([]byte("GIF89a"))
+		case r.URL.Path == "/big.png":
+			w.Write(make([]byte, 100))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	f := &ImageFetcher{UserAgent: "invoice-test", Attempts: 2, RetryDelay: time.Millisecond, MaxSize: 50}
+
+	uri, err := f.DataURI(context.Background(), srv.URL+"/flaky.png")
+	if err != nil || uri != "data:image/gif;base64,R0lGODlh" || calls != 2 {
+		t.Errorf("flaky: %q, %v after %d calls", uri, err, calls)
+	}
+	calls = 0
+	if _, err := f.DataURI(context.Background(), srv.URL+"/missing.png"); err == nil || calls != 1 {
+		t.Errorf("404: %v after %d calls, want one failed call", err, calls)
+	}
+	if _, err := f.DataURI(context.Background(), srv.URL+"/big.png"); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("too big: %v", err)
+	}
+}
+
+func TestImageFetcher_Cache(t *testing.T) {
+	calls, down := 0, false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if down {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "image/gif")
+		w.Write([]byte("GIF89a"))
+	}))
+	defer srv.Close()
+	f := &ImageFetcher{CacheDir: t.TempDir()}
+
+	for range 2 {
+		if uri, err := f.DataURI(context.Background(), srv.URL+"/logo.gif"); err != nil || uri != "data:image/gif;base64,R0lGODlh" {
+			t.Fatalf("DataURI = %q, %v", uri, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("%d requests, want 1 with a fresh cache entry", calls)
+	}
+
+	// An expired entry is refreshed, and still used if that fails
+	f.CacheTTL, down = time.Nanosecond, true
+	if uri, err := f.DataURI(context.Background(), srv.URL+"/logo.gif"); err != nil || uri != "data:image/gif;base64,R0lGODlh" || calls != 2 {
+		t.Errorf("stale entry: %q, %v after %d requests", uri, err, calls)
+	}
+}
+
+func TestImageFetcher_RedirectToBlockedHost(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Redirect(w, r, "http://tracker.example/pixel.gif", http.StatusFound)
+	}))
+	defer srv.Close()
+	client := &http.Client{CheckRedirect: CheckRedirect(nil, []string{"tracker.example"})}
+	f := &ImageFetcher{Client: client, Attempts: 3, RetryDelay: time.Millisecond}
+
+	_, err := f.DataURI(context.Background(), srv.URL+"/logo.png")
+	if !errors.Is(err, ErrHostNotAllowed) || calls != 1 {
+		t.Errorf("DataURI = %v after %d calls, want ErrHostNotAllowed without retries", err, calls)
+	}
+}
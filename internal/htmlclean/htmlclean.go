@@ -0,0 +1,528 @@
+// Package htmlclean prepares the HTML of invoice emails for printing: it
+// removes buttons and link bars that make no sense on paper and embeds
+// external images and those sent with the email (cid: URLs), so they
+// render reliably in the PDF.
+package htmlclean
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// AppleRemove lists the screen-only elements of Apple invoices: the action
+// button and its intro paragraph, the help links and the bottom link bar
+// (privacy, terms, etc.).
+var AppleRemove = []string{
+	".action-button-cell",
+	"#footer_section > p:first-of-type",
+	"#footer_section > .custom-1sstyyn",
+	".inline-link-group",
+}
+
+// TrackingURLs lists URL parts of known open-tracking endpoints of
+// newsletter and shop systems.
+var TrackingURLs = []string{
+	"google-analytics.com/",
+	"doubleclick.net/",
+	"list-manage.com/track/",
+	"mandrillapp.com/track/",
+	"sendgrid.net/wf/open",
+	"/wf/open?",
+	"emltrk.com/",
+	"mailtrack.io/",
+	"t.paypal.com/ts",
+	"/track/open",
+	"/open.gif",
+	"/beacon",
+}
+
+// DefaultWorkers is the number of images embedded concurrently without
+// Options.Workers.
+const DefaultWorkers = 4
+
+// Link handling for Options.Links.
+const (
+	// LinksKeep leaves links clickable.
+	LinksKeep = "keep"
+	// LinksStrip removes the targets of all links, keeping their text.
+	LinksStrip = "strip"
+	// LinksFootnotes removes the targets and lists the web addresses,
+	// without query strings, numbered at the end of the page.
+	LinksFootnotes = "footnotes"
+)
+
+// Rule actions.
+const (
+	// ActionRemove removes the matching elements.
+	ActionRemove = "remove"
+	// ActionUnwrap replaces the matching elements by their contents, e.g.
+	// to keep the text of links.
+	ActionUnwrap = "unwrap"
+	// ActionStyle appends Rule.CSS to the style of the matching elements.
+	ActionStyle = "style"
+	// ActionAttr sets the attribute Rule.Attr to Rule.Value.
+	ActionAttr = "attr"
+	// ActionReplace replaces the matches of Rule.Pattern in the text of
+	// the matching elements by Rule.Value, which may refer to groups as
+	// in regexp.Regexp.Expand.
+	ActionReplace = "replace"
+	// ActionWrap wraps the matching elements in Rule.HTML.
+	ActionWrap = "wrap"
+)
+
+// Rule changes the elements matching a CSS selector.
+type Rule struct {
+	Action   string
+	Selector string
+	// Contains, if set, limits the rule to elements whose text contains
+	// it.
+	Contains string
+	// CSS is the declarations ActionStyle appends.
+	CSS string
+	// Attr and Value are the attribute ActionAttr sets; Value is also the
+	// replacement of ActionReplace.
+	Attr  string
+	Value string
+	// Pattern is the regular expression ActionReplace replaces.
+	Pattern string
+	// HTML is the element ActionWrap wraps in, e.g. "<div></div>".
+	HTML string
+}
+
+// VATLabels maps languages to the labels of the VAT number line in the
+// footers of Apple's localized invoices.
+var VATLabels = map[string][]string{
+	"de": {"UID-Nr", "USt-IdNr"},
+	"en": {"VAT No", "VAT Reg", "VAT ID"},
+	"es": {"NIF:", "Número de IVA"},
+	"fr": {"Numéro de TVA", "N° TVA"},
+	"it": {"Partita IVA", "P. IVA"},
+	"nl": {"Btw-nummer", "BTW-nummer"},
+	"pl": {"NIP:"},
+}
+
+// AppleRules bolds the VAT number line in Apple's footer, in every
+// language of VATLabels.
+var AppleRules = vatRules(".footer-copy p", "font-weight:600")
+
+// vatRules returns style rules applying css to the elements matching
+// selector that contain a VAT label, in a stable order.
+func vatRules(selector, css string) []Rule {
+	var rules []Rule
+	for _, lang := range slices.Sorted(maps.Keys(VATLabels)) {
+		for _, label := range VATLabels[lang] {
+			rules = append(rules, Rule{Action: ActionStyle, Selector: selector, Contains: label, CSS: css})
+		}
+	}
+	return rules
+}
+
+// Validate checks the action and the selector of r.
+func (r Rule) Validate() error {
+	switch r.Action {
+	case ActionRemove, ActionUnwrap:
+	case ActionStyle:
+		if r.CSS == "" {
+			return fmt.Errorf("%s %q: css is required", r.Action, r.Selector)
+		}
+	case ActionAttr:
+		if r.Attr == "" {
+			return fmt.Errorf("%s %q: attribute name is required", r.Action, r.Selector)
+		}
+	case ActionReplace:
+		if r.Pattern == "" {
+			return fmt.Errorf("%s %q: pattern is required", r.Action, r.Selector)
+		}
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("%s %q: %w", r.Action, r.Selector, err)
+		}
+	case ActionWrap:
+		if !strings.HasPrefix(strings.TrimSpace(r.HTML), "<") {
+			return fmt.Errorf("%s %q: html must be an element", r.Action, r.Selector)
+		}
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	if _, err := cascadia.ParseGroup(r.Selector); err != nil {
+		return fmt.Errorf("%s %q: %w", r.Action, r.Selector, err)
+	}
+	return nil
+}
+
+// Options configures Clean.
+type Options struct {
+	// EmbedImage returns the replacement src, usually a data URI, for an
+	// external image. Images are left as they are if it is nil or fails.
+	// It is called once per address, for up to Workers addresses at a
+	// time.
+	EmbedImage func(ctx context.Context, src string) (string, error)
+	// Workers limits the concurrent EmbedImage calls, DefaultWorkers if
+	// zero.
+	Workers int
+	// Remove lists CSS selectors of the elements to remove; nil means
+	// AppleRemove.
+	Remove []string
+	// Rules are applied in order after Remove; nil means AppleRules.
+	Rules []Rule
+	// Images maps Content-IDs, without angle brackets, to the data URIs of
+	// the images sent with the email, for cid: sources.
+	Images map[string]string
+	// Tracking lists URL parts of tracking images to remove, besides
+	// hidden and 1x1 images; nil means TrackingURLs.
+	Tracking []string
+	// Links is LinksKeep (if empty), LinksStrip or LinksFootnotes.
+	Links string
+	// AllowHosts, if set, lists the only hosts external images are kept
+	// from, and BlockHosts hosts whose images are removed. A host matches
+	// a pattern like "apple.com" or "*.apple.com" if it is that domain or
+	// one of its subdomains.
+	AllowHosts []string
+	BlockHosts []string
+}
+
+// Clean removes unwanted elements from the invoice HTML and embeds
+// external images with opts.EmbedImage.
+func Clean(ctx context.Context, htmlContent string, opts Options) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+	if err != nil {
+		return "", fmt.Errorf("parsing HTML: %w", err)
+	}
+
+	// Drop tracking pixels before embedding, so archiving an invoice does
+	// not report it as opened
+	tracking := opts.Tracking
+	if tracking == nil {
+		tracking = TrackingURLs
+	}
+	find(doc, "img").FilterFunction(func(_ int, s *goquery.Selection) bool {
+		return isTracking(s, tracking)
+	}).Each(func(_ int, s *goquery.Selection) {
+		slog.Debug("Removing tracking image", "src", s.AttrOr("src", ""))
+		s.Remove()
+	})
+
+	// Never fetch images from hosts that are not allowed, e.g. in spoofed
+	// emails
+	find(doc, "img").FilterFunction(func(_ int, s *goquery.Selection) bool {
+		return !AllowedURL(s.AttrOr("src", ""), opts.AllowHosts, opts.BlockHosts)
+	}).Each(func(_ int, s *goquery.Selection) {
+		slog.Info("Removing image from a host that is not allowed", "src", s.AttrOr("src", ""))
+		s.Remove()
+	})
+	// Chrome prefers srcset over the embedded src and would load it
+	find(doc, "img[srcset], source[srcset]").RemoveAttr("srcset")
+	find(doc, "[background]").FilterFunction(func(_ int, s *goquery.Selection) bool {
+		return !AllowedURL(s.AttrOr("background", ""), opts.AllowHosts, opts.BlockHosts)
+	}).RemoveAttr("background")
+	find(doc, "[style]").Each(func(_ int, s *goquery.Selection) {
+		if css := s.AttrOr("style", ""); cssURL.MatchString(css) {
+			s.SetAttr("style", filterCSSURLs(css, opts.AllowHosts, opts.BlockHosts))
+		}
+	})
+	// Edit the text nodes in place, as SetText would escape the CSS
+	for _, n := range find(doc, "style").Nodes {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode && cssURL.MatchString(c.Data) {
+				c.Data = filterCSSURLs(c.Data, opts.AllowHosts, opts.BlockHosts)
+			}
+		}
+	}
+
+	// Embed external images as base64 data URIs, and those sent with the
+	// email, which Chrome cannot resolve
+	imgs := find(doc, "img")
+	var urls []string
+	if opts.EmbedImage != nil {
+		imgs.Each(func(_ int, s *goquery.Selection) {
+			if src := s.AttrOr("src", ""); strings.HasPrefix(src, "http") && !slices.Contains(urls, src) {
+				urls = append(urls, src)
+			}
+		})
+	}
+	embedded := embedAll(ctx, urls, opts)
+	imgs.Each(func(_ int, s *goquery.Selection) {
+		src, _ := s.Attr("src")
+		switch {
+		case strings.HasPrefix(strings.ToLower(src), "cid:"):
+			id, err := url.PathUnescape(src[len("cid:"):])
+			if err != nil {
+				id = src[len("cid:"):]
+			}
+			if dataURI, ok := opts.Images[id]; ok {
+				s.SetAttr("src", dataURI)
+			} else {
+				slog.Debug("No image for cid: URL", "src", src)
+			}
+		case strings.HasPrefix(src, "http"):
+			if dataURI, ok := embedded[src]; ok {
+				s.SetAttr("src", dataURI)
+			}
+		}
+	})
+
+	remove := opts.Remove
+	if remove == nil {
+		remove = AppleRemove
+	}
+	for _, selector := range remove {
+		find(doc, selector).Remove()
+	}
+
+	rules := opts.Rules
+	if rules == nil {
+		rules = AppleRules
+	}
+	for _, r := range rules {
+		apply(doc, r)
+	}
+
+	switch opts.Links {
+	case LinksStrip:
+		find(doc, "a[href]").RemoveAttr("href")
+	case LinksFootnotes:
+		footnoteLinks(doc)
+	}
+
+	html, err := doc.Html()
+	if err != nil {
+		return "", fmt.Errorf("rendering HTML: %w", err)
+	}
+	return html, nil
+}
+
+// isTracking reports whether img is a tracking pixel: hidden, at most 1x1
+// or loaded from one of the tracking URLs.
+func isTracking(img *goquery.Selection, tracking []string) bool {
+	src := strings.ToLower(img.AttrOr("src", ""))
+	if strings.HasPrefix(src, "http") {
+		for _, t := range tracking {
+			if strings.Contains(src, strings.ToLower(t)) {
+				return true
+			}
+		}
+	}
+	pixel := func(v string) bool {
+		v = strings.TrimSuffix(strings.TrimSpace(v), "px")
+		return v == "0" || v == "1"
+	}
+	tiny := map[string]bool{}
+	for _, dim := range []string{"width", "height"} {
+		if v, ok := img.Attr(dim); ok {
+			tiny[dim] = pixel(v)
+		}
+	}
+	for _, decl := range strings.Split(img.AttrOr("style", ""), ";") {
+		prop, value, _ := strings.Cut(decl, ":")
+		prop, value = strings.ToLower(strings.TrimSpace(prop)), strings.ToLower(strings.TrimSpace(value))
+		switch {
+		case prop == "display" && value == "none", prop == "visibility" && value == "hidden":
+			return true
+		case prop == "width" || prop == "height":
+			tiny[prop] = pixel(value)
+		}
+	}
+	return tiny["width"] && tiny["height"]
+}
+
+// embedAll calls opts.EmbedImage for urls, up to opts.Workers at a time,
+// and returns the replacements of those that succeeded.
+func embedAll(ctx context.Context, urls []string, opts Options) map[string]string {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	embedded := make(map[string]string, len(urls))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for _, src := range urls {
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			dataURI, err := opts.EmbedImage(ctx, src)
+			if err != nil {
+				slog.Warn("Embedding image failed", "src", src, "err", err)
+				return
+			}
+			mu.Lock()
+			embedded[src] = dataURI
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	return embedded
+}
+
+// footnoteLinks replaces the targets of the links in doc by numbered
+// footnotes listing the web addresses without query strings and
+// fragments, which often carry tokens. Links showing their address and
+// those to anything but web pages are only made unclickable.
+func footnoteLinks(doc *goquery.Document) {
+	var notes []string
+	number := make(map[string]int)
+	find(doc, "a[href]").Each(func(_ int, s *goquery.Selection) {
+		href := s.AttrOr("href", "")
+		s.RemoveAttr("href")
+		u, err := url.Parse(strings.TrimSpace(href))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return
+		}
+		u.RawQuery, u.Fragment = "", ""
+		addr := u.String()
+		if text := strings.TrimSpace(s.Text()); text == addr || text == href {
+			return
+		}
+		n, ok := number[addr]
+		if !ok {
+			notes = append(notes, addr)
+			n = len(notes)
+			number[addr] = n
+		}
+		s.AfterHtml(fmt.Sprintf("<sup>[%d]</sup>", n))
+	})
+	if len(notes) == 0 {
+		return
+	}
+	var list strings.Builder
+	list.WriteString(`<ol class="link-footnotes" style="font-size:8pt;color:#555;word-break:break-all">`)
+	for _, addr := range notes {
+		fmt.Fprintf(&list, "<li>%s</li>", html.EscapeString(addr))
+	}
+	list.WriteString("</ol>")
+	doc.Find("body").AppendHtml(list.String())
+}
+
+// cssURL matches url() references and @import rules in CSS.
+var cssURL = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)]*?))\s*\)|@import\s+(?:"([^"]*)"|'([^']*)')`)
+
+// filterCSSURLs replaces the url() references and @import rules of css
+// that point to hosts that are not allowed with none.
+func filterCSSURLs(css string, allow, block []string) string {
+	return cssURL.ReplaceAllStringFunc(css, func(ref string) string {
+		m := cssURL.FindStringSubmatch(ref)
+		src := strings.Join(m[1:], "")
+		if AllowedURL(src, allow, block) {
+			return ref
+		}
+		slog.Info("Removing CSS reference to a host that is not allowed", "url", src)
+		if strings.HasPrefix(strings.ToLower(ref), "@import") {
+			return "@import url(about:blank)"
+		}
+		return "none"
+	})
+}
+
+// AllowedURL reports whether an image or other resource at src may be
+// embedded or loaded; only external ones are checked.
+func AllowedURL(src string, allow, block []string) bool {
+	if !strings.HasPrefix(strings.ToLower(src), "http") {
+		return true
+	}
+	u, err := url.Parse(src)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range block {
+		if MatchHost(host, pattern) {
+			return false
+		}
+	}
+	if len(allow) == 0 {
+		return true
+	}
+	for _, pattern := range allow {
+		if MatchHost(host, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchHost reports whether host is the domain of pattern, e.g.
+// "apple.com" or "*.apple.com", or one of its subdomains.
+func MatchHost(host, pattern string) bool {
+	domain := strings.ToLower(strings.TrimPrefix(pattern, "*."))
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// apply applies r to the matching elements of doc.
+func apply(doc *goquery.Document, r Rule) {
+	sel := find(doc, r.Selector)
+	var pattern *regexp.Regexp
+	if r.Action == ActionReplace {
+		// Checked by Validate, except for rules built in code
+		var err error
+		if pattern, err = regexp.Compile(r.Pattern); err != nil {
+			slog.Warn("Skipping cleanup rule", "selector", r.Selector, "err", err)
+			return
+		}
+	}
+	if r.Contains != "" {
+		sel = sel.FilterFunction(func(_ int, s *goquery.Selection) bool {
+			return strings.Contains(s.Text(), r.Contains)
+		})
+	}
+	sel.Each(func(_ int, s *goquery.Selection) {
+		switch r.Action {
+		case ActionRemove:
+			s.Remove()
+		case ActionUnwrap:
+			s.ReplaceWithSelection(s.Contents())
+		case ActionStyle:
+			style := strings.TrimRight(strings.TrimSpace(s.AttrOr("style", "")), ";")
+			if strings.Contains(style, r.CSS) {
+				// Already applied by a rule for another label
+				return
+			}
+			if style != "" {
+				style += ";"
+			}
+			s.SetAttr("style", style+r.CSS)
+		case ActionAttr:
+			s.SetAttr(r.Attr, r.Value)
+		case ActionReplace:
+			for _, n := range s.Nodes {
+				replaceText(n, pattern, r.Value)
+			}
+		case ActionWrap:
+			s.WrapHtml(r.HTML)
+		}
+	})
+}
+
+// replaceText replaces the matches of pattern in the text nodes below n,
+// leaving the markup, styles and scripts as they are.
+func replaceText(n *html.Node, pattern *regexp.Regexp, repl string) {
+	switch {
+	case n.Type == html.TextNode:
+		n.Data = pattern.ReplaceAllString(n.Data, repl)
+		return
+	case n.Type == html.ElementNode && (n.Data == "style" || n.Data == "script"):
+		return
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		replaceText(c, pattern, repl)
+	}
+}
+
+// find selects the elements matching selector and logs the number of
+// matches at debug level, so template changes show up as selectors that no
+// longer match.
+func find(doc *goquery.Document, selector string) *goquery.Selection {
+	sel := doc.Find(selector)
+	slog.Debug("cleanHTML selector", "selector", selector, "matches", sel.Length())
+	return sel
+}
@@ -0,0 +1,318 @@
+package htmlclean
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClean_RemovesActionButton(t *testing.T) {
+	html := `<html><body>
+		<div class="action-button-cell">Click here</div>
+		<p>Keep this</p>
+	</body></html>`
+
+	result, err := Clean(context.Background(), html, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(result, "action-button-cell") {
+		t.Error("expected action-button-cell to be removed")
+	}
+	if !strings.Contains(result, "Keep this") {
+		t.Error("expected other content to be preserved")
+	}
+}
+
+func TestClean_RemovesInlineLinkGroup(t *testing.T) {
+	html := `<html><body>
+		<div class="inline-link-group">Privacy | Terms</div>
+		<p>Content</p>
+	</body></html>`
+
+	result, err := Clean(context.Background(), html, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(result, "inline-link-group") {
+		t.Error("expected inline-link-group to be removed")
+	}
+}
+
+func TestClean_BoldsUIDNr(t *testing.T) {
+	html := `<html><body>
+		<div class="footer-copy"><p>UID-Nr: ATU12345</p></div>
+	</body></html>`
+
+	result, err := Clean(context.Background(), html, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(result, "font-weight:600") {
+		t.Error("expected UID-Nr paragraph to be bolded")
+	}
+}
+
+func TestClean_BoldsVATLineInOtherLanguages(t *testing.T) {
+	html := `<html><body>
+		<div class="footer-copy"><p>Numéro de TVA : FR12345678901</p><p>Tous droits réservés</p></div>
+		<div class="footer-copy"><p>VAT No. GB123456789, VAT ID IE9700053D</p></div>
+	</body></html>`
+
+	result, err := Clean(context.Background(), html, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Count(result, `style="font-weight:600"`) != 2 || strings.Contains(result, "font-weight:600;") {
+		t.Errorf("expected each VAT line to be bolded once, got %s", result)
+	}
+}
+
+func TestClean_PreservesNonImageContent(t *testing.T) {
+	html := `<html><body>
+		<h1>Invoice</h1>
+		<p>Amount: €9.99</p>
+	</body></html>`
+
+	result, err := Clean(context.Background(), html, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(result, "Invoice") || !strings.Contains(result, "€9.99") {
+		t.Error("expected content to be preserved")
+	}
+}
+
+func TestClean_Remove(t *testing.T) {
+	html := `<html><body>
+		<div class="action-button-cell">Apple</div>
+		<a class="button" href="https://example.com/orders">Bestellung anzeigen</a>
+		<p>Content</p>
+	</body></html>`
+
+	result, err := Clean(context.Background(), html, Options{Remove: []string{"a.button"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(result, "Bestellung anzeigen") {
+		t.Error("expected a.button to be removed")
+	}
+	if !strings.Contains(result, "action-button-cell") {
+		t.Error("expected Apple's selectors not to apply")
+	}
+}
+
+func TestClean_EmbedsCIDImages(t *testing.T) {
+	html := `<html><body>
+		<img src="cid:logo@apple.com" alt="Apple">
+		<img src="cid:missing">
+	</body></html>`
+
+	result, err := Clean(context.Background(), html, Options{Images: map[string]string{"logo@apple.com": "data:image/png;base64,UE5H"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(result, `src="data:image/png;base64,UE5H"`) {
+		t.Errorf("expected the logo to be inlined, got %s", result)
+	}
+	if !strings.Contains(result, `src="cid:missing"`) {
+		t.Error("expected unknown Content-IDs to be left as they are")
+	}
+}
+
+func TestClean_Rules(t *testing.T) {
+	html := `<html><body>
+		<p class="note" style="color:red">Steuer-ID: DE123</p>
+		<p class="note">Danke</p>
+		<a class="link" href="https://apps.apple.com">App Store</a>
+		<div class="promo">Jetzt testen</div>
+	</body></html>`
+
+	result, err := Clean(context.Background(), html, Options{Rules: []Rule{
+		{Action: ActionRemove, Selector: ".promo"},
+		{Action: ActionUnwrap, Selector: "a.link"},
+		{Action: ActionStyle, Selector: "p.note", Contains: "Steuer-ID", CSS: "font-weight:600"},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(result, "Jetzt testen") {
+		t.Error("expected .promo to be removed")
+	}
+	if strings.Contains(result, "<a") || !strings.Contains(result, "App Store") {
+		t.Error("expected the link to be replaced by its text")
+	}
+	if !strings.Contains(result, `style="color:red;font-weight:600"`) || strings.Count(result, "font-weight") != 1 {
+		t.Errorf("expected only the Steuer-ID paragraph to be bolded, got %s", result)
+	}
+}
+
+func TestClean_RuleTransforms(t *testing.T) {
+	html := `<html><head><style>td.price { color: black }</style></head><body>
+		<table class="items" width="600"><tr><td class="price">EUR 9,99 <b>EUR</b></td></tr></table>
+	</body></html>`
+
+	result, err := Clean(context.Background(), html, Options{Rules: []Rule{
+		{Action: ActionAttr, Selector: "table.items", Attr: "width", Value: "100%"},
+		{Action: ActionReplace, Selector: "td.price", Pattern: `EUR\s*([0-9,]+)`, Value: "${1} €"},
+		{Action: ActionWrap, Selector: "table.items", HTML: `<div class="keep" style="page-break-inside:avoid"></div>`},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(result, `width="100%"`) {
+		t.Error("expected the table width to be set")
+	}
+	if !strings.Contains(result, "9,99 € <b>EUR</b>") || !strings.Contains(result, "td.price { color: black }") {
+		t.Errorf("expected only the price text to be replaced, got %s", result)
+	}
+	if !strings.Contains(result, `<div class="keep" style="page-break-inside:avoid"><table class="items"`) {
+		t.Errorf("expected the table to be wrapped, got %s", result)
+	}
+}
+
+func TestRule_Validate(t *testing.T) {
+	for _, r := range []Rule{
+		{Action: "hide", Selector: "p"},
+		{Action: ActionRemove, Selector: "p[class="},
+		{Action: ActionStyle, Selector: "p"},
+		{Action: ActionAttr, Selector: "p"},
+		{Action: ActionReplace, Selector: "p", Pattern: "("},
+		{Action: ActionWrap, Selector: "p", HTML: "div"},
+	} {
+		if err := r.Validate(); err == nil {
+			t.Errorf("%+v: want error", r)
+		}
+	}
+	if err := AppleRules[0].Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClean_RemovesTrackingPixels(t *testing.T) {
+	html := `<html><body>
+		<img src="https://www.apple.com/logo.png" width="40" height="40">
+		<img src="https://shop.example.com/o.gif?u=1" width="1" height="1">
+		<img src="https://shop.example.com/p.gif" style="width:1px;height:1px">
+		<img src="https://shop.example.com/h.gif" style="display: none">
+		<img src="https://u123.ct.sendgrid.net/wf/open?upn=abc">
+		<img src="https://shop.example.com/spacer.gif" width="600" height="1">
+	</body></html>`
+
+	var mu sync.Mutex
+	var embedded []string
+	result, err := Clean(context.Background(), html, Options{EmbedImage: func(_ context.Context, src string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		embedded = append(embedded, src)
+		return src, nil
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, gone := range []string{"o.gif", "p.gif", "h.gif", "sendgrid"} {
+		if strings.Contains(result, gone) {
+			t.Errorf("expected %s to be removed", gone)
+		}
+	}
+	if len(embedded) != 2 || !strings.Contains(result, "logo.png") || !strings.Contains(result, "spacer.gif") {
+		t.Errorf("embedded %v, want the logo and the spacer only", embedded)
+	}
+}
+
+func TestClean_Links(t *testing.T) {
+	html := `<html><body>
+		<p><a href="https://apps.apple.com/account/subscriptions?token=s3cret">Abonnements verwalten</a></p>
+		<p><a href="https://apps.apple.com/account/subscriptions?token=other">Abo kündigen</a></p>
+		<p><a href="https://www.apple.com/legal/">https://www.apple.com/legal/</a></p>
+		<p><a href="mailto:help@apple.com">Support</a></p>
+	</body></html>`
+
+	result, err := Clean(context.Background(), html, Options{Links: LinksStrip})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(result, "href") || !strings.Contains(result, "Abonnements verwalten") {
+		t.Errorf("strip: expected the links to keep only their text, got %s", result)
+	}
+
+	result, err = Clean(context.Background(), html, Options{Links: LinksFootnotes})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(result, "href") || strings.Contains(result, "s3cret") {
+		t.Errorf("footnotes: expected no targets and no tokens, got %s", result)
+	}
+	if strings.Count(result, "<sup>[1]</sup>") != 2 || strings.Contains(result, "[2]") {
+		t.Errorf("footnotes: expected both subscription links to share note 1, got %s", result)
+	}
+	if !strings.Contains(result, "<li>https://apps.apple.com/account/subscriptions</li>") {
+		t.Errorf("footnotes: expected the address in the list, got %s", result)
+	}
+}
+
+func TestClean_EmbedsConcurrently(t *testing.T) {
+	var b strings.Builder
+	for i := range 8 {
+		fmt.Fprintf(&b, `<img src="https://cdn.example.com/%d.png"><img src="https://cdn.example.com/%d.png">`, i, i)
+	}
+
+	var mu sync.Mutex
+	calls, running, peak := 0, 0, 0
+	result, err := Clean(context.Background(), b.String(), Options{Workers: 3, EmbedImage: func(_ context.Context, src string) (string, error) {
+		mu.Lock()
+		calls++
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return "data:image/png;base64," + path.Base(src), nil
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 8 || peak < 2 || peak > 3 {
+		t.Errorf("%d calls with up to %d at a time, want 8 with at most 3", calls, peak)
+	}
+	if strings.Count(result, "data:image/png;base64,") != 16 {
+		t.Errorf("expected every image to be embedded, got %s", result)
+	}
+}
+
+func TestClean_ImageHosts(t *testing.T) {
+	html := `<html><body>
+		<style>@import "https://evil-apple.com/a.css"; .hero { background: url('https://www.apple.com/bg.png'); }</style>
+		<img src="https://www.apple.com/logo.png" srcset="https://evil-apple.com/logo@2x.png 2x">
+		<img src="https://is1-ssl.mzstatic.com/artwork.jpg">
+		<img src="https://evil-apple.com/x.png">
+		<img src="https://ads.mzstatic.com/banner.png">
+		<img src="cid:logo@apple.com">
+		<div style="background-image: url(https://evil-apple.com/bg.png)">Summe</div>
+		<table background="https://ads.mzstatic.com/tile.png"><tr><td>1</td></tr></table>
+	</body></html>`
+
+	result, err := Clean(context.Background(), html, Options{
+		AllowHosts: []string{"apple.com", "*.mzstatic.com"},
+		BlockHosts: []string{"ads.mzstatic.com"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, kept := range []string{"www.apple.com/logo.png", "url('https://www.apple.com/bg.png')", "is1-ssl.mzstatic.com", "cid:logo", "background-image: none"} {
+		if !strings.Contains(result, kept) {
+			t.Errorf("expected %s to be kept", kept)
+		}
+	}
+	for _, gone := range []string{"evil-apple.com", "ads.mzstatic.com"} {
+		if strings.Contains(result, gone) {
+			t.Errorf("expected %s to be removed", gone)
+		}
+	}
+}
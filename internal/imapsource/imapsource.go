@@ -0,0 +1,499 @@
+// Package imapsource reads invoice emails from an IMAP mailbox.
+package imapsource
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/charset"
+	"github.com/emersion/go-message/mail"
+
+	"github.com/rummeyer/apple-invoice-pdf/pkg/invoice"
+)
+
+func init() {
+	// Decode envelope subjects in ISO-8859-1, windows-1252 and the like;
+	// importing go-message/charset does the same for headers and bodies of
+	// parsed messages.
+	imap.CharsetReader = charset.Reader
+}
+
+// Config holds the server address and login of a mailbox.
+type Config struct {
+	Host string
+	Port int
+	User string
+	Pass string
+	// TLS holds further settings like the minimum version; ServerName is
+	// always set to Host.
+	TLS *tls.Config
+}
+
+// Dial connects to the IMAP server via TLS and logs in. Cancelling ctx
+// closes the connection, aborting any pending command.
+func Dial(ctx context.Context, cfg Config) (*client.Client, error) {
+	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+	conf := &tls.Config{}
+	if cfg.TLS != nil {
+		conf = cfg.TLS.Clone()
+	}
+	conf.ServerName = cfg.Host
+	dialer := &tls.Dialer{Config: conf}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to IMAP server: %w", err)
+	}
+	c, err := client.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connecting to IMAP server: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() { c.Terminate() })
+	go func() {
+		<-c.LoggedOut()
+		stop()
+	}()
+	slog.Debug("IMAP command", "command", "LOGIN", "user", cfg.User)
+	if err := c.Login(cfg.User, cfg.Pass); err != nil {
+		c.Logout()
+		return nil, fmt.Errorf("IMAP login: %w", err)
+	}
+	slog.Info("Logged in to IMAP server", "host", cfg.Host)
+	return c, nil
+}
+
+// FetchEnvelopes calls fn with the envelope, INTERNALDATE and UID of every
+// message in seqSet that has an envelope.
+func FetchEnvelopes(c *client.Client, seqSet *imap.SeqSet, fn func(*imap.Message)) error {
+	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchInternalDate, imap.FetchUid}
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+	slog.Debug("IMAP command", "command", "FETCH", "set", seqSet.String(), "items", items)
+	go func() { done <- c.Fetch(seqSet, items, messages) }()
+
+	for msg := range messages {
+		if msg.Envelope != nil {
+			fn(msg)
+		}
+	}
+	return <-done
+}
+
+// FetchBodies fetches full MIME bodies for the given UIDs without marking
+// them as read and extracts their HTML content, unwrapping forwarded
+// invoices. Messages without an HTML or text part are skipped with a
+// warning.
+func FetchBodies(c *client.Client, uids []uint32, spoolDir string) ([]invoice.Email, error) {
+	uidSet := new(imap.SeqSet)
+	for _, uid := range uids {
+		uidSet.AddNum(uid)
+	}
+
+	section := &imap.BodySectionName{Peek: true}
+	items := []imap.FetchItem{section.FetchItem(), imap.FetchEnvelope}
+	// A small buffer keeps only a few bodies in flight when spooling
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+	slog.Debug("IMAP command", "command", "UID FETCH", "set", uidSet.String(), "items", items)
+	go func() { done <- c.UidFetch(uidSet, items, messages) }()
+
+	var invoices []invoice.Email
+	for msg := range messages {
+		r := msg.GetBody(section)
+		if r == nil {
+			slog.Warn("No body", "uid", msg.Uid)
+			continue
+		}
+		// Keep the full RFC822 source so it can be attached for auditing
+		raw, err := io.ReadAll(r)
+		if err != nil {
+			slog.Warn("Reading body failed", "uid", msg.Uid, "err", err)
+			continue
+		}
+		htmlBody, err := HTMLBody(bytes.NewReader(raw))
+		if err != nil {
+			slog.Warn("Extracting HTML failed", "uid", msg.Uid, "err", err)
+			continue
+		}
+		e := invoice.Email{
+			UID:       msg.Uid,
+			Subject:   msg.Envelope.Subject,
+			Date:      msg.Envelope.Date,
+			MessageID: msg.Envelope.MessageId,
+			HTMLBody:  htmlBody,
+			Raw:       raw,
+		}
+		if len(msg.Envelope.From) > 0 {
+			e.From = msg.Envelope.From[0].Address()
+		}
+		if unwrapForward(&e); e.ForwardedBy != "" {
+			slog.Info("Unwrapped forwarded invoice", "uid", msg.Uid, "subject", e.Subject, "forwarded_by", e.ForwardedBy)
+		}
+		if spoolDir != "" {
+			if err := spool(&e, spoolDir); err != nil {
+				slog.Warn("Spooling body failed, keeping it in memory", "uid", msg.Uid, "err", err)
+			}
+		}
+		invoices = append(invoices, e)
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("fetching bodies: %w", err)
+	}
+	return invoices, nil
+}
+
+// ParseMessage reads an RFC822 message, e.g. a saved .eml file, into an
+// invoice email with subject, date, Message-Id, sender and HTML body,
+// unwrapping a forwarded invoice. The UID is left zero.
+func ParseMessage(raw []byte) (invoice.Email, error) {
+	mr, err := createReader(bytes.NewReader(raw))
+	if err != nil {
+		return invoice.Email{}, fmt.Errorf("creating mail reader: %w", err)
+	}
+	e := invoice.Email{Raw: raw}
+	if e.Subject, err = mr.Header.Subject(); err != nil {
+		return invoice.Email{}, fmt.Errorf("decoding subject: %w", err)
+	}
+	// A missing or malformed Date header leaves the zero time
+	e.Date, _ = mr.Header.Date()
+	if id, err := mr.Header.MessageID(); err == nil && id != "" {
+		// Keep the angle brackets, as in IMAP envelopes
+		e.MessageID = "<" + id + ">"
+	}
+	if from, err := mr.Header.AddressList("From"); err == nil && len(from) > 0 {
+		e.From = from[0].Address
+	}
+	if e.HTMLBody, err = htmlPart(mr); err != nil {
+		return invoice.Email{}, err
+	}
+	unwrapForward(&e)
+	return e, nil
+}
+
+// unwrapForward replaces subject, date, sender and HTML body of e with
+// those of the email it forwards as an attachment (message/rfc822), if that
+// has an HTML body, and records the forwarding sender in ForwardedBy.
+// Forwards of forwards are unwrapped down to the innermost email.
+func unwrapForward(e *invoice.Email) {
+	mr, err := createReader(bytes.NewReader(e.Raw))
+	if err != nil {
+		return
+	}
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF || err != nil && !message.IsUnknownCharset(err) {
+			return
+		}
+		if mimeType(p.Header) != "message/rfc822" {
+			continue
+		}
+		raw, err := io.ReadAll(p.Body)
+		if err != nil {
+			return
+		}
+		inner, err := ParseMessage(raw)
+		if err != nil {
+			// Not an HTML email, e.g. a forwarded reply
+			continue
+		}
+		e.ForwardedBy = e.From
+		e.Subject, e.From, e.HTMLBody = inner.Subject, inner.From, inner.HTMLBody
+		if !inner.Date.IsZero() {
+			e.Date = inner.Date
+		}
+		return
+	}
+}
+
+// HTMLBody walks the MIME parts of an RFC822 message and returns its HTML
+// content: the longest inline text/html part, else the longest attached
+// one, else the first inline text/plain part as a simple page, see
+// htmlPart.
+func HTMLBody(r io.Reader) (string, error) {
+	mr, err := createReader(r)
+	if err != nil {
+		return "", fmt.Errorf("creating mail reader: %w", err)
+	}
+	return htmlPart(mr)
+}
+
+// htmlPart returns the richest text/html part of mr, the longest of the
+// alternatives, which may be nested in multipart/related or in forwarded
+// messages. HTML sent as an attachment counts only if there is no inline
+// one. A message without HTML, such as the plain text emails hosters
+// attach their invoices to, yields its text/plain part rendered into a
+// simple page, see textHTML.
+func htmlPart(mr *mail.Reader) (string, error) {
+	var inline, attached, plain string
+	err := walkParts(mr, func(h mail.PartHeader, body io.Reader) error {
+		ct := mimeType(h)
+		if ct != "text/html" && (ct != "text/plain" || plain != "") {
+			return nil
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return fmt.Errorf("reading %s body: %w", ct, err)
+		}
+		_, isAttachment := h.(*mail.AttachmentHeader)
+		switch {
+		case ct == "text/plain":
+			if !isAttachment {
+				plain = utf8Text(data)
+			}
+		case isAttachment:
+			attached = longer(attached, utf8Text(data))
+		default:
+			inline = longer(inline, utf8Text(data))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case inline != "":
+		return inline, nil
+	case attached != "":
+		return attached, nil
+	case strings.TrimSpace(plain) != "":
+		return textHTML(plain), nil
+	}
+	return "", fmt.Errorf("no text/html or text/plain part found")
+}
+
+// createReader is mail.CreateReader, except that parts in an unknown
+// charset are read as they are rather than failing the message.
+func createReader(r io.Reader) (*mail.Reader, error) {
+	mr, err := mail.CreateReader(r)
+	if err != nil && !message.IsUnknownCharset(err) {
+		return nil, err
+	}
+	return mr, nil
+}
+
+// metaCharset matches the charset declared by an HTML meta tag.
+var metaCharset = regexp.MustCompile(`(?i)(<meta[^>]+charset\s*=\s*["']?)([\w.:-]+)`)
+
+// utf8Text returns a text body as UTF-8. Parts with a charset parameter
+// have been converted by go-message already; a body that is still not
+// valid UTF-8 is decoded with the charset of its meta tag or, lacking one,
+// as windows-1252, a superset of ISO-8859-1. Meta tags are changed to
+// declare the UTF-8 the body now is.
+func utf8Text(body []byte) string {
+	if !utf8.Valid(body) {
+		name := "windows-1252"
+		if m := metaCharset.FindSubmatch(body); m != nil && !strings.EqualFold(string(m[2]), "utf-8") {
+			name = string(m[2])
+		}
+		if r, err := charset.Reader(name, bytes.NewReader(body)); err == nil {
+			if decoded, err := io.ReadAll(r); err == nil {
+				body = decoded
+			}
+		}
+	}
+	return metaCharset.ReplaceAllStringFunc(string(body), func(meta string) string {
+		m := metaCharset.FindStringSubmatch(meta)
+		if strings.EqualFold(m[2], "utf-8") {
+			return meta
+		}
+		return m[1] + "utf-8"
+	})
+}
+
+// longer returns the longer of a and b, a if they are equally long.
+func longer(a, b string) string {
+	if len(b) > len(a) {
+		return b
+	}
+	return a
+}
+
+// walkParts calls fn with the header and body of every leaf part of mr, in
+// order. Forwarded messages (message/rfc822) are walked into instead.
+func walkParts(mr *mail.Reader, fn func(h mail.PartHeader, body io.Reader) error) error {
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && !message.IsUnknownCharset(err) {
+			return fmt.Errorf("reading mail part: %w", err)
+		}
+		if mimeType(p.Header) == "message/rfc822" {
+			inner, err := createReader(p.Body)
+			if err != nil {
+				return fmt.Errorf("reading forwarded message: %w", err)
+			}
+			if err := walkParts(inner, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(p.Header, p.Body); err != nil {
+			return err
+		}
+	}
+}
+
+// mimeType returns the media type of a part, e.g. "text/html".
+func mimeType(h mail.PartHeader) string {
+	var t string
+	switch h := h.(type) {
+	case *mail.InlineHeader:
+		t, _, _ = h.ContentType()
+	case *mail.AttachmentHeader:
+		t, _, _ = h.ContentType()
+	}
+	return t
+}
+
+// textPage is the page text/plain bodies are rendered into. Lines keep
+// their indentation, so columns aligned with spaces stay aligned.
+const textPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<style>
+body { font: 10pt/1.45 -apple-system, "Helvetica Neue", Arial, sans-serif; color: #1d1d1f; margin: 0 auto; max-width: 42em; }
+p { margin: 0; white-space: pre-wrap; }
+p.gap { height: 0.8em; }
+</style>
+</head>
+<body>
+%s</body>
+</html>
+`
+
+// textHTML renders a text/plain body into textPage with a paragraph per
+// line, so labels and values are found as in HTML bodies. Runs of blank
+// lines become a single gap.
+func textHTML(text string) string {
+	var b strings.Builder
+	gap := false
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			if !gap {
+				b.WriteString("<p class=\"gap\"></p>\n")
+			}
+			gap = true
+			continue
+		}
+		b.WriteString("<p>" + html.EscapeString(line) + "</p>\n")
+		gap = false
+	}
+	return fmt.Sprintf(textPage, b.String())
+}
+
+// isPDF reports whether a part is a PDF document. Parts sent as
+// application/octet-stream count if their filename ends in .pdf.
+func isPDF(h mail.PartHeader) bool {
+	ct := mimeType(h)
+	var filename string
+	if h, ok := h.(*mail.AttachmentHeader); ok {
+		filename, _ = h.Filename()
+	}
+	return ct == "application/pdf" || ct == "application/octet-stream" && strings.HasSuffix(strings.ToLower(filename), ".pdf")
+}
+
+// PDFAttachment returns the content of the first PDF part of an RFC822
+// message, attached, inline or in a forwarded message, or nil if there is
+// none.
+func PDFAttachment(raw []byte) ([]byte, error) {
+	mr, err := createReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("creating mail reader: %w", err)
+	}
+	var data []byte
+	err = walkParts(mr, func(h mail.PartHeader, body io.Reader) error {
+		if data != nil || !isPDF(h) {
+			return nil
+		}
+		pdf, err := io.ReadAll(body)
+		if err != nil {
+			return fmt.Errorf("reading PDF attachment: %w", err)
+		}
+		data = pdf
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// InlineImages returns the images of an RFC822 message that have a
+// Content-ID, usually the logos of multipart/related HTML, as base64 data
+// URIs keyed by the Content-ID without angle brackets.
+func InlineImages(raw []byte) (map[string]string, error) {
+	mr, err := createReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("creating mail reader: %w", err)
+	}
+	images := make(map[string]string)
+	err = walkParts(mr, func(h mail.PartHeader, body io.Reader) error {
+		ct := mimeType(h)
+		id := strings.Trim(h.Get("Content-Id"), "<> ")
+		if !strings.HasPrefix(ct, "image/") || id == "" {
+			return nil
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return fmt.Errorf("reading image %s: %w", id, err)
+		}
+		images[id] = "data:" + ct + ";base64," + base64.StdEncoding.EncodeToString(data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// spool moves the RFC822 source and the HTML content of e to temporary
+// files in dir, see invoice.Email.Source and invoice.Email.HTML.
+func spool(e *invoice.Email, dir string) error {
+	raw, err := writeTemp(dir, "invoice-*.eml", e.Raw)
+	if err != nil {
+		return err
+	}
+	body, err := writeTemp(dir, "invoice-*.html", []byte(e.HTMLBody))
+	if err != nil {
+		os.Remove(raw)
+		return err
+	}
+	e.RawFile, e.Raw = raw, nil
+	e.HTMLFile, e.HTMLBody = body, ""
+	return nil
+}
+
+// writeTemp writes data to a new temporary file in dir named after
+// pattern, see os.CreateTemp, and returns its path.
+func writeTemp(dir, pattern string, data []byte) (string, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,202 @@
+package imapsource
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rummeyer/apple-invoice-pdf/pkg/invoice"
+)
+
+func TestHTMLBody(t *testing.T) {
+	raw := "From: Apple <no_reply@email.apple.com>\r\n" +
+		"Subject: Deine Rechnung von Apple\r\n" +
+		"Content-Type: multipart/alternative; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nplain\r\n" +
+		"--b\r\nContent-Type: text/html; charset=utf-8\r\n\r\n<p>Bestellnummer: MX1</p>\r\n" +
+		"--b--\r\n"
+	body, err := HTMLBody(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, "Bestellnummer: MX1") {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestHTMLBody_Nested(t *testing.T) {
+	for name, raw := range map[string]string{
+		"related in alternative": "Content-Type: multipart/alternative; boundary=a\r\n\r\n" +
+			"--a\r\nContent-Type: text/plain\r\n\r\nplain\r\n" +
+			"--a\r\nContent-Type: multipart/related; boundary=r\r\n\r\n" +
+			"--r\r\nContent-Type: text/html\r\n\r\n<p>Bestellnummer: MX1</p>\r\n" +
+			"--r\r\nContent-Type: image/png\r\nContent-Id: <logo>\r\n\r\nPNG\r\n" +
+			"--r--\r\n--a--\r\n",
+		"richest alternative": "Content-Type: multipart/alternative; boundary=a\r\n\r\n" +
+			"--a\r\nContent-Type: text/html\r\n\r\n<p>Rechnung</p>\r\n" +
+			"--a\r\nContent-Type: text/html\r\n\r\n<h1>Rechnung</h1><p>Bestellnummer: MX1</p>\r\n" +
+			"--a--\r\n",
+		"forwarded": "Content-Type: multipart/mixed; boundary=m\r\n\r\n" +
+			"--m\r\nContent-Type: text/plain\r\n\r\nsiehe Anhang\r\n" +
+			"--m\r\nContent-Type: message/rfc822\r\nContent-Disposition: attachment\r\n\r\n" +
+			"Subject: Deine Rechnung von Apple\r\nContent-Type: text/html\r\n\r\n<p>Bestellnummer: MX1</p>\r\n" +
+			"--m--\r\n",
+		"attached HTML": "Content-Type: multipart/mixed; boundary=m\r\n\r\n" +
+			"--m\r\nContent-Type: text/plain\r\n\r\nsiehe Anhang\r\n" +
+			"--m\r\nContent-Type: text/html\r\nContent-Disposition: attachment; filename=rechnung.html\r\n\r\n<p>Bestellnummer: MX1</p>\r\n" +
+			"--m--\r\n",
+	} {
+		body, err := HTMLBody(strings.NewReader("Subject: x\r\n" + raw))
+		if err != nil || !strings.Contains(body, "Bestellnummer: MX1") {
+			t.Errorf("%s: body = %q, %v", name, body, err)
+		}
+	}
+}
+
+func TestHTMLBody_Charset(t *testing.T) {
+	for name, raw := range map[string]string{
+		"ISO-8859-1": "Content-Type: text/html; charset=iso-8859-1\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n" +
+			"<meta http-equiv=3D\"Content-Type\" content=3D\"text/html; charset=3Diso-8859-1\"><p>Geb=FChr f=FCr M=E4rz</p>\r\n",
+		"windows-1252": "Content-Type: text/html; charset=windows-1252\r\n\r\n<p>Geb\xfchr f\xfcr M\xe4rz</p>\r\n",
+		"meta only":    "Content-Type: text/html\r\n\r\n<meta charset=\"ISO-8859-1\"><p>Geb\xfchr f\xfcr M\xe4rz</p>\r\n",
+		"unknown":      "Content-Type: text/html; charset=x-unknown\r\n\r\n<p>Geb\xfchr f\xfcr M\xe4rz</p>\r\n",
+	} {
+		body, err := HTMLBody(strings.NewReader("Subject: x\r\n" + raw))
+		if err != nil || !strings.Contains(body, "Gebühr für März") {
+			t.Errorf("%s: body = %q, %v", name, body, err)
+		}
+		if strings.Contains(strings.ToLower(body), "8859") {
+			t.Errorf("%s: meta tag not changed to UTF-8: %q", name, body)
+		}
+	}
+}
+
+func TestHTMLBody_PlainText(t *testing.T) {
+	raw := "Subject: x\r\nContent-Type: text/plain\r\n\r\nIhre Rechnung\r\n\r\n\r\n  Betrag:  12,00 <EUR>\r\n"
+	body, err := HTMLBody(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<!DOCTYPE html>", "<p>Ihre Rechnung</p>\n<p class=\"gap\"></p>\n<p>  Betrag:  12,00 &lt;EUR&gt;</p>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body does not contain %q: %s", want, body)
+		}
+	}
+}
+
+func TestHTMLBody_NoText(t *testing.T) {
+	raw := "Subject: x\r\nContent-Type: image/png\r\n\r\nPNG\r\n"
+	if _, err := HTMLBody(strings.NewReader(raw)); err == nil {
+		t.Error("want error for a message without text part")
+	}
+}
+
+func TestHTMLBody_TextWithPDF(t *testing.T) {
+	raw := "Subject: Ihre Rechnung\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nSehr geehrte Damen & Herren,\r\n\r\nRechnungsnummer: R0012345678\r\n" +
+		"--b\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=\"R0012345678.pdf\"\r\n\r\n%PDF-1.4\r\n" +
+		"--b--\r\n"
+	body, err := HTMLBody(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, "<p>Rechnungsnummer: R0012345678</p>") || !strings.Contains(body, "Damen &amp; Herren") {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	raw := "From: Apple <no_reply@email.apple.com>\r\n" +
+		"Subject: =?utf-8?q?Deine_Rechnung_von_Apple_?= =?iso-8859-1?q?f=FCr_M=E4rz?=\r\n" +
+		"Date: Sun, 31 Mar 2024 09:00:00 +0200\r\n" +
+		"Message-Id: <a1@email.apple.com>\r\n" +
+		"Content-Type: text/html; charset=utf-8\r\n\r\n<p>Bestellnummer: MX1</p>\r\n"
+	e, err := ParseMessage([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Subject != "Deine Rechnung von Apple für März" || e.MessageID != "<a1@email.apple.com>" || e.From != "no_reply@email.apple.com" || e.Date.Day() != 31 {
+		t.Errorf("email = %+v", e)
+	}
+	if !strings.Contains(e.HTMLBody, "MX1") || len(e.Raw) != len(raw) {
+		t.Errorf("body = %q, raw %d bytes", e.HTMLBody, len(e.Raw))
+	}
+}
+
+func TestParseMessage_Forwarded(t *testing.T) {
+	raw := "From: Anna <anna@example.com>\r\n" +
+		"Subject: WG: Deine Rechnung von Apple\r\n" +
+		"Date: Tue, 02 Apr 2024 18:00:00 +0200\r\n" +
+		"Message-Id: <fwd1@example.com>\r\n" +
+		"Content-Type: multipart/mixed; boundary=m\r\n\r\n" +
+		"--m\r\nContent-Type: text/html\r\n\r\n<p>Für die Ablage</p>\r\n" +
+		"--m\r\nContent-Type: message/rfc822\r\nContent-Disposition: attachment\r\n\r\n" +
+		"From: Apple <no_reply@email.apple.com>\r\nSubject: Deine Rechnung von Apple\r\n" +
+		"Date: Sun, 31 Mar 2024 09:00:00 +0200\r\nContent-Type: text/html\r\n\r\n<p>Bestellnummer: MX1</p>\r\n" +
+		"--m--\r\n"
+	e, err := ParseMessage([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Subject != "Deine Rechnung von Apple" || e.From != "no_reply@email.apple.com" || e.ForwardedBy != "anna@example.com" || e.Date.Day() != 31 {
+		t.Errorf("email = %+v", e)
+	}
+	if e.MessageID != "<fwd1@example.com>" || !strings.Contains(e.HTMLBody, "MX1") {
+		t.Errorf("message ID = %s, body = %q", e.MessageID, e.HTMLBody)
+	}
+}
+
+func TestPDFAttachment(t *testing.T) {
+	raw := "Subject: Your invoice is ready\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/html\r\n\r\n<p>Invoice number: E01</p>\r\n" +
+		"--b\r\nContent-Type: application/octet-stream\r\nContent-Disposition: attachment; filename=\"E01.PDF\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\nJVBERi0xLjcK\r\n" +
+		"--b--\r\n"
+	data, err := PDFAttachment([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "%PDF-1.7\n" {
+		t.Errorf("attachment = %q", data)
+	}
+
+	data, err = PDFAttachment([]byte("Subject: x\r\nContent-Type: text/html\r\n\r\n<p>x</p>\r\n"))
+	if err != nil || data != nil {
+		t.Errorf("without attachment = %q, %v", data, err)
+	}
+}
+
+func TestInlineImages(t *testing.T) {
+	raw := "Subject: Deine Rechnung von Apple\r\n" +
+		"Content-Type: multipart/related; boundary=r\r\n\r\n" +
+		"--r\r\nContent-Type: text/html\r\n\r\n<img src=\"cid:logo@apple.com\">\r\n" +
+		"--r\r\nContent-Type: image/png\r\nContent-Id: <logo@apple.com>\r\nContent-Transfer-Encoding: base64\r\n\r\nUE5H\r\n" +
+		"--r\r\nContent-Type: image/gif\r\n\r\nGIF\r\n" +
+		"--r--\r\n"
+	images, err := InlineImages([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || images["logo@apple.com"] != "data:image/png;base64,UE5H" {
+		t.Errorf("images = %v", images)
+	}
+}
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	e := invoice.Email{Raw: []byte("Subject: Rechnung\r\n\r\n"), HTMLBody: "<p>Bestellnummer: MX1</p>"}
+	if err := spool(&e, dir); err != nil {
+		t.Fatal(err)
+	}
+	if e.Raw != nil || e.HTMLBody != "" || filepath.Dir(e.RawFile) != dir || filepath.Dir(e.HTMLFile) != dir {
+		t.Fatalf("email = %+v", e)
+	}
+	if raw, err := e.Source(); err != nil || string(raw) != "Subject: Rechnung\r\n\r\n" {
+		t.Errorf("Source = %q, %v", raw, err)
+	}
+	if body, err := e.HTML(); err != nil || body != "<p>Bestellnummer: MX1</p>" {
+		t.Errorf("HTML = %q, %v", body, err)
+	}
+}
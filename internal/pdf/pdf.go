@@ -0,0 +1,157 @@
+// Package pdf renders HTML to PDF with headless Chrome.
+package pdf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// Media types for Options.Media.
+const (
+	MediaPrint  = "print"
+	MediaScreen = "screen"
+)
+
+// Options controls how Chrome renders the PDF.
+type Options struct {
+	// Tagged enables tagged (accessible) PDF output with a structure tree,
+	// as required for screen readers and PDF/UA archival.
+	Tagged bool `yaml:"tagged"`
+	// Media is the CSS media type the HTML is rendered with, MediaPrint if
+	// empty. The light color scheme is always used.
+	Media string `yaml:"media"`
+	// AllowURL, if set, decides which URLs Chrome may load while rendering,
+	// like external images, stylesheets and fonts; requests to others fail.
+	AllowURL func(url string) bool `yaml:"-"`
+}
+
+// lightScheme overrides color-scheme declarations of the HTML, which make
+// Chrome use dark default colors for "dark"-only pages regardless of the
+// emulated preference.
+const lightScheme = `(() => {
+	const style = document.createElement("style");
+	style.textContent = ":root { color-scheme: light only !important; }";
+	document.head.appendChild(style);
+})()`
+
+// imagesLoaded waits up to 30 seconds for the images of the page to load
+// or fail, so external ones are printed too.
+const imagesLoaded = `Promise.race([
+	Promise.all(Array.from(document.images, img => img.complete ? null :
+		new Promise(done => { img.onload = img.onerror = done; }))),
+	new Promise(done => setTimeout(done, 30000)),
+]).then(() => true)`
+
+// Convert renders HTML to an A4 PDF using headless Chrome.
+func Convert(ctx context.Context, htmlContent string, opts Options) ([]byte, error) {
+	ctx, cancel := chromedp.NewContext(ctx)
+	defer cancel()
+	media := opts.Media
+	if media == "" {
+		media = MediaPrint
+	}
+
+	var buf []byte
+	if err := chromedp.Run(ctx,
+		// The first action also starts the browser
+		timed("start", chromedp.Navigate("about:blank")),
+		filterRequests(opts.AllowURL),
+		// Render like paper: print media, light color scheme, so dark mode
+		// styles of the email never reach the PDF
+		timed("emulate", emulation.SetEmulatedMedia().
+			WithMedia(media).
+			WithFeatures([]*emulation.MediaFeature{{Name: "prefers-color-scheme", Value: "light"}})),
+		// Inject HTML into the page
+		timed("load", chromedp.ActionFunc(func(ctx context.Context) error {
+			ft, err := page.GetFrameTree().Do(ctx)
+			if err != nil {
+				return err
+			}
+			return page.SetDocumentContent(ft.Frame.ID, htmlContent).Do(ctx)
+		})),
+		chromedp.Evaluate(lightScheme, nil),
+		// External images load only now, embedded ones are decoded
+		timed("images", chromedp.Evaluate(imagesLoaded, nil, func(p *runtime.EvaluateParams) *runtime.EvaluateParams {
+			return p.WithAwaitPromise(true)
+		})),
+		// Print to PDF with A4 dimensions
+		timed("print", chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			buf, _, err = page.PrintToPDF().
+				WithPaperWidth(8.27).
+				WithPaperHeight(11.69).
+				WithPrintBackground(true).
+				WithGenerateTaggedPDF(opts.Tagged).
+				Do(ctx)
+			return err
+		})),
+	); err != nil {
+		return nil, fmt.Errorf("generating PDF: %w", err)
+	}
+	// Older Chrome versions silently ignore the tagged flag
+	if opts.Tagged && !IsTagged(buf) {
+		return nil, fmt.Errorf("generating PDF: Chrome did not produce a tagged PDF (update Chrome or disable pdf.tagged)")
+	}
+	return buf, nil
+}
+
+// filterRequests pauses every request of the page and fails those allow
+// rejects, including redirects, before they leave the browser. It does
+// nothing if allow is nil.
+func filterRequests(allow func(string) bool) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		if allow == nil {
+			return nil
+		}
+		chromedp.ListenTarget(ctx, func(ev any) {
+			e, ok := ev.(*fetch.EventRequestPaused)
+			if !ok {
+				return
+			}
+			// Commands cannot be sent from the event handler itself
+			go func() {
+				var err error
+				if allow(e.Request.URL) {
+					err = fetch.ContinueRequest(e.RequestID).Do(ctx)
+				} else {
+					slog.Info("Blocking request from a host that is not allowed", "url", e.Request.URL)
+					err = fetch.FailRequest(e.RequestID, network.ErrorReasonBlockedByClient).Do(ctx)
+				}
+				if err != nil && ctx.Err() == nil {
+					slog.Debug("Answering paused request failed", "url", e.Request.URL, "err", err)
+				}
+			}()
+		})
+		return fetch.Enable().Do(ctx)
+	})
+}
+
+// timed wraps a Chrome action to log its duration at debug level.
+func timed(step string, action chromedp.Action) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		start := time.Now()
+		err := action.Do(ctx)
+		slog.Debug("Chrome step", "step", step, "duration", time.Since(start), "err", err)
+		return err
+	})
+}
+
+// markedPattern matches the Marked flag of a MarkInfo dictionary.
+var markedPattern = regexp.MustCompile(`/Marked\s+true`)
+
+// IsTagged reports whether the PDF declares itself as tagged, i.e. has a
+// structure tree root and a MarkInfo dictionary with Marked set to true.
+func IsTagged(pdf []byte) bool {
+	return bytes.Contains(pdf, []byte("/StructTreeRoot")) && markedPattern.Match(pdf)
+}
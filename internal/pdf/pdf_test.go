@@ -0,0 +1,23 @@
+package pdf
+
+import "testing"
+
+func TestIsTagged(t *testing.T) {
+	tests := []struct {
+		name string
+		pdf  string
+		want bool
+	}{
+		{"tagged", "<< /Type /Catalog /StructTreeRoot 5 0 R /MarkInfo << /Marked true >> >>", true},
+		{"no struct tree", "<< /Type /Catalog /MarkInfo << /Marked true >> >>", false},
+		{"marked false", "<< /Type /Catalog /StructTreeRoot 5 0 R /MarkInfo << /Marked false >> >>", false},
+		{"untagged", "<< /Type /Catalog /Pages 2 0 R >>", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTagged([]byte(tt.pdf)); got != tt.want {
+				t.Errorf("IsTagged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LexofficeConfig configures uploads to the lexoffice Public API.
+type LexofficeConfig struct {
+	APIKey string `yaml:"api_key"`
+	// Contact is the vendor name, the title of the invoice's vendor if
+	// empty; an existing lexoffice contact with this name is used, otherwise
+	// the voucher is booked on the collective contact.
+	Contact string `yaml:"contact"`
+	// CategoryID is the booking category of the voucher item. Without it the
+	// PDFs are only uploaded to the lexoffice inbox for manual booking.
+	CategoryID string  `yaml:"category_id"`
+	TaxRate    float64 `yaml:"tax_rate"`
+	APIURL     string  `yaml:"api_url"`
+}
+
+// lexofficeRequestInterval keeps us below the API limit of 2 requests/second.
+const lexofficeRequestInterval = 500 * time.Millisecond
+
+// lexofficeSink creates a purchase invoice voucher with amount and date for
+// every invoice and attaches the PDF to it.
+type lexofficeSink struct {
+	cfg      LexofficeConfig
+	client   *http.Client
+	contacts map[string]string // name -> ID, empty if no contact matches
+	interval time.Duration
+	last     time.Time
+	SinkProgress
+}
+
+// newLexofficeSink applies defaults.
+func newLexofficeSink(cfg LexofficeConfig) *lexofficeSink {
+	if cfg.TaxRate == 0 {
+		cfg.TaxRate = 19
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = "https://api.lexoffice.io"
+	}
+	cfg.APIURL = strings.TrimRight(cfg.APIURL, "/")
+	return &lexofficeSink{cfg: cfg, client: newAPIClient(), interval: lexofficeRequestInterval}
+}
+
+func (s *lexofficeSink) Name() string { return "lexoffice" }
+
+func (s *lexofficeSink) Deliver(ctx context.Context, d *Delivery) error {
+	for _, inv := range d.Invoices {
+		key := invoiceKey(inv)
+		if s.isDone(key) {
+			continue
+		}
+		// lexoffice books in EUR only; anything else goes to the inbox
+		if s.cfg.CategoryID == "" || inv.Total.Currency != "EUR" {
+			if err := s.uploadFile(ctx, "/v1/files", inv, [][2]string{{"type", "voucher"}}); err != nil {
+				return fmt.Errorf("lexoffice: uploading %s: %w", inv.Filename, err)
+			}
+			s.markDone(key)
+			slog.Info("Uploaded invoice to the lexoffice inbox", "file", inv.Filename+".pdf")
+			continue
+		}
+		id, ok := s.created(key)
+		if !ok {
+			var err error
+			if id, err = s.createVoucher(ctx, inv); err != nil {
+				return fmt.Errorf("lexoffice: creating voucher for %s: %w", inv.Filename, err)
+			}
+			s.setCreated(key, id)
+		}
+		if err := s.uploadFile(ctx, "/v1/vouchers/"+id+"/files", inv, nil); err != nil {
+			return fmt.Errorf("lexoffice: attaching %s: %w", inv.Filename, err)
+		}
+		s.markDone(key)
+		slog.Info("Created lexoffice voucher", "file", inv.Filename+".pdf")
+	}
+	return nil
+}
+
+// lexofficeVoucher is the subset of the voucher resource we set.
+type lexofficeVoucher struct {
+	Type                 string                 `json:"type"`
+	VoucherNumber        string                 `json:"voucherNumber"`
+	VoucherDate          string                 `json:"voucherDate"`
+	TotalGrossAmount     float64                `json:"totalGrossAmount"`
+	TotalTaxAmount       float64                `json:"totalTaxAmount"`
+	TaxType              string                 `json:"taxType"`
+	UseCollectiveContact bool                   `json:"useCollectiveContact"`
+	ContactID            string                 `json:"contactId,omitempty"`
+	ContactName          string                 `json:"contactName,omitempty"`
+	Remark               string                 `json:"remark,omitempty"`
+	VoucherItems         []lexofficeVoucherItem `json:"voucherItems"`
+}
+
+type lexofficeVoucherItem struct {
+	Amount         float64 `json:"amount"`
+	TaxAmount      float64 `json:"taxAmount"`
+	TaxRatePercent float64 `json:"taxRatePercent"`
+	CategoryID     string  `json:"categoryId"`
+}
+
+// newLexofficeVoucher builds a gross-priced purchase invoice voucher.
+func newLexofficeVoucher(inv ProcessedInvoice, contactID, contactName, categoryID string, taxRate float64) lexofficeVoucher {
+	number := inv.InvoiceNumber
+	if number == "" {
+		number = inv.OrderNumber
+	}
+	tax := Amount{Cents: inv.Total.IncludedTax(taxRate)}.Decimal()
+	v := lexofficeVoucher{
+		Type:             "purchaseinvoice",
+		VoucherNumber:    number,
+		VoucherDate:      inv.Email.Date.Format("2006-01-02T15:04:05.000-07:00"),
+		TotalGrossAmount: inv.Total.Decimal(),
+		TotalTaxAmount:   tax,
+		TaxType:          "gross",
+		Remark:           strings.TrimSpace(inv.vendor().Title + " " + inv.OrderNumber),
+		VoucherItems: []lexofficeVoucherItem{{
+			Amount:         inv.Total.Decimal(),
+			TaxAmount:      tax,
+			TaxRatePercent: taxRate,
+			CategoryID:     categoryID,
+		}},
+	}
+	if contactID != "" {
+		v.ContactID = contactID
+	} else {
+		v.UseCollectiveContact = true
+		v.ContactName = contactName
+	}
+	return v
+}
+
+// createVoucher creates the voucher and returns its ID.
+func (s *lexofficeSink) createVoucher(ctx context.Context, inv ProcessedInvoice) (string, error) {
+	contact := counterparty(s.cfg.Contact, inv)
+	contactID, err := s.lookupContact(ctx, contact)
+	if err != nil {
+		return "", err
+	}
+	voucher := newLexofficeVoucher(inv, contactID, contact, s.cfg.CategoryID, s.cfg.TaxRate)
+	var created struct {
+		ID string `json:"id"`
+	}
+	s.throttle()
+	if err := doJSON(ctx, s.client, http.MethodPost, s.cfg.APIURL+"/v1/vouchers", s.header(), voucher, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// lookupContact returns the ID of the vendor contact named name, or an
+// empty string if there is none.
+func (s *lexofficeSink) lookupContact(ctx context.Context, name string) (string, error) {
+	if id, ok := s.contacts[name]; ok {
+		return id, nil
+	}
+	var page struct {
+		Content []struct {
+			ID string `json:"id"`
+		} `json:"content"`
+	}
+	u := s.cfg.APIURL + "/v1/contacts?vendor=true&name=" + url.QueryEscape(name)
+	s.throttle()
+	if err := doJSON(ctx, s.client, http.MethodGet, u, s.header(), nil, &page); err != nil {
+		return "", fmt.Errorf("looking up contact %q: %w", name, err)
+	}
+	var id string
+	if len(page.Content) > 0 {
+		id = page.Content[0].ID
+	}
+	if s.contacts == nil {
+		s.contacts = map[string]string{}
+	}
+	s.contacts[name] = id
+	return id, nil
+}
+
+// uploadFile posts the invoice PDF as multipart "file" to path.
+func (s *lexofficeSink) uploadFile(ctx context.Context, path string, inv ProcessedInvoice, fields [][2]string) error {
+	data, err := inv.readPDF()
+	if err != nil {
+		return err
+	}
+	body, contentType, err := newMultipartBody(fields, []multipartFile{
+		{Field: "file", Filename: inv.Filename + ".pdf", ContentType: "application/pdf", Data: data},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.APIURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header = s.header()
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	s.throttle()
+	return doRequest(s.client, req, nil)
+}
+
+func (s *lexofficeSink) header() http.Header {
+	return http.Header{"Authorization": {"Bearer " + s.cfg.APIKey}}
+}
+
+// throttle waits until the request interval has passed since the last call.
+func (s *lexofficeSink) throttle() {
+	if wait := s.interval - time.Since(s.last); wait > 0 {
+		time.Sleep(wait)
+	}
+	s.last = time.Now()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLexofficeSink_Deliver(t *testing.T) {
+	var voucher lexofficeVoucher
+	var requests []string
+	var file string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/v1/contacts":
+			if r.URL.Query().Get("name") != "Apple" || r.URL.Query().Get("vendor") != "true" {
+				t.Errorf("contact query = %s", r.URL.RawQuery)
+			}
+			json.NewEncoder(w).Encode(map[string]any{"content": []map[string]string{{"id": "c-1"}}})
+		case "/v1/vouchers":
+			json.NewDecoder(r.Body).Decode(&voucher)
+			json.NewEncoder(w).Encode(map[string]string{"id": "v-1"})
+		case "/v1/vouchers/v-1/files":
+			f, h, _ := r.FormFile("file")
+			data, _ := io.ReadAll(f)
+			file = h.Filename + ":" + string(data)
+			json.NewEncoder(w).Encode(map[string]string{"id": "f-1"})
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	sink := newLexofficeSink(LexofficeConfig{APIKey: "key", CategoryID: "cat-1", APIURL: srv.URL})
+	sink.interval = 0
+	inv := testProcessedInvoice()
+	inv.Filename = "invoice"
+	inv.PDF = []byte("%PDF")
+	second := inv
+	second.Email.MessageID = "<b@apple.com>"
+	d := &Delivery{Invoices: []ProcessedInvoice{inv, second}}
+	if err := sink.Deliver(context.Background(), d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The contact is looked up once per run
+	if len(requests) != 5 || requests[0] != "GET /v1/contacts" {
+		t.Errorf("requests = %v", requests)
+	}
+	if voucher.Type != "purchaseinvoice" || voucher.VoucherNumber != "DE/2024/0815" || voucher.ContactID != "c-1" || voucher.UseCollectiveContact {
+		t.Errorf("voucher = %+v", voucher)
+	}
+	if voucher.TotalGrossAmount != 9.99 || voucher.TotalTaxAmount != 1.6 || voucher.TaxType != "gross" {
+		t.Errorf("amounts = %v/%v", voucher.TotalGrossAmount, voucher.TotalTaxAmount)
+	}
+	if voucher.VoucherDate != "2024-05-14T10:00:00.000+00:00" {
+		t.Errorf("voucherDate = %q", voucher.VoucherDate)
+	}
+	if item := voucher.VoucherItems[0]; item.CategoryID != "cat-1" || item.TaxRatePercent != 19 || item.Amount != 9.99 {
+		t.Errorf("item = %+v", item)
+	}
+	if file != "invoice.pdf:%PDF" {
+		t.Errorf("file = %q", file)
+	}
+}
+
+func TestLexofficeSink_InboxWithoutCategory(t *testing.T) {
+	var path, typ string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, typ = r.URL.Path, r.FormValue("type")
+		w.Write([]byte(`{"id":"f-1"}`))
+	}))
+	defer srv.Close()
+
+	sink := newLexofficeSink(LexofficeConfig{APIKey: "key", APIURL: srv.URL})
+	sink.interval = 0
+	if err := sink.Deliver(context.Background(), &Delivery{Invoices: []ProcessedInvoice{testProcessedInvoice()}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/v1/files" || typ != "voucher" {
+		t.Errorf("path/type = %q/%q", path, typ)
+	}
+}
+
+func TestLexofficeSink_RetryKeepsVoucher(t *testing.T) {
+	var vouchers, attachments int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/contacts":
+			w.Write([]byte(`{"content":[]}`))
+		case "/v1/vouchers":
+			vouchers++
+			w.Write([]byte(`{"id":"v-1"}`))
+		case "/v1/vouchers/v-1/files":
+			// The first attachment fails after the voucher was created
+			if attachments++; attachments == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"id":"f-1"}`))
+		}
+	}))
+	defer srv.Close()
+
+	sink := newLexofficeSink(LexofficeConfig{APIKey: "key", CategoryID: "cat-1", APIURL: srv.URL})
+	sink.interval = 0
+	d := &Delivery{Invoices: []ProcessedInvoice{testProcessedInvoice()}}
+	if err := sink.Deliver(context.Background(), d); err == nil {
+		t.Fatal("expected the attachment to fail")
+	}
+	for range 2 {
+		if err := sink.Deliver(context.Background(), d); err != nil {
+			t.Fatalf("retry: %v", err)
+		}
+	}
+	if vouchers != 1 || attachments != 2 {
+		t.Errorf("created %d vouchers with %d attachments, want 1 and 2", vouchers, attachments)
+	}
+}
+
+func TestNewLexofficeVoucher_CollectiveContact(t *testing.T) {
+	v := newLexofficeVoucher(testProcessedInvoice(), "", "Apple", "cat-1", 19)
+	if !v.UseCollectiveContact || v.ContactName != "Apple" || v.ContactID != "" {
+		t.Errorf("voucher contact = %+v", v)
+	}
+}
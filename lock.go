@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+)
+
+// LockConfig configures the lock file preventing concurrent runs.
+type LockConfig struct {
+	File string `yaml:"file"`
+}
+
+// errLocked is returned by acquireLock if another process holds the lock.
+var errLocked = errors.New("another instance is running")
+
+// defaultLockFile returns the lock file used if lock.file is not set: next
+// to state.file if set, else next to the config file at configPath. Runs
+// sharing a state or config file are serialized wherever they are started
+// from, e.g. cron jobs whose working directory is $HOME.
+func defaultLockFile(stateFile, configPath string) string {
+	dir := filepath.Dir(configPath)
+	if stateFile != "" {
+		dir = filepath.Dir(stateFile)
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return filepath.Join(dir, "apple-invoice-pdf.lock")
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// acquireLock only creates the lock file: flock is not available here, so
+// concurrent runs are not prevented.
+func acquireLock(path string) (*os.File, error) {
+	slog.Warn("Lock files are not supported on this platform")
+	return os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquireLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apple-invoice-pdf.lock")
+	f, err := acquireLock(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := acquireLock(path); !errors.Is(err, errLocked) {
+		t.Fatalf("second lock: err = %v, want errLocked", err)
+	}
+	f.Close()
+
+	// Released with the file
+	f, err = acquireLock(path)
+	if err != nil {
+		t.Fatalf("lock after release: %v", err)
+	}
+	f.Close()
+}
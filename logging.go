@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// newLogger returns a logger writing records of at least level to w in
+// format "text" (key=value pairs) or "json" (one object per line, for Loki
+// or ELK). Credentials are redacted from all records.
+func newLogger(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "text", "":
+		return slog.New(redactHandler{slog.NewTextHandler(w, opts)}), nil
+	case "json":
+		return slog.New(redactHandler{slog.NewJSONHandler(w, opts)}), nil
+	default:
+		return nil, fmt.Errorf("want text or json, got %q", format)
+	}
+}
+
+// logLevel returns the level selected by the -v and -q flags.
+func logLevel(verbose, quiet bool) (slog.Level, error) {
+	switch {
+	case verbose && quiet:
+		return 0, errors.New("-v and -q are mutually exclusive")
+	case verbose:
+		return slog.LevelDebug, nil
+	case quiet:
+		return slog.LevelWarn, nil
+	default:
+		return slog.LevelInfo, nil
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/htmlclean"
+)
+
+func TestNewLogger_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "json", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("PDF generated", "uid", uint32(42), "order_number", "MXYZ123")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("not JSON: %v\n%s", err, buf.String())
+	}
+	if entry["msg"] != "PDF generated" || entry["level"] != "INFO" {
+		t.Errorf("entry = %v", entry)
+	}
+	if entry["uid"] != float64(42) || entry["order_number"] != "MXYZ123" {
+		t.Errorf("fields = %v", entry)
+	}
+}
+
+func TestNewLogger_Text(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "text", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Warn("No body", "uid", 7)
+	if out := buf.String(); !strings.Contains(out, `level=WARN msg="No body" uid=7`) {
+		t.Errorf("output = %q", out)
+	}
+}
+
+func TestNewLogger_Invalid(t *testing.T) {
+	if _, err := newLogger(&bytes.Buffer{}, "xml", slog.LevelInfo); err == nil {
+		t.Error("want error for unknown format")
+	}
+}
+
+func TestNewLogger_Level(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "text", slog.LevelWarn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("Scanning INBOX")
+	logger.Warn("No body")
+	if out := buf.String(); strings.Contains(out, "Scanning INBOX") || !strings.Contains(out, "No body") {
+		t.Errorf("output = %q", out)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	for _, tt := range []struct {
+		verbose, quiet bool
+		want           slog.Level
+	}{
+		{false, false, slog.LevelInfo},
+		{true, false, slog.LevelDebug},
+		{false, true, slog.LevelWarn},
+	} {
+		got, err := logLevel(tt.verbose, tt.quiet)
+		if err != nil || got != tt.want {
+			t.Errorf("logLevel(%v, %v) = %v, %v; want %v", tt.verbose, tt.quiet, got, err, tt.want)
+		}
+	}
+	if _, err := logLevel(true, true); err == nil {
+		t.Error("want error for -v with -q")
+	}
+}
+
+func TestCleanHTML_LogsSelectorMatches(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "text", slog.LevelDebug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	if _, err := cleanHTML(context.Background(), nil, `<div class="inline-link-group">a</div><div class="inline-link-group">b</div>`, htmlclean.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, `selector=.inline-link-group matches=2`) || !strings.Contains(out, `selector=.action-button-cell matches=0`) {
+		t.Errorf("output = %q", out)
+	}
+}
@@ -7,24 +7,31 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"net/http"
+	"log/slog"
+	"net/textproto"
 	"os"
+	"os/signal"
 	"regexp"
+	"runtime/debug"
+	"slices"
 	"strings"
+	"syscall"
+	"text/template"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
-	"github.com/chromedp/cdproto/page"
-	"github.com/chromedp/chromedp"
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
-	"github.com/emersion/go-message/mail"
 	"gopkg.in/gomail.v2"
 	"gopkg.in/yaml.v3"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/deliver"
+	"github.com/rummeyer/apple-invoice-pdf/internal/htmlclean"
+	"github.com/rummeyer/apple-invoice-pdf/internal/imapsource"
+	"github.com/rummeyer/apple-invoice-pdf/internal/pdf"
+	"github.com/rummeyer/apple-invoice-pdf/pkg/invoice"
 )
 
 // Config holds all settings loaded from config.yaml.
@@ -32,36 +39,196 @@ type Config struct {
 	IMAP struct {
 		Host string `yaml:"host"`
 		Port int    `yaml:"port"`
+		// Batch is the number of envelopes fetched per FETCH command,
+		// defaultIMAPBatch if unset.
+		Batch int `yaml:"batch"`
 	} `yaml:"imap"`
-	SMTP struct {
-		Host string `yaml:"host"`
-		Port int    `yaml:"port"`
-	} `yaml:"smtp"`
-	User  string `yaml:"user"`
-	Pass  string `yaml:"pass"`
+	SMTP SMTPConfig `yaml:"smtp"`
+	// TLS applies to the IMAP and SMTP connections.
+	TLS   TLSConfig `yaml:"tls"`
+	User  string    `yaml:"user"`
+	Pass  string    `yaml:"pass"`
 	Email struct {
-		From    string `yaml:"from"`
-		To      string `yaml:"to"`
-		Subject string `yaml:"subject"`
+		From string `yaml:"from"`
+		To   string `yaml:"to"`
+		// Subject and Body are text/template strings, see MessageData.
+		Subject   string `yaml:"subject"`
+		Body      string `yaml:"body"`
+		AttachEML bool   `yaml:"attach_eml"`
+		ReplyTo   string `yaml:"reply_to"`
+		// PerInvoice sends one email per invoice instead of a single one.
+		PerInvoice bool `yaml:"per_invoice"`
+		// Thread replies to the original invoice email (In-Reply-To and
+		// References) when a message carries a single invoice.
+		Thread bool `yaml:"thread"`
+		// Headers are added to the outgoing message as is.
+		Headers map[string]string `yaml:"headers"`
+		// ZIP sends all attachments as a single ZIP archive.
+		ZIP         bool   `yaml:"zip"`
+		ZIPPassword string `yaml:"zip_password"`
+		// HTML adds an HTML body with an invoice summary table.
+		HTML         bool   `yaml:"html"`
+		HTMLTemplate string `yaml:"html_template"`
 	} `yaml:"email"`
-	Filter struct {
+	// Vendor selects the VendorProfiles, "apple" by default; with several,
+	// each email is processed with the one detectVendor finds.
+	Vendor VendorNames `yaml:"vendor"`
+	// Vendors defines further profiles or replaces built-in ones.
+	Vendors []*VendorProfile `yaml:"vendors"`
+	Filter  struct {
 		Count   int    `yaml:"count"`
 		Subject string `yaml:"subject"`
 		From    string `yaml:"from"`
+		// Date selects the date that decides an email's month: "received",
+		// the server's INTERNALDATE (default), or "sent", the Date header.
+		Date string `yaml:"date"`
+		// Timezone is the IANA zone the month boundaries are in, the local
+		// zone if empty.
+		Timezone string `yaml:"timezone"`
+		location *time.Location
 	} `yaml:"filter"`
+	Cover struct {
+		Enabled  bool   `yaml:"enabled"`
+		Template string `yaml:"template"`
+	} `yaml:"cover"`
+	PDF      PDFOptions   `yaml:"pdf"`
+	Images   ImagesConfig `yaml:"images"`
+	Clean    CleanConfig  `yaml:"clean"`
+	Filename struct {
+		Preset        string         `yaml:"preset"`
+		Template      string         `yaml:"template"`
+		IncludeAmount bool           `yaml:"include_amount"`
+		Sanitize      SanitizePolicy `yaml:"sanitize"`
+	} `yaml:"filename"`
+	Manifest struct {
+		Dir    string `yaml:"dir"`
+		Attach bool   `yaml:"attach"`
+	} `yaml:"manifest"`
+	Output struct {
+		Dir      string `yaml:"dir"`
+		Sidecars bool   `yaml:"sidecars"`
+		// HTML keeps the original, uncleaned HTML of each invoice.
+		HTML bool `yaml:"html"`
+	} `yaml:"output"`
+	S3         S3Config         `yaml:"s3"`
+	Paperless  PaperlessConfig  `yaml:"paperless"`
+	SFTP       SFTPConfig       `yaml:"sftp"`
+	FTPS       FTPSConfig       `yaml:"ftps"`
+	Webhook    WebhookConfig    `yaml:"webhook"`
+	Telegram   TelegramConfig   `yaml:"telegram"`
+	Slack      SlackConfig      `yaml:"slack"`
+	Matrix     MatrixConfig     `yaml:"matrix"`
+	Ntfy       NtfyConfig       `yaml:"ntfy"`
+	Sendmail   SendmailConfig   `yaml:"sendmail"`
+	IMAPAppend IMAPAppendConfig `yaml:"imap_append"`
+	Lexoffice  LexofficeConfig  `yaml:"lexoffice"`
+	SevDesk    SevDeskConfig    `yaml:"sevdesk"`
+	DATEV      DATEVConfig      `yaml:"datev"`
+	QuickBooks QuickBooksConfig `yaml:"quickbooks"`
+	Xero       XeroConfig       `yaml:"xero"`
+	Firefly    FireflyConfig    `yaml:"firefly"`
+	YNAB       YNABConfig       `yaml:"ynab"`
+	Actual     ActualConfig     `yaml:"actual"`
+	Delivery   DeliveryConfig   `yaml:"delivery"`
+	Daemon     DaemonConfig     `yaml:"daemon"`
+	State      StateConfig      `yaml:"state"`
+	Spool      SpoolConfig      `yaml:"spool"`
+	Outbox     OutboxConfig     `yaml:"outbox"`
+	Lock       LockConfig       `yaml:"lock"`
+	Tracing    TracingConfig    `yaml:"tracing"`
+	Report     ReportConfig     `yaml:"report"`
+	Sentry     SentryConfig     `yaml:"sentry"`
+	Audit      AuditConfig      `yaml:"audit"`
+	Pipeline   PipelineConfig   `yaml:"pipeline"`
+	Plugins    []PluginConfig   `yaml:"plugins"`
+}
+
+// PDFOptions controls how Chrome renders the PDF.
+type PDFOptions = pdf.Options
+
+// InvoiceEmail holds a matched email's IMAP UID, subject, date, HTML
+// content, and the raw RFC822 source it was extracted from.
+type InvoiceEmail = invoice.Email
+
+// Amount is a monetary value in minor units (cents) with its ISO currency
+// code. The zero Amount means "unknown".
+type Amount = invoice.Amount
+
+// ProcessedInvoice holds an invoice after conversion along with the data
+// parsed from its HTML.
+type ProcessedInvoice struct {
+	Email         InvoiceEmail
+	OrderNumber   string
+	InvoiceNumber string
+	AppleID       string
+	Total         Amount
+	Filename      string // base name without extension
+	PDF           []byte
+	// PDFFile, if set, holds the PDF on disk instead of PDF, see readPDF.
+	PDFFile string
+	// Vendor is the profile the invoice is processed with; nil means
+	// Apple's.
+	Vendor *VendorProfile
+	// HTML is the markup the pdf transformer renders, the email's HTML
+	// body as prepared by the preceding transformers.
+	HTML string
 }
 
-// InvoiceEmail holds a matched email's subject, date, and HTML content.
-type InvoiceEmail struct {
-	Subject  string
-	Date     time.Time
-	HTMLBody string
+// vendor returns p.Vendor or, if unset, Apple's profile.
+func (p ProcessedInvoice) vendor() *VendorProfile {
+	if p.Vendor == nil {
+		return appleVendor
+	}
+	return p.Vendor
+}
+
+// readPDF returns the PDF from PDF or PDFFile.
+func (p ProcessedInvoice) readPDF() ([]byte, error) {
+	if p.PDF != nil || p.PDFFile == "" {
+		return p.PDF, nil
+	}
+	return os.ReadFile(p.PDFFile)
 }
 
-// PDFAttachment holds a generated PDF ready for email attachment.
+// pdfSize returns the size of the PDF in bytes, 0 if it cannot be read.
+func (p ProcessedInvoice) pdfSize() int {
+	if p.PDF != nil || p.PDFFile == "" {
+		return len(p.PDF)
+	}
+	fi, err := os.Stat(p.PDFFile)
+	if err != nil {
+		return 0
+	}
+	return int(fi.Size())
+}
+
+// PDFAttachment holds a generated file (PDF or source .eml) ready for email attachment.
 type PDFAttachment struct {
 	Filename string
 	Data     []byte
+	// File, if set, holds the content on disk instead of Data, see content.
+	File    string
+	Invoice *ProcessedInvoice // source invoice, nil for summaries like the cover page
+}
+
+// content returns the file's content from Data or File.
+func (a PDFAttachment) content() ([]byte, error) {
+	if a.Data != nil || a.File == "" {
+		return a.Data, nil
+	}
+	return os.ReadFile(a.File)
+}
+
+// size returns the size of the file in bytes, 0 if it cannot be read.
+func (a PDFAttachment) size() int {
+	if a.Data != nil || a.File == "" {
+		return len(a.Data)
+	}
+	fi, err := os.Stat(a.File)
+	if err != nil {
+		return 0
+	}
+	return int(fi.Size())
 }
 
 // loadConfig reads config.yaml and applies defaults for optional fields.
@@ -77,280 +244,338 @@ func loadConfig(path string) (*Config, error) {
 	if cfg.Email.From == "" {
 		cfg.Email.From = cfg.User
 	}
-	if cfg.Filter.Subject == "" {
-		cfg.Filter.Subject = "Deine Rechnung von Apple"
+	if len(cfg.Vendor) == 0 {
+		cfg.Vendor = VendorNames{appleVendor.Name}
+	}
+	if err := validateVendors(cfg.Vendors); err != nil {
+		return nil, err
+	}
+	if err := validateVendorNames(&cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Clean.rules, err = cleanRules(cfg.Clean.Rules); err != nil {
+		return nil, fmt.Errorf("clean: %w", err)
+	}
+	switch cfg.Clean.Links {
+	case "", htmlclean.LinksKeep, htmlclean.LinksStrip, htmlclean.LinksFootnotes:
+	default:
+		return nil, fmt.Errorf("clean.links must be %s, %s or %s, not %q", htmlclean.LinksKeep, htmlclean.LinksStrip, htmlclean.LinksFootnotes, cfg.Clean.Links)
+	}
+	switch cfg.PDF.Media {
+	case "", pdf.MediaPrint, pdf.MediaScreen:
+	default:
+		return nil, fmt.Errorf("pdf.media must be %s or %s, not %q", pdf.MediaPrint, pdf.MediaScreen, cfg.PDF.Media)
+	}
+	if cfg.Images.Workers < 0 {
+		return nil, fmt.Errorf("images.workers must not be negative, got %d", cfg.Images.Workers)
+	}
+	if len(cfg.Images.Allow) > 0 || len(cfg.Images.Block) > 0 {
+		// Also for what Chrome loads itself: images.external, stylesheets
+		cfg.PDF.AllowURL = func(u string) bool { return htmlclean.AllowedURL(u, cfg.Images.Allow, cfg.Images.Block) }
+	}
+	if err := cfg.TLS.parse(); err != nil {
+		return nil, err
 	}
-	if cfg.Filter.From == "" {
-		cfg.Filter.From = "apple.com"
+	if cfg.IMAP.Batch < 0 {
+		return nil, fmt.Errorf("imap.batch must not be negative, got %d", cfg.IMAP.Batch)
+	}
+	switch cfg.Filter.Date {
+	case "":
+		cfg.Filter.Date = dateReceived
+	case dateReceived, dateSent:
+	default:
+		return nil, fmt.Errorf("filter.date must be %s or %s, not %q", dateReceived, dateSent, cfg.Filter.Date)
+	}
+	if cfg.Filter.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Filter.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("filter.timezone: %w", err)
+		}
+		cfg.Filter.location = loc
 	}
 	if cfg.Email.Subject == "" {
 		cfg.Email.Subject = "Deine PDF-Rechnungen von Apple"
 	}
+	if cfg.Email.Body == "" {
+		cfg.Email.Body = "Dokumente anbei.\n"
+	}
+	if cfg.Filename.Preset == "" {
+		cfg.Filename.Preset = "default"
+	}
+	if cfg.Images.Attempts == 0 {
+		cfg.Images.Attempts = 3
+	}
+	if cfg.Delivery.Attempts == 0 {
+		cfg.Delivery.Attempts = 3
+	}
+	if cfg.Delivery.RetryDelay == 0 {
+		cfg.Delivery.RetryDelay = 10 * time.Second
+	}
+	if cfg.Lock.File == "" {
+		cfg.Lock.File = defaultLockFile(cfg.State.File, path)
+	}
+	if cfg.Filename.Template == "" {
+		tmpl, ok := filenamePresets[cfg.Filename.Preset]
+		if !ok {
+			return nil, fmt.Errorf("unknown filename preset %q", cfg.Filename.Preset)
+		}
+		cfg.Filename.Template = tmpl
+	}
+	if cfg.Filename.IncludeAmount {
+		cfg.Filename.Template = withAmount(cfg.Filename.Template)
+		for _, v := range cfg.Vendors {
+			if v.Filename != "" {
+				v.Filename = withAmount(v.Filename)
+			}
+		}
+	}
+	if _, err := template.New("filename").Parse(cfg.Filename.Template); err != nil {
+		return nil, fmt.Errorf("parsing filename template: %w", err)
+	}
+	for name, src := range map[string]string{"subject": cfg.Email.Subject, "body": cfg.Email.Body} {
+		if _, err := template.New(name).Parse(src); err != nil {
+			return nil, fmt.Errorf("parsing email %s template: %w", name, err)
+		}
+	}
+	if cfg.Daemon.Schedule != "" {
+		sched, err := parseCron(cfg.Daemon.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("daemon: %w", err)
+		}
+		if _, err := cfg.Daemon.runMonth(sched); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateHeaders(cfg.Email.Headers); err != nil {
+		return nil, fmt.Errorf("email: %w", err)
+	}
+	if err := cfg.Filename.Sanitize.validate(); err != nil {
+		return nil, fmt.Errorf("filename: %w", err)
+	}
+	for name, sp := range map[string]*SanitizePolicy{"s3": cfg.S3.Sanitize, "sftp": cfg.SFTP.Sanitize, "ftps": cfg.FTPS.Sanitize} {
+		if sp == nil {
+			continue
+		}
+		if err := sp.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if err := cfg.SMTP.validate(); err != nil {
+		return nil, fmt.Errorf("smtp: %w", err)
+	}
+	if err := validatePlugins(cfg.Plugins); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
 
 // matchesFilter checks if an email envelope matches the configured subject,
-// sender domain, and is from the current month.
-func matchesFilter(env *imap.Envelope, cfg *Config) bool {
-	// Only match emails from the current month
-	now := time.Now()
-	if env.Date.Year() != now.Year() || env.Date.Month() != now.Month() {
+// sender domain, and is from the given month.
+func matchesFilter(msg *imap.Message, cfg *Config, month time.Time) bool {
+	date := messageDate(msg, cfg)
+	if date.Year() != month.Year() || date.Month() != month.Month() {
 		return false
 	}
-	if env.Subject != cfg.Filter.Subject {
-		return false
+	return matchesInvoice(msg.Envelope, cfg)
+}
+
+// Values of filter.date.
+const (
+	dateReceived = "received"
+	dateSent     = "sent"
+)
+
+// messageDate returns the date of msg selected by filter.date in the zone
+// of filter.timezone. Without an INTERNALDATE from the server, the Date
+// header is used.
+func messageDate(msg *imap.Message, cfg *Config) time.Time {
+	date := msg.InternalDate
+	if cfg.Filter.Date == dateSent || date.IsZero() {
+		date = msg.Envelope.Date
 	}
-	for _, addr := range env.From {
-		if strings.Contains(strings.ToLower(addr.HostName), strings.ToLower(cfg.Filter.From)) {
-			return true
-		}
+	loc := cfg.Filter.location
+	if loc == nil {
+		loc = time.Local
 	}
-	return false
+	return date.In(loc)
 }
 
-// extractHTMLBody walks MIME parts and returns the first text/html content.
-func extractHTMLBody(r io.Reader) (string, error) {
-	mr, err := mail.CreateReader(r)
-	if err != nil {
-		return "", fmt.Errorf("creating mail reader: %w", err)
-	}
-	for {
-		p, err := mr.NextPart()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return "", fmt.Errorf("reading mail part: %w", err)
-		}
-		if h, ok := p.Header.(*mail.InlineHeader); ok {
-			if ct, _, _ := h.ContentType(); ct == "text/html" {
-				body, err := io.ReadAll(p.Body)
-				if err != nil {
-					return "", fmt.Errorf("reading HTML body: %w", err)
-				}
-				return string(body), nil
-			}
+// matchesInvoice checks the subject and sender domain only: env is an
+// invoice if one of the selected vendor profiles matches it, see
+// VendorProfile.matches.
+func matchesInvoice(env *imap.Envelope, cfg *Config) bool {
+	for _, v := range cfg.vendors() {
+		if v.matches(env, cfg) {
+			return true
 		}
 	}
-	return "", fmt.Errorf("no text/html part found")
+	return false
 }
 
 // fetchInvoices connects to IMAP, scans the last N emails, and returns
-// matching invoices. Uses a two-pass approach: first fetch lightweight
-// envelopes, then fetch full bodies only for matches.
-func fetchInvoices(cfg *Config) ([]InvoiceEmail, error) {
-	// Connect via TLS
-	addr := fmt.Sprintf("%s:%d", cfg.IMAP.Host, cfg.IMAP.Port)
-	c, err := client.DialTLS(addr, &tls.Config{ServerName: cfg.IMAP.Host})
+// matching invoices from the given month. Uses a two-pass approach: first
+// fetch lightweight envelopes, then fetch full bodies only for matches.
+func fetchInvoices(ctx context.Context, cfg *Config, month time.Time) (invoices []InvoiceEmail, err error) {
+	ctx, span := startSpan(ctx, "fetch", "month", month.Format("2006-01"))
+	defer timingsFrom(ctx).since("imap_fetch", time.Now())
+	defer func() {
+		span.set("invoices", len(invoices))
+		span.finish(err)
+	}()
+
+	c, err := dialIMAP(ctx, cfg)
 	if err != nil {
-		return nil, fmt.Errorf("connecting to IMAP server: %w", err)
+		return nil, err
 	}
 	defer c.Logout()
 
-	if err := c.Login(cfg.User, cfg.Pass); err != nil {
-		return nil, fmt.Errorf("IMAP login: %w", err)
-	}
-	log.Println("Logged in to IMAP server")
-
 	// Open INBOX read-only (true) since we never modify messages
+	slog.Debug("IMAP command", "command", "EXAMINE", "mailbox", "INBOX")
 	mbox, err := c.Select("INBOX", true)
 	if err != nil {
 		return nil, fmt.Errorf("selecting INBOX: %w", err)
 	}
-	log.Printf("INBOX has %d messages", mbox.Messages)
+	slog.Info("Scanning INBOX", "messages", mbox.Messages)
 	if mbox.Messages == 0 {
 		return nil, nil
 	}
 
-	// Build sequence set: last N messages if count is set, otherwise all
-	from := uint32(1)
-	if cfg.Filter.Count > 0 {
-		count := uint32(cfg.Filter.Count)
-		if mbox.Messages > count {
-			from = mbox.Messages - count + 1
-		}
-	}
-	seqSet := new(imap.SeqSet)
-	seqSet.AddRange(from, mbox.Messages)
-
 	// Pass 1: fetch envelopes only (lightweight) to find matches
-	matchUIDs := fetchMatchingUIDs(c, seqSet, cfg)
+	from, to := scanRange(cfg, mbox.Messages)
+	scanned := int(to - from + 1)
+	matchUIDs, err := fetchMatchingUIDs(ctx, c, cfg, month, from, to)
+	if err != nil {
+		return nil, err
+	}
 	if len(matchUIDs) == 0 {
-		log.Println("No invoice emails found")
+		runReportFrom(ctx).scanned(scanned, 0)
+		slog.Info("No invoice emails found")
 		return nil, nil
 	}
-	log.Printf("Found %d invoice(s), fetching bodies...", len(matchUIDs))
+	slog.Info("Fetching bodies", "invoices", len(matchUIDs))
+	runReportFrom(ctx).scanned(scanned, len(matchUIDs))
 
 	// Pass 2: fetch full bodies only for matching UIDs (Peek=true to avoid marking as read)
-	return fetchBodies(c, matchUIDs)
+	return fetchBodies(ctx, c, cfg, matchUIDs)
 }
 
-// fetchMatchingUIDs fetches envelopes and returns UIDs of emails matching the filter.
-func fetchMatchingUIDs(c *client.Client, seqSet *imap.SeqSet, cfg *Config) []uint32 {
-	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchUid}
-	messages := make(chan *imap.Message, 10)
-	done := make(chan error, 1)
-	go func() { done <- c.Fetch(seqSet, items, messages) }()
+// fetchBodies fetches the emails with the given UIDs from the selected
+// mailbox and drops the forwards that do not carry an invoice of a
+// selected vendor, see matchesForward.
+func fetchBodies(ctx context.Context, c *client.Client, cfg *Config, uids []uint32) ([]InvoiceEmail, error) {
+	invoices, err := imapsource.FetchBodies(c, uids, spoolFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(invoices, func(e InvoiceEmail) bool {
+		if forwarded(e) && !matchesForward(e, cfg) {
+			slog.Info("Skipping forwarded email without an attached invoice", "uid", e.UID, "subject", e.Subject)
+			return true
+		}
+		return false
+	}), nil
+}
 
-	var uids []uint32
-	for msg := range messages {
-		if msg.Envelope != nil && matchesFilter(msg.Envelope, cfg) {
-			log.Printf("Found invoice: %q (UID %d)", msg.Envelope.Subject, msg.Uid)
-			uids = append(uids, msg.Uid)
+// forwarded reports whether e was forwarded, attaching the invoice or not.
+func forwarded(e InvoiceEmail) bool {
+	return e.ForwardedBy != "" || isForward(e.Subject)
+}
+
+// matchesForward checks a forwarded email, which the envelope filter let
+// through on its subject, against the selected vendor profiles now that
+// the sender of the invoice is known.
+func matchesForward(e InvoiceEmail, cfg *Config) bool {
+	for _, v := range cfg.vendors() {
+		if v.matchesEmail(e, cfg) {
+			return true
 		}
 	}
-	if err := <-done; err != nil {
-		log.Printf("WARNING: fetching envelopes: %v", err)
-	}
-	return uids
+	return false
 }
 
-// fetchBodies fetches full MIME bodies for the given UIDs and extracts HTML content.
-func fetchBodies(c *client.Client, uids []uint32) ([]InvoiceEmail, error) {
-	uidSet := new(imap.SeqSet)
-	for _, uid := range uids {
-		uidSet.AddNum(uid)
-	}
+// dialIMAP connects to the configured IMAP server via TLS and logs in.
+// Cancelling ctx closes the connection, aborting any pending command.
+func dialIMAP(ctx context.Context, cfg *Config) (*client.Client, error) {
+	return imapsource.Dial(ctx, imapsource.Config{Host: cfg.IMAP.Host, Port: cfg.IMAP.Port, User: cfg.User, Pass: cfg.Pass, TLS: cfg.TLS.apply(&tls.Config{})})
+}
 
-	section := &imap.BodySectionName{Peek: true}
-	items := []imap.FetchItem{section.FetchItem(), imap.FetchEnvelope}
-	messages := make(chan *imap.Message, len(uids))
-	done := make(chan error, 1)
-	go func() { done <- c.UidFetch(uidSet, items, messages) }()
+// defaultIMAPBatch is the number of envelopes fetched per FETCH command
+// without imap.batch.
+const defaultIMAPBatch = 500
 
-	var invoices []InvoiceEmail
-	for msg := range messages {
-		r := msg.GetBody(section)
-		if r == nil {
-			log.Printf("WARNING: no body for UID %d", msg.Uid)
-			continue
-		}
-		htmlBody, err := extractHTMLBody(r)
-		if err != nil {
-			log.Printf("WARNING: extracting HTML from UID %d: %v", msg.Uid, err)
-			continue
+// scanRange returns the first and last sequence number of the messages a
+// run scans, the last filter.count of the mailbox's messages if count is
+// set, otherwise all.
+func scanRange(cfg *Config, messages uint32) (from, to uint32) {
+	from = 1
+	if cfg.Filter.Count > 0 {
+		count := uint32(cfg.Filter.Count)
+		if messages > count {
+			from = messages - count + 1
 		}
-		invoices = append(invoices, InvoiceEmail{Subject: msg.Envelope.Subject, Date: msg.Envelope.Date, HTMLBody: htmlBody})
-	}
-	if err := <-done; err != nil {
-		return nil, fmt.Errorf("fetching bodies: %w", err)
 	}
-	return invoices, nil
+	return from, messages
 }
 
-// embedImage downloads an image URL and returns it as a base64 data URI.
-func embedImage(imgURL string) (string, error) {
-	resp, err := http.Get(imgURL)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+// fetchEnvelopes calls fn with the envelope of each message from sequence
+// number from to to, fetched in batches of imap.batch since some servers
+// drop the connection on a single FETCH of tens of thousands of messages.
+// Progress is logged when there is more than one batch.
+func fetchEnvelopes(ctx context.Context, c *client.Client, cfg *Config, from, to uint32, fn func(*imap.Message)) error {
+	batch := uint32(cfg.IMAP.Batch)
+	if batch == 0 {
+		batch = defaultIMAPBatch
 	}
-	mime := resp.Header.Get("Content-Type")
-	if mime == "" {
-		mime = "image/png"
+	total := to - from + 1
+	for lo := from; lo <= to; lo += batch {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		heartbeat(ctx)
+		hi := min(lo+batch-1, to)
+		seqSet := new(imap.SeqSet)
+		seqSet.AddRange(lo, hi)
+		if err := imapsource.FetchEnvelopes(c, seqSet, fn); err != nil {
+			return fmt.Errorf("fetching envelopes %d-%d: %w", lo, hi, err)
+		}
+		if total > batch {
+			slog.Info("Fetched envelopes", "done", hi-from+1, "total", total)
+		}
 	}
-	return fmt.Sprintf("data:%s;base64,%s", mime, base64.StdEncoding.EncodeToString(data)), nil
+	return nil
 }
 
-// cleanHTML removes unwanted elements from the invoice HTML and embeds
-// external images as base64 so they render reliably in the PDF.
-func cleanHTML(htmlContent string) (string, error) {
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
-	if err != nil {
-		return "", fmt.Errorf("parsing HTML: %w", err)
-	}
-
-	// Embed external images as base64 data URIs
-	doc.Find("img").Each(func(_ int, s *goquery.Selection) {
-		if src, ok := s.Attr("src"); ok && strings.HasPrefix(src, "http") {
-			if dataURI, err := embedImage(src); err == nil {
-				s.SetAttr("src", dataURI)
-			}
-		}
-	})
-
-	// Remove action button and its intro paragraph
-	doc.Find(".action-button-cell").Remove()
-	doc.Find("#footer_section > p").First().Remove()
-
-	// Remove help links section
-	doc.Find("#footer_section > .custom-1sstyyn").Remove()
-
-	// Bold the UID-Nr line in footer
-	doc.Find(".footer-copy p").Each(func(_ int, s *goquery.Selection) {
-		if strings.Contains(s.Text(), "UID-Nr") {
-			s.SetAttr("style", "font-weight:600")
+// fetchMatchingUIDs fetches envelopes and returns UIDs of emails matching the filter.
+func fetchMatchingUIDs(ctx context.Context, c *client.Client, cfg *Config, month time.Time, from, to uint32) ([]uint32, error) {
+	var uids []uint32
+	err := fetchEnvelopes(ctx, c, cfg, from, to, func(msg *imap.Message) {
+		if matchesFilter(msg, cfg, month) {
+			slog.Info("Found invoice", "subject", msg.Envelope.Subject, "uid", msg.Uid)
+			uids = append(uids, msg.Uid)
 		}
 	})
-
-	// Remove bottom link bar (privacy, terms, etc.)
-	doc.Find(".inline-link-group").Remove()
-
-	html, err := doc.Html()
 	if err != nil {
-		return "", fmt.Errorf("rendering HTML: %w", err)
+		return nil, err
 	}
-	return html, nil
+	return uids, nil
 }
 
-// extractOrderNumber parses the invoice HTML for the value following
-// the "Bestellnummer:" label and returns it (trimmed). Returns an empty
-// string if no order number is found.
-func extractOrderNumber(htmlContent string) string {
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
-	if err != nil {
-		return ""
-	}
-	var orderNum string
-	doc.Find("*").EachWithBreak(func(_ int, s *goquery.Selection) bool {
-		text := strings.TrimSpace(s.Text())
-		if strings.HasPrefix(text, "Bestellnummer:") {
-			orderNum = strings.TrimSpace(strings.TrimPrefix(text, "Bestellnummer:"))
-			// Take only the first line/word to avoid capturing trailing content
-			if idx := strings.IndexAny(orderNum, "\n\r\t"); idx >= 0 {
-				orderNum = strings.TrimSpace(orderNum[:idx])
-			}
-			return false
-		}
-		return true
-	})
-	return orderNum
+// embedImage downloads an image URL with fetcher and returns it as a base64
+// data URI.
+func embedImage(ctx context.Context, fetcher *htmlclean.ImageFetcher, imgURL string) (string, error) {
+	defer timingsFrom(ctx).since("image_embedding", time.Now())
+	return fetcher.DataURI(ctx, imgURL)
 }
 
-// convertHTMLToPDF renders HTML to an A4 PDF using headless Chrome.
-func convertHTMLToPDF(htmlContent string) ([]byte, error) {
-	ctx, cancel := chromedp.NewContext(context.Background())
-	defer cancel()
-
-	var buf []byte
-	if err := chromedp.Run(ctx,
-		chromedp.Navigate("about:blank"),
-		// Inject HTML into the page
-		chromedp.ActionFunc(func(ctx context.Context) error {
-			ft, err := page.GetFrameTree().Do(ctx)
-			if err != nil {
-				return err
-			}
-			return page.SetDocumentContent(ft.Frame.ID, htmlContent).Do(ctx)
-		}),
-		// Print to PDF with A4 dimensions
-		chromedp.ActionFunc(func(ctx context.Context) error {
-			var err error
-			buf, _, err = page.PrintToPDF().
-				WithPaperWidth(8.27).
-				WithPaperHeight(11.69).
-				WithPrintBackground(true).
-				Do(ctx)
-			return err
-		}),
-	); err != nil {
-		return nil, fmt.Errorf("generating PDF: %w", err)
+// cleanHTML applies the remove selectors and rules of opts (Apple's if
+// nil) to the invoice HTML and embeds external images, downloaded with
+// fetcher (left for Chrome to load if nil), and the images of the email in
+// opts.Images as base64 so they render reliably in the PDF.
+func cleanHTML(ctx context.Context, fetcher *htmlclean.ImageFetcher, htmlContent string, opts htmlclean.Options) (string, error) {
+	if fetcher != nil {
+		opts.EmbedImage = func(ctx context.Context, src string) (string, error) { return embedImage(ctx, fetcher, src) }
 	}
-	return buf, nil
+	return htmlclean.Clean(ctx, htmlContent, opts)
 }
 
 // sanitizeFilename replaces non-alphanumeric characters for safe filenames.
@@ -362,85 +587,475 @@ func sanitizeFilename(s string) string {
 	return s
 }
 
-// sendPDFEmail sends a single email with all PDF attachments.
-func sendPDFEmail(cfg *Config, attachments []PDFAttachment) error {
+// newPDFMessage builds the email carrying all PDF attachments, with an HTML
+// summary of the invoices as alternative body if enabled.
+func newPDFMessage(cfg *Config, from, to string, invoices []ProcessedInvoice, attachments []PDFAttachment) (*gomail.Message, error) {
 	m := gomail.NewMessage()
-	m.SetHeader("From", cfg.Email.From)
-	m.SetHeader("To", cfg.Email.To)
-	m.SetHeader("Subject", cfg.Email.Subject)
-	m.SetBody("text/plain", "Dokumente anbei.\n")
+	m.SetHeader("From", from)
+	m.SetHeader("To", to)
+	data := newMessageData(invoices)
+	subject, err := renderMessageText("subject", cfg.Email.Subject, data)
+	if err != nil {
+		return nil, err
+	}
+	body, err := renderMessageText("body", cfg.Email.Body, data)
+	if err != nil {
+		return nil, err
+	}
+	m.SetHeader("Subject", subject)
+	if cfg.Email.ReplyTo != "" {
+		m.SetHeader("Reply-To", cfg.Email.ReplyTo)
+	}
+	if cfg.Email.Thread && len(invoices) == 1 && invoices[0].Email.MessageID != "" {
+		ref := messageIDRef(invoices[0].Email.MessageID)
+		m.SetHeader("In-Reply-To", ref)
+		m.SetHeader("References", ref)
+	}
+	for name, value := range cfg.Email.Headers {
+		m.SetHeader(textproto.CanonicalMIMEHeaderKey(name), value)
+	}
+	m.SetBody("text/plain", body)
+	if cfg.Email.HTML {
+		html, err := renderSummaryHTML("email", defaultEmailTemplate, cfg.Email.HTMLTemplate, subject, invoices, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		m.AddAlternative("text/html", html)
+	}
 
 	for _, att := range attachments {
-		data := att.Data
 		m.Attach(att.Filename, gomail.SetCopyFunc(func(w io.Writer) error {
-			_, err := io.Copy(w, bytes.NewReader(data))
+			// Spooled files are read only while the message is written
+			data, err := att.content()
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(w, bytes.NewReader(data))
 			return err
 		}))
 	}
+	return m, nil
+}
 
-	d := gomail.NewDialer(cfg.SMTP.Host, cfg.SMTP.Port, cfg.User, cfg.Pass)
-	return d.DialAndSend(m)
+// sendPDFEmail sends a single email with all PDF attachments.
+func sendPDFEmail(ctx context.Context, cfg *Config, invoices []ProcessedInvoice, attachments []PDFAttachment) error {
+	m, err := newPDFMessage(cfg, cfg.Email.From, cfg.Email.To, invoices, attachments)
+	if err != nil {
+		return err
+	}
+	t, err := newMailTransport(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	return t.DialAndSend(ctx, m)
 }
 
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	o := newCLIOptions()
+	cmd, args, usageErr := parseCommandLine(o, os.Args[1:])
+	exit := func(code int, msg string, args ...any) {
+		level := slog.LevelError
+		if code == exitLocked || code == exitNoInvoices || code == exitInterrupted {
+			level = slog.LevelInfo
+		}
+		slog.Log(context.Background(), level, msg, args...)
+		os.Exit(code)
+	}
+	level, err := logLevel(o.verbose, o.quiet)
+	if err != nil {
+		exit(exitUsage, "Invalid log level", "err", err)
+	}
+	logger, err := newLogger(os.Stderr, o.logFormat, level)
+	if err != nil {
+		exit(exitUsage, "Invalid --log-format", "err", err)
+	}
+	slog.SetDefault(logger)
+	if usageErr != nil {
+		exit(exitUsage, "Invalid command line", "err", usageErr)
+	}
+
+	cfg, err := loadConfig(o.config)
+	if err != nil {
+		exit(exitConfig, "Failed to load config", "err", err)
+	}
+	registerConfigSecrets(cfg)
+	if _, err := buildPipeline(cfg); err != nil {
+		exit(exitConfig, "Failed to load config", "err", err)
+	}
+	reporter, err := newSentryClient(cfg.Sentry)
+	if err != nil {
+		exit(exitConfig, "Failed to load config", "err", err)
+	}
+
+	// Overlapping invocations (e.g. from cron) would deliver twice; dry runs
+	// deliver nothing and may run alongside
+	if cmd.locks && !o.dryRun {
+		lock, err := acquireLock(cfg.Lock.File)
+		if errors.Is(err, errLocked) {
+			exit(exitLocked, "Another run is in progress, exiting", "file", cfg.Lock.File)
+		}
+		if err != nil {
+			exit(1, "Failed to acquire lock", "err", err)
+		}
+		defer lock.Close()
+	}
+
+	// SIGINT and SIGTERM cancel pending IMAP commands, PDF conversions and
+	// deliveries instead of killing the process mid-operation
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx = withTracer(ctx, newTracer(cfg.Tracing))
+	ctx = withSentry(ctx, reporter)
+	mode := cmd.mode(o)
+	// A panic value may contain credentials, which the runtime would print
+	// as is; it is logged redacted instead
+	defer func() {
+		if v := recover(); v != nil {
+			reporter.capturePanic(v, map[string]string{"mode": mode})
+			exit(1, "Panic", "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+		}
+	}()
+	err = cmd.run(ctx, cfg, o, args)
+	switch {
+	case ctx.Err() != nil:
+		exit(exitInterrupted, "Interrupted, exiting")
+	case errors.Is(err, errNoInvoices):
+		exit(exitNoInvoices, "No invoices to process")
+	case err != nil:
+		reporter.captureError(err, map[string]string{"mode": mode})
+		exit(exitCode(err), "Run failed", "err", err)
+	}
+}
+
+// RunOptions holds the command line settings for a single run.
+type RunOptions struct {
+	// Month selects the month to process; the zero value means the
+	// current month.
+	Month time.Time
+	// DryRun writes the files to a temporary directory instead of
+	// delivering them.
+	DryRun bool
+}
 
-	cfg, err := loadConfig("config.yaml")
+// parseMonth parses a YYYY-MM month in local time, or "previous" or
+// "current" relative to today.
+func parseMonth(s string) (time.Time, error) {
+	switch s {
+	case monthPrevious:
+		return scheduledMonth(monthPrevious, time.Now()), nil
+	case monthCurrent:
+		now := time.Now()
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local), nil
+	}
+	month, err := time.ParseInLocation("2006-01", s, time.Local)
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		return time.Time{}, fmt.Errorf("want YYYY-MM, previous or current, got %q", s)
 	}
+	return month, nil
+}
 
-	invoices, err := fetchInvoices(cfg)
+// run fetches, converts and delivers the invoices once.
+func run(ctx context.Context, cfg *Config, opts RunOptions) (err error) {
+	ctx, span := startSpan(ctx, "run", "dry_run", opts.DryRun)
+	defer func() { span.finish(err) }()
+	ctx, done := startRunReport(ctx, cfg, "run", opts.DryRun)
+	defer func() { done(err) }()
+	ctx, timings := withStageTimings(ctx)
+	defer timings.log(time.Now())
+	ctx, cleanup, err := withSpool(ctx, cfg)
 	if err != nil {
-		log.Fatalf("Failed to fetch invoices: %v", err)
+		return err
+	}
+	defer cleanup()
+
+	sinks, err := buildSinks(cfg)
+	if err != nil {
+		return err
+	}
+	if opts.DryRun {
+		if cfg, sinks, err = dryRunSinks(cfg, sinks); err != nil {
+			return err
+		}
+	}
+	pipeline, err := buildProcessing(cfg, sinks)
+	if err != nil {
+		return err
+	}
+
+	month := opts.Month
+	if month.IsZero() {
+		month = time.Now()
+	}
+	invoices, err := pipeline.Source.Fetch(ctx, month)
+	if err != nil {
+		return withExitCode(exitIMAP, fmt.Errorf("fetching invoices: %w", err))
 	}
 	if len(invoices) == 0 {
-		log.Println("No invoices to process")
-		return
+		return errNoInvoices
 	}
 
-	// Convert each invoice HTML to PDF
-	log.Printf("Processing %d invoice(s)...", len(invoices))
-	var attachments []PDFAttachment
-	for i, inv := range invoices {
-		log.Printf("[%d/%d] Converting %q to PDF...", i+1, len(invoices), inv.Subject)
+	store, err := loadState(cfg.State.File)
+	if err != nil {
+		return err
+	}
+	if opts.DryRun && store != nil {
+		store.readOnly = true
+	}
+	return convertAndDeliver(ctx, cfg, pipeline, invoices, store)
+}
+
+// convertAndDeliver converts the invoices not delivered before and delivers
+// them, recording the outcome in the state store. Duplicates are dropped
+// first, see dedupeInvoices. Invoices that could not be converted are
+// reported with exitPDF after delivering the others.
+func convertAndDeliver(ctx context.Context, cfg *Config, pipeline *Pipeline, invoices []InvoiceEmail, store *stateStore) error {
+	metricsFrom(ctx).fetched(len(invoices))
+	fetched := invoices
+	invoices = store.skipDelivered(dedupeInvoices(cfg, invoices))
+	if len(invoices) == 0 {
+		slog.Info("All invoices already delivered")
+		runReportFrom(ctx).addInvoices(fetched, nil, nil, nil, nil)
+		return nil
+	}
+	processed, failures := processInvoices(ctx, cfg, pipeline.Transformers, invoices)
+	// Deliver all invoices or none
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var convErr error
+	if len(failures) > 0 {
+		convErr = withExitCode(exitPDF, fmt.Errorf("%d of %d invoice(s) could not be converted to PDF", len(failures), len(invoices)))
+	}
+	processed = store.skipDeliveredOrders(processed)
+	if len(processed) == 0 {
+		if convErr == nil {
+			slog.Info("All invoices already delivered")
+		}
+		reportFailures(ctx, cfg, failures, nil, nil)
+		runReportFrom(ctx).addInvoices(fetched, nil, failures, nil, nil)
+		return convErr
+	}
 
-		cleaned, err := cleanHTML(inv.HTMLBody)
+	// Invoices that failed before only go to the sinks still missing them
+	var errs, stateErrs []error
+	statuses := make(map[uint32]string)
+	for _, group := range store.pendingGroups(processed, pipeline.Sinks) {
+		results, err := deliverInvoices(ctx, cfg, group.sinks, group.invoices)
+		var de *DeliveryError
+		status := deliveryStatus(group.invoices, group.sinks, results, errors.As(err, &de) && de.Outbox != "")
+		for i, p := range group.invoices {
+			statuses[p.Email.UID] = overallStatus(status(i))
+		}
+		if err := store.record(group.invoices, status, time.Now()); err != nil {
+			stateErrs = append(stateErrs, err)
+		}
 		if err != nil {
-			log.Printf("ERROR cleaning HTML: %v", err)
-			continue
+			errs = append(errs, err)
 		}
-		pdf, err := convertHTMLToPDF(cleaned)
+	}
+	err := errors.Join(errs...)
+	reportFailures(ctx, cfg, failures, processed, err)
+	runReportFrom(ctx).addInvoices(fetched, processed, failures, statuses, err)
+	if serr := errors.Join(stateErrs...); serr != nil {
+		return errors.Join(err, serr)
+	}
+	if err != nil {
+		return err
+	}
+	return convErr
+}
+
+// dedupeInvoices drops the copies of invoices that were fetched more than
+// once, e.g. the original and a server-side copy or a forward of it: emails
+// with the same Message-Id or with the same vendor and order number. The
+// first one is kept.
+func dedupeInvoices(cfg *Config, invoices []InvoiceEmail) []InvoiceEmail {
+	seen := make(map[string]bool)
+	var unique []InvoiceEmail
+	for _, e := range invoices {
+		var keys []string
+		if e.MessageID != "" {
+			keys = append(keys, "id:"+e.MessageID)
+		}
+		vendor := cfg.detectVendor(e)
+		body, err := e.HTML()
 		if err != nil {
-			log.Printf("ERROR converting to PDF: %v", err)
+			slog.Warn("Reading email body failed", "uid", e.UID, "err", err)
+		}
+		order := vendor.orderNumber(body)
+		if order != "" {
+			keys = append(keys, "order:"+vendor.Name+":"+order)
+		}
+		if slices.ContainsFunc(keys, func(k string) bool { return seen[k] }) {
+			slog.Info("Skipping duplicate invoice", "uid", e.UID, "message_id", e.MessageID, "order_number", order)
 			continue
 		}
-		log.Printf("[%d/%d] PDF generated (%d bytes)", i+1, len(invoices), len(pdf))
+		for _, k := range keys {
+			seen[k] = true
+		}
+		unique = append(unique, e)
+	}
+	return unique
+}
 
-		orderNum := extractOrderNumber(inv.HTMLBody)
-		log.Printf("[%d/%d] Extracted order number: %q", i+1, len(invoices), orderNum)
-		var filename string
-		if orderNum != "" {
-			filename = fmt.Sprintf("%02d_%04d_Rechnung_Apple_%s",
-				inv.Date.Month(), inv.Date.Year(), sanitizeFilename(orderNum))
+// processInvoices runs the transformers on each invoice and names its PDF.
+// Invoices that fail a step are logged, skipped and returned as failures.
+// It stops early if ctx is cancelled.
+func processInvoices(ctx context.Context, cfg *Config, transformers []Transformer, invoices []InvoiceEmail) ([]ProcessedInvoice, []InvoiceFailure) {
+	slog.Info("Processing invoices", "count", len(invoices))
+	var processed []ProcessedInvoice
+	var failures []InvoiceFailure
+	for i, inv := range invoices {
+		if ctx.Err() != nil {
+			break
+		}
+		heartbeat(ctx)
+		logger := slog.With("uid", inv.UID, "index", i+1, "total", len(invoices))
+		vendor := cfg.detectVendor(inv)
+		logger.Info("Converting invoice to PDF", "subject", inv.Subject, "vendor", vendor.Name)
+
+		start := time.Now()
+		body, err := inv.HTML()
+		if err != nil {
+			logger.Error("Reading email body failed", "err", err)
+			failures = append(failures, InvoiceFailure{Email: inv, Err: fmt.Errorf("reading email body: %w", err)})
+			continue
+		}
+		p := ProcessedInvoice{Email: inv, HTML: body, Vendor: vendor}
+		if err := transform(ctx, transformers, &p); err != nil {
+			logger.Error("Processing invoice failed", "err", err)
+			failures = append(failures, InvoiceFailure{Email: inv, Err: err})
+			continue
+		}
+		logger.Info("PDF generated", "bytes", len(p.PDF), "duration", time.Since(start))
+		if dir := spoolFrom(ctx); dir != "" {
+			// The cleaned HTML with its embedded images is not needed anymore
+			p.HTML = ""
+			if err := spoolPDF(&p, dir); err != nil {
+				logger.Error("Spooling PDF failed", "err", err)
+				failures = append(failures, InvoiceFailure{Email: inv, Err: fmt.Errorf("spooling PDF: %w", err)})
+				continue
+			}
+		}
+		logger.Info("Extracted order number", "order_number", p.OrderNumber)
+		if p.OrderNumber != "" {
+			var err error
+			tmpl := cfg.Filename.Template
+			if p.vendor().Filename != "" {
+				tmpl = p.vendor().Filename
+			}
+			p.Filename, err = renderFilename(tmpl, p, cfg.Filename.Sanitize)
+			if err != nil {
+				logger.Error("Building filename failed", "err", err)
+				failures = append(failures, InvoiceFailure{Email: inv, Err: fmt.Errorf("building filename: %w", err)})
+				continue
+			}
 		} else {
-			filename = sanitizeFilename(inv.Subject)
+			policy := cfg.Filename.Sanitize
+			p.Filename = policy.Truncate(policy.Sanitize(normalizeSubject(inv.Subject)))
 			if len(invoices) > 1 {
-				filename = fmt.Sprintf("%s_%d", filename, i+1)
+				p.Filename = fmt.Sprintf("%s_%d", p.Filename, i+1)
 			}
 		}
-		attachments = append(attachments, PDFAttachment{Filename: filename + ".pdf", Data: pdf})
+		processed = append(processed, p)
 	}
+	return processed, failures
+}
+
+// transform applies the transformers to p in order, each in its own span,
+// and stops at the first error.
+func transform(ctx context.Context, transformers []Transformer, p *ProcessedInvoice) error {
+	for _, t := range transformers {
+		tctx, span := startSpan(ctx, t.Name(), "uid", p.Email.UID)
+		err := t.Transform(tctx, p)
+		span.finish(err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliverInvoices builds the attachments (cover page, PDFs, DATEV package,
+// manifest) for the processed invoices and hands them to every sink. It
+// returns the results of the sinks, none if building the attachments failed.
+func deliverInvoices(ctx context.Context, cfg *Config, sinks []Sink, processed []ProcessedInvoice) (results []SinkResult, err error) {
+	ctx, span := startSpan(ctx, "deliver", "invoices", len(processed))
+	defer func() { span.finish(err) }()
 
-	if len(attachments) == 0 {
-		log.Println("No PDFs generated")
-		return
+	var attachments []PDFAttachment
+	if cfg.Cover.Enabled {
+		cover, err := buildCoverPage(ctx, cfg, processed)
+		if err != nil {
+			slog.Error("Generating cover page failed", "err", err)
+		} else {
+			attachments = append(attachments, *cover)
+		}
+	}
+	for i := range processed {
+		p := &processed[i]
+		attachments = append(attachments, PDFAttachment{Filename: p.Filename + ".pdf", Data: p.PDF, File: p.PDFFile, Invoice: p})
+		if cfg.Email.AttachEML {
+			attachments = append(attachments, PDFAttachment{Filename: p.Filename + ".eml", Data: p.Email.Raw, File: p.Email.RawFile, Invoice: p})
+		}
 	}
 
-	// Send all PDFs in a single email
-	log.Printf("Sending email with %d PDF attachment(s)...", len(attachments))
-	if err := sendPDFEmail(cfg, attachments); err != nil {
-		log.Fatalf("ERROR sending email: %v", err)
+	// Bundle the invoices for import into DATEV Unternehmen online
+	if cfg.DATEV.Dir != "" || cfg.DATEV.Attach {
+		now := time.Now()
+		data, err := buildDATEVPackage(cfg.DATEV, processed, now)
+		if err != nil {
+			return nil, fmt.Errorf("building DATEV package: %w", err)
+		}
+		if cfg.DATEV.Dir != "" {
+			path, err := writeDATEVPackage(cfg.DATEV.Dir, now, data)
+			if err != nil {
+				return nil, fmt.Errorf("writing DATEV package: %w", err)
+			}
+			slog.Info("DATEV package written", "path", path)
+		}
+		if cfg.DATEV.Attach {
+			attachments = append(attachments, PDFAttachment{Filename: "datev-" + now.Format("20060102-150405") + ".zip", Data: data})
+		}
+	}
+
+	// Record hashes of everything we deliver so archives can be verified later
+	if cfg.Manifest.Dir != "" || cfg.Manifest.Attach {
+		manifest, err := buildManifest(attachments, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("building manifest: %w", err)
+		}
+		data, err := manifest.JSON()
+		if err != nil {
+			return nil, fmt.Errorf("building manifest: %w", err)
+		}
+		if cfg.Manifest.Dir != "" {
+			path, err := writeManifest(cfg.Manifest.Dir, manifest.Generated, data)
+			if err != nil {
+				return nil, fmt.Errorf("writing manifest: %w", err)
+			}
+			slog.Info("Manifest written", "path", path)
+		}
+		if cfg.Manifest.Attach {
+			attachments = append(attachments, PDFAttachment{Filename: "manifest.json", Data: data})
+		}
+	}
+
+	// Hand all files to every configured destination
+	delivery := &Delivery{Invoices: processed, Attachments: attachments}
+	results = deliverAll(ctx, sinks, delivery, cfg.Delivery)
+	runReportFrom(ctx).delivered(delivery, results)
+	auditDelivery(cfg, delivery, sinks, results)
+	err = deliver.Failed(results)
+	var de *DeliveryError
+	if errors.As(err, &de) && cfg.Outbox.Dir != "" {
+		path, oerr := saveOutbox(cfg.Outbox.Dir, delivery, failedSinks(sinks, results), time.Now())
+		if oerr != nil {
+			slog.Error("Saving outbox failed", "err", oerr)
+		} else {
+			slog.Info("Saved undelivered files to the outbox, retry with \"resume\"", "path", path)
+			de.Outbox = path
+		}
 	}
-	log.Printf("Email with %d PDF(s) sent to %s", len(attachments), cfg.Email.To)
+	return results, err
 }
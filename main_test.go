@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -27,6 +31,7 @@ email:
   from: sender@example.com
   to: recipient@example.com
   subject: Custom Subject
+  attach_eml: true
 filter:
   count: 50
   subject: My Invoice
@@ -52,6 +57,9 @@ filter:
 	if cfg.Email.Subject != "Custom Subject" {
 		t.Errorf("Email.Subject = %q, want %q", cfg.Email.Subject, "Custom Subject")
 	}
+	if !cfg.Email.AttachEML {
+		t.Error("Email.AttachEML = false, want true")
+	}
 	if cfg.Filter.Count != 50 {
 		t.Errorf("Filter.Count = %d, want %d", cfg.Filter.Count, 50)
 	}
@@ -75,15 +83,110 @@ pass: secret
 	if cfg.Email.From != "user@example.com" {
 		t.Errorf("Email.From default = %q, want %q", cfg.Email.From, "user@example.com")
 	}
-	if cfg.Filter.Subject != "Deine Rechnung von Apple" {
-		t.Errorf("Filter.Subject default = %q, want %q", cfg.Filter.Subject, "Deine Rechnung von Apple")
-	}
-	if cfg.Filter.From != "apple.com" {
-		t.Errorf("Filter.From default = %q, want %q", cfg.Filter.From, "apple.com")
+	// filter.subject and filter.from default to the vendor's
+	if vendors := cfg.vendors(); len(vendors) != 1 || vendors[0] != appleVendor {
+		t.Errorf("Vendor default = %q, want apple", cfg.Vendor)
 	}
 	if cfg.Email.Subject != "Deine PDF-Rechnungen von Apple" {
 		t.Errorf("Email.Subject default = %q, want %q", cfg.Email.Subject, "Deine PDF-Rechnungen von Apple")
 	}
+	if cfg.Email.Body != "Dokumente anbei.\n" {
+		t.Errorf("Email.Body default = %q, want %q", cfg.Email.Body, "Dokumente anbei.\n")
+	}
+	if cfg.Filename.Template != defaultFilenameTemplate {
+		t.Errorf("Filename.Template default = %q, want %q", cfg.Filename.Template, defaultFilenameTemplate)
+	}
+	if cfg.Delivery.Attempts != 3 || cfg.Delivery.RetryDelay != 10*time.Second {
+		t.Errorf("Delivery default = %+v, want 3 attempts after 10s", cfg.Delivery)
+	}
+	if want := filepath.Join(dir, "apple-invoice-pdf.lock"); cfg.Lock.File != want {
+		t.Errorf("Lock.File default = %q, want %q", cfg.Lock.File, want)
+	}
+}
+
+func TestLoadConfig_ImageHostsApplyToChrome(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(`
+images:
+  allow: ["apple.com"]
+`), 0644)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.PDF.AllowURL == nil || !cfg.PDF.AllowURL("https://www.apple.com/a.png") || cfg.PDF.AllowURL("https://tracker.example/p.gif") {
+		t.Error("pdf.AllowURL does not apply images.allow")
+	}
+}
+
+func TestDefaultLockFile(t *testing.T) {
+	if got := defaultLockFile("/var/lib/invoices/processed.json", "/etc/apple-invoice-pdf/config.yaml"); got != "/var/lib/invoices/apple-invoice-pdf.lock" {
+		t.Errorf("with state.file = %q", got)
+	}
+	if got := defaultLockFile("", "/etc/apple-invoice-pdf/config.yaml"); got != "/etc/apple-invoice-pdf/apple-invoice-pdf.lock" {
+		t.Errorf("without state.file = %q", got)
+	}
+	wd, _ := os.Getwd()
+	if got := defaultLockFile("", "config.yaml"); got != filepath.Join(wd, "apple-invoice-pdf.lock") {
+		t.Errorf("relative config = %q, want absolute", got)
+	}
+}
+
+func TestLoadConfig_FilenamePreset(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte(`
+filename:
+  preset: paperless
+`), 0644)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Filename.Template != filenamePresets["paperless"] {
+		t.Errorf("Filename.Template = %q, want paperless preset", cfg.Filename.Template)
+	}
+
+	os.WriteFile(path, []byte(`
+filename:
+  preset: nonexistent
+`), 0644)
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected error for unknown preset")
+	}
+}
+
+func TestLoadConfig_InvalidFilenameTemplate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte(`
+filename:
+  template: "{{.Year"
+`), 0644)
+
+	_, err := loadConfig(path)
+	if err == nil {
+		t.Fatal("expected error for invalid filename template")
+	}
+}
+
+func TestLoadConfig_FilterDate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("filter:\n  date: sent\n  timezone: Europe/Berlin\n"), 0644)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Filter.Date != dateSent || cfg.Filter.location.String() != "Europe/Berlin" {
+		t.Errorf("filter = %+v", cfg.Filter)
+	}
+	for _, bad := range []string{"filter:\n  date: arrived\n", "filter:\n  timezone: Mars/Olympus\n"} {
+		os.WriteFile(path, []byte(bad), 0644)
+		if _, err := loadConfig(path); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
 }
 
 func TestLoadConfig_MissingFile(t *testing.T) {
@@ -93,6 +196,19 @@ func TestLoadConfig_MissingFile(t *testing.T) {
 	}
 }
 
+func TestLoadConfig_TargetSanitize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(`
+ftps:
+  host: ftp.example.com
+  sanitize:
+    charset: klingon
+`), 0644)
+	if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), "ftps:") {
+		t.Errorf("loadConfig = %v, want ftps sanitize error", err)
+	}
+}
+
 func TestLoadConfig_InvalidYAML(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "config.yaml")
@@ -126,7 +242,7 @@ func defaultCfg() *Config {
 func TestMatchesFilter_Match(t *testing.T) {
 	cfg := defaultCfg()
 	env := makeEnvelope("Deine Rechnung von Apple", "email.apple.com", time.Now())
-	if !matchesFilter(env, cfg) {
+	if !matchesFilter(&imap.Message{Envelope: env}, cfg, time.Now()) {
 		t.Error("expected match")
 	}
 }
@@ -134,7 +250,7 @@ func TestMatchesFilter_Match(t *testing.T) {
 func TestMatchesFilter_WrongSubject(t *testing.T) {
 	cfg := defaultCfg()
 	env := makeEnvelope("Other Subject", "email.apple.com", time.Now())
-	if matchesFilter(env, cfg) {
+	if matchesFilter(&imap.Message{Envelope: env}, cfg, time.Now()) {
 		t.Error("expected no match for wrong subject")
 	}
 }
@@ -142,7 +258,7 @@ func TestMatchesFilter_WrongSubject(t *testing.T) {
 func TestMatchesFilter_WrongSender(t *testing.T) {
 	cfg := defaultCfg()
 	env := makeEnvelope("Deine Rechnung von Apple", "other.com", time.Now())
-	if matchesFilter(env, cfg) {
+	if matchesFilter(&imap.Message{Envelope: env}, cfg, time.Now()) {
 		t.Error("expected no match for wrong sender domain")
 	}
 }
@@ -151,15 +267,68 @@ func TestMatchesFilter_OldMonth(t *testing.T) {
 	cfg := defaultCfg()
 	oldDate := time.Now().AddDate(0, -2, 0)
 	env := makeEnvelope("Deine Rechnung von Apple", "email.apple.com", oldDate)
-	if matchesFilter(env, cfg) {
+	if matchesFilter(&imap.Message{Envelope: env}, cfg, time.Now()) {
 		t.Error("expected no match for old month")
 	}
 }
 
+func TestMatchesFilter_SelectedMonth(t *testing.T) {
+	cfg := defaultCfg()
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+	env := makeEnvelope("Deine Rechnung von Apple", "email.apple.com", time.Date(2024, 3, 28, 9, 0, 0, 0, time.UTC))
+	if !matchesFilter(&imap.Message{Envelope: env}, cfg, march) {
+		t.Error("expected match for the selected month")
+	}
+	if matchesFilter(&imap.Message{Envelope: env}, cfg, march.AddDate(0, 1, 0)) {
+		t.Error("expected no match for another month")
+	}
+	if matchesFilter(&imap.Message{Envelope: env}, cfg, march.AddDate(1, 0, 0)) {
+		t.Error("expected no match for the same month of another year")
+	}
+}
+
+func TestMatchesFilter_Timezone(t *testing.T) {
+	// Sent late on March 31 in California, received on April 1 in UTC
+	sent := time.Date(2024, 3, 31, 23, 30, 0, 0, time.FixedZone("PDT", -7*3600))
+	msg := &imap.Message{Envelope: makeEnvelope("Deine Rechnung von Apple", "email.apple.com", sent), InternalDate: sent.Add(2 * time.Minute)}
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+
+	cfg := defaultCfg()
+	cfg.Filter.location = time.UTC
+	if matchesFilter(msg, cfg, march) || !matchesFilter(msg, cfg, march.AddDate(0, 1, 0)) {
+		t.Error("expected the invoice in April in UTC")
+	}
+	cfg.Filter.location, _ = time.LoadLocation("America/Los_Angeles")
+	if !matchesFilter(msg, cfg, march) {
+		t.Error("expected the invoice in March in Los Angeles")
+	}
+	cfg.Filter.location, cfg.Filter.Date = time.UTC, dateSent
+	msg.InternalDate = sent.AddDate(0, 0, 5)
+	if !matchesFilter(msg, cfg, march.AddDate(0, 1, 0)) {
+		t.Error("expected filter.date sent to use the Date header")
+	}
+}
+
+func TestParseMonth(t *testing.T) {
+	got, err := parseMonth("2024-03")
+	if err != nil || got.Year() != 2024 || got.Month() != time.March {
+		t.Errorf("parseMonth = %v, %v", got, err)
+	}
+	prev, err := parseMonth("previous")
+	if want := time.Now().AddDate(0, 0, 1-time.Now().Day()).AddDate(0, -1, 0); err != nil || prev.Year() != want.Year() || prev.Month() != want.Month() || prev.Day() != 1 {
+		t.Errorf("parseMonth(previous) = %v, %v", prev, err)
+	}
+	for _, bad := range []string{"2024-13", "03/2024", "2024", ""} {
+		if _, err := parseMonth(bad); err == nil {
+			t.Errorf("parseMonth(%q): expected error", bad)
+		}
+	}
+}
+
 func TestMatchesFilter_CaseInsensitiveDomain(t *testing.T) {
 	cfg := defaultCfg()
 	env := makeEnvelope("Deine Rechnung von Apple", "Email.APPLE.COM", time.Now())
-	if !matchesFilter(env, cfg) {
+	if !matchesFilter(&imap.Message{Envelope: env}, cfg, time.Now()) {
 		t.Error("expected case-insensitive domain match")
 	}
 }
@@ -171,11 +340,68 @@ func TestMatchesFilter_NoFromAddresses(t *testing.T) {
 		Date:    time.Now(),
 		From:    []*imap.Address{},
 	}
-	if matchesFilter(env, cfg) {
+	if matchesFilter(&imap.Message{Envelope: env}, cfg, time.Now()) {
 		t.Error("expected no match with empty From")
 	}
 }
 
+func TestMatchesFilter_Forwarded(t *testing.T) {
+	cfg := defaultCfg()
+	for _, subject := range []string{"WG: Deine Rechnung von Apple", "Fwd: Deine Rechnung von Apple", "FW: WG: Deine Rechnung von Apple"} {
+		if !matchesFilter(&imap.Message{Envelope: makeEnvelope(subject, "example.com", time.Now())}, cfg, time.Now()) {
+			t.Errorf("%q: expected forwards to match on their subject", subject)
+		}
+	}
+	if matchesFilter(&imap.Message{Envelope: makeEnvelope("WG: Newsletter", "example.com", time.Now())}, cfg, time.Now()) {
+		t.Error("expected no match for a forward with another subject")
+	}
+
+	// After unwrapping, the invoice's sender must match
+	unwrapped := InvoiceEmail{Subject: "Deine Rechnung von Apple", From: "no_reply@email.apple.com", ForwardedBy: "anna@example.com"}
+	if !forwarded(unwrapped) || !matchesForward(unwrapped, cfg) {
+		t.Error("expected the unwrapped invoice to match")
+	}
+	inline := InvoiceEmail{Subject: "WG: Deine Rechnung von Apple", From: "anna@example.com"}
+	if !forwarded(inline) || matchesForward(inline, cfg) {
+		t.Error("expected a forward without attached invoice not to match")
+	}
+}
+
+func TestNormalizeSubject(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"Deine Rechnung von Apple", "Deine Rechnung von Apple"},
+		{" Deine  Rechnung\tvon\u00a0Apple ", "Deine Rechnung von Apple"},
+		{"Deine Rechnung\r\n von\u200b Apple", "Deine Rechnung von Apple"},
+		{"WG: Fwd:Deine Rechnung von Apple", "Deine Rechnung von Apple"},
+		{"Re: Deine Rechnung von Apple", "Re: Deine Rechnung von Apple"},
+	} {
+		if got := normalizeSubject(tc.in); got != tc.want {
+			t.Errorf("normalizeSubject(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+	if !matchesFilter(&imap.Message{Envelope: makeEnvelope("Deine\u00a0Rechnung  von Apple", "email.apple.com", time.Now())}, defaultCfg(), time.Now()) {
+		t.Error("expected odd whitespace to match filter.subject")
+	}
+}
+
+func TestDedupeInvoices(t *testing.T) {
+	invoices := []InvoiceEmail{
+		{UID: 1, MessageID: "<a@apple.com>", HTMLBody: "<p>Bestellnummer: MX1</p>"},
+		{UID: 2, MessageID: "<a@apple.com>", HTMLBody: "<p>Bestellnummer: MX1</p>"},
+		{UID: 3, MessageID: "<copy@example.com>", HTMLBody: "<p>Bestellnummer: MX1</p>"},
+		{UID: 4, MessageID: "<b@apple.com>", HTMLBody: "<p>Bestellnummer: MX2</p>"},
+		{UID: 5, HTMLBody: "<p>Vielen Dank</p>"},
+		{UID: 6, HTMLBody: "<p>Vielen Dank</p>"},
+	}
+	var uids []uint32
+	for _, e := range dedupeInvoices(&Config{}, invoices) {
+		uids = append(uids, e.UID)
+	}
+	if !slices.Equal(uids, []uint32{1, 4, 5, 6}) {
+		t.Errorf("unique invoices = %v, want 1, 4, 5, 6", uids)
+	}
+}
+
 // --- sanitizeFilename tests ---
 
 func TestSanitizeFilename(t *testing.T) {
@@ -202,106 +428,17 @@ func TestSanitizeFilename(t *testing.T) {
 	}
 }
 
-// --- extractOrderNumber tests ---
-
-func TestExtractOrderNumber(t *testing.T) {
-	tests := []struct {
-		name string
-		html string
-		want string
-	}{
-		{
-			"found in span",
-			`<html><body><span>Bestellnummer: W123456789</span></body></html>`,
-			"W123456789",
-		},
-		{
-			"found in td",
-			`<html><body><table><tr><td>Bestellnummer: MHJT12345</td></tr></table></body></html>`,
-			"MHJT12345",
-		},
-		{
-			"not found",
-			`<html><body><p>No order number here</p></body></html>`,
-			"",
-		},
-		{
-			"with extra whitespace",
-			`<html><body><p>Bestellnummer:   ABC99  </p></body></html>`,
-			"ABC99",
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got := extractOrderNumber(tt.html)
-			if got != tt.want {
-				t.Errorf("extractOrderNumber() = %q, want %q", got, tt.want)
-			}
-		})
-	}
-}
-
-// --- cleanHTML tests ---
-
-func TestCleanHTML_RemovesActionButton(t *testing.T) {
-	html := `<html><body>
-		<div class="action-button-cell">Click here</div>
-		<p>Keep this</p>
-	</body></html>`
-
-	result, err := cleanHTML(html)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
-	}
-	if contains(result, "action-button-cell") {
-		t.Error("expected action-button-cell to be removed")
-	}
-	if !contains(result, "Keep this") {
-		t.Error("expected other content to be preserved")
-	}
-}
-
-func TestCleanHTML_RemovesInlineLinkGroup(t *testing.T) {
-	html := `<html><body>
-		<div class="inline-link-group">Privacy | Terms</div>
-		<p>Content</p>
-	</body></html>`
-
-	result, err := cleanHTML(html)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
-	}
-	if contains(result, "inline-link-group") {
-		t.Error("expected inline-link-group to be removed")
-	}
-}
-
-func TestCleanHTML_BoldsUIDNr(t *testing.T) {
-	html := `<html><body>
-		<div class="footer-copy"><p>UID-Nr: ATU12345</p></div>
-	</body></html>`
-
-	result, err := cleanHTML(html)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
-	}
-	if !contains(result, "font-weight:600") {
-		t.Error("expected UID-Nr paragraph to be bolded")
-	}
-}
-
-func TestCleanHTML_PreservesNonImageContent(t *testing.T) {
-	html := `<html><body>
-		<h1>Invoice</h1>
-		<p>Amount: €9.99</p>
-	</body></html>`
-
-	result, err := cleanHTML(html)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
-	}
-	if !contains(result, "Invoice") || !contains(result, "€9.99") {
-		t.Error("expected content to be preserved")
+func TestConvertAndDeliver_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sink := &fakeSink{name: "sink"}
+	invoices := []InvoiceEmail{{Subject: "Deine Rechnung von Apple", HTMLBody: "<html></html>"}}
+	err := convertAndDeliver(ctx, &Config{}, &Pipeline{Sinks: []Sink{sink}}, invoices, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if sink.calls != 0 {
+		t.Error("delivered after cancellation")
 	}
 }
 
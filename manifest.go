@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Manifest lists every file delivered in a run with its SHA-256 hash so the
+// integrity of archived invoices can be verified later.
+type Manifest struct {
+	Generated time.Time       `json:"generated"`
+	Files     []ManifestEntry `json:"files"`
+}
+
+// ManifestEntry describes a single delivered file.
+type ManifestEntry struct {
+	Filename  string `json:"filename"`
+	Size      int    `json:"size"`
+	SHA256    string `json:"sha256"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// buildManifest hashes all attachments.
+func buildManifest(attachments []PDFAttachment, now time.Time) (Manifest, error) {
+	m := Manifest{Generated: now}
+	for _, att := range attachments {
+		data, err := att.content()
+		if err != nil {
+			return Manifest{}, fmt.Errorf("reading %s: %w", att.Filename, err)
+		}
+		sum := sha256.Sum256(data)
+		entry := ManifestEntry{
+			Filename: att.Filename,
+			Size:     len(data),
+			SHA256:   hex.EncodeToString(sum[:]),
+		}
+		if att.Invoice != nil {
+			entry.MessageID = att.Invoice.Email.MessageID
+		}
+		m.Files = append(m.Files, entry)
+	}
+	return m, nil
+}
+
+// JSON returns the indented JSON encoding of the manifest.
+func (m Manifest) JSON() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding manifest: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// writeManifest stores the manifest as manifest-YYYYMMDD-HHMMSS.json in dir,
+// creating the directory if needed, and returns the file path.
+func writeManifest(dir string, generated time.Time, data []byte) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("creating manifest directory: %w", err)
+	}
+	path := filepath.Join(dir, "manifest-"+generated.Format("20060102-150405")+".json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("writing manifest: %w", err)
+	}
+	return path, nil
+}
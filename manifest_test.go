@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBuildManifest(t *testing.T) {
+	// a.pdf is spooled to disk
+	spooled := filepath.Join(t.TempDir(), "invoice.pdf")
+	if err := os.WriteFile(spooled, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	attachments := []PDFAttachment{
+		{Filename: "a.pdf", File: spooled, Invoice: &ProcessedInvoice{Email: InvoiceEmail{MessageID: "<1@apple.com>"}}},
+		{Filename: "cover.pdf", Data: []byte{}},
+	}
+	m, err := buildManifest(attachments, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.Files) != 2 {
+		t.Fatalf("got %d entries, want 2", len(m.Files))
+	}
+	want := ManifestEntry{
+		Filename:  "a.pdf",
+		Size:      5,
+		SHA256:    "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		MessageID: "<1@apple.com>",
+	}
+	if m.Files[0] != want {
+		t.Errorf("entry = %+v, want %+v", m.Files[0], want)
+	}
+	if m.Files[1].SHA256 != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("empty file hash = %s", m.Files[1].SHA256)
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "manifests")
+	generated := time.Date(2026, 2, 13, 7, 30, 0, 0, time.UTC)
+	m, err := buildManifest([]PDFAttachment{{Filename: "a.pdf", Data: []byte("x")}}, generated)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := m.JSON()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	path, err := writeManifest(dir, generated, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if filepath.Base(path) != "manifest-20260213-073000.json" {
+		t.Errorf("path = %s", path)
+	}
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading manifest: %v", err)
+	}
+	var decoded Manifest
+	if err := json.Unmarshal(written, &decoded); err != nil {
+		t.Fatalf("decoding manifest: %v", err)
+	}
+	if len(decoded.Files) != 1 || decoded.Files[0].Filename != "a.pdf" || !decoded.Generated.Equal(generated) {
+		t.Errorf("decoded manifest = %+v", decoded)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MatrixConfig configures delivery to a Matrix room.
+type MatrixConfig struct {
+	Homeserver  string `yaml:"homeserver"`
+	AccessToken string `yaml:"access_token"`
+	RoomID      string `yaml:"room_id"`
+}
+
+// matrixSink posts the run summary followed by every invoice PDF as an
+// m.file message into a room.
+type matrixSink struct {
+	cfg    MatrixConfig
+	client *http.Client
+	SinkProgress
+}
+
+// newMatrixSink checks required settings.
+func newMatrixSink(cfg MatrixConfig) (*matrixSink, error) {
+	if cfg.RoomID == "" || cfg.AccessToken == "" {
+		return nil, fmt.Errorf("matrix: access_token and room_id are required")
+	}
+	cfg.Homeserver = strings.TrimRight(cfg.Homeserver, "/")
+	return &matrixSink{cfg: cfg, client: newAPIClient()}, nil
+}
+
+func (s *matrixSink) Name() string { return "matrix" }
+
+func (s *matrixSink) Deliver(ctx context.Context, d *Delivery) error {
+	if summary := summaryKey(d); !s.isDone(summary) {
+		if err := s.send(ctx, summary, map[string]any{"msgtype": "m.text", "body": d.Summary()}); err != nil {
+			return fmt.Errorf("sending summary: %w", err)
+		}
+		s.markDone(summary)
+	}
+	for _, inv := range d.Invoices {
+		key := invoiceKey(inv)
+		if s.isDone(key) {
+			continue
+		}
+		filename := inv.Filename + ".pdf"
+		data, err := inv.readPDF()
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", filename, err)
+		}
+		uri, err := s.upload(ctx, filename, data)
+		if err != nil {
+			return fmt.Errorf("uploading %s: %w", filename, err)
+		}
+		msg := map[string]any{
+			"msgtype":  "m.file",
+			"body":     filename,
+			"filename": filename,
+			"url":      uri,
+			"info":     map[string]any{"mimetype": "application/pdf", "size": len(data)},
+		}
+		if err := s.send(ctx, key, msg); err != nil {
+			return fmt.Errorf("sending %s: %w", filename, err)
+		}
+		s.markDone(key)
+	}
+	slog.Info("Posted PDFs to Matrix room", "count", len(d.Invoices), "room", s.cfg.RoomID)
+	return nil
+}
+
+// upload stores the file in the media repository and returns its mxc:// URI.
+func (s *matrixSink) upload(ctx context.Context, filename string, data []byte) (string, error) {
+	u := s.cfg.Homeserver + "/_matrix/media/v3/upload?filename=" + url.QueryEscape(filename)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.cfg.AccessToken)
+	req.Header.Set("Content-Type", "application/pdf")
+	var resp struct {
+		ContentURI string `json:"content_uri"`
+	}
+	if err := doRequest(s.client, req, &resp); err != nil {
+		return "", err
+	}
+	return resp.ContentURI, nil
+}
+
+// send posts an m.room.message event. The transaction ID is derived from
+// the room and the key of the invoice or summary, so the homeserver drops
+// a message that a retry sends again.
+func (s *matrixSink) send(ctx context.Context, key string, content map[string]any) error {
+	sum := sha256.Sum256([]byte(s.cfg.RoomID + "\n" + key))
+	u := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/apple-invoice-pdf-%s",
+		s.cfg.Homeserver, url.PathEscape(s.cfg.RoomID), hex.EncodeToString(sum[:16]))
+	header := http.Header{"Authorization": {"Bearer " + s.cfg.AccessToken}}
+	return doJSON(ctx, s.client, http.MethodPut, u, header, content, nil)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMatrixSink_Deliver(t *testing.T) {
+	var events []map[string]any
+	var txnIDs []string
+	var uploaded string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer syt_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/_matrix/media/v3/upload":
+			data, _ := io.ReadAll(r.Body)
+			uploaded = r.URL.Query().Get("filename") + ":" + string(data)
+			json.NewEncoder(w).Encode(map[string]string{"content_uri": "mxc://example.org/abc"})
+		case strings.HasPrefix(r.URL.Path, "/_matrix/client/v3/rooms/!room:example.org/send/m.room.message/"):
+			var ev map[string]any
+			json.NewDecoder(r.Body).Decode(&ev)
+			events = append(events, ev)
+			txnIDs = append(txnIDs, r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+			json.NewEncoder(w).Encode(map[string]string{"event_id": "$1"})
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	sink, err := newMatrixSink(MatrixConfig{Homeserver: srv.URL + "/", AccessToken: "syt_token", RoomID: "!room:example.org"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	inv := testProcessedInvoice()
+	inv.Filename = "invoice"
+	inv.PDF = []byte("%PDF")
+	if err := sink.Deliver(context.Background(), &Delivery{Invoices: []ProcessedInvoice{inv}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uploaded != "invoice.pdf:%PDF" {
+		t.Errorf("uploaded = %q", uploaded)
+	}
+	if len(events) != 2 || events[0]["msgtype"] != "m.text" || events[1]["msgtype"] != "m.file" {
+		t.Fatalf("events = %v", events)
+	}
+	if events[1]["url"] != "mxc://example.org/abc" || events[1]["body"] != "invoice.pdf" {
+		t.Errorf("file event = %v", events[1])
+	}
+	if txnIDs[0] == txnIDs[1] {
+		t.Error("transaction IDs must be unique")
+	}
+
+	// Sending the invoice again reuses its transaction ID, so the
+	// homeserver drops the duplicate
+	again, _ := newMatrixSink(MatrixConfig{Homeserver: srv.URL, AccessToken: "syt_token", RoomID: "!room:example.org"})
+	if err := again.Deliver(context.Background(), &Delivery{Invoices: []ProcessedInvoice{inv}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(txnIDs) != 4 || txnIDs[2] != txnIDs[0] || txnIDs[3] != txnIDs[1] {
+		t.Errorf("transaction IDs = %v, want the same for the same invoice", txnIDs)
+	}
+}
+
+func TestNewMatrixSink_Validation(t *testing.T) {
+	if _, err := newMatrixSink(MatrixConfig{Homeserver: "https://m.org", AccessToken: "t"}); err == nil {
+		t.Error("expected error without room_id")
+	}
+}
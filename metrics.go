@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// conversionBuckets are the upper bounds in seconds of the PDF conversion
+// histogram.
+var conversionBuckets = []float64{0.5, 1, 2, 5, 10, 30, 60}
+
+// daemonMetrics collects the counters served on /metrics in daemon mode in
+// the Prometheus text format. All methods are no-ops on a nil receiver, so
+// single runs without metrics need no checks.
+type daemonMetrics struct {
+	mu               sync.Mutex
+	invoicesFetched  uint64
+	pdfsGenerated    uint64
+	conversionCounts []uint64 // per bucket, not cumulative
+	conversionSum    float64
+	conversionCount  uint64
+	deliveryFailures map[string]uint64
+	lastRun          time.Time
+}
+
+func newDaemonMetrics() *daemonMetrics {
+	return &daemonMetrics{
+		conversionCounts: make([]uint64, len(conversionBuckets)),
+		deliveryFailures: make(map[string]uint64),
+	}
+}
+
+// metricsKey carries the metrics of a daemon run in the context.
+type metricsKey struct{}
+
+// withMetrics returns a context whose runs record to m.
+func withMetrics(ctx context.Context, m *daemonMetrics) context.Context {
+	return context.WithValue(ctx, metricsKey{}, m)
+}
+
+// metricsFrom returns the metrics in ctx, or nil outside daemon mode.
+func metricsFrom(ctx context.Context) *daemonMetrics {
+	m, _ := ctx.Value(metricsKey{}).(*daemonMetrics)
+	return m
+}
+
+// fetched counts invoice emails fetched from the mailbox.
+func (m *daemonMetrics) fetched(n int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.invoicesFetched += uint64(n)
+}
+
+// converted counts a generated PDF and the time its conversion took.
+func (m *daemonMetrics) converted(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pdfsGenerated++
+	m.conversionSum += d.Seconds()
+	m.conversionCount++
+	for i, le := range conversionBuckets {
+		if d.Seconds() <= le {
+			m.conversionCounts[i]++
+			break
+		}
+	}
+}
+
+// deliveryFailed counts a delivery that still failed after all retries.
+func (m *daemonMetrics) deliveryFailed(sink string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deliveryFailures[sink]++
+}
+
+// runFinished records the end of a scheduled run.
+func (m *daemonMetrics) runFinished(now time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastRun = now
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *daemonMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+func (m *daemonMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP apple_invoice_pdf_%s %s\n# TYPE apple_invoice_pdf_%s %s\n", name, help, name, typ)
+	}
+	value := func(name string, v float64) {
+		fmt.Fprintf(w, "apple_invoice_pdf_%s %s\n", name, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+
+	metric("invoices_fetched_total", "counter", "Invoice emails fetched from the mailbox.")
+	value("invoices_fetched_total", float64(m.invoicesFetched))
+	metric("pdfs_generated_total", "counter", "Invoices converted to PDF.")
+	value("pdfs_generated_total", float64(m.pdfsGenerated))
+
+	metric("conversion_seconds", "histogram", "Time taken to convert an invoice to PDF.")
+	var cumulative uint64
+	for i, le := range conversionBuckets {
+		cumulative += m.conversionCounts[i]
+		value(fmt.Sprintf(`conversion_seconds_bucket{le="%s"}`, strconv.FormatFloat(le, 'g', -1, 64)), float64(cumulative))
+	}
+	value(`conversion_seconds_bucket{le="+Inf"}`, float64(m.conversionCount))
+	value("conversion_seconds_sum", m.conversionSum)
+	value("conversion_seconds_count", float64(m.conversionCount))
+
+	metric("delivery_failures_total", "counter", "Deliveries that still failed after all retries, per destination.")
+	sinks := make([]string, 0, len(m.deliveryFailures))
+	for sink := range m.deliveryFailures {
+		sinks = append(sinks, sink)
+	}
+	sort.Strings(sinks)
+	for _, sink := range sinks {
+		value(fmt.Sprintf(`delivery_failures_total{sink="%s"}`, escapeLabel(sink)), float64(m.deliveryFailures[sink]))
+	}
+
+	metric("last_run_timestamp_seconds", "gauge", "Unix time the last scheduled run finished, 0 before the first run.")
+	var lastRun float64
+	if !m.lastRun.IsZero() {
+		lastRun = float64(m.lastRun.UnixMilli()) / 1000
+	}
+	value("last_run_timestamp_seconds", lastRun)
+}
+
+// escapeLabel escapes a Prometheus label value.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
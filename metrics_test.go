@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDaemonMetrics(t *testing.T) {
+	m := newDaemonMetrics()
+	m.fetched(3)
+	m.converted(800 * time.Millisecond)
+	m.converted(3 * time.Second)
+	m.deliveryFailed("email")
+	m.deliveryFailed(`s3 "archive"`)
+	m.runFinished(time.Unix(1714543200, 500e6))
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE apple_invoice_pdf_invoices_fetched_total counter\napple_invoice_pdf_invoices_fetched_total 3\n",
+		"apple_invoice_pdf_pdfs_generated_total 2\n",
+		"# TYPE apple_invoice_pdf_conversion_seconds histogram\n",
+		`apple_invoice_pdf_conversion_seconds_bucket{le="0.5"} 0` + "\n",
+		`apple_invoice_pdf_conversion_seconds_bucket{le="1"} 1` + "\n",
+		`apple_invoice_pdf_conversion_seconds_bucket{le="5"} 2` + "\n",
+		`apple_invoice_pdf_conversion_seconds_bucket{le="+Inf"} 2` + "\n",
+		"apple_invoice_pdf_conversion_seconds_sum 3.8\n",
+		"apple_invoice_pdf_conversion_seconds_count 2\n",
+		`apple_invoice_pdf_delivery_failures_total{sink="email"} 1` + "\n",
+		`apple_invoice_pdf_delivery_failures_total{sink="s3 \"archive\""} 1` + "\n",
+		"apple_invoice_pdf_last_run_timestamp_seconds 1.7145432005e+09\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestDaemonMetrics_Nil(t *testing.T) {
+	m := metricsFrom(context.Background())
+	if m != nil {
+		t.Fatalf("metricsFrom = %v, want nil", m)
+	}
+	// Recording without metrics must not panic
+	m.fetched(1)
+	m.converted(time.Second)
+	m.deliveryFailed("email")
+	m.runFinished(time.Now())
+}
+
+func TestDeliverAll_CountsFailures(t *testing.T) {
+	m := newDaemonMetrics()
+	ctx := withMetrics(context.Background(), m)
+	sinks := []Sink{
+		&fakeSink{name: "ok"},
+		&fakeSink{name: "broken", failures: 5},
+	}
+	deliverAll(ctx, sinks, &Delivery{}, DeliveryConfig{Attempts: 2})
+	if m.deliveryFailures["broken"] != 1 || m.deliveryFailures["ok"] != 0 {
+		t.Errorf("deliveryFailures = %v", m.deliveryFailures)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// NtfyConfig configures push notifications via an ntfy server.
+type NtfyConfig struct {
+	URL      string   `yaml:"url"`
+	Topic    string   `yaml:"topic"`
+	Token    string   `yaml:"token"`
+	Priority string   `yaml:"priority"`
+	Tags     []string `yaml:"tags"`
+	// Click is opened when the notification is tapped, e.g. the archive folder.
+	Click string `yaml:"click"`
+	// Attach additionally publishes every PDF as a file attachment.
+	Attach bool `yaml:"attach"`
+}
+
+// ntfySink publishes a summary notification per run and optionally one
+// notification per PDF with the file attached.
+type ntfySink struct {
+	cfg    NtfyConfig
+	client *http.Client
+	SinkProgress
+}
+
+// newNtfySink applies defaults.
+func newNtfySink(cfg NtfyConfig) *ntfySink {
+	if cfg.URL == "" {
+		cfg.URL = "https://ntfy.sh"
+	}
+	cfg.URL = strings.TrimRight(cfg.URL, "/")
+	return &ntfySink{cfg: cfg, client: newAPIClient()}
+}
+
+func (s *ntfySink) Name() string { return "ntfy" }
+
+func (s *ntfySink) Deliver(ctx context.Context, d *Delivery) error {
+	if summary := summaryKey(d); !s.isDone(summary) {
+		if err := s.publish(ctx, "Neue "+invoiceNoun(vendorTitle(d.Invoices))+"en", d.Summary(), "", nil); err != nil {
+			return fmt.Errorf("ntfy: %w", err)
+		}
+		s.markDone(summary)
+	}
+	if s.cfg.Attach {
+		for _, inv := range d.Invoices {
+			if s.isDone(invoiceKey(inv)) {
+				continue
+			}
+			filename := inv.Filename + ".pdf"
+			data, err := inv.readPDF()
+			if err != nil {
+				return fmt.Errorf("ntfy: reading %s: %w", filename, err)
+			}
+			if err := s.publish(ctx, invoiceNoun(inv.vendor().Title), filename, filename, data); err != nil {
+				return fmt.Errorf("ntfy: attaching %s: %w", filename, err)
+			}
+			s.markDone(invoiceKey(inv))
+		}
+	}
+	slog.Info("Published notification to ntfy", "topic", s.cfg.Topic)
+	return nil
+}
+
+// publish sends a single message. With an attachment the file is the request
+// body and the message moves into a header; non-ASCII header values are
+// RFC 2047 encoded, which ntfy decodes.
+func (s *ntfySink) publish(ctx context.Context, title, message, filename string, data []byte) error {
+	body := []byte(message)
+	if data != nil {
+		body = data
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.cfg.URL+"/"+s.cfg.Topic, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", mime.QEncoding.Encode("utf-8", title))
+	if data != nil {
+		req.Header.Set("Filename", mime.QEncoding.Encode("utf-8", filename))
+		req.Header.Set("Message", mime.QEncoding.Encode("utf-8", message))
+	}
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.Token)
+	}
+	if s.cfg.Priority != "" {
+		req.Header.Set("Priority", s.cfg.Priority)
+	}
+	if len(s.cfg.Tags) > 0 {
+		req.Header.Set("Tags", strings.Join(s.cfg.Tags, ","))
+	}
+	if s.cfg.Click != "" {
+		req.Header.Set("Click", s.cfg.Click)
+	}
+	return doRequest(s.client, req, nil)
+}
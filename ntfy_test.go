@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNtfySink_Deliver(t *testing.T) {
+	type message struct {
+		path, title, filename, message, body string
+		auth, tags, click                    string
+	}
+	var messages []message
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		messages = append(messages, message{
+			path: r.URL.Path, title: r.Header.Get("Title"), filename: r.Header.Get("Filename"),
+			message: r.Header.Get("Message"), body: string(body),
+			auth: r.Header.Get("Authorization"), tags: r.Header.Get("Tags"), click: r.Header.Get("Click"),
+		})
+		w.Write([]byte(`{"id":"x"}`))
+	}))
+	defer srv.Close()
+
+	sink := newNtfySink(NtfyConfig{URL: srv.URL + "/", Topic: "invoices", Token: "tk_abc", Tags: []string{"receipt", "apple"}, Click: "https://example.com", Attach: true})
+	inv := testProcessedInvoice()
+	inv.Filename = "invoice"
+	inv.PDF = []byte("%PDF")
+	if err := sink.Deliver(context.Background(), &Delivery{Invoices: []ProcessedInvoice{inv}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, want 2", len(messages))
+	}
+	summary := messages[0]
+	if summary.path != "/invoices" || summary.auth != "Bearer tk_abc" || summary.tags != "receipt,apple" || summary.click != "https://example.com" {
+		t.Errorf("summary headers = %+v", summary)
+	}
+	if summary.body != (&Delivery{Invoices: []ProcessedInvoice{inv}}).Summary() || summary.filename != "" {
+		t.Errorf("summary body = %q", summary.body)
+	}
+	if file := messages[1]; file.body != "%PDF" || file.filename != "invoice.pdf" || file.message != "invoice.pdf" {
+		t.Errorf("attachment = %+v", file)
+	}
+}
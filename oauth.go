@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OAuthConfig holds the client credentials and refresh token used by
+// accounting APIs that only accept OAuth 2.0 access tokens.
+type OAuthConfig struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	RefreshToken string `yaml:"refresh_token"`
+	// TokenFile stores the latest refresh token. QuickBooks and Xero rotate
+	// refresh tokens on every use, so the configured one only works once.
+	TokenFile string `yaml:"token_file"`
+}
+
+// validate checks that a rotated refresh token can be stored.
+func (c OAuthConfig) validate() error {
+	if c.ClientID == "" || c.ClientSecret == "" {
+		return fmt.Errorf("client_id and client_secret are required")
+	}
+	if c.TokenFile == "" {
+		return fmt.Errorf("token_file is required to store rotated refresh tokens")
+	}
+	return nil
+}
+
+// oauthRefresher exchanges the refresh token for an access token.
+type oauthRefresher struct {
+	cfg      OAuthConfig
+	tokenURL string
+	client   *http.Client
+}
+
+// accessToken performs a refresh_token grant and persists a rotated refresh
+// token to the token file, if one is configured.
+func (o *oauthRefresher) accessToken(ctx context.Context) (string, error) {
+	refresh := o.cfg.RefreshToken
+	if o.cfg.TokenFile != "" {
+		if data, err := os.ReadFile(o.cfg.TokenFile); err == nil && len(strings.TrimSpace(string(data))) > 0 {
+			refresh = strings.TrimSpace(string(data))
+		} else if err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("reading token file: %w", err)
+		}
+	}
+	if refresh == "" {
+		return "", fmt.Errorf("no refresh token configured")
+	}
+	registerSecret(refresh)
+
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refresh}}
+	if o.cfg.ClientSecret == "" {
+		// Public clients (e.g. Thunderbird-style desktop registrations)
+		// identify themselves in the form instead
+		form.Set("client_id", o.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	if o.cfg.ClientSecret != "" {
+		req.SetBasicAuth(o.cfg.ClientID, o.cfg.ClientSecret)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	var resp struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := doRequest(o.client, req, &resp); err != nil {
+		return "", fmt.Errorf("refreshing access token: %w", err)
+	}
+	registerSecret(resp.AccessToken)
+	registerSecret(resp.RefreshToken)
+
+	if resp.RefreshToken != "" && resp.RefreshToken != refresh && o.cfg.TokenFile != "" {
+		if err := writeTokenFile(o.cfg.TokenFile, resp.RefreshToken); err != nil {
+			return "", err
+		}
+	}
+	return resp.AccessToken, nil
+}
+
+// writeTokenFile atomically replaces the token file, readable by the owner only.
+func writeTokenFile(path, token string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("creating token directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(token+"\n"), 0600); err != nil {
+		return fmt.Errorf("writing token file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing token file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOAuthRefresher_RotatesRefreshToken(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "id" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		if r.PostForm.Get("grant_type") != "refresh_token" {
+			t.Errorf("grant_type = %q", r.PostForm.Get("grant_type"))
+		}
+		got = append(got, r.PostForm.Get("refresh_token"))
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "refresh_token": "rt-" + string(rune('0'+len(got)))})
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "state", "token")
+	o := &oauthRefresher{
+		cfg:      OAuthConfig{ClientID: "id", ClientSecret: "secret", RefreshToken: "initial", TokenFile: file},
+		tokenURL: srv.URL,
+		client:   newAPIClient(),
+	}
+	for range 2 {
+		token, err := o.accessToken(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if token != "access" {
+			t.Errorf("access token = %q", token)
+		}
+	}
+	// The second refresh must use the rotated token from the file
+	if len(got) != 2 || got[0] != "initial" || got[1] != "rt-1" {
+		t.Errorf("refresh tokens sent = %v", got)
+	}
+	data, _ := os.ReadFile(file)
+	if string(data) != "rt-2\n" {
+		t.Errorf("token file = %q", data)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0600 {
+		t.Errorf("token file mode = %v", info.Mode())
+	}
+}
+
+func TestOAuthConfig_Validate(t *testing.T) {
+	if err := (OAuthConfig{ClientID: "id", ClientSecret: "secret"}).validate(); err == nil {
+		t.Error("expected error without token_file")
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/deliver"
+)
+
+// OutboxConfig configures persisting deliveries that failed, so that
+// `resume` can retry them without fetching and converting again.
+type OutboxConfig struct {
+	Dir string `yaml:"dir"`
+}
+
+// outboxFile is the name of the description file in an outbox entry; the
+// attachments are stored next to it.
+const outboxFile = "delivery.json"
+
+// OutboxEntry describes a saved delivery and the sinks still missing it.
+type OutboxEntry struct {
+	Created time.Time `json:"created"`
+	Sinks   []string  `json:"sinks"`
+	// Progress holds what the sinks delivering invoice by invoice already
+	// completed, by sink name.
+	Progress    map[string]*SinkProgress `json:"progress,omitempty"`
+	Invoices    []InvoiceMetadata        `json:"invoices"`
+	Attachments []OutboxAttachment       `json:"attachments"`
+}
+
+// OutboxAttachment is a saved file with the index of its source invoice in
+// OutboxEntry.Invoices, or -1 for summaries like the cover page.
+type OutboxAttachment struct {
+	Filename string `json:"filename"`
+	Invoice  int    `json:"invoice"`
+}
+
+// failedSinks returns the sinks that failed; results are in the order of
+// sinks, as deliverAll returns them.
+func failedSinks(sinks []Sink, results []SinkResult) []Sink {
+	var failed []Sink
+	for i, res := range results {
+		if res.Err != nil {
+			failed = append(failed, sinks[i])
+		}
+	}
+	return failed
+}
+
+// setPending records the sinks still missing the delivery, along with the
+// progress of those delivering invoice by invoice.
+func (e *OutboxEntry) setPending(sinks []Sink) {
+	e.Sinks, e.Progress = nil, nil
+	for _, sink := range sinks {
+		e.Sinks = append(e.Sinks, sink.Name())
+		t, ok := sink.(progressTracker)
+		if !ok || (len(t.progress().Done) == 0 && len(t.progress().Created) == 0) {
+			continue
+		}
+		if e.Progress == nil {
+			e.Progress = map[string]*SinkProgress{}
+		}
+		e.Progress[sink.Name()] = t.progress()
+	}
+}
+
+// saveOutbox stores the delivery for the failed sinks below dir in a new
+// directory named after now, and returns its path.
+func saveOutbox(dir string, d *Delivery, failed []Sink, now time.Time) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("creating outbox directory: %w", err)
+	}
+	path, err := os.MkdirTemp(dir, now.Format("20060102-150405")+"-")
+	if err != nil {
+		return "", fmt.Errorf("creating outbox entry: %w", err)
+	}
+
+	entry := OutboxEntry{Created: now}
+	entry.setPending(failed)
+	for _, inv := range d.Invoices {
+		entry.Invoices = append(entry.Invoices, inv.Metadata())
+	}
+	for _, att := range d.Attachments {
+		index := -1
+		for i := range d.Invoices {
+			if att.Invoice == &d.Invoices[i] {
+				index = i
+			}
+		}
+		data, err := att.content()
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", att.Filename, err)
+		}
+		if err := os.WriteFile(filepath.Join(path, att.Filename), data, 0644); err != nil {
+			return "", fmt.Errorf("writing %s to outbox: %w", att.Filename, err)
+		}
+		entry.Attachments = append(entry.Attachments, OutboxAttachment{Filename: att.Filename, Invoice: index})
+	}
+	if err := entry.save(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// save writes the entry's description file.
+func (e *OutboxEntry) save(path string) error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding outbox entry: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(path, outboxFile), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing outbox entry: %w", err)
+	}
+	return nil
+}
+
+// loadOutbox reads the entry at path and rebuilds its delivery.
+func loadOutbox(path string) (*OutboxEntry, *Delivery, error) {
+	data, err := os.ReadFile(filepath.Join(path, outboxFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading outbox entry: %w", err)
+	}
+	var entry OutboxEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, nil, fmt.Errorf("parsing outbox entry %s: %w", path, err)
+	}
+
+	d := &Delivery{Invoices: make([]ProcessedInvoice, len(entry.Invoices))}
+	for i, meta := range entry.Invoices {
+		d.Invoices[i] = invoiceFromMetadata(meta)
+	}
+	for _, att := range entry.Attachments {
+		data, err := os.ReadFile(filepath.Join(path, att.Filename))
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading outbox file: %w", err)
+		}
+		file := PDFAttachment{Filename: att.Filename, Data: data}
+		if att.Invoice >= 0 && att.Invoice < len(d.Invoices) {
+			inv := &d.Invoices[att.Invoice]
+			file.Invoice = inv
+			switch att.Filename {
+			case inv.Filename + ".pdf":
+				inv.PDF = data
+			case inv.Filename + ".eml":
+				inv.Email.Raw = data
+			}
+		}
+		d.Attachments = append(d.Attachments, file)
+	}
+	return &entry, d, nil
+}
+
+// invoiceFromMetadata is the inverse of ProcessedInvoice.Metadata, without
+// the PDF and email source.
+func invoiceFromMetadata(m InvoiceMetadata) ProcessedInvoice {
+	p := ProcessedInvoice{
+		Email:         InvoiceEmail{Subject: m.Subject, Date: m.Date, MessageID: m.MessageID},
+		OrderNumber:   m.OrderNumber,
+		InvoiceNumber: m.InvoiceNumber,
+		AppleID:       m.AppleID,
+		Filename:      m.Filename[:len(m.Filename)-len(filepath.Ext(m.Filename))],
+	}
+	if m.Currency != "" {
+		p.Total = Amount{Cents: m.TotalCents, Currency: m.Currency}
+	}
+	return p
+}
+
+// runResume retries every delivery in the outbox, oldest first, but only
+// for the sinks that failed before. Entries are removed once all their
+// sinks succeeded.
+func runResume(ctx context.Context, cfg *Config) (err error) {
+	ctx, span := startSpan(ctx, "resume")
+	defer func() { span.finish(err) }()
+
+	if cfg.Outbox.Dir == "" {
+		return withExitCode(exitConfig, errors.New("resume: outbox.dir is not configured"))
+	}
+	dirs, err := os.ReadDir(cfg.Outbox.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		dirs, err = nil, nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading outbox: %w", err)
+	}
+	var paths []string
+	for _, dir := range dirs {
+		if dir.IsDir() {
+			paths = append(paths, filepath.Join(cfg.Outbox.Dir, dir.Name()))
+		}
+	}
+	sort.Strings(paths)
+	if len(paths) == 0 {
+		slog.Info("Outbox is empty")
+		return nil
+	}
+
+	store, err := loadState(cfg.State.File)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, path := range paths {
+		if err := resumeEntry(ctx, cfg, store, path); err != nil {
+			slog.Error("Resuming delivery failed", "path", path, "err", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// resumeEntry delivers one outbox entry to its pending sinks.
+func resumeEntry(ctx context.Context, cfg *Config, store *stateStore, path string) error {
+	entry, d, err := loadOutbox(path)
+	if err != nil {
+		return err
+	}
+	all, err := buildSinks(cfg)
+	if err != nil {
+		return err
+	}
+	var sinks []Sink
+	for _, sink := range all {
+		if !slices.Contains(entry.Sinks, sink.Name()) {
+			continue
+		}
+		if t, ok := sink.(progressTracker); ok && entry.Progress[sink.Name()] != nil {
+			*t.progress() = *entry.Progress[sink.Name()]
+		}
+		sinks = append(sinks, sink)
+	}
+	slog.Info("Resuming delivery", "entry", filepath.Base(path), "files", len(d.Attachments), "sinks", entry.Sinks)
+
+	results := deliverAll(ctx, sinks, d, cfg.Delivery)
+	auditDelivery(cfg, d, sinks, results)
+	// Failed sinks stay queued for the next resume
+	if err := store.record(d.Invoices, deliveryStatus(d.Invoices, sinks, results, true), time.Now()); err != nil {
+		return err
+	}
+	if err := deliver.Failed(results); err != nil {
+		entry.setPending(failedSinks(sinks, results))
+		if serr := entry.save(path); serr != nil {
+			return errors.Join(err, serr)
+		}
+		return err
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("removing outbox entry: %w", err)
+	}
+	slog.Info("Delivered outbox entry", "entry", filepath.Base(path))
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testOutboxDelivery() *Delivery {
+	inv := testProcessedInvoice()
+	inv.Email.MessageID = "<a@apple.com>"
+	inv.Filename = "05_2024_Rechnung_Apple_MXYZ123"
+	inv.PDF = []byte("%PDF-1.4")
+	d := &Delivery{Invoices: []ProcessedInvoice{inv}}
+	d.Attachments = []PDFAttachment{
+		{Filename: "05_2024_Rechnungen_Apple_Uebersicht.pdf", Data: []byte("%PDF-cover")},
+		{Filename: inv.Filename + ".pdf", Data: inv.PDF, Invoice: &d.Invoices[0]},
+	}
+	return d
+}
+
+func TestOutbox_SaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC)
+	path, err := saveOutbox(dir, testOutboxDelivery(), []Sink{&fakeSink{name: "email"}}, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry, d, err := loadOutbox(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entry.Sinks) != 1 || entry.Sinks[0] != "email" || !entry.Created.Equal(now) {
+		t.Errorf("entry = %+v", entry)
+	}
+	if len(d.Invoices) != 1 || len(d.Attachments) != 2 {
+		t.Fatalf("delivery = %+v", d)
+	}
+	inv := d.Invoices[0]
+	if inv.Filename != "05_2024_Rechnung_Apple_MXYZ123" || inv.OrderNumber != "MXYZ123" || inv.Total != (Amount{Cents: 999, Currency: "EUR"}) || inv.Email.MessageID != "<a@apple.com>" {
+		t.Errorf("invoice = %+v", inv)
+	}
+	if string(inv.PDF) != "%PDF-1.4" {
+		t.Errorf("PDF = %q", inv.PDF)
+	}
+	if d.Attachments[0].Invoice != nil || d.Attachments[1].Invoice != &d.Invoices[0] {
+		t.Error("attachments not linked to their invoices")
+	}
+}
+
+func TestRunResume(t *testing.T) {
+	outbox := t.TempDir()
+	output := t.TempDir()
+	cfg := &Config{}
+	cfg.Outbox.Dir = outbox
+	cfg.Output.Dir = output
+	cfg.State.File = filepath.Join(t.TempDir(), "processed.json")
+
+	// Only the sinks that failed before are retried
+	path, err := saveOutbox(outbox, testOutboxDelivery(), []Sink{&fakeSink{name: "output directory"}, &fakeSink{name: "ftps"}}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runResume(context.Background(), cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{"05_2024_Rechnungen_Apple_Uebersicht.pdf", "05_2024_Rechnung_Apple_MXYZ123.pdf"} {
+		if _, err := os.Stat(filepath.Join(output, name)); err != nil {
+			t.Errorf("%s not delivered: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("outbox entry not removed")
+	}
+	store, err := loadState(cfg.State.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !store.delivered("<a@apple.com>", "", "") {
+		t.Errorf("invoice not recorded as delivered")
+	}
+}
+
+func TestRunResume_KeepsFailedSinks(t *testing.T) {
+	outbox := t.TempDir()
+	cfg := &Config{}
+	cfg.Outbox.Dir = outbox
+	cfg.Delivery.Attempts = 1
+	// A file where the output directory should be makes the sink fail
+	blocker := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(blocker, nil, 0644)
+	cfg.Output.Dir = filepath.Join(blocker, "out")
+
+	path, err := saveOutbox(outbox, testOutboxDelivery(), []Sink{&fakeSink{name: "output directory"}}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runResume(context.Background(), cfg); err == nil {
+		t.Fatal("expected error")
+	}
+	entry, _, err := loadOutbox(path)
+	if err != nil {
+		t.Fatalf("outbox entry lost: %v", err)
+	}
+	if len(entry.Sinks) != 1 || entry.Sinks[0] != "output directory" {
+		t.Errorf("sinks = %v", entry.Sinks)
+	}
+}
+
+func TestOutbox_Progress(t *testing.T) {
+	d := testOutboxDelivery()
+	paperless := &paperlessSink{}
+	paperless.markDone(invoiceKey(d.Invoices[0]))
+	path, err := saveOutbox(t.TempDir(), d, []Sink{paperless, &fakeSink{name: "email"}}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, d, err := loadOutbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := entry.Progress["paperless"]; p == nil || !p.isDone(invoiceKey(d.Invoices[0])) {
+		t.Errorf("progress = %+v, want the invoice done for paperless", entry.Progress)
+	}
+	if _, ok := entry.Progress["email"]; ok {
+		t.Error("progress saved for a sink without any")
+	}
+}
+
+func TestRunResume_NotConfigured(t *testing.T) {
+	if err := runResume(context.Background(), &Config{}); err == nil {
+		t.Error("expected error without outbox.dir")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// dirSink writes all attachments to a local directory, optionally with a
+// JSON sidecar per invoice holding the parsed metadata and a snapshot of
+// the invoice's original HTML, so it can be reprocessed later.
+type dirSink struct {
+	dir      string
+	sidecars bool
+	html     bool
+}
+
+func (s *dirSink) Name() string { return "output directory" }
+
+func (s *dirSink) Deliver(_ context.Context, d *Delivery) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+	for _, att := range d.Attachments {
+		data, err := att.content()
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", att.Filename, err)
+		}
+		if err := os.WriteFile(filepath.Join(s.dir, att.Filename), data, 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", att.Filename, err)
+		}
+	}
+	if s.sidecars {
+		for _, inv := range d.Invoices {
+			data, err := json.MarshalIndent(inv.Metadata(), "", "  ")
+			if err != nil {
+				return fmt.Errorf("encoding sidecar for %s: %w", inv.Filename, err)
+			}
+			path := filepath.Join(s.dir, inv.Filename+".json")
+			if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+				return fmt.Errorf("writing sidecar %s: %w", path, err)
+			}
+		}
+	}
+	if s.html {
+		for _, inv := range d.Invoices {
+			body, err := inv.Email.HTML()
+			if err != nil {
+				return fmt.Errorf("reading HTML of %s: %w", inv.Filename, err)
+			}
+			// Invoices resumed from the outbox have no HTML left
+			if body == "" {
+				continue
+			}
+			path := filepath.Join(s.dir, inv.Filename+".html")
+			if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+				return fmt.Errorf("writing HTML snapshot %s: %w", path, err)
+			}
+		}
+	}
+	slog.Info("Wrote files to output directory", "count", len(d.Attachments), "dir", s.dir)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirSink_Deliver(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	inv := testProcessedInvoice()
+	inv.Filename = "05_2024_Rechnung_Apple_MXYZ123"
+	inv.Email.HTMLBody = `<p class="action-button-cell">Bestellnummer: MXYZ123</p>`
+	d := &Delivery{
+		Invoices:    []ProcessedInvoice{inv},
+		Attachments: []PDFAttachment{{Filename: inv.Filename + ".pdf", Data: []byte("%PDF")}},
+	}
+
+	sink := &dirSink{dir: dir, sidecars: true, html: true}
+	if err := sink.Deliver(context.Background(), d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pdf, err := os.ReadFile(filepath.Join(dir, inv.Filename+".pdf"))
+	if err != nil || string(pdf) != "%PDF" {
+		t.Errorf("PDF not written correctly: %q, %v", pdf, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, inv.Filename+".json"))
+	if err != nil {
+		t.Fatalf("reading sidecar: %v", err)
+	}
+	var md InvoiceMetadata
+	if err := json.Unmarshal(data, &md); err != nil {
+		t.Fatalf("decoding sidecar: %v", err)
+	}
+	if md.OrderNumber != "MXYZ123" || md.Filename != inv.Filename+".pdf" || md.TotalCents != 999 {
+		t.Errorf("sidecar = %+v", md)
+	}
+	if html, err := os.ReadFile(filepath.Join(dir, inv.Filename+".html")); err != nil || string(html) != inv.Email.HTMLBody {
+		t.Errorf("HTML snapshot = %q, %v, want the original HTML", html, err)
+	}
+}
+
+func TestDirSink_NoSidecars(t *testing.T) {
+	dir := t.TempDir()
+	inv := testProcessedInvoice()
+	inv.Filename = "invoice"
+	inv.Email.HTMLBody = "<p>Bestellnummer: MXYZ123</p>"
+	d := &Delivery{
+		Invoices:    []ProcessedInvoice{inv},
+		Attachments: []PDFAttachment{{Filename: "invoice.pdf", Data: []byte("%PDF")}},
+	}
+	if err := (&dirSink{dir: dir}).Deliver(context.Background(), d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "invoice.json")); !os.IsNotExist(err) {
+		t.Error("expected no sidecar to be written")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "invoice.html")); !os.IsNotExist(err) {
+		t.Error("expected no HTML snapshot to be written")
+	}
+}
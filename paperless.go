@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// PaperlessConfig configures direct uploads to the paperless-ngx REST API.
+type PaperlessConfig struct {
+	URL           string   `yaml:"url"`
+	Token         string   `yaml:"token"`
+	Correspondent string   `yaml:"correspondent"`
+	DocumentType  string   `yaml:"document_type"`
+	Title         string   `yaml:"title"`
+	Tags          []string `yaml:"tags"`
+}
+
+// paperlessSink posts every invoice PDF to /api/documents/post_document/.
+// Correspondent, document type and tags are looked up by name and created
+// if they do not exist yet.
+type paperlessSink struct {
+	cfg    PaperlessConfig
+	title  *template.Template
+	tags   []*template.Template
+	client *http.Client
+	ids    map[string]int // "endpoint/name" -> ID
+	SinkProgress
+}
+
+// newPaperlessSink applies defaults and parses the title and tag templates.
+func newPaperlessSink(cfg PaperlessConfig) (*paperlessSink, error) {
+	if cfg.Title == "" {
+		cfg.Title = "{{.Vendor}} Rechnung {{.OrderNumber}}"
+	}
+	cfg.URL = strings.TrimRight(cfg.URL, "/")
+	s := &paperlessSink{cfg: cfg, client: newAPIClient(), ids: map[string]int{}}
+	var err error
+	if s.title, err = template.New("title").Parse(cfg.Title); err != nil {
+		return nil, fmt.Errorf("paperless: parsing title template: %w", err)
+	}
+	for _, tag := range cfg.Tags {
+		tmpl, err := template.New("tag").Parse(tag)
+		if err != nil {
+			return nil, fmt.Errorf("paperless: parsing tag template %q: %w", tag, err)
+		}
+		s.tags = append(s.tags, tmpl)
+	}
+	return s, nil
+}
+
+func (s *paperlessSink) Name() string { return "paperless" }
+
+func (s *paperlessSink) Deliver(ctx context.Context, d *Delivery) error {
+	for _, inv := range d.Invoices {
+		if s.isDone(invoiceKey(inv)) {
+			continue
+		}
+		if err := s.upload(ctx, inv); err != nil {
+			return fmt.Errorf("uploading %s: %w", inv.Filename, err)
+		}
+		s.markDone(invoiceKey(inv))
+		slog.Info("Uploaded invoice to paperless", "file", inv.Filename+".pdf")
+	}
+	return nil
+}
+
+// upload posts a single invoice with its metadata.
+func (s *paperlessSink) upload(ctx context.Context, inv ProcessedInvoice) error {
+	data := newTemplateData(inv)
+	title, err := executeTemplate(s.title, data)
+	if err != nil {
+		return err
+	}
+	fields := [][2]string{
+		{"title", strings.TrimSpace(title)},
+		{"created", inv.Email.Date.Format("2006-01-02")},
+	}
+
+	id, err := s.lookup(ctx, "correspondents", counterparty(s.cfg.Correspondent, inv))
+	if err != nil {
+		return err
+	}
+	fields = append(fields, [2]string{"correspondent", strconv.Itoa(id)})
+	if s.cfg.DocumentType != "" {
+		id, err := s.lookup(ctx, "document_types", s.cfg.DocumentType)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, [2]string{"document_type", strconv.Itoa(id)})
+	}
+	for _, tmpl := range s.tags {
+		name, err := executeTemplate(tmpl, data)
+		if err != nil {
+			return err
+		}
+		// Tags whose placeholders are empty for this invoice are skipped
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		id, err := s.lookup(ctx, "tags", name)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, [2]string{"tags", strconv.Itoa(id)})
+	}
+
+	pdf, err := inv.readPDF()
+	if err != nil {
+		return err
+	}
+	body, contentType, err := newMultipartBody(fields, []multipartFile{
+		{Field: "document", Filename: inv.Filename + ".pdf", ContentType: "application/pdf", Data: pdf},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL+"/api/documents/post_document/", body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Token "+s.cfg.Token)
+	return doRequest(s.client, req, nil)
+}
+
+// lookup returns the ID of the object with the given name from a paperless
+// endpoint (correspondents, document_types, tags), creating it if missing.
+func (s *paperlessSink) lookup(ctx context.Context, endpoint, name string) (int, error) {
+	key := endpoint + "/" + strings.ToLower(name)
+	if id, ok := s.ids[key]; ok {
+		return id, nil
+	}
+	header := http.Header{"Authorization": {"Token " + s.cfg.Token}}
+
+	var list struct {
+		Results []struct {
+			ID int `json:"id"`
+		} `json:"results"`
+	}
+	listURL := fmt.Sprintf("%s/api/%s/?name__iexact=%s", s.cfg.URL, endpoint, url.QueryEscape(name))
+	if err := doJSON(ctx, s.client, http.MethodGet, listURL, header, nil, &list); err != nil {
+		return 0, fmt.Errorf("looking up %s %q: %w", endpoint, name, err)
+	}
+
+	var id int
+	if len(list.Results) > 0 {
+		id = list.Results[0].ID
+	} else {
+		var created struct {
+			ID int `json:"id"`
+		}
+		createURL := fmt.Sprintf("%s/api/%s/", s.cfg.URL, endpoint)
+		if err := doJSON(ctx, s.client, http.MethodPost, createURL, header, map[string]string{"name": name}, &created); err != nil {
+			return 0, fmt.Errorf("creating %s %q: %w", endpoint, name, err)
+		}
+		id = created.ID
+	}
+	s.ids[key] = id
+	return id, nil
+}
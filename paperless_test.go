@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakePaperless serves the subset of the paperless-ngx API used by the sink.
+type fakePaperless struct {
+	objects map[string]map[string]int // endpoint -> name -> id
+	nextID  int
+	posted  []map[string][]string
+	files   []string
+}
+
+func (f *fakePaperless) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Token secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path == "/api/documents/post_document/" {
+		r.ParseMultipartForm(1 << 20)
+		file, header, err := r.FormFile("document")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		f.posted = append(f.posted, r.MultipartForm.Value)
+		f.files = append(f.files, header.Filename+":"+string(data))
+		json.NewEncoder(w).Encode("task-id")
+		return
+	}
+	endpoint := r.URL.Path[len("/api/") : len(r.URL.Path)-1]
+	if f.objects[endpoint] == nil {
+		f.objects[endpoint] = map[string]int{}
+	}
+	switch r.Method {
+	case http.MethodGet:
+		var results []map[string]int
+		if id, ok := f.objects[endpoint][r.URL.Query().Get("name__iexact")]; ok {
+			results = append(results, map[string]int{"id": id})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"results": results})
+	case http.MethodPost:
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		f.nextID++
+		f.objects[endpoint][body["name"]] = f.nextID
+		json.NewEncoder(w).Encode(map[string]int{"id": f.nextID})
+	}
+}
+
+func TestPaperlessSink_Deliver(t *testing.T) {
+	fake := &fakePaperless{objects: map[string]map[string]int{"correspondents": {"Apple": 7}}, nextID: 100}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	sink, err := newPaperlessSink(PaperlessConfig{
+		URL:          srv.URL + "/",
+		Token:        "secret",
+		DocumentType: "Rechnung",
+		Tags:         []string{"Apple", "{{.Year}}", "{{.InvoiceNumber}}"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	inv := testProcessedInvoice()
+	inv.InvoiceNumber = ""
+	inv.Filename = "2024-05-14 Apple Rechnung MXYZ123"
+	inv.PDF = []byte("%PDF")
+	second := inv
+	second.Email.MessageID = "<b@apple.com>"
+	d := &Delivery{Invoices: []ProcessedInvoice{inv, second}}
+
+	if err := sink.Deliver(context.Background(), d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fake.posted) != 2 {
+		t.Fatalf("posted %d documents, want 2", len(fake.posted))
+	}
+	form := fake.posted[0]
+	if form["title"][0] != "Apple Rechnung MXYZ123" || form["created"][0] != "2024-05-14" {
+		t.Errorf("title/created = %v/%v", form["title"], form["created"])
+	}
+	if form["correspondent"][0] != "7" {
+		t.Errorf("correspondent = %v, want existing ID 7", form["correspondent"])
+	}
+	if form["document_type"][0] != "101" {
+		t.Errorf("document_type = %v, want created ID 101", form["document_type"])
+	}
+	// Empty tag from missing invoice number is skipped
+	if len(form["tags"]) != 2 || form["tags"][0] != "102" || form["tags"][1] != "103" {
+		t.Errorf("tags = %v, want [102 103]", form["tags"])
+	}
+	// Second upload reuses cached IDs instead of creating new objects
+	if fake.nextID != 103 || len(fake.posted[1]["tags"]) != 2 || fake.posted[1]["tags"][1] != "103" {
+		t.Errorf("expected cached IDs on second upload, nextID = %d, tags = %v", fake.nextID, fake.posted[1]["tags"])
+	}
+	if fake.files[0] != "2024-05-14 Apple Rechnung MXYZ123.pdf:%PDF" {
+		t.Errorf("file = %q", fake.files[0])
+	}
+}
+
+func TestPaperlessSink_Unauthorized(t *testing.T) {
+	srv := httptest.NewServer(&fakePaperless{objects: map[string]map[string]int{}})
+	defer srv.Close()
+
+	sink, _ := newPaperlessSink(PaperlessConfig{URL: srv.URL, Token: "wrong"})
+	err := sink.Deliver(context.Background(), &Delivery{Invoices: []ProcessedInvoice{testProcessedInvoice()}})
+	if err == nil {
+		t.Fatal("expected error for invalid token")
+	}
+}
+
+func TestNewPaperlessSink_InvalidTemplate(t *testing.T) {
+	if _, err := newPaperlessSink(PaperlessConfig{URL: "http://x", Tags: []string{"{{.Year"}}); err == nil {
+		t.Error("expected error for invalid tag template")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/htmlclean"
+	"github.com/rummeyer/apple-invoice-pdf/internal/imapsource"
+	"github.com/rummeyer/apple-invoice-pdf/internal/pdf"
+	"github.com/rummeyer/apple-invoice-pdf/pkg/invoice"
+)
+
+// PipelineConfig selects where invoices come from and how they are turned
+// into PDFs. The sinks are configured by their own sections.
+type PipelineConfig struct {
+	// Source names the input, see sources, or a source plugin.
+	Source string `yaml:"source"`
+	// Transformers lists the processing steps in order, see transformers.
+	Transformers []string `yaml:"transformers"`
+}
+
+// Source yields the invoice emails of a month.
+type Source interface {
+	// Name identifies the source in log output.
+	Name() string
+	Fetch(ctx context.Context, month time.Time) ([]InvoiceEmail, error)
+}
+
+// Transformer is a processing step applied to every invoice, in the order
+// configured. It works on p.HTML, which starts as the email's HTML body,
+// and fills in p.PDF or parsed fields.
+type Transformer interface {
+	// Name identifies the step in log output and traces.
+	Name() string
+	Transform(ctx context.Context, p *ProcessedInvoice) error
+}
+
+// Pipeline fetches invoices from a source, transforms each one and hands
+// the results to the sinks.
+type Pipeline struct {
+	Source       Source
+	Transformers []Transformer
+	Sinks        []Sink
+}
+
+// sources maps the names accepted in pipeline.source to constructors.
+var sources = map[string]func(cfg *Config) (Source, error){
+	"imap": func(cfg *Config) (Source, error) { return &imapSource{cfg: cfg}, nil },
+}
+
+// transformers maps the names accepted in pipeline.transformers to
+// constructors.
+var transformers = map[string]func(cfg *Config) Transformer{
+	"clean": func(cfg *Config) Transformer {
+		return cleanTransformer{fetcher: newImageFetcher(cfg.Images), clean: cfg.Clean, images: cfg.Images}
+	},
+	"extract": func(*Config) Transformer { return extractTransformer{} },
+	"pdf":     func(cfg *Config) Transformer { return pdfTransformer{opts: cfg.PDF} },
+}
+
+// defaultTransformers are used if pipeline.transformers is empty.
+var defaultTransformers = []string{"clean", "extract", "pdf"}
+
+// buildPipeline returns the configured source and transformers with the
+// sinks of buildSinks.
+func buildPipeline(cfg *Config) (*Pipeline, error) {
+	sinks, err := buildSinks(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return buildProcessing(cfg, sinks)
+}
+
+// buildProcessing returns the configured source and transformers feeding
+// sinks.
+func buildProcessing(cfg *Config, sinks []Sink) (*Pipeline, error) {
+	name := cfg.Pipeline.Source
+	if name == "" {
+		name = "imap"
+	}
+	source, err := sourcePlugin(cfg, name)
+	if newSource, ok := sources[name]; ok {
+		source, err = newSource(cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pipeline: source %s: %w", name, err)
+	}
+	if source == nil {
+		return nil, fmt.Errorf("pipeline: unknown source %q", name)
+	}
+	p := &Pipeline{Source: source, Sinks: sinks}
+
+	names := cfg.Pipeline.Transformers
+	if len(names) == 0 {
+		names = defaultTransformers
+	}
+	if !slices.Contains(names, "pdf") {
+		return nil, fmt.Errorf("pipeline: transformers must include pdf")
+	}
+	for _, name := range names {
+		newTransformer, ok := transformers[name]
+		if !ok {
+			return nil, fmt.Errorf("pipeline: unknown transformer %q", name)
+		}
+		p.Transformers = append(p.Transformers, newTransformer(cfg))
+	}
+	return p, nil
+}
+
+// imapSource fetches invoice emails from the configured IMAP INBOX.
+type imapSource struct {
+	cfg *Config
+}
+
+func (s *imapSource) Name() string { return "imap" }
+
+func (s *imapSource) Fetch(ctx context.Context, month time.Time) ([]InvoiceEmail, error) {
+	return fetchInvoices(ctx, s.cfg, month)
+}
+
+// cleanTransformer removes the screen-only elements of the invoice's vendor
+// and tracking pixels and embeds images, including those sent with the
+// email; external images only if fetcher is set.
+type cleanTransformer struct {
+	fetcher *htmlclean.ImageFetcher
+	clean   CleanConfig
+	images  ImagesConfig
+}
+
+func (cleanTransformer) Name() string { return "clean" }
+
+func (t cleanTransformer) Transform(ctx context.Context, p *ProcessedInvoice) error {
+	var images map[string]string
+	if strings.Contains(strings.ToLower(p.HTML), "cid:") {
+		raw, err := p.Email.Source()
+		if err == nil && len(raw) > 0 {
+			images, err = imapsource.InlineImages(raw)
+		}
+		if err != nil {
+			slog.Warn("Reading inline images failed", "uid", p.Email.UID, "err", err)
+		}
+	}
+	opts := htmlclean.Options{
+		// Empty but non-nil, so htmlclean does not fall back to Apple's
+		Remove:     []string{},
+		Rules:      []htmlclean.Rule{},
+		Images:     images,
+		Tracking:   t.images.trackingURLs(),
+		Links:      t.clean.Links,
+		Workers:    t.images.Workers,
+		AllowHosts: t.images.Allow,
+		BlockHosts: t.images.Block,
+	}
+	if !t.clean.Replace {
+		opts.Remove = append(opts.Remove, p.vendor().Remove...)
+		opts.Rules = append(opts.Rules, p.vendor().Rules...)
+	}
+	opts.Rules = append(opts.Rules, t.clean.rules...)
+	html, err := cleanHTML(ctx, t.fetcher, p.HTML, opts)
+	if err != nil {
+		return fmt.Errorf("cleaning HTML: %w", err)
+	}
+	p.HTML = html
+	return nil
+}
+
+// extractTransformer parses order and invoice number, Apple ID and total
+// from the original email HTML, the order number with the labels of the
+// invoice's vendor.
+type extractTransformer struct{}
+
+func (extractTransformer) Name() string { return "extract" }
+
+func (extractTransformer) Transform(_ context.Context, p *ProcessedInvoice) error {
+	body, err := p.Email.HTML()
+	if err != nil {
+		return fmt.Errorf("reading email body: %w", err)
+	}
+	p.OrderNumber = p.vendor().orderNumber(body)
+	p.InvoiceNumber = invoice.InvoiceNumber(body)
+	p.AppleID = invoice.AppleID(body)
+	p.Total = invoice.Total(body)
+	return nil
+}
+
+// pdfTransformer renders p.HTML with headless Chrome, or takes the
+// attached PDF for vendors with Attachment set.
+type pdfTransformer struct {
+	opts PDFOptions
+}
+
+func (pdfTransformer) Name() string { return "pdf" }
+
+func (t pdfTransformer) Transform(ctx context.Context, p *ProcessedInvoice) error {
+	if p.vendor().Attachment && (len(p.Email.Raw) > 0 || p.Email.RawFile != "") {
+		raw, err := p.Email.Source()
+		if err != nil {
+			return fmt.Errorf("reading email source: %w", err)
+		}
+		data, err := imapsource.PDFAttachment(raw)
+		if err != nil {
+			return fmt.Errorf("reading PDF attachment: %w", err)
+		}
+		if data != nil {
+			p.PDF = data
+			return nil
+		}
+		slog.Warn("No PDF attached, printing the email", "uid", p.Email.UID, "vendor", p.vendor().Name)
+	}
+	start := time.Now()
+	data, err := pdf.Convert(ctx, p.HTML, t.opts)
+	timingsFrom(ctx).since("chrome", start)
+	if err != nil {
+		return fmt.Errorf("converting to PDF: %w", err)
+	}
+	metricsFrom(ctx).converted(time.Since(start))
+	p.PDF = data
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBuildProcessing_Defaults(t *testing.T) {
+	p, err := buildProcessing(&Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Source.Name() != "imap" {
+		t.Errorf("source = %s", p.Source.Name())
+	}
+	var names []string
+	for _, tr := range p.Transformers {
+		names = append(names, tr.Name())
+	}
+	if strings.Join(names, ",") != "clean,extract,pdf" {
+		t.Errorf("transformers = %v", names)
+	}
+}
+
+func TestBuildProcessing_Invalid(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  PipelineConfig
+		want string
+	}{
+		{PipelineConfig{Source: "pop3"}, `unknown source "pop3"`},
+		{PipelineConfig{Transformers: []string{"clean", "ocr", "pdf"}}, `unknown transformer "ocr"`},
+		{PipelineConfig{Transformers: []string{"clean"}}, "must include pdf"},
+	} {
+		_, err := buildProcessing(&Config{Pipeline: tc.cfg}, nil)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: err = %v, want %q", tc.cfg, err, tc.want)
+		}
+	}
+}
+
+// fakeTransformer appends its name to the HTML, or fails with err.
+type fakeTransformer struct {
+	name string
+	err  error
+}
+
+func (t fakeTransformer) Name() string { return t.name }
+
+func (t fakeTransformer) Transform(_ context.Context, p *ProcessedInvoice) error {
+	if t.err != nil {
+		return t.err
+	}
+	p.HTML += t.name
+	return nil
+}
+
+func TestTransform_StopsAtFirstError(t *testing.T) {
+	p := &ProcessedInvoice{}
+	err := transform(context.Background(), []Transformer{
+		fakeTransformer{name: "a"},
+		fakeTransformer{name: "b", err: errors.New("broken")},
+		fakeTransformer{name: "c"},
+	}, p)
+	if err == nil || p.HTML != "a" {
+		t.Errorf("err = %v, HTML = %q", err, p.HTML)
+	}
+}
+
+func TestProcessInvoices_Transformers(t *testing.T) {
+	invoices := []InvoiceEmail{
+		{UID: 1, Subject: "Deine Rechnung von Apple", HTMLBody: "<p>Bestellnummer: MX1</p>"},
+		{UID: 2, Subject: "Deine Rechnung von Apple", HTMLBody: "<p>kaputt</p>"},
+	}
+	cfg := &Config{}
+	cfg.Filename.Template = defaultFilenameTemplate
+	failing := fakeTransformer{name: "pdf", err: errors.New("converting to PDF: no Chrome")}
+	processed, failures := processInvoices(context.Background(), cfg, []Transformer{extractTransformer{}, fakeTransformer{name: "x"}}, invoices[:1])
+	if len(failures) != 0 || len(processed) != 1 || processed[0].OrderNumber != "MX1" || processed[0].HTML != invoices[0].HTMLBody+"x" {
+		t.Fatalf("processed = %+v, failures = %v", processed, failures)
+	}
+	_, failures = processInvoices(context.Background(), cfg, []Transformer{failing}, invoices[1:])
+	if len(failures) != 1 || failures[0].Email.UID != 2 || failures[0].Err.Error() != "converting to PDF: no Chrome" {
+		t.Errorf("failures = %v", failures)
+	}
+}
@@ -0,0 +1,123 @@
+package invoice
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Amount is a monetary value in minor units (cents) with its ISO currency code.
+// The zero Amount means "unknown".
+type Amount struct {
+	Cents    int64
+	Currency string
+}
+
+// currencyExpr and numberExpr are combined into amountExpr, which matches an
+// amount with the currency either before or after the number.
+const (
+	currencyExpr = `(?:€|EUR|US\$|USD|\$|£|GBP|CHF)`
+	numberExpr   = `\d+(?:[.,']\d+)*`
+	amountExpr   = `(?:` + currencyExpr + `\s*` + numberExpr + `|` + numberExpr + `\s*` + currencyExpr + `)`
+)
+
+var (
+	currencyPattern = regexp.MustCompile(currencyExpr)
+	numberPattern   = regexp.MustCompile(numberExpr)
+)
+
+// currencyCodes maps currency symbols and codes found in invoices to ISO codes.
+var currencyCodes = map[string]string{
+	"€":   "EUR",
+	"EUR": "EUR",
+	"$":   "USD",
+	"US$": "USD",
+	"USD": "USD",
+	"£":   "GBP",
+	"GBP": "GBP",
+	"CHF": "CHF",
+}
+
+// ParseAmount parses strings like "9,99 €", "€1.234,56" or "$12.00".
+// The last separator is treated as the decimal mark when followed by one or
+// two digits; all other separators are thousands separators.
+func ParseAmount(s string) (Amount, bool) {
+	cur := currencyPattern.FindString(s)
+	num := numberPattern.FindString(s)
+	if cur == "" || num == "" {
+		return Amount{}, false
+	}
+
+	whole, frac := num, ""
+	if idx := strings.LastIndexAny(num, ".,"); idx >= 0 && len(num)-idx-1 <= 2 {
+		whole, frac = num[:idx], num[idx+1:]
+	}
+	whole = strings.NewReplacer(".", "", ",", "", "'", "").Replace(whole)
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	cents, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Amount{}, false
+	}
+	return Amount{Cents: cents, Currency: currencyCodes[cur]}, true
+}
+
+// IsZero reports whether the amount is unknown.
+func (a Amount) IsZero() bool {
+	return a.Currency == ""
+}
+
+// String formats the amount German-style, e.g. "1.234,56 €" or "12,00 USD".
+// Returns an empty string for the zero Amount.
+func (a Amount) String() string {
+	if a.IsZero() {
+		return ""
+	}
+	sign, cents := "", a.Cents
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	whole := strconv.FormatInt(cents/100, 10)
+	for i := len(whole) - 3; i > 0; i -= 3 {
+		whole = whole[:i] + "." + whole[i:]
+	}
+	symbol := a.Currency
+	if symbol == "EUR" {
+		symbol = "€"
+	}
+	return fmt.Sprintf("%s%s,%02d %s", sign, whole, cents%100, symbol)
+}
+
+// SumAmounts adds up the given amounts. It returns false if any amount is
+// unknown or the currencies differ, since no meaningful total exists then.
+func SumAmounts(amounts []Amount) (Amount, bool) {
+	if len(amounts) == 0 {
+		return Amount{}, false
+	}
+	total := Amount{Currency: amounts[0].Currency}
+	for _, a := range amounts {
+		if a.IsZero() || a.Currency != total.Currency {
+			return Amount{}, false
+		}
+		total.Cents += a.Cents
+	}
+	return total, true
+}
+
+// IncludedTax returns the tax portion in cents contained in a gross amount
+// at the given rate, e.g. 160 for 9,99 € at 19 %.
+func (a Amount) IncludedTax(ratePercent float64) int64 {
+	net := math.Round(float64(a.Cents) * 100 / (100 + ratePercent))
+	return a.Cents - int64(net)
+}
+
+// Decimal returns the amount in major units for APIs expecting a JSON number.
+func (a Amount) Decimal() float64 {
+	return float64(a.Cents) / 100
+}
@@ -0,0 +1,80 @@
+package invoice
+
+import "testing"
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		input  string
+		want   Amount
+		wantOK bool
+	}{
+		{"9,99 €", Amount{999, "EUR"}, true},
+		{"€1.234,56", Amount{123456, "EUR"}, true},
+		{"$12.00", Amount{1200, "USD"}, true},
+		{"US$ 1,299.5", Amount{129950, "USD"}, true},
+		{"CHF 1'000", Amount{100000, "CHF"}, true},
+		{"5 EUR", Amount{500, "EUR"}, true},
+		{"12,49", Amount{}, false},
+		{"€", Amount{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, ok := ParseAmount(tt.input)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("ParseAmount(%q) = %+v, %v; want %+v, %v", tt.input, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		want   string
+	}{
+		{Amount{999, "EUR"}, "9,99 €"},
+		{Amount{123456789, "EUR"}, "1.234.567,89 €"},
+		{Amount{1200, "USD"}, "12,00 USD"},
+		{Amount{-50, "EUR"}, "-0,50 €"},
+		{Amount{}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestSumAmounts(t *testing.T) {
+	total, ok := SumAmounts([]Amount{{999, "EUR"}, {1998, "EUR"}})
+	if !ok || total != (Amount{2997, "EUR"}) {
+		t.Errorf("SumAmounts() = %+v, %v; want 29,97 €", total, ok)
+	}
+	if _, ok := SumAmounts([]Amount{{999, "EUR"}, {100, "USD"}}); ok {
+		t.Error("expected mixed currencies to fail")
+	}
+	if _, ok := SumAmounts([]Amount{{999, "EUR"}, {}}); ok {
+		t.Error("expected unknown amount to fail")
+	}
+	if _, ok := SumAmounts(nil); ok {
+		t.Error("expected empty input to fail")
+	}
+}
+
+func TestAmount_IncludedTax(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		rate   float64
+		want   int64
+	}{
+		{Amount{999, "EUR"}, 19, 160},
+		{Amount{1190, "EUR"}, 19, 190},
+		{Amount{107, "EUR"}, 7, 7},
+		{Amount{999, "EUR"}, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.IncludedTax(tt.rate); got != tt.want {
+			t.Errorf("%v.IncludedTax(%v) = %d, want %d", tt.amount, tt.rate, got, tt.want)
+		}
+	}
+}
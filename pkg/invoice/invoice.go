@@ -0,0 +1,250 @@
+// Package invoice parses Apple invoice emails and converts them to PDF.
+//
+// The extraction functions take the HTML body of an invoice email as
+// returned by imapsource and return the labeled values Apple prints on
+// German and English invoices:
+//
+//	order := invoice.OrderNumber(html)  // "MXYZ123"
+//	total := invoice.Total(html)        // invoice.Amount{Cents: 999, Currency: "EUR"}
+//	items := invoice.LineItems(html)    // []invoice.LineItem{{Description: "iCloud+ 200 GB", ...}}
+//	pdf, err := invoice.ToPDF(ctx, html, invoice.PDFOptions{})
+package invoice
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/htmlclean"
+	"github.com/rummeyer/apple-invoice-pdf/internal/pdf"
+)
+
+// Email holds a matched email's IMAP UID, subject, date, sender, HTML
+// content, and the raw RFC822 source it was extracted from.
+type Email struct {
+	UID       uint32
+	Subject   string
+	Date      time.Time
+	MessageID string
+	// From is the sender's address, e.g. "no_reply@email.apple.com".
+	From string
+	// ForwardedBy is the sender of the email that forwarded the invoice as
+	// an attachment, empty if it was not forwarded. Subject, Date, From
+	// and HTMLBody are those of the invoice, MessageID and Raw those of
+	// the forward.
+	ForwardedBy string
+	HTMLBody    string
+	Raw         []byte
+	// RawFile, if set, holds the RFC822 source on disk instead of Raw,
+	// see Source.
+	RawFile string
+	// HTMLFile, if set, holds the HTML content on disk instead of
+	// HTMLBody, see HTML.
+	HTMLFile string
+}
+
+// HTML returns the HTML content of the email from HTMLBody or HTMLFile.
+func (e Email) HTML() (string, error) {
+	if e.HTMLBody != "" || e.HTMLFile == "" {
+		return e.HTMLBody, nil
+	}
+	data, err := os.ReadFile(e.HTMLFile)
+	return string(data), err
+}
+
+// Source returns the RFC822 source of the email from Raw or RawFile, or
+// nil if it has none.
+func (e Email) Source() ([]byte, error) {
+	if e.Raw != nil || e.RawFile == "" {
+		return e.Raw, nil
+	}
+	return os.ReadFile(e.RawFile)
+}
+
+// OrderNumber parses the invoice HTML for the value following the
+// "Bestellnummer:" label and returns it (trimmed). Returns an empty string
+// if no order number is found.
+func OrderNumber(htmlContent string) string {
+	return LabeledValue(htmlContent, "Bestellnummer:")
+}
+
+// InvoiceNumber returns the value following the "Rechnungsnummer:" or
+// "Dokumentnummer:" label, or an empty string if none is found.
+func InvoiceNumber(htmlContent string) string {
+	return LabeledValue(htmlContent, "Rechnungsnummer:", "Dokumentnummer:")
+}
+
+// AppleID returns the Apple account the invoice was issued to, or an empty
+// string if none is found.
+func AppleID(htmlContent string) string {
+	return LabeledValue(htmlContent, "Apple-ID:", "Apple ID:", "Apple Account:", "Apple-Account:")
+}
+
+// LabeledValue returns the trimmed text following the first element whose
+// text starts with one of the given labels, for values of other vendors'
+// invoices. Returns an empty string if no label is found.
+func LabeledValue(htmlContent string, labels ...string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+	if err != nil {
+		return ""
+	}
+	var value string
+	doc.Find("*").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		text := strings.TrimSpace(s.Text())
+		for _, label := range labels {
+			if strings.HasPrefix(text, label) {
+				value = strings.TrimSpace(strings.TrimPrefix(text, label))
+				// Take only the first line/word to avoid capturing trailing content
+				if idx := strings.IndexAny(value, "\n\r\t"); idx >= 0 {
+					value = strings.TrimSpace(value[:idx])
+				}
+				return false
+			}
+		}
+		return true
+	})
+	return value
+}
+
+// totalPattern matches the invoice total label followed by an amount with
+// its currency on either side, e.g. "Gesamt 9,99 €" or "Total: $12.00".
+var totalPattern = regexp.MustCompile(`(?i)(?:Gesamtbetrag|Gesamtsumme|Gesamt|Total)\s*:?\s*` + amountExpr)
+
+// Total parses the invoice HTML for the total amount. When the label
+// appears more than once, the last occurrence wins since totals follow
+// subtotals. Returns the zero Amount if no total is found.
+func Total(htmlContent string) Amount {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+	if err != nil {
+		return Amount{}
+	}
+	text := strings.Join(strings.Fields(doc.Text()), " ")
+	matches := totalPattern.FindAllString(text, -1)
+	if len(matches) == 0 {
+		return Amount{}
+	}
+	amount, _ := ParseAmount(matches[len(matches)-1])
+	return amount
+}
+
+// LineItem is a purchased item of an invoice with its price.
+type LineItem struct {
+	Description string
+	Amount      Amount
+}
+
+var (
+	// pricePattern matches a table cell holding nothing but an amount.
+	pricePattern = regexp.MustCompile(`^` + amountExpr + `$`)
+	// summaryPattern matches the labels of rows that sum up the items
+	// rather than list one.
+	summaryPattern = regexp.MustCompile(`(?i)^(?:Gesamt|Zwischensumme|Summe|MwSt|USt|inkl|Total|Subtotal|Tax|VAT)`)
+)
+
+// LineItems returns the purchased items: table rows whose last non-empty
+// cell is an amount, described by their first cell. Total, subtotal and tax
+// rows are left out. Returns nil if the HTML lists no items.
+func LineItems(htmlContent string) []LineItem {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+	if err != nil {
+		return nil
+	}
+	var items []LineItem
+	doc.Find("tr").Each(func(_ int, row *goquery.Selection) {
+		// Apple nests layout tables; only the innermost rows hold items
+		if row.Find("tr").Length() > 0 {
+			return
+		}
+		var cells []string
+		row.ChildrenFiltered("td,th").Each(func(_ int, cell *goquery.Selection) {
+			if text := cellText(cell); text != "" {
+				cells = append(cells, text)
+			}
+		})
+		if len(cells) < 2 || !pricePattern.MatchString(cells[len(cells)-1]) || summaryPattern.MatchString(cells[0]) {
+			return
+		}
+		amount, ok := ParseAmount(cells[len(cells)-1])
+		if !ok {
+			return
+		}
+		items = append(items, LineItem{Description: cells[0], Amount: amount})
+	})
+	return items
+}
+
+// cellText returns the text of a table cell with its lines, which Apple
+// separates with <br> and blocks, joined by single spaces.
+func cellText(cell *goquery.Selection) string {
+	return strings.Join(words(cell.Nodes), " ")
+}
+
+// words returns the words of the text in nodes, in document order, leaving
+// out scripts and style sheets. Unlike goquery's Text, words of adjacent
+// elements stay apart.
+func words(nodes []*html.Node) []string {
+	var parts []string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style") {
+			return
+		}
+		if n.Type == html.TextNode {
+			parts = append(parts, strings.Fields(n.Data)...)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range nodes {
+		walk(n)
+	}
+	return parts
+}
+
+// WordAfter returns the first word following the earliest of the labels in
+// the text of the invoice, also when the value is in an element of its own
+// as in "<td>Transaction ID<br><span>1AB23</span></td>". Leading colons and
+// hash signs are skipped. Returns an empty string if no label is found.
+func WordAfter(htmlContent string, labels ...string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+	if err != nil {
+		return ""
+	}
+	text := strings.Join(words(doc.Nodes), " ")
+	first, end := -1, 0
+	for _, label := range labels {
+		if i := strings.Index(text, label); i >= 0 && (first < 0 || i < first) {
+			first, end = i, i+len(label)
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+	if fields := strings.Fields(strings.TrimLeft(text[end:], ":# ")); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// PDFOptions controls how Chrome renders the PDF.
+type PDFOptions = pdf.Options
+
+// ToPDF cleans the invoice HTML, embedding its images, and renders it to an
+// A4 PDF with headless Chrome, which must be installed.
+func ToPDF(ctx context.Context, htmlContent string, opts PDFOptions) ([]byte, error) {
+	cleaned, err := htmlclean.Clean(ctx, htmlContent, htmlclean.Options{
+		EmbedImage: func(ctx context.Context, src string) (string, error) {
+			return htmlclean.DataURI(ctx, nil, src)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pdf.Convert(ctx, cleaned, opts)
+}
@@ -0,0 +1,149 @@
+package invoice
+
+import "testing"
+
+// --- OrderNumber tests ---
+
+func TestExtractOrderNumber(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			"found in span",
+			`<html><body><span>Bestellnummer: W123456789</span></body></html>`,
+			"W123456789",
+		},
+		{
+			"found in td",
+			`<html><body><table><tr><td>Bestellnummer: MHJT12345</td></tr></table></body></html>`,
+			"MHJT12345",
+		},
+		{
+			"not found",
+			`<html><body><p>No order number here</p></body></html>`,
+			"",
+		},
+		{
+			"with extra whitespace",
+			`<html><body><p>Bestellnummer:   ABC99  </p></body></html>`,
+			"ABC99",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := OrderNumber(tt.html)
+			if got != tt.want {
+				t.Errorf("OrderNumber() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractInvoiceNumber(t *testing.T) {
+	html := `<html><body><table><tr><td>Rechnungsnummer: MD12345678</td></tr></table></body></html>`
+	if got := InvoiceNumber(html); got != "MD12345678" {
+		t.Errorf("InvoiceNumber() = %q, want %q", got, "MD12345678")
+	}
+	html = `<html><body><p>Dokumentnummer: 987654</p></body></html>`
+	if got := InvoiceNumber(html); got != "987654" {
+		t.Errorf("InvoiceNumber() = %q, want %q", got, "987654")
+	}
+}
+
+func TestExtractAppleID(t *testing.T) {
+	html := `<html><body><p>Apple Account: jane@example.com</p></body></html>`
+	if got := AppleID(html); got != "jane@example.com" {
+		t.Errorf("AppleID() = %q, want %q", got, "jane@example.com")
+	}
+	if got := AppleID(`<html><body><p>nothing</p></body></html>`); got != "" {
+		t.Errorf("AppleID() = %q, want empty", got)
+	}
+}
+
+// --- Total tests ---
+
+func TestExtractTotal(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want Amount
+	}{
+		{
+			"euro suffix",
+			`<html><body><table><tr><td>Gesamt</td><td>9,99 €</td></tr></table></body></html>`,
+			Amount{Cents: 999, Currency: "EUR"},
+		},
+		{
+			"last total wins",
+			`<html><body><p>Gesamt 1,00 €</p><p>Zwischensumme 5,00 €</p><p>Gesamtbetrag: 12,49 €</p></body></html>`,
+			Amount{Cents: 1249, Currency: "EUR"},
+		},
+		{
+			"dollar prefix",
+			`<html><body><p>Total $1,299.00</p></body></html>`,
+			Amount{Cents: 129900, Currency: "USD"},
+		},
+		{
+			"not found",
+			`<html><body><p>No total here</p></body></html>`,
+			Amount{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Total(tt.html)
+			if got != tt.want {
+				t.Errorf("Total() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// --- LineItems tests ---
+
+func TestExtractLineItems(t *testing.T) {
+	html := `<html><body><table><tr><td><table>
+		<tr><td><b>Final Cut Pro</b><br>Apple Distribution International</td><td>Mac</td><td>299,99 €</td></tr>
+		<tr><td>iCloud+ <span>200 GB</span></td><td></td><td>2,99 €</td></tr>
+		<tr><td>Zwischensumme</td><td>302,98 €</td></tr>
+		<tr><td>MwSt. 19 %</td><td>48,38 €</td></tr>
+		<tr><td>Gesamt</td><td>302,98 €</td></tr>
+		<tr><td>Bestellnummer: MX1</td></tr>
+	</table></td></tr></table></body></html>`
+	want := []LineItem{
+		{"Final Cut Pro Apple Distribution International", Amount{Cents: 29999, Currency: "EUR"}},
+		{"iCloud+ 200 GB", Amount{Cents: 299, Currency: "EUR"}},
+	}
+	got := LineItems(html)
+	if len(got) != len(want) {
+		t.Fatalf("LineItems() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("LineItems()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if got := LineItems(`<html><body><p>Gesamt 9,99 €</p></body></html>`); got != nil {
+		t.Errorf("LineItems() = %+v, want nil", got)
+	}
+}
+
+func TestWordAfter(t *testing.T) {
+	tests := []struct {
+		html string
+		want string
+	}{
+		{`<p>Bestellnummer: MX1</p>`, "MX1"},
+		{`<td><span>Transaction ID</span><br><span>1AB23456CD789012E</span></td>`, "1AB23456CD789012E"},
+		{`<p>Order #<b>112-1234567</b> placed</p>`, "112-1234567"},
+		{`<style>p { content: "Order #x" }</style><p>nothing</p>`, ""},
+		{`<p>Order # A1</p><p>Bestellnummer: B2</p>`, "A1"},
+	}
+	for _, tt := range tests {
+		if got := WordAfter(tt.html, "Bestellnummer", "Transaction ID", "Order #"); got != tt.want {
+			t.Errorf("WordAfter(%q) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+}
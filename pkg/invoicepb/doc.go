@@ -0,0 +1,5 @@
+// Package invoicepb holds the gRPC service of daemon mode, generated from
+// invoice.proto, for typed clients in other programs.
+package invoicepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative invoice.proto
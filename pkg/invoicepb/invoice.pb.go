@@ -0,0 +1,658 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: invoice.proto
+
+package invoicepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type RunEvent_Type int32
+
+const (
+	RunEvent_TYPE_UNSPECIFIED RunEvent_Type = 0
+	// The run waits for the scheduler.
+	RunEvent_TYPE_QUEUED  RunEvent_Type = 1
+	RunEvent_TYPE_STARTED RunEvent_Type = 2
+	// The outcome of one matched invoice email, sent after the run.
+	RunEvent_TYPE_INVOICE  RunEvent_Type = 3
+	RunEvent_TYPE_FINISHED RunEvent_Type = 4
+)
+
+// Enum value maps for RunEvent_Type.
+var (
+	RunEvent_Type_name = map[int32]string{
+		0: "TYPE_UNSPECIFIED",
+		1: "TYPE_QUEUED",
+		2: "TYPE_STARTED",
+		3: "TYPE_INVOICE",
+		4: "TYPE_FINISHED",
+	}
+	RunEvent_Type_value = map[string]int32{
+		"TYPE_UNSPECIFIED": 0,
+		"TYPE_QUEUED":      1,
+		"TYPE_STARTED":     2,
+		"TYPE_INVOICE":     3,
+		"TYPE_FINISHED":    4,
+	}
+)
+
+func (x RunEvent_Type) Enum() *RunEvent_Type {
+	p := new(RunEvent_Type)
+	*p = x
+	return p
+}
+
+func (x RunEvent_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (RunEvent_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_invoice_proto_enumTypes[0].Descriptor()
+}
+
+func (RunEvent_Type) Type() protoreflect.EnumType {
+	return &file_invoice_proto_enumTypes[0]
+}
+
+func (x RunEvent_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use RunEvent_Type.Descriptor instead.
+func (RunEvent_Type) EnumDescriptor() ([]byte, []int) {
+	return file_invoice_proto_rawDescGZIP(), []int{1, 0}
+}
+
+type TriggerRunRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Month to process as YYYY-MM; empty means the current month.
+	Month string `protobuf:"bytes,1,opt,name=month,proto3" json:"month,omitempty"`
+	// Write the files to a temporary directory instead of delivering them.
+	DryRun        bool `protobuf:"varint,2,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TriggerRunRequest) Reset() {
+	*x = TriggerRunRequest{}
+	mi := &file_invoice_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TriggerRunRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TriggerRunRequest) ProtoMessage() {}
+
+func (x *TriggerRunRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_invoice_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TriggerRunRequest.ProtoReflect.Descriptor instead.
+func (*TriggerRunRequest) Descriptor() ([]byte, []int) {
+	return file_invoice_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *TriggerRunRequest) GetMonth() string {
+	if x != nil {
+		return x.Month
+	}
+	return ""
+}
+
+func (x *TriggerRunRequest) GetDryRun() bool {
+	if x != nil {
+		return x.DryRun
+	}
+	return false
+}
+
+type RunEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Type  RunEvent_Type          `protobuf:"varint,1,opt,name=type,proto3,enum=appleinvoicepdf.v1.RunEvent_Type" json:"type,omitempty"`
+	// Set for TYPE_INVOICE.
+	Invoice *Invoice `protobuf:"bytes,2,opt,name=invoice,proto3" json:"invoice,omitempty"`
+	// Set for TYPE_FINISHED: "ok", "no_invoices", "failed" or
+	// "interrupted", with the exit status and error of a single invocation.
+	Status        string `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	ExitCode      int32  `protobuf:"varint,4,opt,name=exit_code,json=exitCode,proto3" json:"exit_code,omitempty"`
+	Error         string `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RunEvent) Reset() {
+	*x = RunEvent{}
+	mi := &file_invoice_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RunEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RunEvent) ProtoMessage() {}
+
+func (x *RunEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_invoice_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RunEvent.ProtoReflect.Descriptor instead.
+func (*RunEvent) Descriptor() ([]byte, []int) {
+	return file_invoice_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RunEvent) GetType() RunEvent_Type {
+	if x != nil {
+		return x.Type
+	}
+	return RunEvent_TYPE_UNSPECIFIED
+}
+
+func (x *RunEvent) GetInvoice() *Invoice {
+	if x != nil {
+		return x.Invoice
+	}
+	return nil
+}
+
+func (x *RunEvent) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *RunEvent) GetExitCode() int32 {
+	if x != nil {
+		return x.ExitCode
+	}
+	return 0
+}
+
+func (x *RunEvent) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// Invoice is a processed invoice. Status is "delivered", "queued" (saved
+// to the outbox), "failed" or, in run events, "skipped" (delivered
+// before).
+type Invoice struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	MessageId   string                 `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	OrderNumber string                 `protobuf:"bytes,2,opt,name=order_number,json=orderNumber,proto3" json:"order_number,omitempty"`
+	Subject     string                 `protobuf:"bytes,3,opt,name=subject,proto3" json:"subject,omitempty"`
+	Date        *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=date,proto3" json:"date,omitempty"`
+	Filename    string                 `protobuf:"bytes,5,opt,name=filename,proto3" json:"filename,omitempty"`
+	// Total with currency, e.g. "12,99 €"; empty if unknown.
+	Total         string                 `protobuf:"bytes,6,opt,name=total,proto3" json:"total,omitempty"`
+	Status        string                 `protobuf:"bytes,7,opt,name=status,proto3" json:"status,omitempty"`
+	Error         string                 `protobuf:"bytes,8,opt,name=error,proto3" json:"error,omitempty"`
+	Updated       *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=updated,proto3" json:"updated,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Invoice) Reset() {
+	*x = Invoice{}
+	mi := &file_invoice_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Invoice) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Invoice) ProtoMessage() {}
+
+func (x *Invoice) ProtoReflect() protoreflect.Message {
+	mi := &file_invoice_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Invoice.ProtoReflect.Descriptor instead.
+func (*Invoice) Descriptor() ([]byte, []int) {
+	return file_invoice_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Invoice) GetMessageId() string {
+	if x != nil {
+		return x.MessageId
+	}
+	return ""
+}
+
+func (x *Invoice) GetOrderNumber() string {
+	if x != nil {
+		return x.OrderNumber
+	}
+	return ""
+}
+
+func (x *Invoice) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
+
+func (x *Invoice) GetDate() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Date
+	}
+	return nil
+}
+
+func (x *Invoice) GetFilename() string {
+	if x != nil {
+		return x.Filename
+	}
+	return ""
+}
+
+func (x *Invoice) GetTotal() string {
+	if x != nil {
+		return x.Total
+	}
+	return ""
+}
+
+func (x *Invoice) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Invoice) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *Invoice) GetUpdated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Updated
+	}
+	return nil
+}
+
+type ListInvoicesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Only invoices dated in this month (YYYY-MM); empty means all.
+	Month string `protobuf:"bytes,1,opt,name=month,proto3" json:"month,omitempty"`
+	// Only invoices with this status; empty means all.
+	Status        string `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListInvoicesRequest) Reset() {
+	*x = ListInvoicesRequest{}
+	mi := &file_invoice_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListInvoicesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListInvoicesRequest) ProtoMessage() {}
+
+func (x *ListInvoicesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_invoice_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListInvoicesRequest.ProtoReflect.Descriptor instead.
+func (*ListInvoicesRequest) Descriptor() ([]byte, []int) {
+	return file_invoice_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListInvoicesRequest) GetMonth() string {
+	if x != nil {
+		return x.Month
+	}
+	return ""
+}
+
+func (x *ListInvoicesRequest) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+type ListInvoicesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Invoices      []*Invoice             `protobuf:"bytes,1,rep,name=invoices,proto3" json:"invoices,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListInvoicesResponse) Reset() {
+	*x = ListInvoicesResponse{}
+	mi := &file_invoice_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListInvoicesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListInvoicesResponse) ProtoMessage() {}
+
+func (x *ListInvoicesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_invoice_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListInvoicesResponse.ProtoReflect.Descriptor instead.
+func (*ListInvoicesResponse) Descriptor() ([]byte, []int) {
+	return file_invoice_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListInvoicesResponse) GetInvoices() []*Invoice {
+	if x != nil {
+		return x.Invoices
+	}
+	return nil
+}
+
+type GetPDFRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The invoice by Message-Id or order number.
+	MessageId   string `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	OrderNumber string `protobuf:"bytes,2,opt,name=order_number,json=orderNumber,proto3" json:"order_number,omitempty"`
+	// Month the invoice is in (YYYY-MM); defaults to its date in the state
+	// file.
+	Month         string `protobuf:"bytes,3,opt,name=month,proto3" json:"month,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPDFRequest) Reset() {
+	*x = GetPDFRequest{}
+	mi := &file_invoice_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPDFRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPDFRequest) ProtoMessage() {}
+
+func (x *GetPDFRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_invoice_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPDFRequest.ProtoReflect.Descriptor instead.
+func (*GetPDFRequest) Descriptor() ([]byte, []int) {
+	return file_invoice_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetPDFRequest) GetMessageId() string {
+	if x != nil {
+		return x.MessageId
+	}
+	return ""
+}
+
+func (x *GetPDFRequest) GetOrderNumber() string {
+	if x != nil {
+		return x.OrderNumber
+	}
+	return ""
+}
+
+func (x *GetPDFRequest) GetMonth() string {
+	if x != nil {
+		return x.Month
+	}
+	return ""
+}
+
+type PDFChunk struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Set in the first chunk only.
+	Filename      string `protobuf:"bytes,1,opt,name=filename,proto3" json:"filename,omitempty"`
+	Data          []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PDFChunk) Reset() {
+	*x = PDFChunk{}
+	mi := &file_invoice_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PDFChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PDFChunk) ProtoMessage() {}
+
+func (x *PDFChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_invoice_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PDFChunk.ProtoReflect.Descriptor instead.
+func (*PDFChunk) Descriptor() ([]byte, []int) {
+	return file_invoice_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *PDFChunk) GetFilename() string {
+	if x != nil {
+		return x.Filename
+	}
+	return ""
+}
+
+func (x *PDFChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+var File_invoice_proto protoreflect.FileDescriptor
+
+const file_invoice_proto_rawDesc = "" +
+	"\n" +
+	"\rinvoice.proto\x12\x12appleinvoicepdf.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"B\n" +
+	"\x11TriggerRunRequest\x12\x14\n" +
+	"\x05month\x18\x01 \x01(\tR\x05month\x12\x17\n" +
+	"\adry_run\x18\x02 \x01(\bR\x06dryRun\"\xa9\x02\n" +
+	"\bRunEvent\x125\n" +
+	"\x04type\x18\x01 \x01(\x0e2!.appleinvoicepdf.v1.RunEvent.TypeR\x04type\x125\n" +
+	"\ainvoice\x18\x02 \x01(\v2\x1b.appleinvoicepdf.v1.InvoiceR\ainvoice\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12\x1b\n" +
+	"\texit_code\x18\x04 \x01(\x05R\bexitCode\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\"d\n" +
+	"\x04Type\x12\x14\n" +
+	"\x10TYPE_UNSPECIFIED\x10\x00\x12\x0f\n" +
+	"\vTYPE_QUEUED\x10\x01\x12\x10\n" +
+	"\fTYPE_STARTED\x10\x02\x12\x10\n" +
+	"\fTYPE_INVOICE\x10\x03\x12\x11\n" +
+	"\rTYPE_FINISHED\x10\x04\"\xab\x02\n" +
+	"\aInvoice\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x01 \x01(\tR\tmessageId\x12!\n" +
+	"\forder_number\x18\x02 \x01(\tR\vorderNumber\x12\x18\n" +
+	"\asubject\x18\x03 \x01(\tR\asubject\x12.\n" +
+	"\x04date\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\x04date\x12\x1a\n" +
+	"\bfilename\x18\x05 \x01(\tR\bfilename\x12\x14\n" +
+	"\x05total\x18\x06 \x01(\tR\x05total\x12\x16\n" +
+	"\x06status\x18\a \x01(\tR\x06status\x12\x14\n" +
+	"\x05error\x18\b \x01(\tR\x05error\x124\n" +
+	"\aupdated\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\aupdated\"C\n" +
+	"\x13ListInvoicesRequest\x12\x14\n" +
+	"\x05month\x18\x01 \x01(\tR\x05month\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\"O\n" +
+	"\x14ListInvoicesResponse\x127\n" +
+	"\binvoices\x18\x01 \x03(\v2\x1b.appleinvoicepdf.v1.InvoiceR\binvoices\"g\n" +
+	"\rGetPDFRequest\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x01 \x01(\tR\tmessageId\x12!\n" +
+	"\forder_number\x18\x02 \x01(\tR\vorderNumber\x12\x14\n" +
+	"\x05month\x18\x03 \x01(\tR\x05month\":\n" +
+	"\bPDFChunk\x12\x1a\n" +
+	"\bfilename\x18\x01 \x01(\tR\bfilename\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data2\x95\x02\n" +
+	"\x0eInvoiceService\x12S\n" +
+	"\n" +
+	"TriggerRun\x12%.appleinvoicepdf.v1.TriggerRunRequest\x1a\x1c.appleinvoicepdf.v1.RunEvent0\x01\x12a\n" +
+	"\fListInvoices\x12'.appleinvoicepdf.v1.ListInvoicesRequest\x1a(.appleinvoicepdf.v1.ListInvoicesResponse\x12K\n" +
+	"\x06GetPDF\x12!.appleinvoicepdf.v1.GetPDFRequest\x1a\x1c.appleinvoicepdf.v1.PDFChunk0\x01B5Z3github.com/rummeyer/apple-invoice-pdf/pkg/invoicepbb\x06proto3"
+
+var (
+	file_invoice_proto_rawDescOnce sync.Once
+	file_invoice_proto_rawDescData []byte
+)
+
+func file_invoice_proto_rawDescGZIP() []byte {
+	file_invoice_proto_rawDescOnce.Do(func() {
+		file_invoice_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_invoice_proto_rawDesc), len(file_invoice_proto_rawDesc)))
+	})
+	return file_invoice_proto_rawDescData
+}
+
+var file_invoice_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_invoice_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_invoice_proto_goTypes = []any{
+	(RunEvent_Type)(0),            // 0: appleinvoicepdf.v1.RunEvent.Type
+	(*TriggerRunRequest)(nil),     // 1: appleinvoicepdf.v1.TriggerRunRequest
+	(*RunEvent)(nil),              // 2: appleinvoicepdf.v1.RunEvent
+	(*Invoice)(nil),               // 3: appleinvoicepdf.v1.Invoice
+	(*ListInvoicesRequest)(nil),   // 4: appleinvoicepdf.v1.ListInvoicesRequest
+	(*ListInvoicesResponse)(nil),  // 5: appleinvoicepdf.v1.ListInvoicesResponse
+	(*GetPDFRequest)(nil),         // 6: appleinvoicepdf.v1.GetPDFRequest
+	(*PDFChunk)(nil),              // 7: appleinvoicepdf.v1.PDFChunk
+	(*timestamppb.Timestamp)(nil), // 8: google.protobuf.Timestamp
+}
+var file_invoice_proto_depIdxs = []int32{
+	0, // 0: appleinvoicepdf.v1.RunEvent.type:type_name -> appleinvoicepdf.v1.RunEvent.Type
+	3, // 1: appleinvoicepdf.v1.RunEvent.invoice:type_name -> appleinvoicepdf.v1.Invoice
+	8, // 2: appleinvoicepdf.v1.Invoice.date:type_name -> google.protobuf.Timestamp
+	8, // 3: appleinvoicepdf.v1.Invoice.updated:type_name -> google.protobuf.Timestamp
+	3, // 4: appleinvoicepdf.v1.ListInvoicesResponse.invoices:type_name -> appleinvoicepdf.v1.Invoice
+	1, // 5: appleinvoicepdf.v1.InvoiceService.TriggerRun:input_type -> appleinvoicepdf.v1.TriggerRunRequest
+	4, // 6: appleinvoicepdf.v1.InvoiceService.ListInvoices:input_type -> appleinvoicepdf.v1.ListInvoicesRequest
+	6, // 7: appleinvoicepdf.v1.InvoiceService.GetPDF:input_type -> appleinvoicepdf.v1.GetPDFRequest
+	2, // 8: appleinvoicepdf.v1.InvoiceService.TriggerRun:output_type -> appleinvoicepdf.v1.RunEvent
+	5, // 9: appleinvoicepdf.v1.InvoiceService.ListInvoices:output_type -> appleinvoicepdf.v1.ListInvoicesResponse
+	7, // 10: appleinvoicepdf.v1.InvoiceService.GetPDF:output_type -> appleinvoicepdf.v1.PDFChunk
+	8, // [8:11] is the sub-list for method output_type
+	5, // [5:8] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_invoice_proto_init() }
+func file_invoice_proto_init() {
+	if File_invoice_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_invoice_proto_rawDesc), len(file_invoice_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_invoice_proto_goTypes,
+		DependencyIndexes: file_invoice_proto_depIdxs,
+		EnumInfos:         file_invoice_proto_enumTypes,
+		MessageInfos:      file_invoice_proto_msgTypes,
+	}.Build()
+	File_invoice_proto = out.File
+	file_invoice_proto_goTypes = nil
+	file_invoice_proto_depIdxs = nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/deliver"
+	"github.com/rummeyer/apple-invoice-pdf/internal/imapsource"
+)
+
+// PluginConfig configures an external executable acting as a source or a
+// sink. It is started once per fetch or delivery, reads one JSON request
+// from stdin and writes one JSON response to stdout; its stderr is logged.
+type PluginConfig struct {
+	// Name identifies the plugin in log output and pipeline.source.
+	Name string `yaml:"name"`
+	// Type is "source" or "sink".
+	Type string `yaml:"type"`
+	// Command is the executable with optional arguments, e.g.
+	// "/usr/local/bin/dms-upload --tenant home".
+	Command string `yaml:"command"`
+	// Env is added to the environment of the process.
+	Env map[string]string `yaml:"env"`
+	// Timeout limits a single invocation (default 5m).
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// validatePlugins checks names, types and commands of the plugins.
+func validatePlugins(plugins []PluginConfig) error {
+	seen := make(map[string]bool)
+	for i, p := range plugins {
+		if p.Name == "" {
+			return fmt.Errorf("plugins[%d]: name is required", i)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("plugins: duplicate name %q", p.Name)
+		}
+		seen[p.Name] = true
+		if p.Type != "source" && p.Type != "sink" {
+			return fmt.Errorf("plugin %s: want type source or sink, got %q", p.Name, p.Type)
+		}
+		if len(strings.Fields(p.Command)) == 0 {
+			return fmt.Errorf("plugin %s: command is required", p.Name)
+		}
+	}
+	return nil
+}
+
+// pluginFile is an attachment in a deliver request. Invoice is the index
+// in pluginRequest.Invoices, or -1 for summaries like the cover page.
+type pluginFile struct {
+	Filename string `json:"filename"`
+	Invoice  int    `json:"invoice"`
+	Data     []byte `json:"data"`
+}
+
+// pluginRequest is written to the plugin's stdin: "fetch" with Month for
+// sources, "deliver" with Invoices and Files for sinks.
+type pluginRequest struct {
+	Type     string            `json:"type"`
+	Month    string            `json:"month,omitempty"`
+	Invoices []InvoiceMetadata `json:"invoices,omitempty"`
+	Files    []pluginFile      `json:"files,omitempty"`
+}
+
+// pluginEmail is an invoice email returned by a source plugin. Raw is the
+// RFC822 source; HTML is extracted from it if not given.
+type pluginEmail struct {
+	UID       uint32    `json:"uid"`
+	Subject   string    `json:"subject"`
+	Date      time.Time `json:"date"`
+	MessageID string    `json:"message_id"`
+	From      string    `json:"from"`
+	HTML      string    `json:"html"`
+	Raw       []byte    `json:"raw"`
+}
+
+// pluginResponse is read from the plugin's stdout. A set Error fails the
+// call; Permanent marks it as not worth retrying.
+type pluginResponse struct {
+	Error     string        `json:"error"`
+	Permanent bool          `json:"permanent"`
+	Invoices  []pluginEmail `json:"invoices"`
+}
+
+// plugin runs the executable of a PluginConfig.
+type plugin struct {
+	cfg PluginConfig
+}
+
+func (p *plugin) Name() string { return p.cfg.Name }
+
+// call runs the plugin with req and decodes its response. Empty output is
+// a valid response.
+func (p *plugin) call(ctx context.Context, req pluginRequest) (*pluginResponse, error) {
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("encoding plugin request: %w", err)
+	}
+	timeout := p.cfg.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	args := strings.Fields(p.cfg.Command)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = os.Environ()
+	for k, v := range p.cfg.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	slog.Debug("Running plugin", "plugin", p.cfg.Name, "request", req.Type)
+	runErr := cmd.Run()
+	for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+		if line != "" {
+			slog.Info("Plugin output", "plugin", p.cfg.Name, "line", line)
+		}
+	}
+
+	var resp pluginResponse
+	if out := bytes.TrimSpace(stdout.Bytes()); len(out) > 0 {
+		if err := json.Unmarshal(out, &resp); err != nil && runErr == nil {
+			return nil, fmt.Errorf("plugin %s: decoding response: %w", p.cfg.Name, err)
+		}
+	}
+	switch {
+	case resp.Error != "" && resp.Permanent:
+		return nil, deliver.Permanent(fmt.Errorf("plugin %s: %s", p.cfg.Name, resp.Error))
+	case resp.Error != "":
+		return nil, fmt.Errorf("plugin %s: %s", p.cfg.Name, resp.Error)
+	case runErr != nil:
+		return nil, fmt.Errorf("plugin %s: %w", p.cfg.Name, runErr)
+	}
+	return &resp, nil
+}
+
+// pluginSink delivers by sending all files to a sink plugin.
+type pluginSink struct {
+	plugin
+}
+
+func (s *pluginSink) Deliver(ctx context.Context, d *Delivery) error {
+	req := pluginRequest{Type: "deliver", Invoices: []InvoiceMetadata{}, Files: []pluginFile{}}
+	for _, inv := range d.Invoices {
+		req.Invoices = append(req.Invoices, inv.Metadata())
+	}
+	for _, att := range d.Attachments {
+		index := -1
+		for i := range d.Invoices {
+			if att.Invoice == &d.Invoices[i] {
+				index = i
+			}
+		}
+		data, err := att.content()
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", att.Filename, err)
+		}
+		req.Files = append(req.Files, pluginFile{Filename: att.Filename, Invoice: index, Data: data})
+	}
+	if _, err := s.call(ctx, req); err != nil {
+		return err
+	}
+	slog.Info("Delivered via plugin", "plugin", s.cfg.Name, "files", len(req.Files))
+	return nil
+}
+
+// pluginSource fetches invoice emails from a source plugin.
+type pluginSource struct {
+	plugin
+}
+
+func (s *pluginSource) Fetch(ctx context.Context, month time.Time) (invoices []InvoiceEmail, err error) {
+	ctx, span := startSpan(ctx, "fetch", "month", month.Format("2006-01"), "plugin", s.cfg.Name)
+	defer func() {
+		span.set("invoices", len(invoices))
+		span.finish(err)
+	}()
+
+	resp, err := s.call(ctx, pluginRequest{Type: "fetch", Month: month.Format("2006-01")})
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range resp.Invoices {
+		html := e.HTML
+		if html == "" && len(e.Raw) > 0 {
+			if html, err = imapsource.HTMLBody(bytes.NewReader(e.Raw)); err != nil {
+				slog.Warn("Extracting HTML failed", "plugin", s.cfg.Name, "index", i, "err", err)
+				continue
+			}
+		}
+		if html == "" {
+			slog.Warn("No body", "plugin", s.cfg.Name, "index", i)
+			continue
+		}
+		invoices = append(invoices, InvoiceEmail{
+			UID:       e.UID,
+			Subject:   e.Subject,
+			Date:      e.Date,
+			MessageID: e.MessageID,
+			From:      e.From,
+			HTMLBody:  html,
+			Raw:       e.Raw,
+		})
+	}
+	slog.Info("Fetched invoices from plugin", "plugin", s.cfg.Name, "invoices", len(invoices))
+	runReportFrom(ctx).scanned(len(resp.Invoices), len(invoices))
+	return invoices, nil
+}
+
+// sourcePlugin returns the source plugin called name, if configured.
+func sourcePlugin(cfg *Config, name string) (Source, error) {
+	for _, p := range cfg.Plugins {
+		if p.Name != name {
+			continue
+		}
+		if p.Type != "source" {
+			return nil, errors.New("plugin " + name + " is not a source")
+		}
+		return &pluginSource{plugin{cfg: p}}, nil
+	}
+	return nil, nil
+}
+
+// pluginSinks returns a sink for every sink plugin.
+func pluginSinks(cfg *Config) []Sink {
+	var sinks []Sink
+	for _, p := range cfg.Plugins {
+		if p.Type == "sink" {
+			sinks = append(sinks, &pluginSink{plugin{cfg: p}})
+		}
+	}
+	return sinks
+}
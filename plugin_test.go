@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/deliver"
+)
+
+// writePlugin writes a shell script plugin and returns its path.
+func writePlugin(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "plugin.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPluginSink_Deliver(t *testing.T) {
+	dir := t.TempDir()
+	path := writePlugin(t, `cat > "$OUT_DIR/request.json"; echo "uploading" >&2`)
+	sink := &pluginSink{plugin{cfg: PluginConfig{Name: "dms", Type: "sink", Command: path, Env: map[string]string{"OUT_DIR": dir}}}}
+
+	d := &Delivery{Invoices: []ProcessedInvoice{{OrderNumber: "MX1", Filename: "a"}}}
+	d.Attachments = []PDFAttachment{{Filename: "a.pdf", Data: []byte("%PDF"), Invoice: &d.Invoices[0]}, {Filename: "manifest.json", Data: []byte("{}")}}
+	if err := sink.Deliver(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "request.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req pluginRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Type != "deliver" || len(req.Invoices) != 1 || req.Invoices[0].OrderNumber != "MX1" {
+		t.Errorf("request = %+v", req)
+	}
+	if len(req.Files) != 2 || req.Files[0].Invoice != 0 || string(req.Files[0].Data) != "%PDF" || req.Files[1].Invoice != -1 {
+		t.Errorf("files = %+v", req.Files)
+	}
+}
+
+func TestPluginSink_Errors(t *testing.T) {
+	for _, tc := range []struct {
+		script    string
+		want      string
+		permanent bool
+	}{
+		{`echo '{"error": "quota exceeded"}'`, "plugin dms: quota exceeded", false},
+		{`echo '{"error": "unknown folder", "permanent": true}'; exit 1`, "plugin dms: unknown folder", true},
+		{`exit 3`, "plugin dms: exit status 3", false},
+		{`echo 'not json'`, "plugin dms: decoding response", false},
+	} {
+		sink := &pluginSink{plugin{cfg: PluginConfig{Name: "dms", Command: writePlugin(t, tc.script)}}}
+		err := sink.Deliver(context.Background(), &Delivery{})
+		if err == nil || !strings.Contains(err.Error(), tc.want) || deliver.IsPermanent(err) != tc.permanent {
+			t.Errorf("%s: err = %v, want %q (permanent %v)", tc.script, err, tc.want, tc.permanent)
+		}
+	}
+}
+
+func TestPluginSource_Fetch(t *testing.T) {
+	raw := "Subject: Deine Rechnung von Apple\r\nContent-Type: text/html\r\n\r\n<p>Bestellnummer: MX2</p>\r\n"
+	resp, _ := json.Marshal(pluginResponse{Invoices: []pluginEmail{
+		{UID: 1, Subject: "Deine Rechnung von Apple", Date: time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC), HTML: "<p>Bestellnummer: MX1</p>"},
+		{UID: 2, Subject: "Deine Rechnung von Apple", Raw: []byte(raw)},
+		{UID: 3, Subject: "empty"},
+	}})
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "resp.json"), resp, 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := writePlugin(t, `grep -q '"month":"2024-05"' && cat "$DIR/resp.json"`)
+	cfg := &Config{Plugins: []PluginConfig{{Name: "archive", Type: "source", Command: path, Env: map[string]string{"DIR": dir}}}}
+	cfg.Pipeline.Source = "archive"
+
+	p, err := buildProcessing(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoices, err := p.Source.Fetch(context.Background(), time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invoices) != 2 || invoices[0].UID != 1 || !strings.Contains(invoices[1].HTMLBody, "MX2") {
+		t.Errorf("invoices = %+v", invoices)
+	}
+}
+
+func TestValidatePlugins(t *testing.T) {
+	for _, tc := range []struct {
+		plugins []PluginConfig
+		want    string
+	}{
+		{[]PluginConfig{{Type: "sink", Command: "x"}}, "name is required"},
+		{[]PluginConfig{{Name: "a", Type: "sink", Command: "x"}, {Name: "a", Type: "sink", Command: "y"}}, "duplicate name"},
+		{[]PluginConfig{{Name: "a", Type: "transformer", Command: "x"}}, "want type source or sink"},
+		{[]PluginConfig{{Name: "a", Type: "sink"}}, "command is required"},
+	} {
+		if err := validatePlugins(tc.plugins); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: err = %v, want %q", tc.plugins, err, tc.want)
+		}
+	}
+	if _, err := sourcePlugin(&Config{Plugins: []PluginConfig{{Name: "dms", Type: "sink"}}}, "dms"); err == nil {
+		t.Error("want error for a sink plugin used as source")
+	}
+}
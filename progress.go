@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+)
+
+// SinkProgress records the parts of a delivery a sink already completed,
+// so that a retry, or resuming the delivery from the outbox, skips them
+// instead of creating duplicates at the destination. Sinks that deliver
+// invoice by invoice embed it.
+type SinkProgress struct {
+	// Done holds the keys of the invoices and messages that went out.
+	Done map[string]bool `json:"done,omitempty"`
+	// Created maps keys to the IDs of objects created by the first step of
+	// a two-step upload, like a voucher its PDF still has to be attached to.
+	Created map[string]string `json:"created,omitempty"`
+}
+
+// progress returns the sink's record.
+func (p *SinkProgress) progress() *SinkProgress { return p }
+
+// isDone reports whether the part identified by key went out.
+func (p *SinkProgress) isDone(key string) bool {
+	return p.Done[key]
+}
+
+// markDone records that the part identified by key went out.
+func (p *SinkProgress) markDone(key string) {
+	if p.Done == nil {
+		p.Done = map[string]bool{}
+	}
+	p.Done[key] = true
+}
+
+// created returns the ID recorded for key by setCreated.
+func (p *SinkProgress) created(key string) (string, bool) {
+	id, ok := p.Created[key]
+	return id, ok
+}
+
+// setCreated records the ID of the object created for key.
+func (p *SinkProgress) setCreated(key, id string) {
+	if p.Created == nil {
+		p.Created = map[string]string{}
+	}
+	p.Created[key] = id
+}
+
+// progressTracker is implemented by sinks embedding SinkProgress.
+type progressTracker interface {
+	progress() *SinkProgress
+}
+
+// invoiceKey identifies an invoice across retries and resumed deliveries.
+func invoiceKey(inv ProcessedInvoice) string {
+	if inv.Email.MessageID != "" {
+		return inv.Email.MessageID
+	}
+	return inv.Filename
+}
+
+// summaryKey identifies the summary message of a delivery by its invoices.
+func summaryKey(d *Delivery) string {
+	h := sha256.New()
+	for _, inv := range d.Invoices {
+		io.WriteString(h, invoiceKey(inv)+"\n")
+	}
+	return "summary:" + hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// QuickBooksConfig configures expenses in QuickBooks Online.
+type QuickBooksConfig struct {
+	OAuthConfig `yaml:",inline"`
+	RealmID     string `yaml:"realm_id"`
+	// PaymentAccountID is the bank or credit card account the expense is
+	// paid from, ExpenseAccountID the expense category account.
+	PaymentAccountID string `yaml:"payment_account_id"`
+	ExpenseAccountID string `yaml:"expense_account_id"`
+	PaymentType      string `yaml:"payment_type"`
+	VendorID         string `yaml:"vendor_id"`
+	APIURL           string `yaml:"api_url"`
+	TokenURL         string `yaml:"token_url"`
+}
+
+// quickBooksSink creates a Purchase (expense) per invoice and attaches the PDF.
+type quickBooksSink struct {
+	cfg    QuickBooksConfig
+	oauth  *oauthRefresher
+	client *http.Client
+	SinkProgress
+}
+
+// newQuickBooksSink applies defaults and checks required settings.
+func newQuickBooksSink(cfg QuickBooksConfig) (*quickBooksSink, error) {
+	if err := cfg.OAuthConfig.validate(); err != nil {
+		return nil, fmt.Errorf("quickbooks: %w", err)
+	}
+	if cfg.RealmID == "" || cfg.PaymentAccountID == "" || cfg.ExpenseAccountID == "" {
+		return nil, fmt.Errorf("quickbooks: realm_id, payment_account_id and expense_account_id are required")
+	}
+	if cfg.PaymentType == "" {
+		cfg.PaymentType = "CreditCard"
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = "https://quickbooks.api.intuit.com"
+	}
+	if cfg.TokenURL == "" {
+		cfg.TokenURL = "https://oauth.platform.intuit.com/oauth2/v1/tokens/bearer"
+	}
+	cfg.APIURL = strings.TrimRight(cfg.APIURL, "/")
+	client := newAPIClient()
+	return &quickBooksSink{
+		cfg:    cfg,
+		oauth:  &oauthRefresher{cfg: cfg.OAuthConfig, tokenURL: cfg.TokenURL, client: client},
+		client: client,
+	}, nil
+}
+
+func (s *quickBooksSink) Name() string { return "quickbooks" }
+
+func (s *quickBooksSink) Deliver(ctx context.Context, d *Delivery) error {
+	token, err := s.oauth.accessToken(ctx)
+	if err != nil {
+		return fmt.Errorf("quickbooks: %w", err)
+	}
+	for _, inv := range d.Invoices {
+		key := invoiceKey(inv)
+		if s.isDone(key) {
+			continue
+		}
+		if inv.Total.IsZero() {
+			slog.Info("Skipping invoice for QuickBooks: no amount found", "file", inv.Filename+".pdf")
+			continue
+		}
+		id, ok := s.created(key)
+		if !ok {
+			if id, err = s.createPurchase(ctx, token, inv); err != nil {
+				return fmt.Errorf("quickbooks: creating expense for %s: %w", inv.Filename, err)
+			}
+			s.setCreated(key, id)
+		}
+		if err := s.attach(ctx, token, id, inv); err != nil {
+			return fmt.Errorf("quickbooks: attaching %s: %w", inv.Filename, err)
+		}
+		s.markDone(key)
+		slog.Info("Created QuickBooks expense", "id", id, "file", inv.Filename+".pdf")
+	}
+	return nil
+}
+
+// quickBooksRef references another QuickBooks entity.
+type quickBooksRef struct {
+	Value string `json:"value"`
+	Type  string `json:"type,omitempty"`
+}
+
+// newQuickBooksPurchase builds the Purchase entity for an invoice.
+func newQuickBooksPurchase(cfg QuickBooksConfig, inv ProcessedInvoice) map[string]any {
+	description := strings.TrimSpace(inv.vendor().Title + " " + inv.OrderNumber)
+	purchase := map[string]any{
+		"PaymentType": cfg.PaymentType,
+		"AccountRef":  quickBooksRef{Value: cfg.PaymentAccountID},
+		"TxnDate":     inv.Email.Date.Format("2006-01-02"),
+		"PrivateNote": description,
+		"CurrencyRef": quickBooksRef{Value: inv.Total.Currency},
+		"Line": []map[string]any{{
+			"Amount":      inv.Total.Decimal(),
+			"DetailType":  "AccountBasedExpenseLineDetail",
+			"Description": description,
+			"AccountBasedExpenseLineDetail": map[string]any{
+				"AccountRef": quickBooksRef{Value: cfg.ExpenseAccountID},
+			},
+		}},
+	}
+	// DocNumber is limited to 21 characters
+	if number := inv.InvoiceNumber; number != "" && len(number) <= 21 {
+		purchase["DocNumber"] = number
+	}
+	if cfg.VendorID != "" {
+		purchase["EntityRef"] = quickBooksRef{Value: cfg.VendorID, Type: "Vendor"}
+	}
+	return purchase
+}
+
+// createPurchase creates the expense and returns its ID.
+func (s *quickBooksSink) createPurchase(ctx context.Context, token string, inv ProcessedInvoice) (string, error) {
+	var resp struct {
+		Purchase struct {
+			ID string `json:"Id"`
+		} `json:"Purchase"`
+	}
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	if err := doJSON(ctx, s.client, http.MethodPost, s.companyURL("/purchase"), header, newQuickBooksPurchase(s.cfg, inv), &resp); err != nil {
+		return "", err
+	}
+	return resp.Purchase.ID, nil
+}
+
+// attach uploads the PDF as an Attachable linked to the purchase.
+func (s *quickBooksSink) attach(ctx context.Context, token, purchaseID string, inv ProcessedInvoice) error {
+	filename := inv.Filename + ".pdf"
+	meta, err := json.Marshal(map[string]any{
+		"AttachableRef": []map[string]any{{"EntityRef": quickBooksRef{Value: purchaseID, Type: "Purchase"}}},
+		"FileName":      filename,
+		"ContentType":   "application/pdf",
+	})
+	if err != nil {
+		return err
+	}
+	data, err := inv.readPDF()
+	if err != nil {
+		return err
+	}
+	body, contentType, err := newMultipartBody(nil, []multipartFile{
+		{Field: "file_metadata_01", Filename: "attachment.json", ContentType: "application/json", Data: meta},
+		{Field: "file_content_01", Filename: filename, ContentType: "application/pdf", Data: data},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.companyURL("/upload"), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	return doRequest(s.client, req, nil)
+}
+
+func (s *quickBooksSink) companyURL(path string) string {
+	return s.cfg.APIURL + "/v3/company/" + s.cfg.RealmID + path + "?minorversion=73"
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuickBooksSink_Deliver(t *testing.T) {
+	var purchase map[string]any
+	var meta map[string]any
+	var file string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "refresh_token": "rt"})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer at" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v3/company/123/purchase":
+			json.NewDecoder(r.Body).Decode(&purchase)
+			json.NewEncoder(w).Encode(map[string]any{"Purchase": map[string]string{"Id": "77"}})
+		case "/v3/company/123/upload":
+			r.ParseMultipartForm(1 << 20)
+			m, _, _ := r.FormFile("file_metadata_01")
+			json.NewDecoder(m).Decode(&meta)
+			f, h, _ := r.FormFile("file_content_01")
+			data, _ := io.ReadAll(f)
+			file = h.Filename + ":" + string(data)
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	sink, err := newQuickBooksSink(QuickBooksConfig{
+		OAuthConfig:      OAuthConfig{ClientID: "id", ClientSecret: "secret", RefreshToken: "rt", TokenFile: filepath.Join(t.TempDir(), "token")},
+		RealmID:          "123",
+		PaymentAccountID: "41",
+		ExpenseAccountID: "7",
+		APIURL:           srv.URL,
+		TokenURL:         srv.URL + "/token",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	inv := testProcessedInvoice()
+	inv.Filename = "invoice"
+	inv.PDF = []byte("%PDF")
+	if err := sink.Deliver(context.Background(), &Delivery{Invoices: []ProcessedInvoice{inv}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if purchase["TxnDate"] != "2024-05-14" || purchase["PaymentType"] != "CreditCard" || purchase["DocNumber"] != "DE/2024/0815" {
+		t.Errorf("purchase = %v", purchase)
+	}
+	line := purchase["Line"].([]any)[0].(map[string]any)
+	if line["Amount"] != 9.99 || line["AccountBasedExpenseLineDetail"].(map[string]any)["AccountRef"].(map[string]any)["value"] != "7" {
+		t.Errorf("line = %v", line)
+	}
+	ref := meta["AttachableRef"].([]any)[0].(map[string]any)["EntityRef"].(map[string]any)
+	if ref["value"] != "77" || ref["type"] != "Purchase" {
+		t.Errorf("attachable ref = %v", ref)
+	}
+	if file != "invoice.pdf:%PDF" {
+		t.Errorf("file = %q", file)
+	}
+}
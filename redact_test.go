@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	registerSecret("hunter2-imap")
+	for _, tc := range []struct{ in, want string }{
+		{"login failed for hunter2-imap", "login failed for [REDACTED]"},
+		{"dial imaps://me%40icloud.com:pw@imap.mail.me.com:993", "dial imaps://[REDACTED]@imap.mail.me.com:993"},
+		{"https://abc123@o1.ingest.sentry.io/42", "https://[REDACTED]@o1.ingest.sentry.io/42"},
+		{`Get "https://api.example.com/x?token=s3cr3t&page=2"`, `Get "https://api.example.com/x?token=[REDACTED]&page=2"`},
+		{"PUT /bucket/a.pdf?X-Amz-Signature=deadbeef", "PUT /bucket/a.pdf?X-Amz-Signature=[REDACTED]"},
+		{"Authorization: Bearer eyJhbGciOi.x-y_z", "Authorization: Bearer [REDACTED]"},
+		{"sent to rechnungen@example.com", "sent to rechnungen@example.com"},
+	} {
+		if got := redact(tc.in); got != tc.want {
+			t.Errorf("redact(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestRegisterConfigSecrets(t *testing.T) {
+	cfg := &Config{}
+	cfg.Pass = "imap-app-password"
+	cfg.Sentry.DSN = "https://key@sentry.example.com/1"
+	cfg.Tracing.Headers = map[string]string{"Authorization": "ApiKey tracing-secret", "X-Scope": "tenant-one"}
+	registerConfigSecrets(cfg)
+
+	out := redact("imap-app-password tracing-secret tenant-one")
+	if out != "[REDACTED] [REDACTED] tenant-one" {
+		t.Errorf("redact = %q", out)
+	}
+}
+
+func TestSecretKey(t *testing.T) {
+	for key, want := range map[string]bool{
+		"pass": true, "zip_password": true, "client_secret": true, "api_key": true,
+		"access_token": true, "dsn": true, "Authorization": true,
+		"token_url": false, "key_file": false, "user": false, "token_file": false,
+	} {
+		if got := secretKey(key); got != want {
+			t.Errorf("secretKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestRedactHandler(t *testing.T) {
+	registerSecret("debug-level-token")
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "json", slog.LevelDebug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.With("url", "https://u:p@dav.example.com/").WithGroup("smtp").Debug("Using debug-level-token",
+		"err", fmt.Errorf("auth: %w", errors.New("rejected debug-level-token")),
+		"uid", 42,
+		slog.Group("req", "query", "?api_key=zq9wv7"))
+
+	out := buf.String()
+	for _, leak := range []string{"debug-level-token", "u:p@", "zq9wv7"} {
+		if strings.Contains(out, leak) {
+			t.Errorf("output contains %q: %s", leak, out)
+		}
+	}
+	if !strings.Contains(out, `"uid":42`) {
+		t.Errorf("output lost unrelated fields: %s", out)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"gopkg.in/gomail.v2"
+)
+
+// ReportConfig configures the failure report email and the JSON run
+// report.
+type ReportConfig struct {
+	// To receives a plain-text report whenever an invoice could not be
+	// converted or delivered; empty disables the report.
+	To string `yaml:"to"`
+	// File is replaced with a RunReport after every run; empty disables it.
+	File string `yaml:"file"`
+}
+
+// InvoiceFailure is an invoice email that could not be converted.
+type InvoiceFailure struct {
+	Email InvoiceEmail
+	Err   error
+}
+
+// reportFailures sends the failure report for a run if report.to is set
+// and anything failed. Sending errors are logged, since the run already
+// failed and reports its own exit status.
+func reportFailures(ctx context.Context, cfg *Config, failures []InvoiceFailure, processed []ProcessedInvoice, deliveryErr error) {
+	if cfg.Report.To == "" || (len(failures) == 0 && deliveryErr == nil) {
+		return
+	}
+	// Failed conversions have no vendor yet, so name the configured one
+	title := cfg.vendorTitle()
+	if len(failures) == 0 {
+		title = vendorTitle(processed)
+	}
+	subject, body := failureReport(title, failures, processed, deliveryErr)
+	m := gomail.NewMessage()
+	m.SetHeader("From", cfg.Email.From)
+	m.SetHeader("To", cfg.Report.To)
+	m.SetHeader("Subject", subject)
+	m.SetBody("text/plain", body)
+	t, err := newMailTransport(ctx, cfg)
+	if err == nil {
+		err = t.DialAndSend(ctx, m)
+	}
+	if err != nil {
+		slog.Error("Sending failure report failed", "to", cfg.Report.To, "err", err)
+		return
+	}
+	slog.Info("Failure report sent", "to", cfg.Report.To)
+}
+
+// failureReport returns the subject and body of the report listing the
+// invoices that failed to convert and, if deliveryErr is set, the invoices
+// that were not delivered along with the reasons per destination. title
+// names the vendor of the invoices, empty for several.
+func failureReport(title string, failures []InvoiceFailure, processed []ProcessedInvoice, deliveryErr error) (string, string) {
+	failed := len(failures)
+	if deliveryErr != nil {
+		failed += len(processed)
+	}
+	noun := invoiceNoun(title)
+	subject := fmt.Sprintf("Fehler bei %d %s(en)", failed, noun)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Beim Verarbeiten der %sen sind Fehler aufgetreten.\n", noun)
+	if len(failures) > 0 {
+		fmt.Fprintf(&b, "\nNicht in PDF umgewandelt (%d):\n", len(failures))
+		for _, f := range failures {
+			fmt.Fprintf(&b, "- %s\n  %s\n", reportInvoice(f.Email), redactError(f.Err))
+		}
+	}
+	if deliveryErr != nil {
+		fmt.Fprintf(&b, "\nNicht zugestellt (%d):\n", len(processed))
+		for _, p := range processed {
+			fmt.Fprintf(&b, "- %s: %s.pdf\n", reportInvoice(p.Email), p.Filename)
+		}
+		b.WriteString("\nFehler:\n")
+		var de *DeliveryError
+		if errors.As(deliveryErr, &de) {
+			for _, res := range de.Results {
+				if res.Err != nil {
+					fmt.Fprintf(&b, "- %s (%d Versuch(e)): %s\n", res.Sink, res.Attempts, redactError(res.Err))
+				}
+			}
+			if de.Outbox != "" {
+				fmt.Fprintf(&b, "\nDie Dateien liegen in %s und werden mit `apple-invoice-pdf resume` erneut zugestellt.\n", de.Outbox)
+			}
+		} else {
+			fmt.Fprintf(&b, "- %s\n", redactError(deliveryErr))
+		}
+	}
+	return subject, b.String()
+}
+
+// reportInvoice identifies an invoice email by UID, date and subject.
+func reportInvoice(e InvoiceEmail) string {
+	return fmt.Sprintf("UID %d vom %s: %q", e.UID, e.Date.Format("02.01.2006"), e.Subject)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFailureReport(t *testing.T) {
+	date := time.Date(2024, 3, 31, 9, 0, 0, 0, time.UTC)
+	failures := []InvoiceFailure{{
+		Email: InvoiceEmail{UID: 4711, Subject: "Deine Rechnung von Apple", Date: date},
+		Err:   errors.New("converting to PDF: chrome crashed"),
+	}}
+	delivered := testProcessedInvoice()
+	delivered.Email.UID = 4712
+	deliveryErr := &DeliveryError{
+		Results: []SinkResult{
+			{Sink: "email", Attempts: 3, Err: errors.New("421 try again later")},
+			{Sink: "s3", Attempts: 1},
+		},
+		Outbox: "/srv/outbox/20240331-090000-1",
+	}
+
+	subject, body := failureReport("Apple", failures, []ProcessedInvoice{delivered}, deliveryErr)
+	if subject != "Fehler bei 2 Apple-Rechnung(en)" {
+		t.Errorf("subject = %q", subject)
+	}
+	for _, want := range []string{
+		"Nicht in PDF umgewandelt (1):\n- UID 4711 vom 31.03.2024: \"Deine Rechnung von Apple\"\n  converting to PDF: chrome crashed\n",
+		"Nicht zugestellt (1):\n- UID 4712 ",
+		delivered.Filename + ".pdf\n",
+		"- email (3 Versuch(e)): 421 try again later\n",
+		"/srv/outbox/20240331-090000-1",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body misses %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "- s3") {
+		t.Errorf("body lists successful sink:\n%s", body)
+	}
+}
+
+func TestFailureReport_ConversionOnly(t *testing.T) {
+	failures := []InvoiceFailure{{Email: InvoiceEmail{UID: 1}, Err: errors.New("cleaning HTML: bad markup")}}
+	subject, body := failureReport("Apple", failures, []ProcessedInvoice{testProcessedInvoice()}, nil)
+	if subject != "Fehler bei 1 Apple-Rechnung(en)" || strings.Contains(body, "Nicht zugestellt") {
+		t.Errorf("report = %q\n%s", subject, body)
+	}
+}
+
+func TestReportFailures(t *testing.T) {
+	script, dir := fakeSendmail(t, 0)
+	cfg := &Config{Sendmail: SendmailConfig{Command: script}, Report: ReportConfig{To: "admin@example.com"}}
+	cfg.Email.From = "invoices@example.com"
+
+	// Nothing failed: no report
+	reportFailures(context.Background(), cfg, nil, []ProcessedInvoice{testProcessedInvoice()}, nil)
+	if _, err := os.Stat(filepath.Join(dir, "stdin")); !os.IsNotExist(err) {
+		t.Fatal("sent a report without failures")
+	}
+
+	failures := []InvoiceFailure{{Email: InvoiceEmail{UID: 4711, Subject: "Deine Rechnung von Apple"}, Err: errors.New("converting to PDF: timeout")}}
+	reportFailures(context.Background(), cfg, failures, nil, nil)
+	msg, err := os.ReadFile(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if !strings.Contains(string(args), "admin@example.com") {
+		t.Errorf("recipients = %q", args)
+	}
+	if !strings.Contains(string(msg), "Subject: Fehler bei 1 Apple-Rechnung(en)") || !strings.Contains(string(msg), "UID 4711") {
+		t.Errorf("message:\n%s", msg)
+	}
+}
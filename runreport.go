@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RunReport is the machine-readable summary of a run written to
+// report.file, for wrapper scripts and audits.
+type RunReport struct {
+	Mode            string             `json:"mode"`
+	DryRun          bool               `json:"dry_run,omitempty"`
+	Started         time.Time          `json:"started"`
+	Finished        time.Time          `json:"finished"`
+	Status          string             `json:"status"`
+	ExitCode        int                `json:"exit_code"`
+	Error           string             `json:"error,omitempty"`
+	MessagesScanned int                `json:"messages_scanned"`
+	Matches         int                `json:"matches"`
+	BytesSent       int64              `json:"bytes_sent"`
+	Invoices        []RunReportInvoice `json:"invoices"`
+	Sinks           []RunReportSink    `json:"sinks,omitempty"`
+}
+
+// RunReportInvoice is the outcome for one matched invoice email. Status is
+// "delivered", "queued" (saved to the outbox), "failed" or "skipped"
+// (delivered before).
+type RunReportInvoice struct {
+	UID         uint32    `json:"uid"`
+	Subject     string    `json:"subject"`
+	Date        time.Time `json:"date"`
+	MessageID   string    `json:"message_id,omitempty"`
+	OrderNumber string    `json:"order_number,omitempty"`
+	Filename    string    `json:"filename,omitempty"`
+	Total       string    `json:"total,omitempty"`
+	Bytes       int       `json:"bytes,omitempty"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// RunReportSink is the outcome of a delivery to one sink. Bytes counts the
+// files it accepted.
+type RunReportSink struct {
+	Name     string `json:"name"`
+	Attempts int    `json:"attempts"`
+	Bytes    int64  `json:"bytes"`
+	Error    string `json:"error,omitempty"`
+}
+
+// runReportKey carries the report of the current run in the context.
+type runReportKey struct{}
+
+// withRunReport returns a context whose run records to r.
+func withRunReport(ctx context.Context, r *RunReport) context.Context {
+	return context.WithValue(ctx, runReportKey{}, r)
+}
+
+// runReportFrom returns the report in ctx, or nil if neither report.file
+// nor the dashboard is enabled. All recording methods are no-ops on a nil report.
+func runReportFrom(ctx context.Context) *RunReport {
+	r, _ := ctx.Value(runReportKey{}).(*RunReport)
+	return r
+}
+
+// scanned counts the messages looked at and those matching the filter.
+func (r *RunReport) scanned(messages, matches int) {
+	if r == nil {
+		return
+	}
+	r.MessagesScanned += messages
+	r.Matches += matches
+}
+
+// addInvoices records the outcome of each fetched invoice: failed if it
+// is among failures, its status in statuses (with deliveryErr) if it was
+// processed, and skipped otherwise. Invoices are told apart by UID.
+func (r *RunReport) addInvoices(fetched []InvoiceEmail, processed []ProcessedInvoice, failures []InvoiceFailure, statuses map[uint32]string, deliveryErr error) {
+	if r == nil {
+		return
+	}
+	for _, e := range fetched {
+		inv := RunReportInvoice{UID: e.UID, Subject: e.Subject, Date: e.Date, MessageID: e.MessageID, Status: "skipped"}
+		for _, f := range failures {
+			if f.Email.UID == e.UID {
+				inv.Status, inv.Error = stateFailed, redactError(f.Err)
+			}
+		}
+		for _, p := range processed {
+			if p.Email.UID == e.UID {
+				inv.OrderNumber, inv.Filename, inv.Total, inv.Bytes, inv.Status = p.OrderNumber, p.Filename+".pdf", p.Total.String(), p.pdfSize(), statuses[p.Email.UID]
+				if deliveryErr != nil {
+					inv.Error = redactError(deliveryErr)
+				}
+			}
+		}
+		r.Invoices = append(r.Invoices, inv)
+	}
+}
+
+// delivered records the sink results of a delivery.
+func (r *RunReport) delivered(d *Delivery, results []SinkResult) {
+	if r == nil {
+		return
+	}
+	var size int64
+	for _, att := range d.Attachments {
+		size += int64(att.size())
+	}
+	for _, res := range results {
+		sink := RunReportSink{Name: res.Sink, Attempts: res.Attempts}
+		if res.Err != nil {
+			sink.Error = redactError(res.Err)
+		} else {
+			sink.Bytes = size
+			r.BytesSent += size
+		}
+		r.Sinks = append(r.Sinks, sink)
+	}
+}
+
+// finish sets the end time and outcome of the run.
+func (r *RunReport) finish(ctx context.Context, err error, now time.Time) {
+	r.Finished = now
+	r.ExitCode = exitCode(err)
+	switch {
+	case ctx.Err() != nil:
+		r.Status, r.ExitCode = "interrupted", exitInterrupted
+	case errors.Is(err, errNoInvoices):
+		r.Status = "no_invoices"
+	case err != nil:
+		r.Status = "failed"
+	default:
+		r.Status = "ok"
+	}
+	r.Error = redactError(err)
+	if r.Invoices == nil {
+		r.Invoices = []RunReportInvoice{}
+	}
+}
+
+// write replaces the file at path with the report.
+func (r *RunReport) write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding run report: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating run report directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing run report: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing run report: %w", err)
+	}
+	return nil
+}
+
+// startRunReport adds a report for a run in mode to ctx if report.file is
+// set or the dashboard keeps a run history. The returned function finishes
+// and writes it; write errors are logged so they do not mask the outcome of
+// the run.
+func startRunReport(ctx context.Context, cfg *Config, mode string, dryRun bool) (context.Context, func(error)) {
+	history := runHistoryFrom(ctx)
+	if cfg.Report.File == "" && history == nil {
+		return ctx, func(error) {}
+	}
+	r := &RunReport{Mode: mode, DryRun: dryRun, Started: time.Now()}
+	done := func(err error) {
+		r.finish(ctx, err, time.Now())
+		history.add(r)
+		if cfg.Report.File == "" {
+			return
+		}
+		if werr := r.write(cfg.Report.File); werr != nil {
+			slog.Error("Writing run report failed", "path", cfg.Report.File, "err", werr)
+		}
+	}
+	return withRunReport(ctx, r), done
+}
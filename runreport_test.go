@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunReport(t *testing.T) {
+	start := time.Date(2024, 4, 1, 7, 0, 0, 0, time.UTC)
+	r := &RunReport{Mode: "run", Started: start}
+	r.scanned(50, 3)
+
+	delivered := testProcessedInvoice()
+	delivered.Email.UID = 2
+	fetched := []InvoiceEmail{{UID: 1, Subject: "old"}, delivered.Email, {UID: 3, Subject: "broken"}}
+	failures := []InvoiceFailure{{Email: fetched[2], Err: errors.New("converting to PDF: timeout")}}
+	d := &Delivery{Invoices: []ProcessedInvoice{delivered}, Attachments: []PDFAttachment{{Filename: "a.pdf", Data: make([]byte, 100)}, {Filename: "b.eml", Data: make([]byte, 20)}}}
+	r.delivered(d, []SinkResult{{Sink: "email", Attempts: 1}, {Sink: "s3", Attempts: 3, Err: errors.New("503")}})
+	r.addInvoices(fetched, []ProcessedInvoice{delivered}, failures, map[uint32]string{2: stateDelivered}, nil)
+	r.finish(context.Background(), withExitCode(exitPDF, errors.New("1 of 2 invoice(s) could not be converted to PDF")), start.Add(time.Minute))
+
+	if r.Status != "failed" || r.ExitCode != exitPDF || !r.Finished.Equal(start.Add(time.Minute)) {
+		t.Errorf("outcome = %s %d %v", r.Status, r.ExitCode, r.Finished)
+	}
+	if r.MessagesScanned != 50 || r.Matches != 3 || r.BytesSent != 120 {
+		t.Errorf("counts = %d %d %d", r.MessagesScanned, r.Matches, r.BytesSent)
+	}
+	if len(r.Sinks) != 2 || r.Sinks[0].Bytes != 120 || r.Sinks[1].Bytes != 0 || r.Sinks[1].Error != "503" {
+		t.Errorf("sinks = %+v", r.Sinks)
+	}
+	want := []struct {
+		uid    uint32
+		status string
+	}{{1, "skipped"}, {2, stateDelivered}, {3, stateFailed}}
+	if len(r.Invoices) != len(want) {
+		t.Fatalf("invoices = %+v", r.Invoices)
+	}
+	for i, w := range want {
+		if inv := r.Invoices[i]; inv.UID != w.uid || inv.Status != w.status {
+			t.Errorf("invoice %d = %+v, want %d %s", i, inv, w.uid, w.status)
+		}
+	}
+	if inv := r.Invoices[1]; inv.Filename != delivered.Filename+".pdf" || inv.Bytes != len(delivered.PDF) {
+		t.Errorf("delivered invoice = %+v", inv)
+	}
+	if r.Invoices[2].Error != "converting to PDF: timeout" {
+		t.Errorf("failed invoice = %+v", r.Invoices[2])
+	}
+}
+
+func TestRunReport_Finish(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, tt := range []struct {
+		ctx    context.Context
+		err    error
+		status string
+		code   int
+	}{
+		{context.Background(), nil, "ok", 0},
+		{context.Background(), errNoInvoices, "no_invoices", exitNoInvoices},
+		{ctx, context.Canceled, "interrupted", exitInterrupted},
+	} {
+		r := &RunReport{}
+		r.finish(tt.ctx, tt.err, time.Now())
+		if r.Status != tt.status || r.ExitCode != tt.code || r.Invoices == nil {
+			t.Errorf("finish(%v) = %s %d %v", tt.err, r.Status, r.ExitCode, r.Invoices)
+		}
+	}
+}
+
+func TestStartRunReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reports", "last-run.json")
+	cfg := &Config{}
+	cfg.Report.File = path
+
+	ctx, done := startRunReport(context.Background(), cfg, "run", true)
+	runReportFrom(ctx).scanned(7, 0)
+	done(errNoInvoices)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r RunReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Mode != "run" || !r.DryRun || r.Status != "no_invoices" || r.MessagesScanned != 7 || r.Invoices == nil {
+		t.Errorf("report = %+v", r)
+	}
+
+	// Without report.file nothing is recorded
+	ctx, done = startRunReport(context.Background(), &Config{}, "run", false)
+	if runReportFrom(ctx) != nil {
+		t.Error("report without report.file")
+	}
+	done(nil)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// S3Config configures uploads to S3-compatible object storage
+// (AWS S3, MinIO, Backblaze B2, ...).
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	PathStyle bool   `yaml:"path_style"`
+	// Sanitize replaces filename.sanitize for the object keys if set.
+	Sanitize *SanitizePolicy `yaml:"sanitize"`
+}
+
+// awsCredentials is a resolved access key pair with optional session token.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// s3Sink uploads every attachment as an object below a templated prefix.
+type s3Sink struct {
+	cfg      S3Config
+	prefix   *template.Template
+	sanitize SanitizePolicy
+	client   *http.Client
+	now      func() time.Time
+}
+
+// newS3Sink validates the config and parses the prefix template.
+func newS3Sink(cfg S3Config, sanitize SanitizePolicy) (*s3Sink, error) {
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+	}
+	if _, err := url.Parse(cfg.Endpoint); err != nil {
+		return nil, fmt.Errorf("s3: invalid endpoint: %w", err)
+	}
+	tmpl, err := template.New("prefix").Option("missingkey=error").Parse(cfg.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("s3: parsing prefix template: %w", err)
+	}
+	return &s3Sink{
+		cfg:      cfg,
+		prefix:   tmpl,
+		sanitize: sanitize,
+		client:   &http.Client{Timeout: 2 * time.Minute},
+		now:      time.Now,
+	}, nil
+}
+
+func (s *s3Sink) Name() string { return "s3" }
+
+func (s *s3Sink) Deliver(ctx context.Context, d *Delivery) error {
+	creds, err := resolveAWSCredentials(ctx, s.cfg, s.client)
+	if err != nil {
+		return err
+	}
+	for _, att := range d.Attachments {
+		key, err := s.objectKey(att)
+		if err != nil {
+			return err
+		}
+		data, err := att.content()
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", att.Filename, err)
+		}
+		if err := s.put(ctx, creds, key, data); err != nil {
+			return fmt.Errorf("uploading %s: %w", key, err)
+		}
+		slog.Info("Uploaded file to S3", "bucket", s.cfg.Bucket, "key", key)
+	}
+	return nil
+}
+
+// objectKey renders the prefix for an attachment and appends its filename.
+func (s *s3Sink) objectKey(att PDFAttachment) (string, error) {
+	key, err := remotePath(s.prefix, att, s.now(), s.sanitize)
+	if err != nil {
+		return "", fmt.Errorf("s3: %w", err)
+	}
+	return strings.TrimLeft(key, "/"), nil
+}
+
+// objectURL returns the URL of an object using path-style
+// (endpoint/bucket/key) or virtual-hosted-style (bucket.endpoint/key) addressing.
+func (s *s3Sink) objectURL(key string) *url.URL {
+	u, _ := url.Parse(s.cfg.Endpoint)
+	if s.cfg.PathStyle {
+		u.Path = "/" + s.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	// Send the path exactly as it is encoded for signing
+	u.RawPath = awsURIEncode(u.Path, false)
+	return u
+}
+
+// put uploads a single object with a SigV4-signed PUT request.
+func (s *s3Sink) put(ctx context.Context, creds awsCredentials, key string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key).String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+	sum := sha256.Sum256(data)
+	signV4(req, hex.EncodeToString(sum[:]), creds, s.cfg.Region, "s3", s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// signV4 adds AWS Signature Version 4 headers to req. All headers already
+// set on the request are signed along with Host.
+func signV4(req *http.Request, payloadHash string, creds awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	// Canonical headers: lowercase names, sorted, trimmed values
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsURIEncode percent-encodes everything except unreserved characters as
+// required by SigV4; slashes are kept unless encodeSlash is set.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery returns the query string sorted and encoded for SigV4.
+func canonicalQuery(q url.Values) string {
+	var parts []string
+	for k, vs := range q {
+		for _, v := range vs {
+			parts = append(parts, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "&")
+}
+
+// resolveAWSCredentials returns credentials from the config, the standard
+// AWS_* environment variables, the ECS container endpoint, or the EC2
+// instance metadata service (IAM role), in that order.
+func resolveAWSCredentials(ctx context.Context, cfg S3Config, client *http.Client) (awsCredentials, error) {
+	if cfg.AccessKey != "" {
+		return awsCredentials{AccessKeyID: cfg.AccessKey, SecretAccessKey: cfg.SecretKey}, nil
+	}
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); rel != "" {
+		return fetchRoleCredentials(ctx, client, "http://169.254.170.2"+rel, "")
+	}
+	return fetchIMDSCredentials(ctx, client)
+}
+
+// fetchIMDSCredentials retrieves IAM role credentials via IMDSv2.
+func fetchIMDSCredentials(ctx context.Context, client *http.Client) (awsCredentials, error) {
+	const base = "http://169.254.169.254/latest"
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPut, base+"/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	token, err := httpGetString(client, req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("s3: no credentials configured and instance metadata unavailable: %w", err)
+	}
+
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, base+"/meta-data/iam/security-credentials/", nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	role, err := httpGetString(client, req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("s3: looking up IAM role: %w", err)
+	}
+	role, _, _ = strings.Cut(strings.TrimSpace(role), "\n")
+	return fetchRoleCredentials(ctx, client, base+"/meta-data/iam/security-credentials/"+role, token)
+}
+
+// fetchRoleCredentials reads the JSON credentials document served by the
+// EC2 and ECS metadata endpoints.
+func fetchRoleCredentials(ctx context.Context, client *http.Client, credURL, imdsToken string) (awsCredentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, credURL, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if imdsToken != "" {
+		req.Header.Set("X-aws-ec2-metadata-token", imdsToken)
+	}
+	body, err := httpGetString(client, req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("s3: fetching role credentials: %w", err)
+	}
+	var doc struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+	}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		return awsCredentials{}, fmt.Errorf("s3: decoding role credentials: %w", err)
+	}
+	return awsCredentials{AccessKeyID: doc.AccessKeyID, SecretAccessKey: doc.SecretAccessKey, SessionToken: doc.Token}, nil
+}
+
+// httpGetString performs req and returns the body, failing on non-2xx status.
+func httpGetString(client *http.Client, req *http.Request) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return string(body), nil
+}
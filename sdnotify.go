@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// sdNotify sends a state like "READY=1" or "STATUS=..." to systemd if the
+// service runs with Type=notify; it does nothing otherwise.
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:] // abstract socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogTimeout returns the systemd watchdog timeout (WatchdogSec=) for
+// this process, or 0 if the watchdog is not enabled.
+func watchdogTimeout() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// heartbeatKey carries the progress callback of a daemon run in the context.
+type heartbeatKey struct{}
+
+// withHeartbeat returns a context whose heartbeat calls beat.
+func withHeartbeat(ctx context.Context, beat func()) context.Context {
+	return context.WithValue(ctx, heartbeatKey{}, beat)
+}
+
+// heartbeat reports progress of a run so the watchdog knows it is not stuck.
+func heartbeat(ctx context.Context) {
+	if beat, ok := ctx.Value(heartbeatKey{}).(func()); ok {
+		beat()
+	}
+}
+
+// watchdog pings systemd at half the timeout as long as the daemon is idle
+// or the current run reported progress within the timeout. A run stuck
+// without progress (e.g. a wedged IMAP connection) stops the pings, so
+// systemd restarts the service.
+type watchdog struct {
+	timeout  time.Duration
+	busy     atomic.Bool
+	lastBeat atomic.Int64 // unix nanoseconds
+	now      func() time.Time
+}
+
+func newWatchdog(timeout time.Duration) *watchdog {
+	w := &watchdog{timeout: timeout, now: time.Now}
+	w.beat()
+	return w
+}
+
+func (w *watchdog) beat() { w.lastBeat.Store(w.now().UnixNano()) }
+
+// healthy reports whether the daemon should ping the watchdog.
+func (w *watchdog) healthy() bool {
+	return !w.busy.Load() || w.now().Sub(time.Unix(0, w.lastBeat.Load())) < w.timeout
+}
+
+// run pings systemd until ctx is cancelled.
+func (w *watchdog) run(ctx context.Context) {
+	ticker := time.NewTicker(w.timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if w.healthy() {
+				sdNotify("WATCHDOG=1")
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Errorf("received %q, %v", buf[:n], err)
+	}
+
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("without NOTIFY_SOCKET: %v", err)
+	}
+}
+
+func TestWatchdogTimeout(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if got := watchdogTimeout(); got != 30*time.Second {
+		t.Errorf("watchdogTimeout = %s, want 30s", got)
+	}
+	t.Setenv("WATCHDOG_PID", "1")
+	if got := watchdogTimeout(); got != 0 {
+		t.Errorf("watchdog for another PID = %s, want 0", got)
+	}
+	t.Setenv("WATCHDOG_PID", "")
+	t.Setenv("WATCHDOG_USEC", "")
+	if got := watchdogTimeout(); got != 0 {
+		t.Errorf("watchdogTimeout without env = %s, want 0", got)
+	}
+}
+
+func TestWatchdog_Healthy(t *testing.T) {
+	now := time.Date(2024, 5, 14, 10, 0, 0, 0, time.UTC)
+	w := &watchdog{timeout: time.Minute, now: func() time.Time { return now }}
+	w.beat()
+	now = now.Add(10 * time.Minute)
+	if !w.healthy() {
+		t.Error("idle daemon must stay healthy")
+	}
+
+	w.busy.Store(true)
+	if w.healthy() {
+		t.Error("run without progress for longer than the timeout must be unhealthy")
+	}
+	heartbeat(withHeartbeat(context.Background(), w.beat))
+	if !w.healthy() {
+		t.Error("heartbeat must restore health")
+	}
+}
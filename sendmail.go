@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/gomail.v2"
+)
+
+// SendmailConfig hands outgoing mail to a local MTA (Postfix, Exim, msmtp)
+// instead of connecting to an SMTP server.
+type SendmailConfig struct {
+	// Command is the sendmail binary with optional arguments, e.g.
+	// "/usr/sbin/sendmail" or "msmtp -a invoices". Setting it enables the
+	// transport; -i, -f <from> and the recipients are appended.
+	Command string `yaml:"command"`
+}
+
+// mailTransport sends composed messages (SMTP or sendmail).
+type mailTransport interface {
+	DialAndSend(ctx context.Context, m ...*gomail.Message) error
+}
+
+// newMailTransport returns the configured transport for outgoing mail.
+func newMailTransport(ctx context.Context, cfg *Config) (mailTransport, error) {
+	if cfg.Sendmail.Command != "" {
+		return &sendmailTransport{args: strings.Fields(cfg.Sendmail.Command)}, nil
+	}
+	d, err := newSMTPDialer(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// sendmailTransport pipes each message into the sendmail command.
+type sendmailTransport struct {
+	args []string
+}
+
+func (t *sendmailTransport) DialAndSend(ctx context.Context, m ...*gomail.Message) error {
+	for _, msg := range m {
+		from, to, err := envelope(msg)
+		if err != nil {
+			return err
+		}
+		if err := t.send(ctx, from, to, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send runs the command once per message. The message is converted to
+// Unix line endings, which sendmail expects on its standard input.
+func (t *sendmailTransport) send(ctx context.Context, from string, to []string, msg io.WriterTo) error {
+	var buf bytes.Buffer
+	if _, err := msg.WriteTo(&buf); err != nil {
+		return err
+	}
+	args := append(t.args[1:len(t.args):len(t.args)], "-i", "-f", from, "--")
+	cmd := exec.CommandContext(ctx, t.args[0], append(args, to...)...)
+	cmd.Stdin = bytes.NewReader(bytes.ReplaceAll(buf.Bytes(), []byte("\r\n"), []byte("\n")))
+	var stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stderr, &stderr
+	if err := cmd.Run(); err != nil {
+		if out := strings.TrimSpace(stderr.String()); out != "" {
+			return fmt.Errorf("%s: %w: %s", t.args[0], err, out)
+		}
+		return fmt.Errorf("%s: %w", t.args[0], err)
+	}
+	return nil
+}
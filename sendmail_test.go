@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSendmail writes a script that records its arguments and standard
+// input next to itself, or fails with a message if exitCode is non-zero.
+func fakeSendmail(t *testing.T, exitCode int) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := filepath.Join(dir, "sendmail")
+	body := "#!/bin/sh\necho \"$@\" > " + dir + "/args\ncat > " + dir + "/stdin\n"
+	if exitCode != 0 {
+		body += "echo 'sendmail: fatal: no such user' >&2\nexit 75\n"
+	}
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return script, dir
+}
+
+func TestSendmailTransport(t *testing.T) {
+	script, dir := fakeSendmail(t, 0)
+	cfg := &Config{Sendmail: SendmailConfig{Command: script + " -oi -Finvoices"}}
+	tr, err := newMailTransport(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := tr.DialAndSend(context.Background(), testMessage()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if got := strings.TrimSpace(string(args)); got != "-oi -Finvoices -i -f jane@example.com -- books@example.com" {
+		t.Errorf("args = %q", got)
+	}
+	stdin, _ := os.ReadFile(filepath.Join(dir, "stdin"))
+	if strings.Contains(string(stdin), "\r\n") {
+		t.Error("message should use Unix line endings")
+	}
+	if !strings.Contains(string(stdin), "To: books@example.com\n") || !strings.Contains(string(stdin), "Dokumente anbei.") {
+		t.Errorf("stdin = %q", stdin)
+	}
+}
+
+func TestSendmailTransport_Failure(t *testing.T) {
+	script, _ := fakeSendmail(t, 75)
+	tr := &sendmailTransport{args: []string{script}}
+	err := tr.DialAndSend(context.Background(), testMessage())
+	if err == nil || !strings.Contains(err.Error(), "no such user") {
+		t.Errorf("error = %v, want stderr in message", err)
+	}
+}
+
+func TestNewMailTransport_DefaultsToSMTP(t *testing.T) {
+	tr, err := newMailTransport(context.Background(), &Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := tr.(*smtpDialer); !ok {
+		t.Errorf("transport = %T, want *smtpDialer", tr)
+	}
+}
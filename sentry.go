@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// SentryConfig configures reporting failed runs and panics to Sentry.
+type SentryConfig struct {
+	// DSN is the client key URL of the Sentry project, e.g.
+	// "https://<key>@o123.ingest.sentry.io/456"; empty disables reporting.
+	DSN         string `yaml:"dsn"`
+	Environment string `yaml:"environment"`
+}
+
+// sentryClient sends events to the envelope endpoint of a Sentry project.
+// All methods are no-ops on a nil receiver, so reporting needs no checks
+// when disabled.
+type sentryClient struct {
+	dsn         string
+	url         string
+	key         string
+	environment string
+	client      *http.Client
+}
+
+// newSentryClient returns a client for cfg, or nil if no DSN is configured.
+func newSentryClient(cfg SentryConfig) (*sentryClient, error) {
+	if cfg.DSN == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("sentry.dsn: %w", err)
+	}
+	project := strings.TrimPrefix(u.Path, "/")
+	if u.User == nil || u.User.Username() == "" || u.Host == "" || project == "" {
+		return nil, fmt.Errorf("sentry.dsn: want https://<key>@<host>/<project>, got %q", cfg.DSN)
+	}
+	prefix := ""
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	return &sentryClient{
+		dsn:         cfg.DSN,
+		url:         fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, project),
+		key:         u.User.Username(),
+		environment: cfg.Environment,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// sentryKey carries the Sentry client in the context.
+type sentryKey struct{}
+
+// withSentry returns a context whose failed runs are reported to s.
+func withSentry(ctx context.Context, s *sentryClient) context.Context {
+	return context.WithValue(ctx, sentryKey{}, s)
+}
+
+// sentryFrom returns the Sentry client in ctx, or nil.
+func sentryFrom(ctx context.Context) *sentryClient {
+	s, _ := ctx.Value(sentryKey{}).(*sentryClient)
+	return s
+}
+
+// captureError reports a failed run with tags describing it.
+func (s *sentryClient) captureError(err error, tags map[string]string) {
+	if s == nil {
+		return
+	}
+	tags = maps.Clone(tags)
+	tags["exit_code"] = fmt.Sprint(exitCode(err))
+	s.send("error", map[string]any{"type": "RunError", "value": redactError(err)}, tags)
+}
+
+// capturePanic reports the recovered panic value v with its stack trace. It
+// must be called by the deferred function that recovered it.
+func (s *sentryClient) capturePanic(v any, tags map[string]string) {
+	if s == nil {
+		return
+	}
+	s.send("fatal", map[string]any{
+		"type":       "panic",
+		"value":      redact(fmt.Sprint(v)),
+		"stacktrace": map[string]any{"frames": panicFrames()},
+	}, tags)
+}
+
+// panicFrames returns the stack of the panicking goroutine below the panic,
+// outermost call first as Sentry expects.
+func panicFrames() []map[string]any {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(1, pcs)])
+	var out []map[string]any
+	var inPanic bool
+	for {
+		f, more := frames.Next()
+		if inPanic {
+			module, function := splitFunction(f.Function)
+			out = append(out, map[string]any{
+				"function": function,
+				"module":   module,
+				"abs_path": f.File,
+				"lineno":   f.Line,
+				"in_app":   module == "main",
+			})
+		}
+		if f.Function == "runtime.gopanic" {
+			inPanic = true
+		}
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// splitFunction splits "pkg/path.Type.Method" into package and function.
+func splitFunction(name string) (string, string) {
+	slash := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[slash+1:], "."); dot >= 0 {
+		return name[:slash+1+dot], name[slash+2+dot:]
+	}
+	return "", name
+}
+
+// send posts an event with one exception. Failures are logged only.
+func (s *sentryClient) send(level string, exception map[string]any, tags map[string]string) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	eventID := hex.EncodeToString(id)
+	host, _ := os.Hostname()
+	now := time.Now().UTC()
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.Encode(map[string]any{"event_id": eventID, "dsn": s.dsn, "sent_at": now.Format(time.RFC3339)})
+	enc.Encode(map[string]any{"type": "event"})
+	enc.Encode(map[string]any{
+		"event_id":    eventID,
+		"timestamp":   now.Format(time.RFC3339Nano),
+		"platform":    "go",
+		"level":       level,
+		"logger":      "apple-invoice-pdf",
+		"server_name": host,
+		"environment": s.environment,
+		"tags":        tags,
+		"exception":   map[string]any{"values": []any{exception}},
+	})
+
+	req, err := http.NewRequest(http.MethodPost, s.url, &body)
+	if err != nil {
+		slog.Warn("Reporting to Sentry failed", "err", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=apple-invoice-pdf, sentry_key="+s.key)
+	if err := doRequest(s.client, req, nil); err != nil {
+		slog.Warn("Reporting to Sentry failed", "err", err)
+		return
+	}
+	slog.Info("Reported to Sentry", "event_id", eventID)
+}
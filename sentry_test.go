@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewSentryClient(t *testing.T) {
+	s, err := newSentryClient(SentryConfig{DSN: "https://abc123@o1.ingest.sentry.io/456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.url != "https://o1.ingest.sentry.io/api/456/envelope/" || s.key != "abc123" {
+		t.Errorf("client = %+v", s)
+	}
+	s, err = newSentryClient(SentryConfig{DSN: "https://abc123@sentry.example.com/prefix/7"})
+	if err != nil || s.url != "https://sentry.example.com/prefix/api/7/envelope/" {
+		t.Errorf("client with path prefix = %+v, %v", s, err)
+	}
+	if s, err := newSentryClient(SentryConfig{}); s != nil || err != nil {
+		t.Errorf("without DSN = %v, %v", s, err)
+	}
+	for _, dsn := range []string{"https://sentry.io/1", "https://key@sentry.io/", "::"} {
+		if _, err := newSentryClient(SentryConfig{DSN: dsn}); err == nil {
+			t.Errorf("%q: want error", dsn)
+		}
+	}
+}
+
+// sentryServer records the events posted to it.
+func sentryServer(t *testing.T) (*sentryClient, *[]map[string]any) {
+	t.Helper()
+	var events []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/42/envelope/" || !strings.Contains(r.Header.Get("X-Sentry-Auth"), "sentry_key=key") {
+			t.Errorf("request %s with auth %q", r.URL.Path, r.Header.Get("X-Sentry-Auth"))
+		}
+		scanner := bufio.NewScanner(r.Body)
+		var lines []string
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if len(lines) != 3 || lines[1] != `{"type":"event"}` {
+			t.Fatalf("envelope = %q", lines)
+		}
+		var event map[string]any
+		if err := json.Unmarshal([]byte(lines[2]), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}))
+	t.Cleanup(srv.Close)
+	s, err := newSentryClient(SentryConfig{DSN: "http://key@" + strings.TrimPrefix(srv.URL, "http://") + "/42", Environment: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, &events
+}
+
+func TestSentryClient_CaptureError(t *testing.T) {
+	s, events := sentryServer(t)
+	tags := map[string]string{"mode": "daemon"}
+	s.captureError(withExitCode(exitIMAP, errors.New("fetching invoices: timeout")), tags)
+	if len(*events) != 1 {
+		t.Fatalf("got %d events", len(*events))
+	}
+	event := (*events)[0]
+	if event["level"] != "error" || event["environment"] != "test" || len(event["event_id"].(string)) != 32 {
+		t.Errorf("event = %v", event)
+	}
+	if got := event["tags"].(map[string]any); got["mode"] != "daemon" || got["exit_code"] != "5" {
+		t.Errorf("tags = %v", got)
+	}
+	if _, ok := tags["exit_code"]; ok {
+		t.Error("captureError modified the tags of the caller")
+	}
+	exc := event["exception"].(map[string]any)["values"].([]any)[0].(map[string]any)
+	if exc["value"] != "fetching invoices: timeout" {
+		t.Errorf("exception = %v", exc)
+	}
+}
+
+func TestSentryClient_CapturePanic(t *testing.T) {
+	s, events := sentryServer(t)
+	func() {
+		defer func() {
+			s.capturePanic(recover(), map[string]string{"mode": "run"})
+		}()
+		panickingRun()
+	}()
+	if len(*events) != 1 || (*events)[0]["level"] != "fatal" {
+		t.Fatalf("events = %v", *events)
+	}
+	exc := (*events)[0]["exception"].(map[string]any)["values"].([]any)[0].(map[string]any)
+	frames := exc["stacktrace"].(map[string]any)["frames"].([]any)
+	last := frames[len(frames)-1].(map[string]any)
+	if exc["value"] != "boom" || last["function"] != "panickingRun" || last["lineno"] == float64(0) {
+		t.Errorf("exception = %v, innermost frame %v", exc, last)
+	}
+}
+
+func panickingRun() {
+	panic("boom")
+}
+
+func TestSentryClient_Nil(t *testing.T) {
+	var s *sentryClient
+	s.captureError(errors.New("ignored"), map[string]string{})
+	s.capturePanic("ignored", nil)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"math/bits"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// serviceName names the systemd units; launchdLabel names the launchd job.
+const (
+	serviceName  = "apple-invoice-pdf"
+	launchdLabel = "com.github.rummeyer.apple-invoice-pdf"
+)
+
+// serviceFile is a generated unit or plist with its install location.
+type serviceFile struct {
+	Path    string
+	Content string
+}
+
+// serviceCommand is run after writing the files to activate them.
+type serviceCommand struct {
+	args    []string
+	mayFail bool
+}
+
+// installService generates a systemd service and timer (Linux) or a launchd
+// agent (macOS) running the current binary in the current directory on
+// daemon.schedule, installs and enables it. With dryRun, the files are only
+// printed to out.
+func installService(cfg *Config, dryRun bool, out io.Writer) error {
+	if cfg.Daemon.Schedule == "" {
+		return withExitCode(exitConfig, errors.New("install-service requires daemon.schedule in the config"))
+	}
+	sched, err := parseCron(cfg.Daemon.Schedule)
+	if err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	month, err := cfg.Daemon.runMonth(sched)
+	if err != nil {
+		return withExitCode(exitConfig, err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locating executable: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("locating executable: %w", err)
+	}
+	command := []string{exe}
+	if month == monthPrevious {
+		command = append(command, "--month", monthPrevious)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	var files []serviceFile
+	var commands []serviceCommand
+	switch runtime.GOOS {
+	case "linux":
+		unitDir, systemctl := filepath.Join(home, ".config", "systemd", "user"), []string{"systemctl", "--user"}
+		if os.Geteuid() == 0 {
+			unitDir, systemctl = "/etc/systemd/system", []string{"systemctl"}
+		}
+		files = systemdUnits(unitDir, dir, command, sched)
+		commands = []serviceCommand{
+			{args: slices.Concat(systemctl, []string{"daemon-reload"})},
+			{args: slices.Concat(systemctl, []string{"enable", "--now", serviceName + ".timer"})},
+		}
+	case "darwin":
+		plist := launchdPlist(filepath.Join(home, "Library", "LaunchAgents"), dir, command, sched)
+		files = []serviceFile{plist}
+		domain := "gui/" + strconv.Itoa(os.Getuid())
+		commands = []serviceCommand{
+			// Unload a previous version, which fails if there is none
+			{args: []string{"launchctl", "bootout", domain, plist.Path}, mayFail: true},
+			{args: []string{"launchctl", "bootstrap", domain, plist.Path}},
+		}
+	default:
+		return fmt.Errorf("install-service is not supported on %s", runtime.GOOS)
+	}
+
+	if dryRun {
+		for _, f := range files {
+			fmt.Fprintf(out, "# %s\n%s\n", f.Path, f.Content)
+		}
+		return nil
+	}
+	for _, f := range files {
+		if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(f.Path, []byte(f.Content), 0644); err != nil {
+			return err
+		}
+		slog.Info("Wrote service file", "path", f.Path)
+	}
+	for _, c := range commands {
+		cmd := exec.Command(c.args[0], c.args[1:]...)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil && !c.mayFail {
+			return fmt.Errorf("%s: %w", strings.Join(c.args, " "), err)
+		}
+	}
+	slog.Info("Installed service", "schedule", cfg.Daemon.Schedule)
+	return nil
+}
+
+// systemdUnits returns a oneshot service running command and a timer
+// triggering it.
+func systemdUnits(unitDir, dir string, command []string, sched *cronSchedule) []serviceFile {
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = systemdQuote(arg)
+	}
+	service := fmt.Sprintf(`[Unit]
+Description=Convert Apple invoice emails to PDF and deliver them
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+WorkingDirectory=%s
+ExecStart=%s
+# Another instance running or no invoices found is not a failure
+SuccessExitStatus=%d %d
+`, dir, strings.Join(quoted, " "), exitLocked, exitNoInvoices)
+
+	var timer strings.Builder
+	timer.WriteString("[Unit]\nDescription=Run " + serviceName + " on schedule\n\n[Timer]\n")
+	for _, cal := range onCalendar(sched) {
+		timer.WriteString("OnCalendar=" + cal + "\n")
+	}
+	timer.WriteString("Persistent=true\n\n[Install]\nWantedBy=timers.target\n")
+
+	return []serviceFile{
+		{Path: filepath.Join(unitDir, serviceName+".service"), Content: service},
+		{Path: filepath.Join(unitDir, serviceName+".timer"), Content: timer.String()},
+	}
+}
+
+// systemdQuote quotes a command path containing spaces.
+func systemdQuote(s string) string {
+	if strings.ContainsAny(s, " \t\"") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// cronDays returns the day-of-month and day-of-week sets to combine. Cron
+// matches either day field if both are restricted, which systemd and
+// launchd cannot express in one entry, so that case yields two entries.
+func cronDays(s *cronSchedule) [][2]uint64 {
+	dow := s.dow &^ (1 << 7) // Sunday is bit 0
+	if s.domStar || s.dowStar {
+		return [][2]uint64{{s.dom, dow}}
+	}
+	return [][2]uint64{{s.dom, cronAll(0, 6)}, {cronAll(1, 31), dow}}
+}
+
+// cronAll returns the bit set with all values from min to max.
+func cronAll(min, max int) uint64 {
+	return (1<<(max+1) - 1) &^ (1<<min - 1)
+}
+
+// cronValues lists the values in set, or nil if it contains all of min-max.
+func cronValues(set uint64, min, max int) []int {
+	if set == cronAll(min, max) {
+		return nil
+	}
+	var values []int
+	for set != 0 {
+		v := bits.TrailingZeros64(set)
+		values = append(values, v)
+		set &^= 1 << v
+	}
+	return values
+}
+
+// calendarField formats the values of a set for OnCalendar.
+func calendarField(set uint64, min, max int, format func(int) string) string {
+	values := cronValues(set, min, max)
+	if values == nil {
+		return "*"
+	}
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = format(v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// onCalendar converts the schedule to systemd OnCalendar expressions.
+func onCalendar(s *cronSchedule) []string {
+	weekdays := []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+	twoDigits := func(v int) string { return fmt.Sprintf("%02d", v) }
+	var cals []string
+	for _, days := range cronDays(s) {
+		cal := fmt.Sprintf("*-%s-%s %s:%s:00",
+			calendarField(s.month, 1, 12, twoDigits),
+			calendarField(days[0], 1, 31, twoDigits),
+			calendarField(s.hour, 0, 23, twoDigits),
+			calendarField(s.minute, 0, 59, twoDigits))
+		if dow := calendarField(days[1], 0, 6, func(v int) string { return weekdays[v] }); dow != "*" {
+			cal = dow + " " + cal
+		}
+		cals = append(cals, cal)
+	}
+	return cals
+}
+
+// calendarIntervals converts the schedule to launchd StartCalendarInterval
+// entries, one per combination of restricted values. Omitted keys match
+// any value.
+func calendarIntervals(s *cronSchedule) []map[string]int {
+	var intervals []map[string]int
+	for _, days := range cronDays(s) {
+		combos := []map[string]int{{}}
+		for _, f := range []struct {
+			key      string
+			set      uint64
+			min, max int
+		}{
+			{"Month", s.month, 1, 12},
+			{"Day", days[0], 1, 31},
+			{"Weekday", days[1], 0, 6},
+			{"Hour", s.hour, 0, 23},
+			{"Minute", s.minute, 0, 59},
+		} {
+			values := cronValues(f.set, f.min, f.max)
+			if values == nil {
+				continue
+			}
+			var next []map[string]int
+			for _, combo := range combos {
+				for _, v := range values {
+					c := map[string]int{f.key: v}
+					for k, old := range combo {
+						c[k] = old
+					}
+					next = append(next, c)
+				}
+			}
+			combos = next
+		}
+		intervals = append(intervals, combos...)
+	}
+	return intervals
+}
+
+// launchdPlist returns a launch agent running command on the schedule.
+func launchdPlist(agentDir, dir string, command []string, sched *cronSchedule) serviceFile {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	fmt.Fprintf(&b, "\t<key>Label</key>\n\t<string>%s</string>\n", launchdLabel)
+	b.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range command {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", html.EscapeString(arg))
+	}
+	b.WriteString("\t</array>\n")
+	fmt.Fprintf(&b, "\t<key>WorkingDirectory</key>\n\t<string>%s</string>\n", html.EscapeString(dir))
+	logFile := html.EscapeString(filepath.Join(dir, serviceName+".log"))
+	fmt.Fprintf(&b, "\t<key>StandardOutPath</key>\n\t<string>%s</string>\n", logFile)
+	fmt.Fprintf(&b, "\t<key>StandardErrorPath</key>\n\t<string>%s</string>\n", logFile)
+	b.WriteString("\t<key>StartCalendarInterval</key>\n\t<array>\n")
+	for _, interval := range calendarIntervals(sched) {
+		b.WriteString("\t\t<dict>\n")
+		for _, key := range []string{"Month", "Day", "Weekday", "Hour", "Minute"} {
+			if v, ok := interval[key]; ok {
+				fmt.Fprintf(&b, "\t\t\t<key>%s</key>\n\t\t\t<integer>%d</integer>\n", key, v)
+			}
+		}
+		b.WriteString("\t\t</dict>\n")
+	}
+	b.WriteString("\t</array>\n</dict>\n</plist>\n")
+	return serviceFile{Path: filepath.Join(agentDir, launchdLabel+".plist"), Content: b.String()}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOnCalendar(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"0 7 1 * *", []string{"*-*-01 07:00:00"}},
+		{"@daily", []string{"*-*-* 00:00:00"}},
+		{"*/15 8-10 * * 1-5", []string{"Mon,Tue,Wed,Thu,Fri *-*-* 08,09,10:00,15,30,45:00"}},
+		{"30 6 * 1,7 0", []string{"Sun *-01,07-* 06:30:00"}},
+		{"0 0 * * 7", []string{"Sun *-*-* 00:00:00"}},
+		// Both day fields restricted: either one matches
+		{"0 9 1 * 1", []string{"*-*-01 09:00:00", "Mon *-*-* 09:00:00"}},
+	}
+	for _, tt := range tests {
+		sched, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.expr, err)
+		}
+		if got := onCalendar(sched); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("onCalendar(%q) = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestCalendarIntervals(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []map[string]int
+	}{
+		{"0 7 1 * *", []map[string]int{{"Day": 1, "Hour": 7, "Minute": 0}}},
+		{"@hourly", []map[string]int{{"Minute": 0}}},
+		{"0 8,18 * * 6", []map[string]int{{"Weekday": 6, "Hour": 8, "Minute": 0}, {"Weekday": 6, "Hour": 18, "Minute": 0}}},
+		{"0 9 1 * 1", []map[string]int{{"Day": 1, "Hour": 9, "Minute": 0}, {"Weekday": 1, "Hour": 9, "Minute": 0}}},
+	}
+	for _, tt := range tests {
+		sched, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.expr, err)
+		}
+		if got := calendarIntervals(sched); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("calendarIntervals(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestSystemdUnits(t *testing.T) {
+	sched, _ := parseCron("0 7 1 * *")
+	files := systemdUnits("/etc/systemd/system", "/opt/apple invoice", []string{"/opt/apple invoice/apple-invoice-pdf", "--month", "previous"}, sched)
+	if len(files) != 2 || files[0].Path != "/etc/systemd/system/apple-invoice-pdf.service" || files[1].Path != "/etc/systemd/system/apple-invoice-pdf.timer" {
+		t.Fatalf("files = %+v", files)
+	}
+	for _, want := range []string{
+		"WorkingDirectory=/opt/apple invoice\n",
+		`ExecStart="/opt/apple invoice/apple-invoice-pdf" --month previous` + "\n",
+		"SuccessExitStatus=3 4\n",
+	} {
+		if !strings.Contains(files[0].Content, want) {
+			t.Errorf("service missing %q:\n%s", want, files[0].Content)
+		}
+	}
+	if !strings.Contains(files[1].Content, "OnCalendar=*-*-01 07:00:00\n") {
+		t.Errorf("timer:\n%s", files[1].Content)
+	}
+}
+
+func TestLaunchdPlist(t *testing.T) {
+	sched, _ := parseCron("0 7 1 * *")
+	f := launchdPlist("/Users/jane/Library/LaunchAgents", "/Users/jane/R&D", []string{"/Users/jane/bin/apple-invoice-pdf", "--month", "previous"}, sched)
+	if f.Path != "/Users/jane/Library/LaunchAgents/com.github.rummeyer.apple-invoice-pdf.plist" {
+		t.Errorf("path = %s", f.Path)
+	}
+	for _, want := range []string{
+		"<string>/Users/jane/bin/apple-invoice-pdf</string>\n\t\t<string>--month</string>\n\t\t<string>previous</string>\n\t</array>",
+		"<string>/Users/jane/R&amp;D</string>",
+		"<key>Day</key>\n\t\t\t<integer>1</integer>\n\t\t\t<key>Hour</key>\n\t\t\t<integer>7</integer>\n\t\t\t<key>Minute</key>\n\t\t\t<integer>0</integer>",
+	} {
+		if !strings.Contains(f.Content, want) {
+			t.Errorf("plist missing %q:\n%s", want, f.Content)
+		}
+	}
+}
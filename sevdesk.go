@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// SevDeskConfig configures uploads of incoming vouchers to sevDesk.
+type SevDeskConfig struct {
+	Token string `yaml:"token"`
+	// AccountDatevID is the booking account of the voucher position
+	// (see GET /AccountDatev).
+	AccountDatevID string `yaml:"account_datev_id"`
+	// TaxRuleID defaults to 9, "Vorsteuerabziehbare Aufwendungen".
+	TaxRuleID string  `yaml:"tax_rule_id"`
+	TaxRate   float64 `yaml:"tax_rate"`
+	Supplier  string  `yaml:"supplier"`
+	APIURL    string  `yaml:"api_url"`
+}
+
+// sevDeskSink creates a draft expense voucher per invoice with the PDF
+// attached, using the voucher factory endpoints.
+type sevDeskSink struct {
+	cfg    SevDeskConfig
+	client *http.Client
+	SinkProgress
+}
+
+// newSevDeskSink applies defaults and checks required settings.
+func newSevDeskSink(cfg SevDeskConfig) (*sevDeskSink, error) {
+	if cfg.AccountDatevID == "" {
+		return nil, fmt.Errorf("sevdesk: account_datev_id is required")
+	}
+	if cfg.TaxRuleID == "" {
+		cfg.TaxRuleID = "9"
+	}
+	if cfg.TaxRate == 0 {
+		cfg.TaxRate = 19
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = "https://my.sevdesk.de/api/v1"
+	}
+	cfg.APIURL = strings.TrimRight(cfg.APIURL, "/")
+	return &sevDeskSink{cfg: cfg, client: newAPIClient()}, nil
+}
+
+func (s *sevDeskSink) Name() string { return "sevdesk" }
+
+func (s *sevDeskSink) Deliver(ctx context.Context, d *Delivery) error {
+	for _, inv := range d.Invoices {
+		if s.isDone(invoiceKey(inv)) {
+			continue
+		}
+		filename, err := s.uploadTempFile(ctx, inv)
+		if err != nil {
+			return fmt.Errorf("sevdesk: uploading %s: %w", inv.Filename, err)
+		}
+		if err := s.saveVoucher(ctx, inv, filename); err != nil {
+			return fmt.Errorf("sevdesk: saving voucher for %s: %w", inv.Filename, err)
+		}
+		s.markDone(invoiceKey(inv))
+		slog.Info("Created sevDesk voucher", "file", inv.Filename+".pdf")
+	}
+	return nil
+}
+
+// uploadTempFile uploads the PDF and returns the temporary file name to
+// reference when saving the voucher.
+func (s *sevDeskSink) uploadTempFile(ctx context.Context, inv ProcessedInvoice) (string, error) {
+	data, err := inv.readPDF()
+	if err != nil {
+		return "", err
+	}
+	body, contentType, err := newMultipartBody(nil, []multipartFile{
+		{Field: "file", Filename: inv.Filename + ".pdf", ContentType: "application/pdf", Data: data},
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.APIURL+"/Voucher/Factory/uploadTempFile", body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", s.cfg.Token)
+	req.Header.Set("Content-Type", contentType)
+	var resp struct {
+		Objects struct {
+			Filename string `json:"filename"`
+		} `json:"objects"`
+	}
+	if err := doRequest(s.client, req, &resp); err != nil {
+		return "", err
+	}
+	return resp.Objects.Filename, nil
+}
+
+// sevDeskRef references another sevDesk object by ID.
+type sevDeskRef struct {
+	ID         string `json:"id"`
+	ObjectName string `json:"objectName"`
+}
+
+// newSevDeskVoucher builds the saveVoucher payload for a draft expense
+// voucher with a single gross position.
+func newSevDeskVoucher(inv ProcessedInvoice, cfg SevDeskConfig, filename string) map[string]any {
+	description := inv.InvoiceNumber
+	if description == "" {
+		description = inv.OrderNumber
+	}
+	currency := inv.Total.Currency
+	if currency == "" {
+		currency = "EUR"
+	}
+	return map[string]any{
+		"voucher": map[string]any{
+			"objectName":   "Voucher",
+			"mapAll":       true,
+			"voucherDate":  inv.Email.Date.Format("2006-01-02"),
+			"supplierName": counterparty(cfg.Supplier, inv),
+			"description":  description,
+			"status":       50, // draft
+			"creditDebit":  "C",
+			"voucherType":  "VOU",
+			"currency":     currency,
+			"taxRule":      sevDeskRef{ID: cfg.TaxRuleID, ObjectName: "TaxRule"},
+		},
+		"voucherPosSave": []map[string]any{{
+			"objectName":   "VoucherPos",
+			"mapAll":       true,
+			"accountDatev": sevDeskRef{ID: cfg.AccountDatevID, ObjectName: "AccountDatev"},
+			"taxRate":      cfg.TaxRate,
+			"net":          false,
+			"sumGross":     inv.Total.Decimal(),
+			"comment":      strings.TrimSpace(inv.vendor().Title + " " + inv.OrderNumber),
+		}},
+		"voucherPosDelete": nil,
+		"filename":         filename,
+	}
+}
+
+func (s *sevDeskSink) saveVoucher(ctx context.Context, inv ProcessedInvoice, filename string) error {
+	header := http.Header{"Authorization": {s.cfg.Token}}
+	payload := newSevDeskVoucher(inv, s.cfg, filename)
+	return doJSON(ctx, s.client, http.MethodPost, s.cfg.APIURL+"/Voucher/Factory/saveVoucher", header, payload, nil)
+}
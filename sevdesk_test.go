@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSevDeskSink_Deliver(t *testing.T) {
+	var saved struct {
+		Voucher        map[string]any   `json:"voucher"`
+		VoucherPosSave []map[string]any `json:"voucherPosSave"`
+		Filename       string           `json:"filename"`
+	}
+	var uploaded string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/Voucher/Factory/uploadTempFile":
+			f, h, _ := r.FormFile("file")
+			data, _ := io.ReadAll(f)
+			uploaded = h.Filename + ":" + string(data)
+			json.NewEncoder(w).Encode(map[string]any{"objects": map[string]string{"filename": "tmp123.pdf"}})
+		case "/Voucher/Factory/saveVoucher":
+			json.NewDecoder(r.Body).Decode(&saved)
+			w.Write([]byte(`{"objects":{}}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	sink, err := newSevDeskSink(SevDeskConfig{Token: "tok", AccountDatevID: "42", APIURL: srv.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	inv := testProcessedInvoice()
+	inv.Filename = "invoice"
+	inv.PDF = []byte("%PDF")
+	if err := sink.Deliver(context.Background(), &Delivery{Invoices: []ProcessedInvoice{inv}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uploaded != "invoice.pdf:%PDF" {
+		t.Errorf("uploaded = %q", uploaded)
+	}
+	if saved.Filename != "tmp123.pdf" {
+		t.Errorf("filename = %q", saved.Filename)
+	}
+	v := saved.Voucher
+	if v["voucherDate"] != "2024-05-14" || v["supplierName"] != "Apple" || v["description"] != "DE/2024/0815" || v["creditDebit"] != "C" {
+		t.Errorf("voucher = %v", v)
+	}
+	pos := saved.VoucherPosSave[0]
+	if pos["sumGross"] != 9.99 || pos["taxRate"] != 19.0 || pos["accountDatev"].(map[string]any)["id"] != "42" {
+		t.Errorf("position = %v", pos)
+	}
+}
+
+func TestNewSevDeskSink_RequiresAccount(t *testing.T) {
+	if _, err := newSevDeskSink(SevDeskConfig{Token: "tok"}); err == nil {
+		t.Error("expected error without account_datev_id")
+	}
+}
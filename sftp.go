@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPConfig configures uploads to an SFTP server.
+type SFTPConfig struct {
+	Host          string `yaml:"host"`
+	Port          int    `yaml:"port"`
+	User          string `yaml:"user"`
+	Password      string `yaml:"password"`
+	KeyFile       string `yaml:"key_file"`
+	KeyPassphrase string `yaml:"key_passphrase"`
+	KnownHosts    string `yaml:"known_hosts"`
+	// InsecureIgnoreHostKey disables host key verification (testing only).
+	InsecureIgnoreHostKey bool   `yaml:"insecure_ignore_host_key"`
+	Path                  string `yaml:"path"`
+	// Sanitize replaces filename.sanitize for the remote paths if set.
+	Sanitize *SanitizePolicy `yaml:"sanitize"`
+}
+
+// sftpSink uploads every attachment below a templated remote directory.
+type sftpSink struct {
+	cfg      SFTPConfig
+	dir      *template.Template
+	sanitize SanitizePolicy
+	now      func() time.Time
+}
+
+// newSFTPSink applies defaults and parses the remote path template.
+func newSFTPSink(cfg SFTPConfig, sanitize SanitizePolicy) (*sftpSink, error) {
+	if cfg.Port == 0 {
+		cfg.Port = 22
+	}
+	if cfg.KeyFile == "" && cfg.Password == "" {
+		return nil, fmt.Errorf("sftp: key_file or password is required")
+	}
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: parsing path template: %w", err)
+	}
+	return &sftpSink{cfg: cfg, dir: tmpl, sanitize: sanitize, now: time.Now}, nil
+}
+
+func (s *sftpSink) Name() string { return "sftp" }
+
+// sftpIOTimeout bounds every read and write on the connection, so a
+// server that stops responding fails the upload instead of hanging it.
+const sftpIOTimeout = time.Minute
+
+// deadlineConn sets a fresh deadline before every read and write.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c deadlineConn) Read(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c deadlineConn) Write(b []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
+
+func (s *sftpSink) Deliver(ctx context.Context, d *Delivery) error {
+	conf, err := s.clientConfig()
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("sftp: connecting to %s: %w", addr, err)
+	}
+	defer conn.Close()
+	// Closing the connection aborts whatever step is waiting on it
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if err := s.upload(deadlineConn{Conn: conn, timeout: sftpIOTimeout}, addr, conf, d); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("sftp: %w", ctx.Err())
+		}
+		return err
+	}
+	return nil
+}
+
+// upload performs the SSH handshake on conn and uploads the attachments.
+func (s *sftpSink) upload(conn net.Conn, addr string, conf *ssh.ClientConfig, d *Delivery) error {
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, conf)
+	if err != nil {
+		return fmt.Errorf("sftp: SSH handshake: %w", err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("sftp: opening session: %w", err)
+	}
+	defer session.Close()
+	w, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return fmt.Errorf("sftp: requesting subsystem: %w", err)
+	}
+	sc, err := newSFTPClient(r, w)
+	if err != nil {
+		return err
+	}
+
+	for _, att := range d.Attachments {
+		remote, err := remotePath(s.dir, att, s.now(), s.sanitize)
+		if err != nil {
+			return fmt.Errorf("sftp: %w", err)
+		}
+		data, err := att.content()
+		if err != nil {
+			return fmt.Errorf("sftp: reading %s: %w", att.Filename, err)
+		}
+		sc.mkdirAll(path.Dir(remote))
+		if err := sc.writeFile(remote, data); err != nil {
+			return fmt.Errorf("sftp: uploading %s: %w", remote, err)
+		}
+		slog.Info("Uploaded file via SFTP", "path", remote, "host", s.cfg.Host)
+	}
+	return nil
+}
+
+// clientConfig builds the SSH client config with key and/or password auth
+// and known_hosts verification.
+func (s *sftpSink) clientConfig() (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+	if s.cfg.KeyFile != "" {
+		pem, err := os.ReadFile(s.cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("sftp: reading key file: %w", err)
+		}
+		var signer ssh.Signer
+		if s.cfg.KeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(s.cfg.KeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pem)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("sftp: parsing key file: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if s.cfg.Password != "" {
+		auth = append(auth, ssh.Password(s.cfg.Password))
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if !s.cfg.InsecureIgnoreHostKey {
+		file := s.cfg.KnownHosts
+		if file == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("sftp: locating known_hosts: %w", err)
+			}
+			file = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		cb, err := knownhosts.New(file)
+		if err != nil {
+			return nil, fmt.Errorf("sftp: loading known_hosts: %w", err)
+		}
+		hostKeyCallback = cb
+	}
+	return &ssh.ClientConfig{
+		User:            s.cfg.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}, nil
+}
+
+// SFTP protocol version 3 packet types and flags used by sftpClient
+// (draft-ietf-secsh-filexfer-02).
+const (
+	sshFxpInit    = 1
+	sshFxpVersion = 2
+	sshFxpOpen    = 3
+	sshFxpClose   = 4
+	sshFxpWrite   = 6
+	sshFxpMkdir   = 14
+	sshFxpStatus  = 101
+	sshFxpHandle  = 102
+
+	sshFxfWrite = 0x02
+	sshFxfCreat = 0x08
+	sshFxfTrunc = 0x10
+
+	sftpChunkSize = 32 * 1024
+)
+
+// sftpClient is a minimal sequential SFTP v3 client supporting just enough
+// of the protocol to create directories and upload files.
+type sftpClient struct {
+	r      io.Reader
+	w      io.Writer
+	nextID uint32
+}
+
+// newSFTPClient performs the version handshake on an sftp subsystem stream.
+func newSFTPClient(r io.Reader, w io.Writer) (*sftpClient, error) {
+	c := &sftpClient{r: r, w: w}
+	if err := c.send(sshFxpInit, binary.BigEndian.AppendUint32(nil, 3)); err != nil {
+		return nil, fmt.Errorf("sftp: init: %w", err)
+	}
+	typ, _, err := c.recv()
+	if err != nil {
+		return nil, fmt.Errorf("sftp: init: %w", err)
+	}
+	if typ != sshFxpVersion {
+		return nil, fmt.Errorf("sftp: init: unexpected packet type %d", typ)
+	}
+	return c, nil
+}
+
+// send writes a packet: uint32 length, byte type, payload.
+func (c *sftpClient) send(typ byte, payload []byte) error {
+	pkt := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+1))
+	pkt = append(pkt, typ)
+	_, err := c.w.Write(append(pkt, payload...))
+	return err
+}
+
+// recv reads a packet and returns its type and payload.
+func (c *sftpClient) recv() (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(hdr[:4])
+	if length < 1 || length > 256*1024 {
+		return 0, nil, fmt.Errorf("invalid packet length %d", length)
+	}
+	payload := make([]byte, length-1)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	return hdr[4], payload, nil
+}
+
+// request sends a packet with a fresh request ID prepended to payload and
+// returns the response type and payload (without the ID).
+func (c *sftpClient) request(typ byte, payload []byte) (byte, []byte, error) {
+	c.nextID++
+	if err := c.send(typ, append(binary.BigEndian.AppendUint32(nil, c.nextID), payload...)); err != nil {
+		return 0, nil, err
+	}
+	respType, resp, err := c.recv()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(resp) < 4 || binary.BigEndian.Uint32(resp) != c.nextID {
+		return 0, nil, errors.New("response ID mismatch")
+	}
+	return respType, resp[4:], nil
+}
+
+// statusError converts an SSH_FXP_STATUS payload into an error, nil for SSH_FX_OK.
+func statusError(payload []byte) error {
+	if len(payload) < 4 {
+		return errors.New("malformed status")
+	}
+	code := binary.BigEndian.Uint32(payload)
+	if code == 0 {
+		return nil
+	}
+	msg, _ := sftpString(payload[4:])
+	return fmt.Errorf("status %d: %s", code, msg)
+}
+
+// expectStatus sends a request that is answered with a status packet.
+func (c *sftpClient) expectStatus(typ byte, payload []byte) error {
+	respType, resp, err := c.request(typ, payload)
+	if err != nil {
+		return err
+	}
+	if respType != sshFxpStatus {
+		return fmt.Errorf("unexpected packet type %d", respType)
+	}
+	return statusError(resp)
+}
+
+// mkdirAll creates dir and its parents. Errors are only logged at debug
+// level since most servers report existing directories as a generic
+// failure; a missing directory surfaces when the file is opened.
+func (c *sftpClient) mkdirAll(dir string) {
+	if dir == "." || dir == "/" || dir == "" {
+		return
+	}
+	var current string
+	if strings.HasPrefix(dir, "/") {
+		current = "/"
+	}
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		current = path.Join(current, part)
+		if err := c.expectStatus(sshFxpMkdir, append(appendSFTPString(nil, current), 0, 0, 0, 0)); err != nil {
+			slog.Debug("Creating SFTP directory failed", "dir", current, "err", err)
+		}
+	}
+}
+
+// writeFile creates or truncates name and writes data to it.
+func (c *sftpClient) writeFile(name string, data []byte) error {
+	payload := appendSFTPString(nil, name)
+	payload = binary.BigEndian.AppendUint32(payload, sshFxfWrite|sshFxfCreat|sshFxfTrunc)
+	payload = binary.BigEndian.AppendUint32(payload, 0) // no attributes
+	respType, resp, err := c.request(sshFxpOpen, payload)
+	if err != nil {
+		return err
+	}
+	if respType == sshFxpStatus {
+		return statusError(resp)
+	}
+	if respType != sshFxpHandle {
+		return fmt.Errorf("unexpected packet type %d", respType)
+	}
+	handle, _ := sftpString(resp)
+
+	for offset := 0; offset < len(data); offset += sftpChunkSize {
+		chunk := data[offset:min(offset+sftpChunkSize, len(data))]
+		payload := appendSFTPString(nil, handle)
+		payload = binary.BigEndian.AppendUint64(payload, uint64(offset))
+		payload = appendSFTPString(payload, string(chunk))
+		if err := c.expectStatus(sshFxpWrite, payload); err != nil {
+			c.expectStatus(sshFxpClose, appendSFTPString(nil, handle))
+			return err
+		}
+	}
+	return c.expectStatus(sshFxpClose, appendSFTPString(nil, handle))
+}
+
+// appendSFTPString appends a uint32 length-prefixed string.
+func appendSFTPString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// sftpString decodes a uint32 length-prefixed string.
+func sftpString(b []byte) (string, []byte) {
+	if len(b) < 4 {
+		return "", nil
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < n {
+		return "", nil
+	}
+	return string(b[4 : 4+n]), b[4+n:]
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSFTPServer implements the server side of the packets sftpClient uses,
+// storing files and directories in memory.
+type fakeSFTPServer struct {
+	r       io.Reader
+	w       io.Writer
+	dirs    map[string]bool
+	files   map[string][]byte
+	handles map[string]string
+}
+
+func (s *fakeSFTPServer) serve() {
+	c := &sftpClient{r: s.r, w: s.w}
+	for {
+		typ, payload, err := c.recv()
+		if err != nil {
+			return
+		}
+		if typ == sshFxpInit {
+			c.send(sshFxpVersion, binary.BigEndian.AppendUint32(nil, 3))
+			continue
+		}
+		id, payload := payload[:4], payload[4:]
+		status := func(code uint32) {
+			resp := append(append([]byte{}, id...), binary.BigEndian.AppendUint32(nil, code)...)
+			resp = appendSFTPString(appendSFTPString(resp, "msg"), "")
+			c.send(sshFxpStatus, resp)
+		}
+		switch typ {
+		case sshFxpMkdir:
+			name, _ := sftpString(payload)
+			if s.dirs[name] {
+				status(4) // SSH_FX_FAILURE
+				continue
+			}
+			s.dirs[name] = true
+			status(0)
+		case sshFxpOpen:
+			name, _ := sftpString(payload)
+			dir := name[:strings.LastIndex(name, "/")]
+			if dir != "" && !s.dirs[dir] {
+				status(2) // SSH_FX_NO_SUCH_FILE
+				continue
+			}
+			handle := "h" + name
+			s.handles[handle] = name
+			s.files[name] = nil
+			c.send(sshFxpHandle, appendSFTPString(append([]byte{}, id...), handle))
+		case sshFxpWrite:
+			handle, rest := sftpString(payload)
+			offset := binary.BigEndian.Uint64(rest)
+			data, _ := sftpString(rest[8:])
+			name := s.handles[handle]
+			if int(offset) != len(s.files[name]) {
+				status(4)
+				continue
+			}
+			s.files[name] = append(s.files[name], data...)
+			status(0)
+		case sshFxpClose:
+			status(0)
+		default:
+			status(8) // SSH_FX_OP_UNSUPPORTED
+		}
+	}
+}
+
+func TestSFTPClient_WriteFile(t *testing.T) {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	server := &fakeSFTPServer{r: serverR, w: serverW, dirs: map[string]bool{"/upload": true}, files: map[string][]byte{}, handles: map[string]string{}}
+	go server.serve()
+	defer clientW.Close()
+
+	c, err := newSFTPClient(clientR, clientW)
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+
+	data := []byte(strings.Repeat("x", sftpChunkSize*2+10))
+	c.mkdirAll("/upload/2024/05")
+	if err := c.writeFile("/upload/2024/05/a.pdf", data); err != nil {
+		t.Fatalf("writeFile: %v", err)
+	}
+	if got := server.files["/upload/2024/05/a.pdf"]; string(got) != string(data) {
+		t.Errorf("uploaded %d bytes, want %d", len(got), len(data))
+	}
+	if !server.dirs["/upload/2024"] || !server.dirs["/upload/2024/05"] {
+		t.Errorf("directories not created: %v", server.dirs)
+	}
+
+	err = c.writeFile("/missing/a.pdf", data)
+	if err == nil || !strings.Contains(err.Error(), "status 2") {
+		t.Errorf("expected no-such-file status, got %v", err)
+	}
+}
+
+func TestNewSFTPSink_Validation(t *testing.T) {
+	if _, err := newSFTPSink(SFTPConfig{Host: "h"}, SanitizePolicy{}); err == nil {
+		t.Error("expected error without key or password")
+	}
+	sink, err := newSFTPSink(SFTPConfig{Host: "h", Password: "p", Path: "/in/{{.Year}}"}, SanitizePolicy{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sink.cfg.Port != 22 {
+		t.Errorf("Port default = %d, want 22", sink.cfg.Port)
+	}
+}
+
+func TestSFTPSink_ClientConfigMissingKnownHosts(t *testing.T) {
+	sink, _ := newSFTPSink(SFTPConfig{Host: "h", Password: "p", KnownHosts: "/nonexistent/known_hosts"}, SanitizePolicy{})
+	if _, err := sink.clientConfig(); err == nil {
+		t.Error("expected error for missing known_hosts")
+	}
+	sink.cfg.InsecureIgnoreHostKey = true
+	if _, err := sink.clientConfig(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSFTPSink_CancelStalledServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// Accept, but never answer the SSH handshake
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	s, err := newSFTPSink(SFTPConfig{Host: "127.0.0.1", Port: addr.Port, Password: "secret", InsecureIgnoreHostKey: true}, SanitizePolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = s.Deliver(ctx, &Delivery{})
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 5*time.Second {
+		t.Errorf("Deliver = %v after %s, want prompt context error", err, time.Since(start))
+	}
+}
+
+func TestDeadlineConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := deadlineConn{Conn: client, timeout: 10 * time.Millisecond}
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read = %v, want deadline exceeded", err)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rummeyer/apple-invoice-pdf/pkg/invoice"
+)
+
+// Delivery is the result of a run handed to every sink: the processed
+// invoices plus all files to deliver (cover page, PDFs, sources, manifest).
+type Delivery struct {
+	Invoices    []ProcessedInvoice
+	Attachments []PDFAttachment
+}
+
+// Summary returns a short plain-text overview of the delivered invoices
+// for chat and notification sinks.
+func (d *Delivery) Summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d %s(en)", len(d.Invoices), invoiceNoun(vendorTitle(d.Invoices)))
+	amounts := make([]Amount, 0, len(d.Invoices))
+	for _, inv := range d.Invoices {
+		amounts = append(amounts, inv.Total)
+	}
+	if total, ok := invoice.SumAmounts(amounts); ok {
+		fmt.Fprintf(&b, ", Summe %s", total)
+	}
+	for _, inv := range d.Invoices {
+		line := strings.TrimSpace(strings.Join([]string{inv.Email.Date.Format("02.01.2006"), inv.OrderNumber, inv.Total.String()}, " "))
+		fmt.Fprintf(&b, "\n• %s", strings.Join(strings.Fields(line), " "))
+	}
+	return b.String()
+}
+
+// Sink delivers the generated files to a destination.
+type Sink interface {
+	// Name identifies the sink in log output.
+	Name() string
+	Deliver(ctx context.Context, d *Delivery) error
+}
+
+// InvoiceMetadata is the machine-readable description of a processed
+// invoice, used for sidecar files and API payloads.
+type InvoiceMetadata struct {
+	Filename      string    `json:"filename"`
+	Subject       string    `json:"subject"`
+	Date          time.Time `json:"date"`
+	MessageID     string    `json:"message_id,omitempty"`
+	OrderNumber   string    `json:"order_number,omitempty"`
+	InvoiceNumber string    `json:"invoice_number,omitempty"`
+	AppleID       string    `json:"apple_id,omitempty"`
+	Total         string    `json:"total,omitempty"`
+	TotalCents    int64     `json:"total_cents,omitempty"`
+	Currency      string    `json:"currency,omitempty"`
+}
+
+// Metadata returns the invoice's machine-readable description.
+func (p ProcessedInvoice) Metadata() InvoiceMetadata {
+	return InvoiceMetadata{
+		Filename:      p.Filename + ".pdf",
+		Subject:       p.Email.Subject,
+		Date:          p.Email.Date,
+		MessageID:     p.Email.MessageID,
+		OrderNumber:   p.OrderNumber,
+		InvoiceNumber: p.InvoiceNumber,
+		AppleID:       p.AppleID,
+		Total:         p.Total.String(),
+		TotalCents:    p.Total.Cents,
+		Currency:      p.Total.Currency,
+	}
+}
+
+// remotePath renders a directory template for an attachment and appends the
+// filename. The template receives the filename placeholders of the source
+// invoice; attachments without one (cover page, manifest) use now.
+func remotePath(dir *template.Template, att PDFAttachment, now time.Time, policy SanitizePolicy) (string, error) {
+	inv := ProcessedInvoice{Email: InvoiceEmail{Date: now}}
+	if att.Invoice != nil {
+		inv = *att.Invoice
+	}
+	prefix, err := executeTemplate(dir, newFilenameData(inv, policy))
+	if err != nil {
+		return "", err
+	}
+	return path.Join(prefix, att.Filename), nil
+}
+
+// buildSinks returns the sinks enabled in the config.
+func buildSinks(cfg *Config) ([]Sink, error) {
+	var sinks []Sink
+	if cfg.Output.Dir != "" {
+		sinks = append(sinks, &dirSink{dir: cfg.Output.Dir, sidecars: cfg.Output.Sidecars, html: cfg.Output.HTML})
+	}
+	if cfg.S3.Bucket != "" {
+		s3, err := newS3Sink(cfg.S3, cfg.Filename.Sanitize.override(cfg.S3.Sanitize))
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s3)
+	}
+	if cfg.Paperless.URL != "" {
+		paperless, err := newPaperlessSink(cfg.Paperless)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, paperless)
+	}
+	if cfg.SFTP.Host != "" {
+		sftp, err := newSFTPSink(cfg.SFTP, cfg.Filename.Sanitize.override(cfg.SFTP.Sanitize))
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sftp)
+	}
+	if cfg.FTPS.Host != "" {
+		ftps, err := newFTPSSink(cfg.FTPS, cfg.Filename.Sanitize.override(cfg.FTPS.Sanitize))
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, ftps)
+	}
+	if cfg.Lexoffice.APIKey != "" {
+		sinks = append(sinks, newLexofficeSink(cfg.Lexoffice))
+	}
+	if cfg.SevDesk.Token != "" {
+		sevDesk, err := newSevDeskSink(cfg.SevDesk)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sevDesk)
+	}
+	if cfg.QuickBooks.ClientID != "" {
+		quickBooks, err := newQuickBooksSink(cfg.QuickBooks)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, quickBooks)
+	}
+	if cfg.Xero.ClientID != "" {
+		xero, err := newXeroSink(cfg.Xero)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, xero)
+	}
+	if cfg.Firefly.URL != "" {
+		firefly, err := newFireflySink(cfg.Firefly)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, firefly)
+	}
+	if cfg.YNAB.Token != "" {
+		ynab, err := newYNABSink(cfg.YNAB)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, ynab)
+	}
+	if cfg.Actual.URL != "" {
+		actual, err := newActualSink(cfg.Actual)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, actual)
+	}
+	if cfg.Webhook.URL != "" {
+		sinks = append(sinks, &webhookSink{cfg: cfg.Webhook, client: newAPIClient()})
+	}
+	if cfg.Telegram.Token != "" {
+		telegram, err := newTelegramSink(cfg.Telegram)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, telegram)
+	}
+	if cfg.Slack.Token != "" {
+		slack, err := newSlackSink(cfg.Slack)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, slack)
+	}
+	if cfg.Matrix.Homeserver != "" {
+		matrix, err := newMatrixSink(cfg.Matrix)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, matrix)
+	}
+	if cfg.Ntfy.Topic != "" {
+		sinks = append(sinks, newNtfySink(cfg.Ntfy))
+	}
+	if cfg.IMAPAppend.Folder != "" {
+		sinks = append(sinks, newIMAPAppendSink(cfg))
+	}
+	sinks = append(sinks, pluginSinks(cfg)...)
+	if cfg.Email.To != "" {
+		sinks = append(sinks, &emailSink{cfg: cfg})
+	}
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("no delivery target configured (set email.to, output.dir, one of the upload targets or a sink plugin)")
+	}
+	return sinks, nil
+}
+
+// emailSink sends all attachments in a single email via SMTP or sendmail,
+// or one email per invoice if configured.
+type emailSink struct {
+	cfg *Config
+	// SinkProgress records the messages of a per-invoice delivery that
+	// already went out.
+	SinkProgress
+}
+
+func (s *emailSink) Name() string { return "email" }
+
+// retryConfig applies smtp.retry on top of the delivery defaults.
+func (s *emailSink) retryConfig(defaults DeliveryConfig) DeliveryConfig {
+	if s.cfg.SMTP.Retry.Attempts != 0 {
+		defaults.Attempts = s.cfg.SMTP.Retry.Attempts
+	}
+	if s.cfg.SMTP.Retry.RetryDelay != 0 {
+		defaults.RetryDelay = s.cfg.SMTP.Retry.RetryDelay
+	}
+	return defaults
+}
+
+func (s *emailSink) Deliver(ctx context.Context, d *Delivery) error {
+	if !s.cfg.Email.PerInvoice {
+		return s.send(ctx, d)
+	}
+	for i, part := range splitDelivery(d) {
+		// The last part holds the files not tied to an invoice
+		key := summaryKey(d)
+		if i < len(d.Invoices) {
+			key = invoiceKey(d.Invoices[i])
+		}
+		if s.isDone(key) {
+			continue
+		}
+		if err := s.send(ctx, part); err != nil {
+			return err
+		}
+		s.markDone(key)
+	}
+	return nil
+}
+
+// send delivers d as a single email.
+func (s *emailSink) send(ctx context.Context, d *Delivery) error {
+	attachments, err := emailAttachments(s.cfg, d)
+	if err != nil {
+		return err
+	}
+	slog.Info("Sending email", "attachments", len(attachments))
+	if err := sendPDFEmail(ctx, s.cfg, d.Invoices, attachments); err != nil {
+		return err
+	}
+	slog.Info("Email sent", "attachments", len(attachments), "to", s.cfg.Email.To)
+	return nil
+}
+
+// splitDelivery returns one delivery per invoice with its attachments,
+// followed by one with the files not tied to an invoice (cover page,
+// manifest), if any.
+func splitDelivery(d *Delivery) []*Delivery {
+	parts := make([]*Delivery, len(d.Invoices))
+	for i := range d.Invoices {
+		parts[i] = &Delivery{Invoices: d.Invoices[i : i+1]}
+	}
+	rest := &Delivery{Invoices: d.Invoices}
+	for _, att := range d.Attachments {
+		if att.Invoice == nil {
+			rest.Attachments = append(rest.Attachments, att)
+			continue
+		}
+		for i := range d.Invoices {
+			if att.Invoice == &d.Invoices[i] {
+				parts[i].Attachments = append(parts[i].Attachments, att)
+				break
+			}
+		}
+	}
+	if len(rest.Attachments) > 0 {
+		parts = append(parts, rest)
+	}
+	return parts
+}
+
+// emailAttachments returns the files to attach to a message, bundled into a
+// single ZIP if configured.
+func emailAttachments(cfg *Config, d *Delivery) ([]PDFAttachment, error) {
+	if !cfg.Email.ZIP && cfg.Email.ZIPPassword == "" {
+		return d.Attachments, nil
+	}
+	bundle, err := bundleAttachments(d, cfg.Email.ZIPPassword, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Bundled attachments", "count", len(d.Attachments), "file", bundle.Filename)
+	return []PDFAttachment{bundle}, nil
+}
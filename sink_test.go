@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildSinks(t *testing.T) {
+	cfg := &Config{}
+	if _, err := buildSinks(cfg); err == nil {
+		t.Error("expected error without any delivery target")
+	}
+
+	cfg.Email.To = "recipient@example.com"
+	cfg.Output.Dir = "/tmp/invoices"
+	sinks, err := buildSinks(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, s := range sinks {
+		names = append(names, s.Name())
+	}
+	if len(names) != 2 || names[0] != "output directory" || names[1] != "email" {
+		t.Errorf("sinks = %v, want [output directory email]", names)
+	}
+}
+
+func TestBuildSinks_TargetSanitize(t *testing.T) {
+	cfg := &Config{}
+	cfg.Filename.Sanitize = SanitizePolicy{Charset: "unicode"}
+	cfg.S3 = S3Config{Bucket: "invoices", Sanitize: &SanitizePolicy{Charset: "ascii", MaxLength: 20}}
+	cfg.SFTP = SFTPConfig{Host: "sftp.example.com", Password: "secret"}
+	sinks, err := buildSinks(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := sinks[0].(*s3Sink).sanitize; got != *cfg.S3.Sanitize {
+		t.Errorf("s3 sanitize = %+v, want its own", got)
+	}
+	if got := sinks[1].(*sftpSink).sanitize; got != cfg.Filename.Sanitize {
+		t.Errorf("sftp sanitize = %+v, want filename.sanitize", got)
+	}
+}
+
+func TestProcessedInvoiceMetadata(t *testing.T) {
+	md := testProcessedInvoice().Metadata()
+	if md.Filename != ".pdf" || md.OrderNumber != "MXYZ123" || md.AppleID != "jane@example.com" {
+		t.Errorf("unexpected metadata: %+v", md)
+	}
+	if md.Total != "9,99 €" || md.TotalCents != 999 || md.Currency != "EUR" {
+		t.Errorf("total = %q/%d/%q, want 9,99 €/999/EUR", md.Total, md.TotalCents, md.Currency)
+	}
+}
+
+func TestDeliverySummary(t *testing.T) {
+	a, b := testProcessedInvoice(), testProcessedInvoice()
+	b.OrderNumber = ""
+	b.Total = Amount{Cents: 1998, Currency: "EUR"}
+	d := &Delivery{Invoices: []ProcessedInvoice{a, b}}
+	want := "2 Apple-Rechnung(en), Summe 29,97 €\n• 14.05.2024 MXYZ123 9,99 €\n• 14.05.2024 19,98 €"
+	if got := d.Summary(); got != want {
+		t.Errorf("Summary() =\n%s\nwant\n%s", got, want)
+	}
+
+	b.Total = Amount{}
+	d = &Delivery{Invoices: []ProcessedInvoice{a, b}}
+	if got := d.Summary(); strings.Contains(got, "Summe") {
+		t.Errorf("Summary() with unknown amount should omit the total: %s", got)
+	}
+}
+
+func TestSplitDelivery(t *testing.T) {
+	invoices := []ProcessedInvoice{testProcessedInvoice(), testProcessedInvoice()}
+	d := &Delivery{Invoices: invoices, Attachments: []PDFAttachment{
+		{Filename: "cover.pdf"},
+		{Filename: "a.pdf", Invoice: &invoices[0]},
+		{Filename: "a.eml", Invoice: &invoices[0]},
+		{Filename: "b.pdf", Invoice: &invoices[1]},
+	}}
+	parts := splitDelivery(d)
+	var got []string
+	for _, p := range parts {
+		var names []string
+		for _, att := range p.Attachments {
+			names = append(names, att.Filename)
+		}
+		got = append(got, fmt.Sprintf("%d:%s", len(p.Invoices), strings.Join(names, ",")))
+	}
+	want := "1:a.pdf,a.eml 1:b.pdf 2:cover.pdf"
+	if strings.Join(got, " ") != want {
+		t.Errorf("splitDelivery = %v, want %s", got, want)
+	}
+}
+
+func TestEmailSink_PerInvoiceSkipsSentOnRetry(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "sendmail")
+	// Fails on the second call only, recording every call
+	os.WriteFile(script, []byte("#!/bin/sh\ncat >/dev/null\necho x >> "+dir+"/calls\n[ $(wc -l < "+dir+"/calls) -ne 2 ]\n"), 0755)
+
+	cfg := &Config{Sendmail: SendmailConfig{Command: script}}
+	cfg.Email.From, cfg.Email.To = "jane@example.com", "books@example.com"
+	cfg.Email.PerInvoice = true
+	invoices := []ProcessedInvoice{testProcessedInvoice(), testProcessedInvoice()}
+	invoices[0].Email.MessageID, invoices[1].Email.MessageID = "<a@apple.com>", "<b@apple.com>"
+	d := &Delivery{Invoices: invoices, Attachments: []PDFAttachment{
+		{Filename: "a.pdf", Data: []byte("a"), Invoice: &invoices[0]},
+		{Filename: "b.pdf", Data: []byte("b"), Invoice: &invoices[1]},
+	}}
+	sink := &emailSink{cfg: cfg}
+	if err := sink.Deliver(context.Background(), d); err == nil {
+		t.Fatal("expected the second message to fail")
+	}
+	if err := sink.Deliver(context.Background(), d); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	calls, _ := os.ReadFile(filepath.Join(dir, "calls"))
+	if n := strings.Count(string(calls), "x"); n != 3 {
+		t.Errorf("sendmail called %d times, want 3 (first message not resent)", n)
+	}
+}
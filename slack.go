@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SlackConfig configures uploads to a Slack channel.
+type SlackConfig struct {
+	Token   string `yaml:"token"`
+	Channel string `yaml:"channel"`
+	// APIURL overrides the Web API base URL (for testing).
+	APIURL string `yaml:"api_url"`
+}
+
+// slackSink uploads all invoice PDFs to a channel using Slack's external
+// upload flow (files.getUploadURLExternal + files.completeUploadExternal),
+// sharing them in one message with the run summary.
+type slackSink struct {
+	cfg    SlackConfig
+	client *http.Client
+	SinkProgress
+}
+
+// newSlackSink applies defaults and checks required settings.
+func newSlackSink(cfg SlackConfig) (*slackSink, error) {
+	if cfg.Channel == "" {
+		return nil, fmt.Errorf("slack: channel is required")
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = "https://slack.com/api"
+	}
+	cfg.APIURL = strings.TrimRight(cfg.APIURL, "/")
+	return &slackSink{cfg: cfg, client: newAPIClient()}, nil
+}
+
+func (s *slackSink) Name() string { return "slack" }
+
+// slackResponse is the common envelope of Slack Web API responses.
+type slackResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+func (s *slackSink) Deliver(ctx context.Context, d *Delivery) error {
+	type slackFile struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+	}
+	summary := summaryKey(d)
+	if s.isDone(summary) {
+		return nil
+	}
+	// Uploads stay invisible until shared, so a retry shares those of the
+	// failed attempt instead of uploading again
+	var files []slackFile
+	for _, inv := range d.Invoices {
+		id, ok := s.created(invoiceKey(inv))
+		if !ok {
+			data, err := inv.readPDF()
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", inv.Filename, err)
+			}
+			if id, err = s.upload(ctx, inv.Filename+".pdf", data); err != nil {
+				return fmt.Errorf("uploading %s: %w", inv.Filename, err)
+			}
+			s.setCreated(invoiceKey(inv), id)
+		}
+		files = append(files, slackFile{ID: id, Title: inv.Filename + ".pdf"})
+	}
+
+	req := map[string]any{
+		"files":           files,
+		"channel_id":      s.cfg.Channel,
+		"initial_comment": d.Summary(),
+	}
+	var resp slackResponse
+	if err := s.call(ctx, "files.completeUploadExternal", req, &resp); err != nil {
+		return err
+	}
+	s.markDone(summary)
+	slog.Info("Shared PDFs in Slack channel", "count", len(files), "channel", s.cfg.Channel)
+	return nil
+}
+
+// upload reserves an upload URL, sends the file content, and returns the file ID.
+func (s *slackSink) upload(ctx context.Context, filename string, data []byte) (string, error) {
+	form := url.Values{"filename": {filename}, "length": {strconv.Itoa(len(data))}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.APIURL+"/files.getUploadURLExternal", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+s.cfg.Token)
+	var reserved struct {
+		slackResponse
+		UploadURL string `json:"upload_url"`
+		FileID    string `json:"file_id"`
+	}
+	if err := doRequest(s.client, req, &reserved); err != nil {
+		return "", fmt.Errorf("files.getUploadURLExternal: %w", err)
+	}
+	if !reserved.OK {
+		return "", fmt.Errorf("files.getUploadURLExternal: %s", reserved.Error)
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, reserved.UploadURL, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/pdf")
+	if err := doRequest(s.client, req, nil); err != nil {
+		return "", fmt.Errorf("uploading content: %w", err)
+	}
+	return reserved.FileID, nil
+}
+
+// call invokes a JSON Web API method and checks the ok flag.
+func (s *slackSink) call(ctx context.Context, method string, in any, out *slackResponse) error {
+	header := http.Header{"Authorization": {"Bearer " + s.cfg.Token}}
+	if err := doJSON(ctx, s.client, http.MethodPost, s.cfg.APIURL+"/"+method, header, in, out); err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	if !out.OK {
+		return fmt.Errorf("%s: %s", method, out.Error)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSlackSink_Deliver(t *testing.T) {
+	uploads := map[string]string{}
+	var completed map[string]any
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/upload/F1" && r.Header.Get("Authorization") != "Bearer xoxb-test" {
+			json.NewEncoder(w).Encode(map[string]any{"ok": false, "error": "invalid_auth"})
+			return
+		}
+		switch r.URL.Path {
+		case "/files.getUploadURLExternal":
+			r.ParseForm()
+			if r.FormValue("length") != "4" {
+				t.Errorf("length = %q, want 4", r.FormValue("length"))
+			}
+			json.NewEncoder(w).Encode(map[string]any{"ok": true, "upload_url": srv.URL + "/upload/F1", "file_id": "F1"})
+		case "/upload/F1":
+			data, _ := io.ReadAll(r.Body)
+			uploads["F1"] = string(data)
+		case "/files.completeUploadExternal":
+			json.NewDecoder(r.Body).Decode(&completed)
+			json.NewEncoder(w).Encode(map[string]any{"ok": true})
+		}
+	}))
+	defer srv.Close()
+
+	sink, err := newSlackSink(SlackConfig{Token: "xoxb-test", Channel: "C123", APIURL: srv.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	inv := testProcessedInvoice()
+	inv.Filename = "invoice"
+	inv.PDF = []byte("%PDF")
+	if err := sink.Deliver(context.Background(), &Delivery{Invoices: []ProcessedInvoice{inv}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uploads["F1"] != "%PDF" {
+		t.Errorf("uploaded content = %q", uploads["F1"])
+	}
+	if completed["channel_id"] != "C123" {
+		t.Errorf("channel_id = %v", completed["channel_id"])
+	}
+	if comment, _ := completed["initial_comment"].(string); comment == "" {
+		t.Error("expected summary as initial comment")
+	}
+	if files := fmt.Sprint(completed["files"]); files != "[map[id:F1 title:invoice.pdf]]" {
+		t.Errorf("files = %s", files)
+	}
+}
+
+func TestSlackSink_APIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"ok": false, "error": "not_in_channel"})
+	}))
+	defer srv.Close()
+
+	sink, _ := newSlackSink(SlackConfig{Token: "t", Channel: "C1", APIURL: srv.URL})
+	err := sink.Deliver(context.Background(), &Delivery{Invoices: []ProcessedInvoice{testProcessedInvoice()}})
+	if err == nil || !contains(err.Error(), "not_in_channel") {
+		t.Errorf("expected not_in_channel error, got %v", err)
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/gomail.v2"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/deliver"
+)
+
+// SMTPConfig configures the SMTP submission server.
+type SMTPConfig struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// TLS selects "ssl" (TLS from the first byte, the default on port 465),
+	// "starttls" (upgrade required) or "none" (plaintext, for local relays).
+	// Empty upgrades with STARTTLS whenever the server offers it.
+	TLS                string `yaml:"tls"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	// CAFile is a PEM bundle trusted in addition to the system roots,
+	// e.g. for relays with certificates from a private CA.
+	CAFile string `yaml:"ca_file"`
+	// Auth selects the SASL mechanism: "plain" (user/pass, default) or
+	// "xoauth2" for Gmail and Microsoft 365 without app passwords.
+	Auth  string          `yaml:"auth"`
+	OAuth SMTPOAuthConfig `yaml:"oauth"`
+	// DirectMX delivers straight to the MX hosts of the recipient domains
+	// on port 25 for setups without a relay; Host, Port and Auth are
+	// ignored then.
+	DirectMX bool `yaml:"direct_mx"`
+	// HELO is the name sent with EHLO; defaults to "localhost", or the
+	// system host name with direct_mx.
+	HELO string     `yaml:"helo"`
+	DKIM DKIMConfig `yaml:"dkim"`
+	// Retry overrides the delivery retry settings for email, e.g. with a
+	// longer delay for servers that greylist.
+	Retry DeliveryConfig `yaml:"retry"`
+}
+
+// SMTPOAuthConfig provides the XOAUTH2 access token, either directly or by
+// refreshing it at the provider's token endpoint.
+type SMTPOAuthConfig struct {
+	OAuthConfig `yaml:",inline"`
+	TokenURL    string `yaml:"token_url"`
+	// AccessToken is used as is, e.g. when injected by an external helper.
+	AccessToken string `yaml:"access_token"`
+}
+
+// validate checks the TLS and authentication settings.
+func (c SMTPConfig) validate() error {
+	switch c.TLS {
+	case "", "ssl", "starttls", "none":
+	default:
+		return fmt.Errorf("unknown tls mode %q (use ssl, starttls or none)", c.TLS)
+	}
+	if c.DirectMX && c.TLS == "ssl" {
+		return errors.New("tls ssl is not supported with direct_mx (MX hosts use STARTTLS on port 25)")
+	}
+	if err := c.DKIM.validate(); err != nil {
+		return err
+	}
+	switch c.Auth {
+	case "", "plain":
+	case "xoauth2":
+		if c.OAuth.AccessToken == "" && (c.OAuth.TokenURL == "" || c.OAuth.ClientID == "") {
+			return fmt.Errorf("xoauth2 needs oauth.access_token or oauth.token_url, client_id and refresh_token")
+		}
+	default:
+		return fmt.Errorf("unknown auth %q (use plain or xoauth2)", c.Auth)
+	}
+	return nil
+}
+
+// smtpDialer connects to the submission server with the configured TLS
+// mode and sends gomail messages over net/smtp. Unlike gomail.Dialer it can
+// require STARTTLS or skip it entirely.
+type smtpDialer struct {
+	host     string
+	port     int
+	mode     string
+	tls      *tls.Config
+	username string
+	password string
+	helo     string
+	// auth overrides mechanism negotiation; nil picks one from the
+	// mechanisms the server advertises.
+	auth smtp.Auth
+	dkim *dkimSigner
+
+	directMX bool
+	mxPort   int
+	// policy is applied to the TLS configs of direct MX delivery, without
+	// the client certificate.
+	policy   TLSConfig
+	lookupMX func(ctx context.Context, domain string) ([]*net.MX, error)
+}
+
+// newSMTPDialer returns a dialer authenticating with the configured mechanism.
+func newSMTPDialer(ctx context.Context, cfg *Config) (*smtpDialer, error) {
+	tlsConf, err := smtpTLSConfig(cfg.SMTP, cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	d := &smtpDialer{
+		host:     cfg.SMTP.Host,
+		port:     cfg.SMTP.Port,
+		mode:     cfg.SMTP.TLS,
+		tls:      tlsConf,
+		policy:   cfg.TLS.public(),
+		username: cfg.User,
+		password: cfg.Pass,
+		helo:     cfg.SMTP.HELO,
+		directMX: cfg.SMTP.DirectMX,
+		mxPort:   25,
+		lookupMX: net.DefaultResolver.LookupMX,
+	}
+	if d.mode == "" && d.port == 465 {
+		d.mode = "ssl"
+	}
+	if d.directMX && d.helo == "" {
+		d.helo, _ = os.Hostname()
+	}
+	if d.dkim, err = newDKIMSigner(cfg.SMTP.DKIM); err != nil {
+		return nil, err
+	}
+	if cfg.SMTP.Auth == "xoauth2" {
+		token := cfg.SMTP.OAuth.AccessToken
+		if token == "" {
+			o := &oauthRefresher{cfg: cfg.SMTP.OAuth.OAuthConfig, tokenURL: cfg.SMTP.OAuth.TokenURL, client: newAPIClient()}
+			var err error
+			if token, err = o.accessToken(ctx); err != nil {
+				return nil, fmt.Errorf("SMTP OAuth: %w", err)
+			}
+		}
+		d.auth = &xoauth2Auth{username: cfg.User, token: token, host: cfg.SMTP.Host}
+	}
+	return d, nil
+}
+
+// smtpTLSConfig builds the TLS config for the SMTP connection from the TLS
+// policy, adding the CA bundle to the system roots.
+func smtpTLSConfig(cfg SMTPConfig, policy TLSConfig) (*tls.Config, error) {
+	conf := policy.apply(&tls.Config{ServerName: cfg.Host, InsecureSkipVerify: cfg.InsecureSkipVerify})
+	if cfg.CAFile != "" {
+		pool, err := loadCAFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("SMTP: %w", err)
+		}
+		conf.RootCAs = pool
+	}
+	return conf, nil
+}
+
+// dial connects, secures the connection according to the TLS mode and
+// authenticates if the server supports it.
+func (d *smtpDialer) dial(ctx context.Context) (*smtpSender, error) {
+	addr := net.JoinHostPort(d.host, strconv.Itoa(d.port))
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if d.mode == "ssl" {
+		conn = tls.Client(conn, d.tls)
+	}
+	c, err := smtp.NewClient(conn, d.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if d.helo != "" {
+		if err := c.Hello(d.helo); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	if d.mode == "" || d.mode == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(d.tls); err != nil {
+				c.Close()
+				return nil, err
+			}
+		} else if d.mode == "starttls" {
+			c.Close()
+			return nil, fmt.Errorf("%s does not offer STARTTLS", addr)
+		}
+	}
+
+	auth := d.auth
+	if auth == nil && d.username != "" {
+		if ok, mechs := c.Extension("AUTH"); ok {
+			auth = negotiateAuth(mechs, d.username, d.password, d.host)
+		}
+	}
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return &smtpSender{c: c, dkim: d.dkim}, nil
+}
+
+// DialAndSend opens a connection, sends the messages and closes it.
+func (d *smtpDialer) DialAndSend(ctx context.Context, m ...*gomail.Message) error {
+	return classifySMTPError(d.dialAndSend(ctx, m))
+}
+
+func (d *smtpDialer) dialAndSend(ctx context.Context, m []*gomail.Message) error {
+	if d.directMX {
+		for _, msg := range m {
+			if err := d.sendDirect(ctx, msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	s, err := d.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	for _, msg := range m {
+		from, to, err := envelope(msg)
+		if err != nil {
+			return err
+		}
+		if err := s.Send(from, to, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// classifySMTPError marks 5xx replies as permanent. 4xx replies (e.g.
+// greylisting) and network errors stay temporary and are retried.
+func classifySMTPError(err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return deliver.Permanent(err)
+	}
+	return err
+}
+
+// negotiateAuth picks a password mechanism from the server's AUTH list,
+// preferring CRAM-MD5 and using LOGIN only for servers without PLAIN
+// (the same choice gomail makes).
+func negotiateAuth(mechs, username, password, host string) smtp.Auth {
+	switch {
+	case strings.Contains(mechs, "CRAM-MD5"):
+		return smtp.CRAMMD5Auth(username, password)
+	case strings.Contains(mechs, "LOGIN") && !strings.Contains(mechs, "PLAIN"):
+		return &loginAuth{username: username, password: password, host: host}
+	default:
+		return smtp.PlainAuth("", username, password, host)
+	}
+}
+
+// sendDirect delivers m to the MX hosts of each recipient domain, trying
+// them in order of preference. Without smtp.tls, STARTTLS is used whenever
+// offered but certificates are not verified, like most MTAs do on port 25.
+func (d *smtpDialer) sendDirect(ctx context.Context, m *gomail.Message) error {
+	from, to, err := envelope(m)
+	if err != nil {
+		return err
+	}
+	byDomain := map[string][]string{}
+	for _, addr := range to {
+		_, domain, _ := strings.Cut(addr, "@")
+		domain = strings.ToLower(domain)
+		byDomain[domain] = append(byDomain[domain], addr)
+	}
+	domains := make([]string, 0, len(byDomain))
+	for domain := range byDomain {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	for _, domain := range domains {
+		hosts, err := d.mxHosts(ctx, domain)
+		if err != nil {
+			return err
+		}
+		var errs []error
+		for _, host := range hosts {
+			mx := *d
+			mx.host, mx.port = host, d.mxPort
+			mx.tls = d.policy.apply(&tls.Config{ServerName: host, InsecureSkipVerify: d.mode == ""})
+			mx.username, mx.auth = "", nil
+			err = mx.send(ctx, from, byDomain[domain], m)
+			if err == nil {
+				slog.Info("Delivered via MX", "to", strings.Join(byDomain[domain], ", "), "mx", host)
+				break
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", host, err))
+			var reply *textproto.Error
+			if errors.As(err, &reply) && reply.Code >= 500 {
+				break // permanent rejection, other MX hosts will not accept it either
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("delivering to %s: %w", domain, errors.Join(errs...))
+		}
+	}
+	return nil
+}
+
+// send opens a connection and sends a single message to the given envelope.
+func (d *smtpDialer) send(ctx context.Context, from string, to []string, m io.WriterTo) error {
+	s, err := d.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return s.Send(from, to, m)
+}
+
+// mxHosts returns the mail exchangers for domain by preference, falling
+// back to the domain itself if it has no MX records (RFC 5321 section 5.1).
+func (d *smtpDialer) mxHosts(ctx context.Context, domain string) ([]string, error) {
+	records, err := d.lookupMX(ctx, domain)
+	var dnsErr *net.DNSError
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		return nil, fmt.Errorf("looking up MX for %s: %w", domain, err)
+	}
+	if len(records) == 0 {
+		return []string{domain}, nil
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Pref < records[j].Pref })
+	var hosts []string
+	for _, r := range records {
+		host := strings.TrimSuffix(r.Host, ".")
+		if host == "" {
+			return nil, fmt.Errorf("%s does not accept mail (null MX)", domain)
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// envelope returns the sender and all recipients (To, Cc, Bcc) of m.
+func envelope(m *gomail.Message) (string, []string, error) {
+	from := m.GetHeader("From")
+	if len(from) == 0 {
+		return "", nil, errors.New("message has no From header")
+	}
+	sender, err := mail.ParseAddress(from[0])
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing From: %w", err)
+	}
+	var to []string
+	for _, field := range []string{"To", "Cc", "Bcc"} {
+		for _, value := range m.GetHeader(field) {
+			list, err := mail.ParseAddressList(value)
+			if err != nil {
+				return "", nil, fmt.Errorf("parsing %s: %w", field, err)
+			}
+			for _, addr := range list {
+				to = append(to, addr.Address)
+			}
+		}
+	}
+	if len(to) == 0 {
+		return "", nil, errors.New("message has no recipients")
+	}
+	return sender.Address, to, nil
+}
+
+// smtpSender implements gomail.SendCloser on top of net/smtp, DKIM signing
+// messages if configured.
+type smtpSender struct {
+	c    *smtp.Client
+	dkim *dkimSigner
+}
+
+func (s *smtpSender) Send(from string, to []string, msg io.WriterTo) error {
+	if s.dkim != nil {
+		var buf bytes.Buffer
+		if _, err := msg.WriteTo(&buf); err != nil {
+			return err
+		}
+		signed, err := s.dkim.Sign(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		msg = bytes.NewReader(signed)
+	}
+	if err := s.c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := s.c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := s.c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := msg.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (s *smtpSender) Close() error {
+	return s.c.Quit()
+}
+
+// loginAuth implements the non-standard LOGIN mechanism still required by
+// some servers (e.g. older Exchange).
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("LOGIN requires an encrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch prompt := strings.ToLower(string(fromServer)); {
+	case strings.Contains(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.Contains(prompt, "password"):
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+}
+
+// xoauth2Auth implements the XOAUTH2 SASL mechanism used by Google and
+// Microsoft (https://developers.google.com/gmail/imap/xoauth2-protocol).
+type xoauth2Auth struct {
+	username, token, host string
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// Like smtp.PlainAuth, never send the token over an unencrypted connection
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("XOAUTH2 requires an encrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	resp := "user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"
+	return "XOAUTH2", []byte(resp), nil
+}
+
+// Next answers the server's error challenge with an empty response so the
+// server completes the exchange with its failure status.
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
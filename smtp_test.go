@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/gomail.v2"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/deliver"
+)
+
+func TestXOAUTH2Auth(t *testing.T) {
+	a := &xoauth2Auth{username: "jane@example.com", token: "ya29.token", host: "smtp.gmail.com"}
+	mech, resp, err := a.Start(&smtp.ServerInfo{Name: "smtp.gmail.com", TLS: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mech != "XOAUTH2" || string(resp) != "user=jane@example.com\x01auth=Bearer ya29.token\x01\x01" {
+		t.Errorf("Start() = %q, %q", mech, resp)
+	}
+	if next, err := a.Next([]byte(`{"status":"400"}`), true); err != nil || len(next) != 0 {
+		t.Errorf("Next() = %q, %v; want empty response", next, err)
+	}
+	if _, _, err := a.Start(&smtp.ServerInfo{Name: "smtp.gmail.com"}); err == nil {
+		t.Error("expected error without TLS")
+	}
+	if _, _, err := a.Start(&smtp.ServerInfo{Name: "evil.example.com", TLS: true}); err == nil {
+		t.Error("expected error for wrong host")
+	}
+}
+
+func TestNewSMTPDialer_XOAUTH2Refresh(t *testing.T) {
+	var clientID string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		clientID = r.PostForm.Get("client_id")
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "fresh"})
+	}))
+	defer srv.Close()
+
+	cfg := &Config{User: "jane@example.com"}
+	cfg.SMTP = SMTPConfig{Host: "smtp.office365.com", Port: 587, Auth: "xoauth2"}
+	cfg.SMTP.OAuth.ClientID = "public-client"
+	cfg.SMTP.OAuth.RefreshToken = "rt"
+	cfg.SMTP.OAuth.TokenURL = srv.URL
+	d, err := newSMTPDialer(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a, ok := d.auth.(*xoauth2Auth)
+	if !ok || a.token != "fresh" || a.username != "jane@example.com" {
+		t.Errorf("auth = %#v", d.auth)
+	}
+	if clientID != "public-client" {
+		t.Errorf("public client must send client_id in the form, got %q", clientID)
+	}
+}
+
+func TestSMTPConfig_Validate(t *testing.T) {
+	tests := []struct {
+		cfg     SMTPConfig
+		wantErr bool
+	}{
+		{SMTPConfig{}, false},
+		{SMTPConfig{Auth: "plain"}, false},
+		{SMTPConfig{Auth: "xoauth2", OAuth: SMTPOAuthConfig{AccessToken: "t"}}, false},
+		{SMTPConfig{Auth: "xoauth2"}, true},
+		{SMTPConfig{Auth: "cram-md5"}, true},
+		{SMTPConfig{TLS: "ssl"}, false},
+		{SMTPConfig{TLS: "none"}, false},
+		{SMTPConfig{TLS: "tls"}, true},
+		{SMTPConfig{DirectMX: true}, false},
+		{SMTPConfig{DirectMX: true, TLS: "ssl"}, true},
+		{SMTPConfig{DKIM: DKIMConfig{Domain: "example.com"}}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("validate(%+v) error = %v, wantErr %v", tt.cfg, err, tt.wantErr)
+		}
+	}
+}
+
+// fakeSMTPServer serves a single SMTP session without authentication and
+// returns the received message data on the channel. With implicitTLS the
+// listener speaks TLS from the start; with starttls it offers STARTTLS.
+func fakeSMTPServer(t *testing.T, cert tls.Certificate, implicitTLS, starttls bool) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	tlsConf := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if implicitTLS {
+		ln = tls.NewListener(ln, tlsConf)
+	}
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		text := textproto.NewConn(conn)
+		secure := implicitTLS
+		text.PrintfLine("220 fake ESMTP")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			verb, _, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "EHLO":
+				if starttls && !secure {
+					text.PrintfLine("250-fake\r\n250 STARTTLS")
+				} else {
+					text.PrintfLine("250 fake")
+				}
+			case "STARTTLS":
+				text.PrintfLine("220 ready")
+				tlsConn := tls.Server(conn, tlsConf)
+				if tlsConn.Handshake() != nil {
+					return
+				}
+				conn, text, secure = tlsConn, textproto.NewConn(tlsConn), true
+			case "DATA":
+				text.PrintfLine("354 go ahead")
+				data, _ := text.ReadDotBytes()
+				received <- string(data)
+				text.PrintfLine("250 queued")
+			case "QUIT":
+				text.PrintfLine("221 bye")
+				return
+			default:
+				text.PrintfLine("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().String(), received
+}
+
+// writeCAFile stores the certificate as a PEM bundle.
+func writeCAFile(t *testing.T, cert tls.Certificate) string {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	return caFile
+}
+
+func smtpTestConfig(addr string, smtpCfg SMTPConfig) *Config {
+	host, port, _ := net.SplitHostPort(addr)
+	smtpCfg.Host = host
+	smtpCfg.Port, _ = net.LookupPort("tcp", port)
+	return &Config{User: "jane@example.com", SMTP: smtpCfg}
+}
+
+func testMessage() *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetHeader("From", "jane@example.com")
+	m.SetHeader("To", "books@example.com")
+	m.SetBody("text/plain", "Dokumente anbei.")
+	return m
+}
+
+func TestSMTPDialer_TLSModes(t *testing.T) {
+	cert := testCertificate(t)
+	caFile := writeCAFile(t, cert)
+	tests := []struct {
+		name        string
+		implicitTLS bool
+		starttls    bool
+		cfg         SMTPConfig
+		wantErr     string
+	}{
+		{"ssl with CA bundle", true, false, SMTPConfig{TLS: "ssl", CAFile: caFile}, ""},
+		{"ssl untrusted", true, false, SMTPConfig{TLS: "ssl"}, "certificate"},
+		{"ssl insecure", true, false, SMTPConfig{TLS: "ssl", InsecureSkipVerify: true}, ""},
+		{"starttls with CA bundle", false, true, SMTPConfig{TLS: "starttls", CAFile: caFile}, ""},
+		{"starttls required", false, false, SMTPConfig{TLS: "starttls"}, "does not offer STARTTLS"},
+		{"opportunistic without STARTTLS", false, false, SMTPConfig{}, ""},
+		{"none skips STARTTLS", false, true, SMTPConfig{TLS: "none"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, received := fakeSMTPServer(t, cert, tt.implicitTLS, tt.starttls)
+			d, err := newSMTPDialer(context.Background(), smtpTestConfig(addr, tt.cfg))
+			if err != nil {
+				t.Fatalf("newSMTPDialer: %v", err)
+			}
+			err = d.DialAndSend(context.Background(), testMessage())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if data := <-received; !strings.Contains(data, "Dokumente anbei.") {
+				t.Errorf("received %q", data)
+			}
+		})
+	}
+}
+
+func TestSMTPTLSConfig_InvalidCAFile(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, []byte("not a certificate"), 0600)
+	if _, err := smtpTLSConfig(SMTPConfig{CAFile: caFile}, TLSConfig{}); err == nil {
+		t.Error("expected error for CA file without certificates")
+	}
+}
+
+func TestLoginAuth(t *testing.T) {
+	a := &loginAuth{username: "jane", password: "secret", host: "mail.example.com"}
+	if mech, _, err := a.Start(&smtp.ServerInfo{Name: "mail.example.com", TLS: true}); err != nil || mech != "LOGIN" {
+		t.Fatalf("Start() = %q, %v", mech, err)
+	}
+	for challenge, want := range map[string]string{"Username:": "jane", "Password:": "secret"} {
+		if got, err := a.Next([]byte(challenge), true); err != nil || string(got) != want {
+			t.Errorf("Next(%q) = %q, %v", challenge, got, err)
+		}
+	}
+	if _, _, err := a.Start(&smtp.ServerInfo{Name: "mail.example.com"}); err == nil {
+		t.Error("expected error without TLS")
+	}
+}
+
+func TestSMTPDialer_DirectMX(t *testing.T) {
+	cert := testCertificate(t)
+	addr, received := fakeSMTPServer(t, cert, false, true)
+	_, port, _ := net.SplitHostPort(addr)
+
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keyFile := filepath.Join(t.TempDir(), "dkim.pem")
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600)
+
+	cfg := &Config{User: "jane@example.com", Pass: "unused"}
+	cfg.SMTP = SMTPConfig{DirectMX: true, HELO: "mail.example.com", DKIM: DKIMConfig{Domain: "example.com", Selector: "mail", KeyFile: keyFile}}
+	d, err := newSMTPDialer(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("newSMTPDialer: %v", err)
+	}
+	d.mxPort, _ = strconv.Atoi(port)
+	var lookedUp string
+	d.lookupMX = func(_ context.Context, domain string) ([]*net.MX, error) {
+		lookedUp = domain
+		// 127.0.0.2 refuses the connection, so delivery falls back to the
+		// next host by preference
+		return []*net.MX{{Host: "127.0.0.1.", Pref: 20}, {Host: "127.0.0.2.", Pref: 10}}, nil
+	}
+	if err := d.DialAndSend(context.Background(), testMessage()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lookedUp != "example.com" {
+		t.Errorf("looked up MX for %q", lookedUp)
+	}
+	data := <-received
+	if !strings.HasPrefix(data, "DKIM-Signature: v=1; a=rsa-sha256;") {
+		t.Errorf("message not signed: %q", data[:min(len(data), 80)])
+	}
+	verifyDKIM(t, []byte(strings.ReplaceAll(data, "\n", "\r\n")), &key.PublicKey)
+}
+
+func TestSMTPDialer_MXHosts(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []*net.MX
+		err     error
+		want    []string
+		wantErr bool
+	}{
+		{"by preference", []*net.MX{{Host: "b.example.com.", Pref: 20}, {Host: "a.example.com.", Pref: 10}}, nil, []string{"a.example.com", "b.example.com"}, false},
+		{"implicit MX", nil, &net.DNSError{Err: "no such host", IsNotFound: true}, []string{"example.com"}, false},
+		{"null MX", []*net.MX{{Host: ".", Pref: 0}}, nil, nil, true},
+		{"lookup failure", nil, &net.DNSError{Err: "server misbehaving", IsTemporary: true}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &smtpDialer{lookupMX: func(context.Context, string) ([]*net.MX, error) { return tt.records, tt.err }}
+			got, err := d.mxHosts(context.Background(), "example.com")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("mxHosts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifySMTPError(t *testing.T) {
+	tests := []struct {
+		err       error
+		permanent bool
+	}{
+		{&textproto.Error{Code: 550, Msg: "mailbox unavailable"}, true},
+		{&textproto.Error{Code: 535, Msg: "authentication failed"}, true},
+		{&textproto.Error{Code: 451, Msg: "greylisted, try again later"}, false},
+		{fmt.Errorf("delivering to example.com: %w", &textproto.Error{Code: 554}), true},
+		{&net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, false},
+	}
+	for _, tt := range tests {
+		if got := deliver.IsPermanent(classifySMTPError(tt.err)); got != tt.permanent {
+			t.Errorf("deliver.IsPermanent(%v) = %v, want %v", tt.err, got, tt.permanent)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// SpoolConfig keeps the emails and PDFs of a run on disk rather than in
+// memory, for backfills of hundreds of invoices on small machines.
+type SpoolConfig struct {
+	// Enabled writes the raw emails, their HTML bodies and the generated
+	// PDFs to temporary files, read back one at a time when needed, and
+	// drops the rendered HTML of each invoice once it is converted.
+	Enabled bool `yaml:"enabled"`
+	// Dir is where the temporary files go, the system's temp dir if empty.
+	Dir string `yaml:"dir"`
+}
+
+// spoolKey carries the spool directory of a run in the context.
+type spoolKey struct{}
+
+// withSpool returns a context with a fresh spool directory if spooling is
+// enabled, and a function removing the directory at the end of the run.
+func withSpool(ctx context.Context, cfg *Config) (context.Context, func(), error) {
+	if !cfg.Spool.Enabled {
+		return ctx, func() {}, nil
+	}
+	dir, err := os.MkdirTemp(cfg.Spool.Dir, "apple-invoice-pdf-spool-")
+	if err != nil {
+		return ctx, nil, fmt.Errorf("spool: %w", err)
+	}
+	slog.Debug("Spooling emails", "dir", dir)
+	return context.WithValue(ctx, spoolKey{}, dir), func() { os.RemoveAll(dir) }, nil
+}
+
+// spoolFrom returns the spool directory of the run in ctx, or an empty
+// string if spooling is off.
+func spoolFrom(ctx context.Context) string {
+	dir, _ := ctx.Value(spoolKey{}).(string)
+	return dir
+}
+
+// spoolPDF moves the PDF of p to a temporary file in dir, see
+// ProcessedInvoice.readPDF.
+func spoolPDF(p *ProcessedInvoice, dir string) error {
+	f, err := os.CreateTemp(dir, "invoice-*.pdf")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(p.PDF)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	p.PDFFile, p.PDF = f.Name(), nil
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithSpool(t *testing.T) {
+	ctx, cleanup, err := withSpool(context.Background(), &Config{})
+	if err != nil || spoolFrom(ctx) != "" {
+		t.Fatalf("disabled: dir %q, %v", spoolFrom(ctx), err)
+	}
+	cleanup()
+
+	cfg := &Config{Spool: SpoolConfig{Enabled: true, Dir: t.TempDir()}}
+	ctx, cleanup, err = withSpool(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := spoolFrom(ctx)
+	if filepath.Dir(dir) != cfg.Spool.Dir {
+		t.Fatalf("dir = %q, want one in %s", dir, cfg.Spool.Dir)
+	}
+	file := filepath.Join(dir, "invoice-1.eml")
+	if err := os.WriteFile(file, []byte("Subject: Rechnung\r\n\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if raw, err := (InvoiceEmail{RawFile: file}).Source(); err != nil || string(raw) != "Subject: Rechnung\r\n\r\n" {
+		t.Errorf("Source = %q, %v", raw, err)
+	}
+	body := filepath.Join(dir, "invoice-1.html")
+	if err := os.WriteFile(body, []byte("<p>Rechnung</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if html, err := (InvoiceEmail{HTMLFile: body}).HTML(); err != nil || html != "<p>Rechnung</p>" {
+		t.Errorf("HTML = %q, %v", html, err)
+	}
+	cleanup()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("spool dir left behind: %v", err)
+	}
+}
+
+// fakePDFTransformer renders the HTML as the PDF's content.
+type fakePDFTransformer struct{}
+
+func (fakePDFTransformer) Name() string { return "pdf" }
+
+func (fakePDFTransformer) Transform(_ context.Context, p *ProcessedInvoice) error {
+	p.PDF = []byte("%PDF " + p.HTML)
+	return nil
+}
+
+func TestProcessInvoices_Spool(t *testing.T) {
+	cfg := &Config{Spool: SpoolConfig{Enabled: true, Dir: t.TempDir()}}
+	cfg.Filename.Template = defaultFilenameTemplate
+	ctx, cleanup, err := withSpool(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	dir := spoolFrom(ctx)
+	body := filepath.Join(dir, "invoice-1.html")
+	if err := os.WriteFile(body, []byte("<p>Bestellnummer: MX1</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	inv := InvoiceEmail{UID: 1, Subject: "Deine Rechnung von Apple", HTMLFile: body}
+	processed, failures := processInvoices(ctx, cfg, []Transformer{extractTransformer{}, fakePDFTransformer{}}, []InvoiceEmail{inv})
+	if len(failures) != 0 || len(processed) != 1 {
+		t.Fatalf("processed = %+v, failures = %v", processed, failures)
+	}
+	p := processed[0]
+	if p.OrderNumber != "MX1" || p.HTML != "" || p.PDF != nil || filepath.Dir(p.PDFFile) != dir {
+		t.Errorf("processed = %+v", p)
+	}
+	const want = "%PDF <p>Bestellnummer: MX1</p>"
+	if data, err := p.readPDF(); err != nil || string(data) != want {
+		t.Errorf("readPDF = %q, %v", data, err)
+	}
+	if p.pdfSize() != len(want) {
+		t.Errorf("pdfSize = %d, want %d", p.pdfSize(), len(want))
+	}
+
+	out := t.TempDir()
+	d := &Delivery{Invoices: processed, Attachments: []PDFAttachment{{Filename: "a.pdf", File: p.PDFFile, Invoice: &processed[0]}}}
+	if err := (&dirSink{dir: out}).Deliver(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "a.pdf")); err != nil || string(data) != want {
+		t.Errorf("delivered = %q, %v", data, err)
+	}
+}
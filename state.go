@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// StateConfig configures the processed-invoice store.
+type StateConfig struct {
+	// File is the JSON file recording delivered invoices; empty disables
+	// the store and every run delivers all matching invoices.
+	File string `yaml:"file"`
+}
+
+// Delivery states recorded in the store. Queued invoices wait in the
+// outbox for `resume` and are skipped like delivered ones.
+const (
+	stateDelivered = "delivered"
+	stateQueued    = "queued"
+	stateFailed    = "failed"
+)
+
+// StateEntry records the outcome of delivering one invoice.
+type StateEntry struct {
+	MessageID   string    `json:"message_id,omitempty"`
+	OrderNumber string    `json:"order_number,omitempty"`
+	Filename    string    `json:"filename"`
+	Status      string    `json:"status"`
+	Updated     time.Time `json:"updated"`
+	// Date and Total of the invoice; missing in entries written by
+	// versions before the dashboard.
+	Date  time.Time `json:"date,omitzero"`
+	Total string    `json:"total,omitempty"`
+	// Sinks holds the status at each sink by name; Status sums them up.
+	// Entries written before it was tracked have Status for all sinks.
+	Sinks map[string]string `json:"sinks,omitempty"`
+	// Vendor is the name of the invoice's vendor profile, which scopes
+	// OrderNumber; empty in entries written before it was recorded.
+	Vendor string `json:"vendor,omitempty"`
+}
+
+// matches reports whether e is the invoice with the given Message-Id or
+// the given vendor's order number. Empty values never match, except for
+// the vendor: an empty one, given or recorded, matches any.
+func (e StateEntry) matches(messageID, vendor, orderNumber string) bool {
+	if messageID != "" && e.MessageID == messageID {
+		return true
+	}
+	return orderNumber != "" && e.OrderNumber == orderNumber && (vendor == "" || e.Vendor == "" || e.Vendor == vendor)
+}
+
+// month returns the date of the invoice in local time, or for older
+// entries without one the time it was delivered.
+func (e StateEntry) month() time.Time {
+	if e.Date.IsZero() {
+		return e.Updated.Local()
+	}
+	return e.Date.Local()
+}
+
+// stateStore remembers which invoices have been delivered so re-runs skip
+// them. A nil store is valid and records nothing.
+type stateStore struct {
+	path    string
+	entries []StateEntry
+	// readOnly skips recording, e.g. for dry runs.
+	readOnly bool
+}
+
+// loadState reads the store at path, which may not exist yet. It returns
+// nil if path is empty.
+func loadState(path string) (*stateStore, error) {
+	if path == "" {
+		return nil, nil
+	}
+	s := &stateStore{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.entries); err != nil {
+		return nil, fmt.Errorf("parsing state file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// delivered reports whether an invoice with the given Message-Id or order
+// number of the vendor has been delivered or queued, see
+// StateEntry.matches.
+func (s *stateStore) delivered(messageID, vendor, orderNumber string) bool {
+	if s == nil {
+		return false
+	}
+	for _, e := range s.entries {
+		if e.Status != stateFailed && e.matches(messageID, vendor, orderNumber) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipDelivered drops emails whose Message-Id has been delivered, before
+// spending time on converting them.
+func (s *stateStore) skipDelivered(invoices []InvoiceEmail) []InvoiceEmail {
+	var pending []InvoiceEmail
+	for _, inv := range invoices {
+		if s.delivered(inv.MessageID, "", "") {
+			slog.Info("Skipping invoice: already delivered", "subject", inv.Subject, "message_id", inv.MessageID)
+			continue
+		}
+		pending = append(pending, inv)
+	}
+	return pending
+}
+
+// skipDeliveredOrders drops invoices whose order number has been delivered
+// for the same vendor, e.g. when Apple resent an invoice with a new
+// Message-Id.
+func (s *stateStore) skipDeliveredOrders(processed []ProcessedInvoice) []ProcessedInvoice {
+	var pending []ProcessedInvoice
+	for _, p := range processed {
+		if s.delivered("", p.vendor().Name, p.OrderNumber) {
+			slog.Info("Skipping invoice: order already delivered", "file", p.Filename+".pdf", "order_number", p.OrderNumber)
+			continue
+		}
+		pending = append(pending, p)
+	}
+	return pending
+}
+
+// pendingSinks returns the sinks still missing the invoice: all of them
+// unless an earlier run delivered it to some, or queued it for them in the
+// outbox.
+func (s *stateStore) pendingSinks(p ProcessedInvoice, sinks []Sink) []Sink {
+	if s == nil {
+		return sinks
+	}
+	i := s.find(StateEntry{MessageID: p.Email.MessageID, OrderNumber: p.OrderNumber, Vendor: p.vendor().Name})
+	if i < 0 {
+		return sinks
+	}
+	var pending []Sink
+	for _, sink := range sinks {
+		if status := s.entries[i].Sinks[sink.Name()]; status != stateDelivered && status != stateQueued {
+			pending = append(pending, sink)
+		}
+	}
+	return pending
+}
+
+// sinkGroup holds invoices missing the same sinks.
+type sinkGroup struct {
+	sinks    []Sink
+	invoices []ProcessedInvoice
+}
+
+// pendingGroups groups the invoices by their pendingSinks, in order of
+// appearance. New invoices form a single group with all sinks; invoices
+// no sink is missing are left out.
+func (s *stateStore) pendingGroups(processed []ProcessedInvoice, sinks []Sink) []sinkGroup {
+	var groups []sinkGroup
+	index := make(map[string]int)
+	for _, p := range processed {
+		pending := s.pendingSinks(p, sinks)
+		if len(pending) == 0 && len(sinks) > 0 {
+			slog.Info("Skipping invoice: already delivered to all destinations", "file", p.Filename+".pdf")
+			continue
+		}
+		names := make([]string, len(pending))
+		for i, sink := range pending {
+			names[i] = sink.Name()
+		}
+		key := strings.Join(names, "\x00")
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, sinkGroup{sinks: pending})
+		}
+		groups[i].invoices = append(groups[i].invoices, p)
+	}
+	return groups
+}
+
+// deliveryStatus returns the status of processed[i] at each of sinks after
+// deliverAll returned results for them: delivered if the sink succeeded,
+// or got the invoice before failing as tracked by SinkProgress; queued if
+// the delivery was saved to the outbox; failed otherwise.
+func deliveryStatus(processed []ProcessedInvoice, sinks []Sink, results []SinkResult, queued bool) func(i int) map[string]string {
+	return func(i int) map[string]string {
+		statuses := make(map[string]string, len(sinks))
+		for j, sink := range sinks {
+			t, ok := sink.(progressTracker)
+			switch {
+			case j < len(results) && results[j].Err == nil:
+				statuses[sink.Name()] = stateDelivered
+			case ok && t.progress().isDone(invoiceKey(processed[i])):
+				statuses[sink.Name()] = stateDelivered
+			case queued:
+				statuses[sink.Name()] = stateQueued
+			default:
+				statuses[sink.Name()] = stateFailed
+			}
+		}
+		return statuses
+	}
+}
+
+// overallStatus sums up the statuses at the sinks: failed if any sink
+// failed, else queued if any is queued, else delivered.
+func overallStatus(statuses map[string]string) string {
+	status := stateDelivered
+	for _, s := range statuses {
+		switch s {
+		case stateFailed:
+			return stateFailed
+		case stateQueued:
+			status = stateQueued
+		}
+	}
+	return status
+}
+
+// record stores the statuses of the invoices at the sinks and saves the
+// file; status returns those of processed[i] by sink name. Statuses
+// recorded before for other sinks are kept, and the entry's Status is
+// their overallStatus.
+func (s *stateStore) record(processed []ProcessedInvoice, status func(i int) map[string]string, now time.Time) error {
+	if s == nil || s.readOnly {
+		return nil
+	}
+	for i, p := range processed {
+		entry := StateEntry{
+			MessageID:   p.Email.MessageID,
+			OrderNumber: p.OrderNumber,
+			Filename:    p.Filename + ".pdf",
+			Sinks:       make(map[string]string),
+			Updated:     now,
+			Date:        p.Email.Date,
+			Total:       p.Total.String(),
+			Vendor:      p.vendor().Name,
+		}
+		j := s.find(entry)
+		if j >= 0 {
+			maps.Copy(entry.Sinks, s.entries[j].Sinks)
+		}
+		maps.Copy(entry.Sinks, status(i))
+		entry.Status = overallStatus(entry.Sinks)
+		if j >= 0 {
+			s.entries[j] = entry
+		} else {
+			s.entries = append(s.entries, entry)
+		}
+	}
+	return s.save()
+}
+
+// forget removes the entries for the invoice with the given Message-Id or
+// order number of the vendor and saves the file, so the next run delivers
+// it again. It reports whether an entry was found.
+func (s *stateStore) forget(messageID, vendor, orderNumber string) (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+	n := len(s.entries)
+	s.entries = slices.DeleteFunc(s.entries, func(e StateEntry) bool {
+		return e.matches(messageID, vendor, orderNumber)
+	})
+	if len(s.entries) == n {
+		return false, nil
+	}
+	return true, s.save()
+}
+
+// lookup returns the entry for the invoice with the given Message-Id or
+// order number of the vendor; an empty vendor matches any.
+func (s *stateStore) lookup(messageID, vendor, orderNumber string) (StateEntry, bool) {
+	if s == nil {
+		return StateEntry{}, false
+	}
+	for _, e := range s.entries {
+		if e.matches(messageID, vendor, orderNumber) {
+			return e, true
+		}
+	}
+	return StateEntry{}, false
+}
+
+// recent returns a copy of the entries, most recently updated first.
+func (s *stateStore) recent() []StateEntry {
+	if s == nil {
+		return nil
+	}
+	entries := slices.Clone(s.entries)
+	slices.SortStableFunc(entries, func(a, b StateEntry) int { return b.Updated.Compare(a.Updated) })
+	return entries
+}
+
+// find returns the index of the entry for the same invoice, or -1: the
+// one with its Message-Id, or without one the one with its vendor's order
+// number.
+func (s *stateStore) find(entry StateEntry) int {
+	for i, e := range s.entries {
+		if entry.MessageID != "" && e.MessageID == entry.MessageID {
+			return i
+		}
+		if entry.MessageID == "" && e.matches("", entry.Vendor, entry.OrderNumber) {
+			return i
+		}
+	}
+	return -1
+}
+
+// save writes the store atomically so a crash never leaves a truncated file.
+func (s *stateStore) save() error {
+	data, err := json.MarshalIndent(s.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding state file: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("creating state directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	return nil
+}
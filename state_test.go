@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sinkStatus returns a status func for stateStore.record giving every
+// invoice status at the email sink.
+func sinkStatus(status string) func(int) map[string]string {
+	return func(int) map[string]string { return map[string]string{"email": status} }
+}
+
+func TestStateStore_RecordAndSkip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "processed.json")
+	store, err := loadState(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	delivered := testProcessedInvoice()
+	delivered.Email.MessageID = "<a@apple.com>"
+	delivered.Filename = "05_2024_Rechnung_Apple_MXYZ123"
+	failed := testProcessedInvoice()
+	failed.Email.MessageID = "<b@apple.com>"
+	failed.OrderNumber = "MABC456"
+	now := time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC)
+	if err := store.record([]ProcessedInvoice{delivered}, sinkStatus(stateDelivered), now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.record([]ProcessedInvoice{failed}, sinkStatus(stateFailed), now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Reload from disk as the next run would
+	store, err = loadState(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	emails := store.skipDelivered([]InvoiceEmail{delivered.Email, failed.Email, {Subject: "new"}})
+	if len(emails) != 2 || emails[0].MessageID != "<b@apple.com>" || emails[1].Subject != "new" {
+		t.Errorf("skipDelivered = %+v", emails)
+	}
+
+	// Same order resent with a new Message-Id
+	resent := delivered
+	resent.Email.MessageID = "<c@apple.com>"
+	if got := store.skipDeliveredOrders([]ProcessedInvoice{resent, failed}); len(got) != 1 || got[0].OrderNumber != "MABC456" {
+		t.Errorf("skipDeliveredOrders = %+v", got)
+	}
+
+	// A later successful retry replaces the failed entry
+	if err := store.record([]ProcessedInvoice{failed}, sinkStatus(stateDelivered), now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(store.entries) != 2 || !store.delivered("<b@apple.com>", "", "") {
+		t.Errorf("entries = %+v", store.entries)
+	}
+}
+
+func TestStateStore_VendorOrders(t *testing.T) {
+	store, _ := loadState(filepath.Join(t.TempDir(), "processed.json"))
+	apple := testProcessedInvoice()
+	apple.Email.MessageID, apple.OrderNumber = "<a@apple.com>", "MX1"
+	if err := store.record([]ProcessedInvoice{apple}, sinkStatus(stateDelivered), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if store.entries[0].Vendor != "apple" {
+		t.Errorf("vendor = %q, want apple", store.entries[0].Vendor)
+	}
+	// Written before vendors were recorded
+	store.entries = append(store.entries, StateEntry{OrderNumber: "MX2", Status: stateDelivered})
+
+	other := &VendorProfile{Name: "other"}
+	sameOrder := testProcessedInvoice()
+	sameOrder.Email.MessageID, sameOrder.OrderNumber, sameOrder.Vendor = "<b@other.example>", "MX1", other
+	oldOrder := testProcessedInvoice()
+	oldOrder.Email.MessageID, oldOrder.OrderNumber, oldOrder.Vendor = "<c@other.example>", "MX2", other
+	resent := apple
+	resent.Email.MessageID = "<d@apple.com>"
+
+	got := store.skipDeliveredOrders([]ProcessedInvoice{sameOrder, oldOrder, resent})
+	if len(got) != 1 || got[0].Email.MessageID != "<b@other.example>" {
+		t.Errorf("skipDeliveredOrders = %+v, want only the other vendor's MX1", got)
+	}
+	sameOrder.Email.MessageID = ""
+	if sinks := store.pendingSinks(sameOrder, []Sink{&fakeSink{name: "email"}}); len(sinks) != 1 {
+		t.Errorf("pendingSinks = %v, want email", sinks)
+	}
+	if _, ok := store.lookup("", "other", "MX1"); ok {
+		t.Error("lookup found Apple's MX1 for the other vendor")
+	}
+	if e, ok := store.lookup("", "", "MX1"); !ok || e.Vendor != "apple" {
+		t.Errorf("lookup without vendor = %+v, %v", e, ok)
+	}
+}
+
+func TestStateStore_Disabled(t *testing.T) {
+	store, err := loadState("")
+	if err != nil || store != nil {
+		t.Fatalf("loadState(\"\") = %v, %v", store, err)
+	}
+	inv := testProcessedInvoice()
+	if got := store.skipDelivered([]InvoiceEmail{inv.Email}); len(got) != 1 {
+		t.Errorf("nil store skipped invoices")
+	}
+	if err := store.record([]ProcessedInvoice{inv}, sinkStatus(stateDelivered), time.Now()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStateStore_ReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processed.json")
+	store, _ := loadState(path)
+	store.readOnly = true
+	if err := store.record([]ProcessedInvoice{testProcessedInvoice()}, sinkStatus(stateDelivered), time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("read-only store wrote %s", path)
+	}
+}
+
+func TestLoadState_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processed.json")
+	os.WriteFile(path, []byte("{"), 0644)
+	if _, err := loadState(path); err == nil {
+		t.Error("expected error for invalid state file")
+	}
+}
+
+func TestStateStore_PendingSinks(t *testing.T) {
+	store, _ := loadState(filepath.Join(t.TempDir(), "processed.json"))
+	email, s3 := &fakeSink{name: "email"}, &fakeSink{name: "s3", failures: 100}
+	sinks := []Sink{email, s3}
+
+	partial := testProcessedInvoice()
+	partial.Email.MessageID = "<a@apple.com>"
+	fresh := testProcessedInvoice()
+	fresh.Email.MessageID = "<b@apple.com>"
+	fresh.OrderNumber = "MABC456"
+
+	// The first run reached email but not S3
+	results := []SinkResult{{Sink: "email"}, {Sink: "s3", Err: errors.New("unavailable")}}
+	status := deliveryStatus([]ProcessedInvoice{partial}, sinks, results, false)
+	if err := store.record([]ProcessedInvoice{partial}, status, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	entry, _ := store.lookup("<a@apple.com>", "", "")
+	if entry.Status != stateFailed || entry.Sinks["email"] != stateDelivered || entry.Sinks["s3"] != stateFailed {
+		t.Fatalf("entry = %+v", entry)
+	}
+
+	groups := store.pendingGroups([]ProcessedInvoice{partial, fresh}, sinks)
+	if len(groups) != 2 || len(groups[0].sinks) != 1 || groups[0].sinks[0] != s3 || groups[0].invoices[0].Email.MessageID != "<a@apple.com>" ||
+		len(groups[1].sinks) != 2 || groups[1].invoices[0].Email.MessageID != "<b@apple.com>" {
+		t.Fatalf("groups = %+v", groups)
+	}
+
+	// The retry to S3 succeeds and keeps the email status
+	status = deliveryStatus(groups[0].invoices, groups[0].sinks, []SinkResult{{Sink: "s3"}}, false)
+	if err := store.record(groups[0].invoices, status, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	entry, _ = store.lookup("<a@apple.com>", "", "")
+	if entry.Status != stateDelivered || entry.Sinks["email"] != stateDelivered || entry.Sinks["s3"] != stateDelivered {
+		t.Errorf("entry after retry = %+v", entry)
+	}
+	if groups := store.pendingGroups([]ProcessedInvoice{partial}, sinks); len(groups) != 0 {
+		t.Errorf("groups after retry = %+v, want none", groups)
+	}
+}
+
+func TestDeliveryStatus_Progress(t *testing.T) {
+	a, b := testProcessedInvoice(), testProcessedInvoice()
+	a.Email.MessageID, b.Email.MessageID = "<a@apple.com>", "<b@apple.com>"
+	sink := &paperlessSink{}
+	sink.markDone(invoiceKey(a))
+	results := []SinkResult{{Sink: sink.Name(), Err: errors.New("unavailable")}}
+
+	status := deliveryStatus([]ProcessedInvoice{a, b}, []Sink{sink}, results, true)
+	if got := status(0)[sink.Name()]; got != stateDelivered {
+		t.Errorf("invoice the sink got = %s, want delivered", got)
+	}
+	if got := status(1)[sink.Name()]; got != stateQueued {
+		t.Errorf("invoice the sink missed = %s, want queued", got)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// TelegramConfig configures delivery to a Telegram chat via the Bot API.
+type TelegramConfig struct {
+	Token   string `yaml:"token"`
+	ChatID  string `yaml:"chat_id"`
+	Caption string `yaml:"caption"`
+	// APIURL overrides the Bot API server, e.g. for a self-hosted one.
+	APIURL string `yaml:"api_url"`
+}
+
+// telegramSink sends every invoice PDF as a document with a templated caption.
+type telegramSink struct {
+	cfg     TelegramConfig
+	caption *template.Template
+	client  *http.Client
+	SinkProgress
+}
+
+// newTelegramSink applies defaults and parses the caption template.
+func newTelegramSink(cfg TelegramConfig) (*telegramSink, error) {
+	if cfg.ChatID == "" {
+		return nil, fmt.Errorf("telegram: chat_id is required")
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = "https://api.telegram.org"
+	}
+	cfg.APIURL = strings.TrimRight(cfg.APIURL, "/")
+	if cfg.Caption == "" {
+		cfg.Caption = "{{.Vendor}} Rechnung {{.OrderNumber}} vom {{.Day}}.{{.Month}}.{{.Year}} {{.Amount}}"
+	}
+	tmpl, err := template.New("caption").Parse(cfg.Caption)
+	if err != nil {
+		return nil, fmt.Errorf("telegram: parsing caption template: %w", err)
+	}
+	return &telegramSink{cfg: cfg, caption: tmpl, client: newAPIClient()}, nil
+}
+
+func (s *telegramSink) Name() string { return "telegram" }
+
+func (s *telegramSink) Deliver(ctx context.Context, d *Delivery) error {
+	for _, inv := range d.Invoices {
+		if s.isDone(invoiceKey(inv)) {
+			continue
+		}
+		if err := s.sendDocument(ctx, inv); err != nil {
+			return fmt.Errorf("sending %s: %w", inv.Filename, err)
+		}
+		s.markDone(invoiceKey(inv))
+		slog.Info("Sent invoice to Telegram", "file", inv.Filename+".pdf", "chat", s.cfg.ChatID)
+	}
+	return nil
+}
+
+// sendDocument uploads a single PDF via the sendDocument method.
+func (s *telegramSink) sendDocument(ctx context.Context, inv ProcessedInvoice) error {
+	caption, err := executeTemplate(s.caption, newTemplateData(inv))
+	if err != nil {
+		return err
+	}
+	data, err := inv.readPDF()
+	if err != nil {
+		return err
+	}
+	body, contentType, err := newMultipartBody(
+		[][2]string{{"chat_id", s.cfg.ChatID}, {"caption", strings.TrimSpace(caption)}},
+		[]multipartFile{{Field: "document", Filename: inv.Filename + ".pdf", ContentType: "application/pdf", Data: data}},
+	)
+	if err != nil {
+		return err
+	}
+	// The token is part of the URL, so errors must not include it
+	url := s.cfg.APIURL + "/bot" + s.cfg.Token + "/sendDocument"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return fmt.Errorf("building request")
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	var resp struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := doRequest(s.client, req, &resp); err != nil {
+		return fmt.Errorf("sendDocument: %s", strings.ReplaceAll(err.Error(), s.cfg.Token, "<token>"))
+	}
+	if !resp.OK {
+		return fmt.Errorf("sendDocument: %s", resp.Description)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTelegramSink_Deliver(t *testing.T) {
+	var path, chatID, caption, file string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		r.ParseMultipartForm(1 << 20)
+		chatID, caption = r.FormValue("chat_id"), r.FormValue("caption")
+		f, h, _ := r.FormFile("document")
+		data, _ := io.ReadAll(f)
+		file = h.Filename + ":" + string(data)
+		json.NewEncoder(w).Encode(map[string]any{"ok": true})
+	}))
+	defer srv.Close()
+
+	sink, err := newTelegramSink(TelegramConfig{Token: "123:abc", ChatID: "42", APIURL: srv.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	inv := testProcessedInvoice()
+	inv.Filename = "invoice"
+	inv.PDF = []byte("%PDF")
+	if err := sink.Deliver(context.Background(), &Delivery{Invoices: []ProcessedInvoice{inv}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/bot123:abc/sendDocument" {
+		t.Errorf("path = %q", path)
+	}
+	if chatID != "42" || caption != "Apple Rechnung MXYZ123 vom 14.05.2024 9,99 EUR" {
+		t.Errorf("chat_id/caption = %q/%q", chatID, caption)
+	}
+	if file != "invoice.pdf:%PDF" {
+		t.Errorf("document = %q", file)
+	}
+}
+
+func TestTelegramSink_ErrorHidesToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]any{"ok": false, "description": "Unauthorized"})
+	}))
+	defer srv.Close()
+
+	sink, _ := newTelegramSink(TelegramConfig{Token: "123:secret", ChatID: "42", APIURL: srv.URL})
+	err := sink.Deliver(context.Background(), &Delivery{Invoices: []ProcessedInvoice{testProcessedInvoice()}})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error leaks bot token: %v", err)
+	}
+}
+
+func TestNewTelegramSink_RequiresChatID(t *testing.T) {
+	if _, err := newTelegramSink(TelegramConfig{Token: "t"}); err == nil {
+		t.Error("expected error without chat_id")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// stageTimings adds up where the time of a run went, to tell slow IMAP,
+// image downloads, Chrome or destinations apart. All methods are no-ops
+// on a nil receiver.
+type stageTimings struct {
+	mu     sync.Mutex
+	stages []string // in order of first use
+	total  map[string]time.Duration
+	count  map[string]int
+}
+
+// timingsKey carries the stage timings of a run in the context.
+type timingsKey struct{}
+
+// withStageTimings returns a context collecting stage timings.
+func withStageTimings(ctx context.Context) (context.Context, *stageTimings) {
+	t := &stageTimings{total: make(map[string]time.Duration), count: make(map[string]int)}
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+// timingsFrom returns the stage timings in ctx, or nil.
+func timingsFrom(ctx context.Context) *stageTimings {
+	t, _ := ctx.Value(timingsKey{}).(*stageTimings)
+	return t
+}
+
+// since adds the time elapsed since start to stage.
+func (t *stageTimings) since(stage string, start time.Time) {
+	if t == nil {
+		return
+	}
+	d := time.Since(start)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.total[stage]; !ok {
+		t.stages = append(t.stages, stage)
+	}
+	t.total[stage] += d
+	t.count[stage]++
+}
+
+// attrs returns the total per stage, plus the count and average for
+// stages run more than once (e.g. chrome per invoice).
+func (t *stageTimings) attrs() []any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var attrs []any
+	for _, stage := range t.stages {
+		total, n := t.total[stage], t.count[stage]
+		if n == 1 {
+			attrs = append(attrs, slog.Duration(stage, total.Round(time.Millisecond)))
+			continue
+		}
+		attrs = append(attrs, slog.Group(stage,
+			slog.Duration("total", total.Round(time.Millisecond)),
+			slog.Int("count", n),
+			slog.Duration("avg", (total/time.Duration(n)).Round(time.Millisecond))))
+	}
+	return attrs
+}
+
+// log writes the summary of a run that began at start.
+func (t *stageTimings) log(start time.Time) {
+	if t == nil {
+		return
+	}
+	attrs := append([]any{slog.Duration("total", time.Since(start).Round(time.Millisecond))}, t.attrs()...)
+	slog.Info("Run timings", attrs...)
+}
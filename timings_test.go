@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStageTimings(t *testing.T) {
+	ctx, timings := withStageTimings(context.Background())
+	if timingsFrom(ctx) != timings {
+		t.Fatal("timings not in context")
+	}
+	start := time.Now().Add(-2 * time.Second)
+	timings.since("imap_fetch", start)
+	timings.since("chrome", start)
+	timings.since("chrome", start)
+	timings.since("deliver_email", start)
+
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "text", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+	timings.log(start)
+
+	out := buf.String()
+	for _, want := range []string{`msg="Run timings" total=2`, " imap_fetch=2", " chrome.total=4", " chrome.count=2 chrome.avg=2", " deliver_email=2"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in %q", want, out)
+		}
+	}
+	if strings.Index(out, "imap_fetch") > strings.Index(out, "chrome") || strings.Index(out, "chrome") > strings.Index(out, "deliver_email") {
+		t.Errorf("stages out of order: %q", out)
+	}
+}
+
+func TestStageTimings_Nil(t *testing.T) {
+	timings := timingsFrom(context.Background())
+	timings.since("chrome", time.Now())
+	timings.log(time.Now())
+}
+
+func TestDeliverAll_RecordsTimings(t *testing.T) {
+	ctx, timings := withStageTimings(context.Background())
+	deliverAll(ctx, []Sink{&fakeSink{name: "output directory"}}, &Delivery{}, DeliveryConfig{Attempts: 1})
+	if timings.count["deliver_output_directory"] != 1 {
+		t.Errorf("stages = %v", timings.stages)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// tlsVersions maps the tls.min_version values to crypto/tls versions.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSConfig configures the TLS connections to the IMAP and SMTP servers.
+type TLSConfig struct {
+	// MinVersion is the lowest accepted protocol version, "1.2" (Go's
+	// default) or "1.3".
+	MinVersion string `yaml:"min_version"`
+	// Ciphers lists the allowed TLS 1.2 cipher suites by their Go names,
+	// e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256; all secure ones if
+	// empty. TLS 1.3 suites cannot be restricted.
+	Ciphers []string `yaml:"ciphers"`
+	// CertFile and KeyFile are a PEM client certificate and key presented
+	// to servers that require one (mTLS).
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// CAFile is a PEM bundle of additional trusted CAs; smtp.ca_file
+	// takes precedence for SMTP.
+	CAFile string `yaml:"ca_file"`
+
+	minVersion uint16
+	ciphers    []uint16
+	cert       *tls.Certificate
+	roots      *x509.CertPool
+}
+
+// parse validates the settings and resolves the version and cipher suite
+// names.
+func (c *TLSConfig) parse() error {
+	if c.MinVersion != "" {
+		v, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return fmt.Errorf("tls.min_version must be 1.2 or 1.3, not %q", c.MinVersion)
+		}
+		c.minVersion = v
+	}
+	c.ciphers = nil
+	for _, name := range c.Ciphers {
+		id, ok := cipherSuite(name)
+		if !ok {
+			return fmt.Errorf("tls.ciphers: unknown or insecure cipher suite %q", name)
+		}
+		c.ciphers = append(c.ciphers, id)
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("tls.cert_file and tls.key_file must be set together")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return fmt.Errorf("tls: loading client certificate: %w", err)
+		}
+		c.cert = &cert
+	}
+	if c.CAFile != "" {
+		pool, err := loadCAFile(c.CAFile)
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+		c.roots = pool
+	}
+	return nil
+}
+
+// loadCAFile returns the system roots plus the certificates of the PEM
+// bundle file.
+func loadCAFile(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+// cipherSuite returns the ID of the secure TLS 1.2 cipher suite name.
+func cipherSuite(name string) (uint16, bool) {
+	for _, s := range tls.CipherSuites() {
+		if s.Name == name && !onlyTLS13(s) {
+			return s.ID, true
+		}
+	}
+	return 0, false
+}
+
+// onlyTLS13 reports whether s is a TLS 1.3 suite, which Go always enables.
+func onlyTLS13(s *tls.CipherSuite) bool {
+	return len(s.SupportedVersions) == 1 && s.SupportedVersions[0] == tls.VersionTLS13
+}
+
+// apply sets the minimum version, cipher suites, client certificate and
+// CA bundle on conf and returns it.
+func (c TLSConfig) apply(conf *tls.Config) *tls.Config {
+	if c.minVersion != 0 {
+		conf.MinVersion = c.minVersion
+	}
+	if len(c.ciphers) > 0 {
+		conf.CipherSuites = c.ciphers
+	}
+	if c.cert != nil {
+		conf.Certificates = []tls.Certificate{*c.cert}
+	}
+	if c.roots != nil {
+		conf.RootCAs = c.roots
+	}
+	return conf
+}
+
+// public returns the policy without the client certificate and CA bundle,
+// for connections to third-party servers like MX hosts.
+func (c TLSConfig) public() TLSConfig {
+	c.cert, c.roots = nil, nil
+	return c
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTLSConfig(t *testing.T) {
+	c := TLSConfig{MinVersion: "1.3", Ciphers: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}
+	if err := c.parse(); err != nil {
+		t.Fatal(err)
+	}
+	conf := c.apply(&tls.Config{ServerName: "imap.example.com"})
+	if conf.MinVersion != tls.VersionTLS13 || len(conf.CipherSuites) != 1 || conf.CipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("config = %+v", conf)
+	}
+	if conf := (TLSConfig{}).apply(&tls.Config{}); conf.MinVersion != 0 || conf.CipherSuites != nil {
+		t.Errorf("empty policy changed config: %+v", conf)
+	}
+
+	for _, c := range []TLSConfig{
+		{MinVersion: "1.0"},
+		{Ciphers: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{Ciphers: []string{"TLS_AES_128_GCM_SHA256"}},
+		{Ciphers: []string{"AES128"}},
+	} {
+		if err := c.parse(); err == nil {
+			t.Errorf("%+v: want error", c)
+		}
+	}
+}
+
+func TestTLSConfig_ClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, clientCert := testCertificate(t), testCertificate(t)
+	key, _ := x509.MarshalECPrivateKey(clientCert.PrivateKey.(*ecdsa.PrivateKey))
+	c := TLSConfig{
+		CertFile: filepath.Join(dir, "client.pem"),
+		KeyFile:  filepath.Join(dir, "client.key"),
+		CAFile:   writeCAFile(t, serverCert),
+	}
+	os.WriteFile(c.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientCert.Certificate[0]}), 0600)
+	os.WriteFile(c.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600)
+	if err := c.parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	peer := make(chan []*x509.Certificate, 1)
+	go func() {
+		defer serverConn.Close()
+		conn := tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{serverCert}, ClientAuth: tls.RequireAnyClientCert})
+		if err := conn.Handshake(); err != nil {
+			peer <- nil
+			return
+		}
+		peer <- conn.ConnectionState().PeerCertificates
+	}()
+	if err := tls.Client(clientConn, c.apply(&tls.Config{ServerName: "127.0.0.1"})).Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if certs := <-peer; len(certs) != 1 || !bytes.Equal(certs[0].Raw, clientCert.Certificate[0]) {
+		t.Errorf("server got client certificates %v", certs)
+	}
+
+	if conf := c.public().apply(&tls.Config{}); conf.Certificates != nil || conf.RootCAs != nil {
+		t.Errorf("public policy has credentials: %+v", conf)
+	}
+	if err := (&TLSConfig{CertFile: c.CertFile}).parse(); err == nil {
+		t.Error("cert_file without key_file: want error")
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TracingConfig configures exporting spans of the pipeline (fetch, clean,
+// convert, deliver) to an OpenTelemetry collector via OTLP/HTTP.
+type TracingConfig struct {
+	// Endpoint is the collector base URL, e.g. "http://localhost:4318";
+	// spans are posted to <endpoint>/v1/traces. Falls back to the
+	// OTEL_EXPORTER_OTLP_ENDPOINT environment variable.
+	Endpoint    string            `yaml:"endpoint"`
+	Headers     map[string]string `yaml:"headers"`
+	ServiceName string            `yaml:"service_name"`
+}
+
+// tracer collects the spans of a trace and exports them in the OTLP JSON
+// encoding once its root span ends. All methods are no-ops on a nil
+// receiver, so tracing needs no checks when disabled.
+type tracer struct {
+	url     string
+	headers map[string]string
+	service string
+	client  *http.Client
+
+	mu    sync.Mutex
+	spans map[[16]byte][]*span
+}
+
+// span is a timed operation of a trace.
+type span struct {
+	tracer  *tracer
+	traceID [16]byte
+	id      [8]byte
+	parent  [8]byte
+	name    string
+	start   time.Time
+	end     time.Time
+	attrs   []slog.Attr
+	err     error
+}
+
+// newTracer returns a tracer for cfg, or nil if no endpoint is configured.
+func newTracer(cfg TracingConfig) *tracer {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if endpoint == "" {
+		return nil
+	}
+	service := cfg.ServiceName
+	if service == "" {
+		service = "apple-invoice-pdf"
+	}
+	return &tracer{
+		url:     strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		headers: cfg.Headers,
+		service: service,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		spans:   make(map[[16]byte][]*span),
+	}
+}
+
+// tracerKey and spanKey carry the tracer and the current span in the
+// context.
+type (
+	tracerKey struct{}
+	spanKey   struct{}
+)
+
+// withTracer returns a context whose spans are recorded by t.
+func withTracer(ctx context.Context, t *tracer) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+// startSpan starts a span as child of the current span in ctx, or as root
+// of a new trace, with attributes given as alternating keys and values
+// like slog. It returns a context carrying the new span; without a tracer,
+// ctx and a nil span are returned.
+func startSpan(ctx context.Context, name string, args ...any) (context.Context, *span) {
+	t, _ := ctx.Value(tracerKey{}).(*tracer)
+	if t == nil {
+		return ctx, nil
+	}
+	s := &span{tracer: t, name: name, start: time.Now()}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok {
+		s.traceID, s.parent = parent.traceID, parent.id
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.id[:])
+	s.set(args...)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// set adds attributes given as alternating keys and values.
+func (s *span) set(args ...any) {
+	if s == nil {
+		return
+	}
+	var r slog.Record
+	r.Add(args...)
+	r.Attrs(func(a slog.Attr) bool {
+		s.attrs = append(s.attrs, a)
+		return true
+	})
+}
+
+// finish ends the span with the outcome err; no matching invoices is not
+// an error. Ending the root span exports the trace.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	if !errors.Is(err, errNoInvoices) {
+		s.err = err
+	}
+	t := s.tracer
+	t.mu.Lock()
+	t.spans[s.traceID] = append(t.spans[s.traceID], s)
+	var spans []*span
+	if s.parent == [8]byte{} {
+		spans = t.spans[s.traceID]
+		delete(t.spans, s.traceID)
+	}
+	t.mu.Unlock()
+
+	if spans != nil {
+		if err := t.export(spans); err != nil {
+			slog.Warn("Exporting traces failed", "err", err)
+		}
+	}
+}
+
+// export posts spans to the collector. It does not use the context of the
+// run, so a trace of an interrupted run is still exported.
+func (t *tracer) export(spans []*span) error {
+	body, err := json.Marshal(t.otlp(spans))
+	if err != nil {
+		return fmt.Errorf("encoding spans: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doRequest(t.client, req, nil)
+}
+
+// otlp returns the ExportTraceServiceRequest for spans in the OTLP/JSON
+// encoding: IDs are hex strings and 64-bit integers decimal strings.
+func (t *tracer) otlp(spans []*span) map[string]any {
+	var out []map[string]any
+	for _, s := range spans {
+		o := map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.id[:]),
+			"name":              s.name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+			"status":            map[string]any{"code": 1}, // STATUS_CODE_OK
+		}
+		if s.parent != [8]byte{} {
+			o["parentSpanId"] = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != nil {
+			o["status"] = map[string]any{"code": 2, "message": redactError(s.err)} // STATUS_CODE_ERROR
+		}
+		out = append(out, o)
+	}
+	return map[string]any{"resourceSpans": []any{map[string]any{
+		"resource": map[string]any{"attributes": otlpAttributes([]slog.Attr{slog.String("service.name", t.service)})},
+		"scopeSpans": []any{map[string]any{
+			"scope": map[string]any{"name": "apple-invoice-pdf"},
+			"spans": out,
+		}},
+	}}}
+}
+
+// otlpAttributes converts attributes to OTLP KeyValues.
+func otlpAttributes(attrs []slog.Attr) []map[string]any {
+	out := make([]map[string]any, 0, len(attrs))
+	for _, a := range attrs {
+		var value map[string]any
+		switch v := a.Value.Resolve(); v.Kind() {
+		case slog.KindInt64:
+			value = map[string]any{"intValue": strconv.FormatInt(v.Int64(), 10)}
+		case slog.KindUint64:
+			value = map[string]any{"intValue": strconv.FormatUint(v.Uint64(), 10)}
+		case slog.KindFloat64:
+			value = map[string]any{"doubleValue": v.Float64()}
+		case slog.KindBool:
+			value = map[string]any{"boolValue": v.Bool()}
+		default:
+			value = map[string]any{"stringValue": v.String()}
+		}
+		out = append(out, map[string]any{"key": a.Key, "value": value})
+	}
+	return out
+}
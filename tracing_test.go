@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// otlpRequest is the part of an OTLP/JSON export checked by the tests.
+type otlpRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []otlpKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []struct {
+				TraceID      string         `json:"traceId"`
+				SpanID       string         `json:"spanId"`
+				ParentSpanID string         `json:"parentSpanId"`
+				Name         string         `json:"name"`
+				Start        string         `json:"startTimeUnixNano"`
+				End          string         `json:"endTimeUnixNano"`
+				Attributes   []otlpKeyValue `json:"attributes"`
+				Status       struct {
+					Code    int    `json:"code"`
+					Message string `json:"message"`
+				} `json:"status"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func TestTracer_ExportsTraceOnRootEnd(t *testing.T) {
+	var requests []otlpRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("request %s with Authorization %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding export: %v", err)
+		}
+		requests = append(requests, req)
+	}))
+	defer srv.Close()
+
+	tr := newTracer(TracingConfig{Endpoint: srv.URL + "/", Headers: map[string]string{"Authorization": "Bearer token"}})
+	ctx, root := startSpan(withTracer(context.Background(), tr), "run")
+	_, child := startSpan(ctx, "convert", "uid", uint32(42))
+	child.finish(errors.New("chrome crashed"))
+	if len(requests) != 0 {
+		t.Fatal("exported before the root span ended")
+	}
+	root.finish(errNoInvoices)
+
+	if len(requests) != 1 {
+		t.Fatalf("got %d exports, want 1", len(requests))
+	}
+	rs := requests[0].ResourceSpans[0]
+	if got := rs.Resource.Attributes[0]; got.Key != "service.name" || got.Value["stringValue"] != "apple-invoice-pdf" {
+		t.Errorf("resource attributes = %+v", rs.Resource.Attributes)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	convert, run := spans[0], spans[1]
+	if convert.Name != "convert" || run.Name != "run" {
+		t.Fatalf("span names = %q, %q", convert.Name, run.Name)
+	}
+	if convert.TraceID != run.TraceID || convert.ParentSpanID != run.SpanID || run.ParentSpanID != "" {
+		t.Errorf("convert %s/%s parent %s, run %s/%s", convert.TraceID, convert.SpanID, convert.ParentSpanID, run.TraceID, run.SpanID)
+	}
+	if len(run.TraceID) != 32 || len(run.SpanID) != 16 || run.Start == "" || run.End < run.Start {
+		t.Errorf("run span = %+v", run)
+	}
+	if convert.Status.Code != 2 || convert.Status.Message != "chrome crashed" {
+		t.Errorf("convert status = %+v", convert.Status)
+	}
+	if run.Status.Code != 1 {
+		t.Errorf("run status = %+v, want OK without invoices", run.Status)
+	}
+	if got := convert.Attributes[0]; got.Key != "uid" || got.Value["intValue"] != "42" {
+		t.Errorf("convert attributes = %+v", convert.Attributes)
+	}
+}
+
+func TestTracer_Disabled(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	tr := newTracer(TracingConfig{})
+	if tr != nil {
+		t.Fatalf("newTracer = %v, want nil", tr)
+	}
+	ctx := withTracer(context.Background(), tr)
+	got, span := startSpan(ctx, "run")
+	if got != ctx || span != nil {
+		t.Errorf("startSpan without tracer = %v, %v", got, span)
+	}
+	span.set("invoices", 1)
+	span.finish(nil)
+}
+
+func TestTracer_EnvironmentEndpoint(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318")
+	tr := newTracer(TracingConfig{ServiceName: "invoices"})
+	if tr == nil || tr.url != "http://collector:4318/v1/traces" || tr.service != "invoices" {
+		t.Errorf("newTracer = %+v", tr)
+	}
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/emersion/go-imap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/rummeyer/apple-invoice-pdf/internal/htmlclean"
+	"github.com/rummeyer/apple-invoice-pdf/pkg/invoice"
+)
+
+// VendorProfile describes the invoice emails of one merchant: how to
+// recognize them, where their order number is and what to remove before
+// printing. Besides the built-in profiles, the vendors setting defines
+// profiles in YAML, see UnmarshalYAML.
+type VendorProfile struct {
+	// Name selects the profile in the vendor setting.
+	Name string
+	// Title names the vendor in filenames ({{.Vendor}}), Name if empty in
+	// the config.
+	Title string
+	// Subject matches the subjects of invoice emails, unless
+	// filter.subject is set.
+	Subject *regexp.Regexp
+	// From lists sender domains, unless filter.from is set; a sender
+	// matches if its host contains one of them.
+	From []string
+	// OrderLabels precede the order number in the HTML.
+	OrderLabels []string
+	// Remove lists CSS selectors of screen-only elements, and Rules
+	// further changes for printing, see htmlclean.Options.
+	Remove []string
+	Rules  []htmlclean.Rule
+	// Filename, if set, replaces filename.template for the vendor's
+	// invoices.
+	Filename string
+	// Attachment uses the PDF attached to the email as the invoice instead
+	// of printing the HTML, which is only printed if there is none.
+	Attachment bool
+}
+
+// vendorYAML is the config form of a VendorProfile.
+type vendorYAML struct {
+	Name        string      `yaml:"name"`
+	Title       string      `yaml:"title"`
+	Subject     string      `yaml:"subject"`
+	From        []string    `yaml:"from"`
+	OrderLabels []string    `yaml:"order_labels"`
+	Remove      []string    `yaml:"remove"`
+	Rules       []CleanRule `yaml:"rules"`
+	Filename    string      `yaml:"filename"`
+	Attachment  bool        `yaml:"attachment"`
+}
+
+// UnmarshalYAML reads a profile from the vendors setting, compiling the
+// subject regular expression.
+func (v *VendorProfile) UnmarshalYAML(node *yaml.Node) error {
+	var raw vendorYAML
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+	if raw.Name == "" {
+		return fmt.Errorf("line %d: vendor name is required", node.Line)
+	}
+	if raw.Subject == "" || len(raw.From) == 0 {
+		return fmt.Errorf("vendor %s: subject and from are required", raw.Name)
+	}
+	subject, err := regexp.Compile(raw.Subject)
+	if err != nil {
+		return fmt.Errorf("vendor %s: subject: %w", raw.Name, err)
+	}
+	rules, err := cleanRules(raw.Rules)
+	if err != nil {
+		return fmt.Errorf("vendor %s: %w", raw.Name, err)
+	}
+	if raw.Title == "" {
+		raw.Title = raw.Name
+	}
+	*v = VendorProfile{
+		Name:        raw.Name,
+		Title:       raw.Title,
+		Subject:     subject,
+		From:        raw.From,
+		OrderLabels: raw.OrderLabels,
+		Remove:      raw.Remove,
+		Rules:       rules,
+		Filename:    raw.Filename,
+		Attachment:  raw.Attachment,
+	}
+	return nil
+}
+
+var appleVendor = &VendorProfile{
+	Name:        "apple",
+	Title:       "Apple",
+	Subject:     regexp.MustCompile(`^Deine Rechnung von Apple$`),
+	From:        []string{"apple.com"},
+	OrderLabels: []string{"Bestellnummer:"},
+	Remove:      htmlclean.AppleRemove,
+	Rules:       htmlclean.AppleRules,
+}
+
+var amazonVendor = &VendorProfile{
+	Name:  "amazon",
+	Title: "Amazon",
+	// Order confirmations and invoices of the German and English shops,
+	// e.g. "Ihre Amazon.de Bestellung von „Kabel“" or "Ordered: „Kabel“"
+	Subject:     regexp.MustCompile(`^(?:Ihre Amazon\.\S+ Bestellung|Bestellt:|Ihre Rechnung|Your Amazon\.\S+ order|Ordered:|Your invoice)`),
+	From:        []string{"amazon."},
+	OrderLabels: []string{"Bestellnr.", "Bestellnummer", "Bestellung #", "Order #", "Order number"},
+	// Links to the order and the account, the help pages and the
+	// recommendations below the order
+	Remove: []string{
+		`a[href*="/gp/css/"]`,
+		`a[href*="/your-orders/"]`,
+		`a[href*="/gp/help/"]`,
+		`[id*="recommendation"]`,
+	},
+}
+
+var paypalVendor = &VendorProfile{
+	Name:  "paypal",
+	Title: "PayPal",
+	// Receipts of payments sent, e.g. "Ihr Beleg für Ihre Zahlung an
+	// Beispiel GmbH" or "Receipt for your payment to Example Inc."
+	Subject:     regexp.MustCompile(`^(?:Ihr Beleg für Ihre Zahlung an|Sie haben eine Zahlung an .+ gesendet|Receipt for your payment to|You sent a payment to)`),
+	From:        []string{"paypal."},
+	OrderLabels: []string{"Transaktionscode", "Transaction ID"},
+	// The "Details anzeigen" button, links to the account activity and the
+	// help and legal links of the footer
+	Remove: []string{
+		`a[href*="/myaccount/"]`,
+		`a[href*="/activity/"]`,
+		`a[href*="/smarthelp/"]`,
+		`a[href*="/webapps/mpp/"]`,
+		`[class*="button"]`,
+	},
+}
+
+var googleVendor = &VendorProfile{
+	Name:  "google",
+	Title: "Google",
+	// Google Play order receipts and Google Workspace invoice notices, e.g.
+	// "Ihre Google Play-Bestellbestätigung vom 03.05.2024" or "Google
+	// Workspace: Your invoice is available for example.com"
+	Subject:     regexp.MustCompile(`^(?:Ihre Google Play-Bestellbestätigung|Your Google Play Order Receipt|Google Workspace: (?:Ihre Rechnung|Your invoice))`),
+	From:        []string{"google.com"},
+	OrderLabels: []string{"Bestellnummer:", "Order number:", "Rechnungsnummer:", "Invoice number:"},
+	// Links to the order history, the payment and account settings and
+	// the help center
+	Remove: []string{
+		`a[href*="play.google.com/store/account"]`,
+		`a[href*="payments.google.com"]`,
+		`a[href*="myaccount.google.com"]`,
+		`a[href*="support.google.com"]`,
+	},
+}
+
+// Receipts of the common subscriptions
+
+var spotifyVendor = &VendorProfile{
+	Name:        "spotify",
+	Title:       "Spotify",
+	Subject:     regexp.MustCompile(`^(?:Deine Spotify.*(?:Quittung|Beleg|Rechnung)|Your Spotify.*receipt)`),
+	From:        []string{"spotify.com"},
+	OrderLabels: []string{"Bestellnummer", "Bestell-ID", "Order ID", "Order number"},
+	Remove: []string{
+		`a[href*="spotify.com/account"]`,
+		`a[href*="open.spotify.com"]`,
+		`a[href*="support.spotify.com"]`,
+	},
+}
+
+var netflixVendor = &VendorProfile{
+	Name:        "netflix",
+	Title:       "Netflix",
+	Subject:     regexp.MustCompile(`^(?:Ihre (?:Netflix-)?(?:Rechnung|Quittung|Zahlungsbestätigung)|Your (?:Netflix )?(?:receipt|invoice|payment))`),
+	From:        []string{"netflix.com"},
+	OrderLabels: []string{"Rechnungsnummer", "Invoice number", "Invoice ID"},
+	Remove: []string{
+		`a[href*="netflix.com/browse"]`,
+		`a[href*="netflix.com/YourAccount"]`,
+		`a[href*="help.netflix.com"]`,
+	},
+}
+
+var adobeVendor = &VendorProfile{
+	Name:        "adobe",
+	Title:       "Adobe",
+	Subject:     regexp.MustCompile(`^(?:Ihre Adobe-?(?:Rechnung|Bestellung)|Your Adobe (?:invoice|order)|Adobe: (?:Rechnung|Bestellbestätigung|Invoice|Order confirmation))`),
+	From:        []string{"adobe.com"},
+	OrderLabels: []string{"Rechnungsnummer", "Bestellnummer", "Invoice number", "Order number"},
+	Remove: []string{
+		`a[href*="account.adobe.com"]`,
+		`a[href*="helpx.adobe.com"]`,
+		`a[href*="adobe.com/go/"]`,
+	},
+}
+
+var microsoftVendor = &VendorProfile{
+	Name:  "microsoft",
+	Title: "Microsoft",
+	// Billing notices of Microsoft 365 and Azure, e.g. "Ihre Rechnung für
+	// Microsoft 365 Business Standard ist verfügbar" or "Your invoice is
+	// ready". The invoice itself is the attached PDF; the HTML is only a
+	// summary and printed if the PDF must be downloaded from the admin
+	// center.
+	Subject:     regexp.MustCompile(`^(?:Ihre (?:Microsoft[- ])?Rechnung.* ist (?:verfügbar|bereit)|Your (?:Microsoft )?invoice.* is (?:ready|available))`),
+	From:        []string{"microsoft.com"},
+	OrderLabels: []string{"Rechnungsnummer", "Invoice number", "Invoice ID"},
+	Remove: []string{
+		`a[href*="admin.microsoft.com"]`,
+		`a[href*="go.microsoft.com"]`,
+		`a[href*="privacy.microsoft.com"]`,
+	},
+	Attachment: true,
+}
+
+// Hosters and domain registrars, which attach the invoice as a PDF to a
+// short HTML or plain text email
+
+var hetznerVendor = &VendorProfile{
+	Name:  "hetzner",
+	Title: "Hetzner",
+	// e.g. "Hetzner Online GmbH - Rechnung R0012345678" or "Ihre Rechnung"
+	Subject:     regexp.MustCompile(`^(?:Hetzner .*- )?(?:Ihre |Your )?(?:Rechnung|Invoice)\b`),
+	From:        []string{"hetzner.com", "hetzner.de"},
+	OrderLabels: []string{"Rechnungsnummer", "Rechnungs-Nr.", "Invoice number"},
+	Remove: []string{
+		`a[href*="accounts.hetzner.com"]`,
+		`a[href*="robot.hetzner.com"]`,
+		`a[href*="console.hetzner.cloud"]`,
+	},
+	Attachment: true,
+}
+
+var ionosVendor = &VendorProfile{
+	Name:  "ionos",
+	Title: "IONOS",
+	// e.g. "Ihre Rechnung Nr. 202412345678 vom 03.05.2024" or "Your IONOS
+	// invoice"
+	Subject:     regexp.MustCompile(`^(?:Ihre (?:IONOS[- ])?Rechnung|Your (?:IONOS )?invoice)`),
+	From:        []string{"ionos.de", "ionos.com", "ionos.co.uk"},
+	OrderLabels: []string{"Rechnungsnummer", "Rechnung Nr.", "Invoice number", "Invoice No."},
+	Remove: []string{
+		`a[href*="my.ionos"]`,
+		`a[href*="ionos.de/hilfe"]`,
+		`a[href*="ionos.com/help"]`,
+	},
+	Attachment: true,
+}
+
+var netcupVendor = &VendorProfile{
+	Name:  "netcup",
+	Title: "netcup",
+	// e.g. "netcup GmbH - Rechnung RE12345678" or "Ihre Rechnung"
+	Subject:     regexp.MustCompile(`^(?:netcup .*- )?(?:Ihre |Your )?(?:Rechnung|Invoice)\b`),
+	From:        []string{"netcup.de", "netcup.com"},
+	OrderLabels: []string{"Rechnungsnummer", "Rechnung Nr.", "Invoice number"},
+	Remove: []string{
+		`a[href*="customercontrolpanel.de"]`,
+		`a[href*="helpcenter.netcup.com"]`,
+	},
+	Attachment: true,
+}
+
+// builtinVendors are the built-in profiles, in the order auto tries them.
+var builtinVendors = []*VendorProfile{
+	appleVendor, amazonVendor, googleVendor, paypalVendor,
+	spotifyVendor, netflixVendor, adobeVendor, microsoftVendor,
+	hetznerVendor, ionosVendor, netcupVendor,
+}
+
+// autoVendor in the vendor setting selects all profiles.
+const autoVendor = "auto"
+
+// VendorNames is the vendor setting: the name of a profile, a list of
+// names or "auto" for all built-in and configured profiles.
+type VendorNames []string
+
+func (n *VendorNames) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*n = VendorNames{node.Value}
+		return nil
+	}
+	var names []string
+	if err := node.Decode(&names); err != nil {
+		return err
+	}
+	*n = names
+	return nil
+}
+
+// counterparty returns name or, if empty, the title of the invoice's
+// vendor, for the contact, supplier or payee of bookkeeping targets.
+func counterparty(name string, inv ProcessedInvoice) string {
+	if name != "" {
+		return name
+	}
+	return inv.vendor().Title
+}
+
+// vendorTitle returns the title of the vendor all invoices are from, or ""
+// if they come from several.
+func vendorTitle(invoices []ProcessedInvoice) string {
+	var title string
+	for i, inv := range invoices {
+		if i > 0 && inv.vendor().Title != title {
+			return ""
+		}
+		title = inv.vendor().Title
+	}
+	return title
+}
+
+// vendorTitle returns the title of the only selected vendor, or "" if
+// there are several.
+func (cfg *Config) vendorTitle() string {
+	if vendors := cfg.vendors(); len(vendors) == 1 {
+		return vendors[0].Title
+	}
+	return ""
+}
+
+// invoiceNoun returns "<title>-Rechnung", or "Rechnung" without a title,
+// for summaries; append "en" for the plural.
+func invoiceNoun(title string) string {
+	if title == "" {
+		return "Rechnung"
+	}
+	return title + "-Rechnung"
+}
+
+// monthFilename returns the name of a file covering the invoices of the
+// month of date, like "05_2024_Rechnungen_Apple" plus suffix; the vendor
+// is left out for invoices of several vendors.
+func monthFilename(date time.Time, invoices []ProcessedInvoice, suffix string) string {
+	name := fmt.Sprintf("%02d_%04d_Rechnungen", date.Month(), date.Year())
+	if title := vendorTitle(invoices); title != "" {
+		name += "_" + sanitizeFilename(title)
+	}
+	return name + suffix
+}
+
+// vendors returns the profiles selected by cfg.Vendor, Apple's by default.
+func (cfg *Config) vendors() []*VendorProfile {
+	if slices.Equal(cfg.Vendor, VendorNames{autoVendor}) {
+		all := slices.Clone(cfg.Vendors)
+		for _, v := range builtinVendors {
+			if !slices.ContainsFunc(cfg.Vendors, func(c *VendorProfile) bool { return c.Name == v.Name }) {
+				all = append(all, v)
+			}
+		}
+		return all
+	}
+	var selected []*VendorProfile
+	for _, name := range cfg.Vendor {
+		if v := cfg.findVendor(name); v != nil {
+			selected = append(selected, v)
+		}
+	}
+	if len(selected) == 0 {
+		return []*VendorProfile{appleVendor}
+	}
+	return selected
+}
+
+// findVendor returns the profile called name from the vendors setting or,
+// if not defined there, the built-in one, or nil.
+func (cfg *Config) findVendor(name string) *VendorProfile {
+	for _, v := range cfg.Vendors {
+		if v.Name == name {
+			return v
+		}
+	}
+	for _, v := range builtinVendors {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// validateVendorNames checks that the vendor setting names known profiles,
+// with auto on its own.
+func validateVendorNames(cfg *Config) error {
+	for _, name := range cfg.Vendor {
+		if name == autoVendor {
+			if len(cfg.Vendor) > 1 {
+				return fmt.Errorf("vendor: %s selects all profiles and cannot be combined with others", autoVendor)
+			}
+			continue
+		}
+		if cfg.findVendor(name) == nil {
+			return fmt.Errorf("unknown vendor %q", name)
+		}
+	}
+	return nil
+}
+
+// detectVendor returns the selected profile that fits inv best. A sender
+// of one of its domains counts most, then a matching subject, an order
+// number found with its labels and its domains mentioned in the body, e.g.
+// in links. Ties go to the profile selected first.
+func (cfg *Config) detectVendor(inv InvoiceEmail) *VendorProfile {
+	vendors := cfg.vendors()
+	best, bestScore := vendors[0], 0
+	if len(vendors) == 1 {
+		return best
+	}
+	// Read a spooled body once for all profiles; without it only sender
+	// and subject count
+	if body, err := inv.HTML(); err == nil {
+		inv.HTMLBody = body
+	}
+	for _, v := range vendors {
+		if score := v.score(inv); score > bestScore {
+			best, bestScore = v, score
+		}
+	}
+	return best
+}
+
+// score rates how well inv fits the profile, see detectVendor.
+func (v *VendorProfile) score(inv InvoiceEmail) int {
+	score := 0
+	_, host, _ := strings.Cut(strings.ToLower(inv.From), "@")
+	body := strings.ToLower(inv.HTMLBody)
+	if host != "" && slices.ContainsFunc(v.From, func(d string) bool { return strings.Contains(host, strings.ToLower(d)) }) {
+		score += 8
+	}
+	if v.Subject.MatchString(normalizeSubject(inv.Subject)) {
+		score += 4
+	}
+	if len(v.OrderLabels) > 0 && v.orderNumber(inv.HTMLBody) != "" {
+		score += 2
+	}
+	if slices.ContainsFunc(v.From, func(d string) bool { return strings.Contains(body, strings.ToLower(d)) }) {
+		score++
+	}
+	return score
+}
+
+// validateVendors checks the vendors setting for duplicate names and
+// invalid filename templates; UnmarshalYAML has checked the rest.
+func validateVendors(vendors []*VendorProfile) error {
+	seen := make(map[string]bool)
+	for _, v := range vendors {
+		if seen[v.Name] {
+			return fmt.Errorf("vendors: duplicate name %q", v.Name)
+		}
+		seen[v.Name] = true
+		if v.Filename == "" {
+			continue
+		}
+		if _, err := template.New("filename").Parse(v.Filename); err != nil {
+			return fmt.Errorf("vendor %s: parsing filename template: %w", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// forwardPrefix matches the prefixes mail clients put before the subjects
+// of forwarded emails, e.g. "WG: " or "Fwd: ".
+var forwardPrefix = regexp.MustCompile(`(?i)^(?:(?:fwd?|wg)\s*:\s*)+`)
+
+// normalizeSubject collapses runs of whitespace, including the no-break
+// spaces and line folds left over from decoding encoded-words, into single
+// spaces, drops zero-width characters and strips forward prefixes.
+func normalizeSubject(subject string) string {
+	subject = strings.Map(func(r rune) rune {
+		if r == '\u200b' || r == '\ufeff' {
+			return -1
+		}
+		return r
+	}, subject)
+	subject = strings.Join(strings.FieldsFunc(subject, unicode.IsSpace), " ")
+	return forwardPrefix.ReplaceAllString(subject, "")
+}
+
+// isForward reports whether subject has a forward prefix.
+func isForward(subject string) bool {
+	return forwardPrefix.MatchString(strings.TrimLeftFunc(subject, unicode.IsSpace))
+}
+
+// matches checks the subject and sender of env against the profile, or
+// against filter.subject and filter.from where set. A forwarded email
+// matches on its subject alone, since it comes from whoever forwarded it;
+// its sender is checked with matchesEmail once it is unwrapped.
+func (v *VendorProfile) matches(env *imap.Envelope, cfg *Config) bool {
+	if isForward(env.Subject) {
+		return v.matchesSubject(env.Subject, cfg)
+	}
+	if !v.matchesSubject(env.Subject, cfg) {
+		return false
+	}
+	return slices.ContainsFunc(env.From, func(addr *imap.Address) bool { return v.matchesSender(addr.HostName, cfg) })
+}
+
+// matchesEmail is matches for a fetched email, after unwrapping forwards.
+func (v *VendorProfile) matchesEmail(inv InvoiceEmail, cfg *Config) bool {
+	_, host, _ := strings.Cut(inv.From, "@")
+	return v.matchesSubject(inv.Subject, cfg) && v.matchesSender(host, cfg)
+}
+
+// matchesSubject compares the normalized subject, see normalizeSubject.
+func (v *VendorProfile) matchesSubject(subject string, cfg *Config) bool {
+	subject = normalizeSubject(subject)
+	if cfg.Filter.Subject != "" {
+		return subject == normalizeSubject(cfg.Filter.Subject)
+	}
+	return v.Subject.MatchString(subject)
+}
+
+func (v *VendorProfile) matchesSender(host string, cfg *Config) bool {
+	domains := v.From
+	if cfg.Filter.From != "" {
+		domains = []string{cfg.Filter.From}
+	}
+	return slices.ContainsFunc(domains, func(domain string) bool {
+		return strings.Contains(strings.ToLower(host), strings.ToLower(domain))
+	})
+}
+
+// orderNumber returns the first word following one of the profile's order
+// labels, in the same element or, for layouts with the value below the
+// label, in the next one. Returns an empty string if none is found.
+func (v *VendorProfile) orderNumber(htmlContent string) string {
+	value := strings.TrimLeft(invoice.LabeledValue(htmlContent, v.OrderLabels...), ":#. ")
+	if fields := strings.Fields(value); len(fields) > 0 {
+		return fields[0]
+	}
+	return invoice.WordAfter(htmlContent, v.OrderLabels...)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVendorProfile_Matches(t *testing.T) {
+	for _, tc := range []struct {
+		vendor, subject, host string
+		want                  bool
+	}{
+		{"amazon", "Ihre Amazon.de Bestellung von „USB-C Kabel“", "amazon.de", true},
+		{"amazon", "Bestellt: „USB-C Kabel“", "bestellbestaetigung.amazon.de", true},
+		{"amazon", "Your Amazon.com order #112-1234567-1234567", "amazon.com", true},
+		{"amazon", "Ihre Amazon.de Bestellung von „USB-C Kabel“", "example.com", false},
+		{"amazon", "Ihr Paket wurde zugestellt", "amazon.de", false},
+		{"amazon", "Deine Rechnung von Apple", "email.apple.com", false},
+		{"paypal", "Ihr Beleg für Ihre Zahlung an Beispiel GmbH", "paypal.de", true},
+		{"paypal", "Sie haben eine Zahlung an Beispiel GmbH gesendet", "paypal.de", true},
+		{"paypal", "Receipt for your payment to Example Inc.", "paypal.com", true},
+		{"paypal", "Sie haben eine Zahlung erhalten", "paypal.de", false},
+		{"paypal", "Receipt for your payment to Example Inc.", "paypal-support.example", false},
+		{"google", "Ihre Google Play-Bestellbestätigung vom 03.05.2024", "google.com", true},
+		{"google", "Your Google Play Order Receipt from May 3, 2024", "google.com", true},
+		{"google", "Google Workspace: Your invoice is available for example.com", "google.com", true},
+		{"google", "Sicherheitswarnung", "accounts.google.com", false},
+		{"spotify", "Deine Spotify Premium-Quittung", "spotify.com", true},
+		{"spotify", "Your Spotify Premium receipt", "spotify.com", true},
+		{"spotify", "Dein Wochenmix ist da", "spotify.com", false},
+		{"netflix", "Ihre Rechnung", "members.netflix.com", true},
+		{"netflix", "Your Netflix receipt", "netflix.com", true},
+		{"netflix", "Neu auf Netflix", "netflix.com", false},
+		{"adobe", "Ihre Adobe-Rechnung", "mail.adobe.com", true},
+		{"adobe", "Adobe: Bestellbestätigung", "adobe.com", true},
+		{"adobe", "Neue Funktionen in Photoshop", "adobe.com", false},
+		{"microsoft", "Ihre Rechnung für Microsoft 365 Business Standard ist verfügbar", "microsoft.com", true},
+		{"microsoft", "Your invoice is ready", "microsoft.com", true},
+		{"microsoft", "Ihr Microsoft-Konto: Sicherheitswarnung", "microsoft.com", false},
+		{"hetzner", "Hetzner Online GmbH - Rechnung R0012345678", "hetzner.com", true},
+		{"hetzner", "Rechnungsadresse geändert", "hetzner.com", false},
+		{"ionos", "Ihre Rechnung Nr. 202412345678 vom 03.05.2024", "ionos.de", true},
+		{"ionos", "Ihre Domain läuft ab", "ionos.de", false},
+		{"netcup", "netcup GmbH - Rechnung RE12345678", "netcup.de", true},
+		{"netcup", "Ihre Rechnung", "example.com", false},
+	} {
+		cfg := &Config{Vendor: VendorNames{tc.vendor}}
+		if got := matchesInvoice(makeEnvelope(tc.subject, tc.host, time.Now()), cfg); got != tc.want {
+			t.Errorf("%s: %q from %s: match = %v, want %v", tc.vendor, tc.subject, tc.host, got, tc.want)
+		}
+	}
+
+	// filter.subject and filter.from override the profile
+	cfg := &Config{Vendor: VendorNames{"amazon"}}
+	cfg.Filter.Subject, cfg.Filter.From = "Ihre Rechnung", "marketplace.example"
+	if !matchesInvoice(makeEnvelope("Ihre Rechnung", "marketplace.example", time.Now()), cfg) {
+		t.Error("expected filter.* to override the profile")
+	}
+}
+
+func TestVendorProfile_OrderNumber(t *testing.T) {
+	for _, tc := range []struct {
+		vendor *VendorProfile
+		html   string
+		want   string
+	}{
+		{appleVendor, `<p>Bestellnummer: MX1</p>`, "MX1"},
+		{amazonVendor, `<table><tr><td>Bestellnr. 302-1234567-7654321</td></tr></table>`, "302-1234567-7654321"},
+		{amazonVendor, `<p>Order #112-1234567-1234567 placed on May 3</p>`, "112-1234567-1234567"},
+		{amazonVendor, `<p>Vielen Dank</p>`, ""},
+		{googleVendor, "<p>Vielen Dank</p>\n<p>Bestellnummer: GPA.3312-4455-6677-88990</p>", "GPA.3312-4455-6677-88990"},
+		{googleVendor, "<p>Summary</p>\n<table><tr><td>Invoice number:</td><td>5012345678</td></tr></table>", "5012345678"},
+		{spotifyVendor, "<p>Danke</p>\n<table><tr><td>Bestellnummer</td><td>S1234567890</td></tr></table>", "S1234567890"},
+		{adobeVendor, "<p>Danke</p>\n<p>Rechnungsnummer: IDE2024001234</p>", "IDE2024001234"},
+		{ionosVendor, "<p>Guten Tag,</p>\n<p>Ihre Rechnung Nr. 202412345678 vom 03.05.2024 liegt bei.</p>", "202412345678"},
+		{netcupVendor, "<p>Guten Tag,</p>\n<p>Rechnungsnummer: RE12345678</p>", "RE12345678"},
+		{microsoftVendor, "<p>Your invoice is ready</p>\n<table><tr><td>Invoice number</td><td>E0300ABCDE</td></tr></table>", "E0300ABCDE"},
+		{paypalVendor, "<p>Sie haben 12,00 € an Beispiel GmbH gesendet</p>\n<table><tr><td><span>Transaktionscode</span>\n<br><span>1AB23456CD789012E</span></td></tr></table>", "1AB23456CD789012E"},
+	} {
+		if got := tc.vendor.orderNumber(tc.html); got != tc.want {
+			t.Errorf("%s: orderNumber(%q) = %q, want %q", tc.vendor.Name, tc.html, got, tc.want)
+		}
+	}
+}
+
+func TestProcessInvoices_Vendor(t *testing.T) {
+	cfg := &Config{Vendor: VendorNames{"amazon"}}
+	cfg.Filename.Template = defaultFilenameTemplate
+	inv := InvoiceEmail{
+		UID:      1,
+		Date:     time.Date(2024, 5, 3, 10, 0, 0, 0, time.Local),
+		HTMLBody: "<p>Vielen Dank für Ihre Bestellung</p>\n<p>Bestellnr. 302-1234567-7654321</p>\n<a href=\"https://www.amazon.de/gp/css/order-details\">Bestellung anzeigen</a>",
+	}
+	processed, failures := processInvoices(context.Background(), cfg, []Transformer{cleanTransformer{}, extractTransformer{}}, []InvoiceEmail{inv})
+	if len(failures) != 0 || len(processed) != 1 {
+		t.Fatalf("processed = %v, failures = %v", processed, failures)
+	}
+	p := processed[0]
+	if p.Filename != "05_2024_Rechnung_Amazon_302-1234567-7654321" {
+		t.Errorf("filename = %q", p.Filename)
+	}
+	if p.HTML == inv.HTMLBody || p.Vendor != amazonVendor {
+		t.Errorf("HTML = %q, vendor = %v; want the order link removed", p.HTML, p.Vendor)
+	}
+}
+
+func TestPDFTransformer_Attachment(t *testing.T) {
+	raw := "From: microsoft-noreply@microsoft.com\r\nSubject: Your invoice is ready\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/html\r\n\r\n<p>Invoice number: E0300ABCDE</p>\r\n" +
+		"--b\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=\"E0300ABCDE.pdf\"\r\n\r\n%PDF-1.7 invoice\r\n" +
+		"--b--\r\n"
+	p := &ProcessedInvoice{Email: InvoiceEmail{Raw: []byte(raw)}, HTML: "<p>Invoice number: E0300ABCDE</p>", Vendor: microsoftVendor}
+	if err := (pdfTransformer{}).Transform(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	if string(p.PDF) != "%PDF-1.7 invoice" {
+		t.Errorf("PDF = %q, want the attachment", p.PDF)
+	}
+}
+
+func TestLoadConfig_UnknownVendor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("vendor: ebay\n"), 0644)
+	if _, err := loadConfig(path); err == nil {
+		t.Error("expected error for unknown vendor")
+	}
+}
+
+func TestLoadConfig_Vendors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(`
+vendor: telekom
+vendors:
+  - name: telekom
+    title: Telekom
+    subject: "^Ihre Telekom Rechnung (?:zu|für) "
+    from: ["telekom.de"]
+    order_labels: ["Rechnungsnummer:"]
+    remove: ["a.button"]
+    filename: "{{.Year}}-{{.Month}}_{{.Vendor}}_{{.OrderNumber}}"
+    attachment: true
+`), 0644)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := cfg.vendors()[0]; v.Title != "Telekom" || !v.Subject.MatchString("Ihre Telekom Rechnung für Mai") || v.Remove[0] != "a.button" || !v.Attachment {
+		t.Fatalf("vendor = %+v", v)
+	}
+	if !matchesInvoice(makeEnvelope("Ihre Telekom Rechnung zu Vertrag 123", "telekom.de", time.Now()), cfg) {
+		t.Error("expected the configured vendor to match")
+	}
+
+	inv := InvoiceEmail{UID: 1, Date: time.Date(2024, 5, 3, 10, 0, 0, 0, time.Local), HTMLBody: "<h1>Rechnung</h1>\n<p>Rechnungsnummer: R0012345678</p>"}
+	processed, _ := processInvoices(context.Background(), cfg, []Transformer{extractTransformer{}}, []InvoiceEmail{inv})
+	if len(processed) != 1 || processed[0].Filename != "2024-05_Telekom_R0012345678" {
+		t.Errorf("processed = %+v, want the vendor's filename template", processed)
+	}
+
+	for _, bad := range []string{
+		"vendors:\n  - name: x\n    subject: \"(\"\n    from: [x.com]\n",
+		"vendors:\n  - subject: a\n    from: [x.com]\n",
+		"vendors:\n  - name: x\n    subject: a\n",
+		"vendors:\n  - {name: x, subject: a, from: [x.com]}\n  - {name: x, subject: b, from: [x.com]}\n",
+		"vendors:\n  - {name: x, subject: a, from: [x.com], filename: \"{{.Year\"}\n",
+		"vendor: x\nvendors:\n  - {name: y, subject: a, from: [x.com]}\n",
+	} {
+		os.WriteFile(path, []byte(bad), 0644)
+		if _, err := loadConfig(path); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestDetectVendor(t *testing.T) {
+	cfg := &Config{Vendor: VendorNames{autoVendor}}
+	for _, tc := range []struct {
+		inv  InvoiceEmail
+		want *VendorProfile
+	}{
+		{InvoiceEmail{From: "no_reply@email.apple.com", Subject: "Deine Rechnung von Apple", HTMLBody: "<p>Bestellnummer: MX1</p>"}, appleVendor},
+		{InvoiceEmail{From: "bestellbestaetigung@amazon.de", Subject: "Bestellt: „Kabel“", HTMLBody: "<p>Bestellnr. 302-1</p>"}, amazonVendor},
+		// Forwarded, so the sender does not tell; the subject and order label do
+		{InvoiceEmail{From: "jane@example.com", Subject: "Ihre Google Play-Bestellbestätigung", HTMLBody: "<p>Bestellnummer: GPA.1</p>"}, googleVendor},
+		{InvoiceEmail{From: "jane@example.com", Subject: "Fwd: Beleg", HTMLBody: `<p>Transaktionscode 1AB</p><a href="https://www.paypal.de/myaccount/">`}, paypalVendor},
+		// Both bill "Rechnungsnummer"; the sender decides
+		{InvoiceEmail{From: "info@mailer.netflix.com", Subject: "Ihre Rechnung", HTMLBody: "<p>x</p>\n<p>Rechnungsnummer: N1</p>"}, netflixVendor},
+		// Nothing fits, the first profile takes it
+		{InvoiceEmail{From: "jane@example.com", Subject: "Hallo"}, appleVendor},
+	} {
+		if got := cfg.detectVendor(tc.inv); got != tc.want {
+			t.Errorf("%q from %s: vendor = %s, want %s", tc.inv.Subject, tc.inv.From, got.Name, tc.want.Name)
+		}
+	}
+
+	cfg.Vendor = VendorNames{"paypal", "amazon"}
+	if !matchesInvoice(makeEnvelope("Bestellt: „Kabel“", "amazon.de", time.Now()), cfg) || matchesInvoice(makeEnvelope("Deine Rechnung von Apple", "email.apple.com", time.Now()), cfg) {
+		t.Error("expected only the selected vendors to match")
+	}
+}
+
+func TestLoadConfig_VendorNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	for src, want := range map[string]int{
+		"vendor: amazon\n":                  1,
+		"vendor: [apple, amazon, paypal]\n": 3,
+		"vendor: auto\nvendors:\n  - {name: telekom, subject: a, from: [telekom.de]}\n  - {name: apple, subject: b, from: [apple.com]}\n": len(builtinVendors) + 1,
+	} {
+		os.WriteFile(path, []byte(src), 0644)
+		cfg, err := loadConfig(path)
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+		if got := len(cfg.vendors()); got != want {
+			t.Errorf("%q: %d vendors, want %d", src, got, want)
+		}
+	}
+	for _, bad := range []string{"vendor: [apple, ebay]\n", "vendor: [auto, apple]\n"} {
+		os.WriteFile(path, []byte(bad), 0644)
+		if _, err := loadConfig(path); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestVendorTitles(t *testing.T) {
+	apple, amazon := testProcessedInvoice(), testProcessedInvoice()
+	amazon.Vendor = amazonVendor
+	if got := counterparty("", amazon); got != "Amazon" {
+		t.Errorf("counterparty = %q, want the vendor's title", got)
+	}
+	if got := counterparty("Amazon EU S.à r.l.", amazon); got != "Amazon EU S.à r.l." {
+		t.Errorf("counterparty = %q, want the configured name", got)
+	}
+	date := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	if got := monthFilename(date, []ProcessedInvoice{amazon}, ".zip"); got != "05_2024_Rechnungen_Amazon.zip" {
+		t.Errorf("single vendor: %s", got)
+	}
+	if got := monthFilename(date, []ProcessedInvoice{apple, amazon}, ".zip"); got != "05_2024_Rechnungen.zip" {
+		t.Errorf("several vendors: %s", got)
+	}
+	d := &Delivery{Invoices: []ProcessedInvoice{amazon}}
+	if s := d.Summary(); !strings.HasPrefix(s, "1 Amazon-Rechnung(en)") {
+		t.Errorf("summary = %q", s)
+	}
+}
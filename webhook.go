@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// WebhookConfig configures delivery of each invoice to an HTTP endpoint.
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Secret  string            `yaml:"secret"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// webhookSink POSTs every invoice as multipart/form-data with a "metadata"
+// JSON part and a "file" PDF part. If a secret is configured, the body is
+// signed with HMAC-SHA256 in the X-Signature-256 header ("sha256=<hex>").
+type webhookSink struct {
+	cfg    WebhookConfig
+	client *http.Client
+	SinkProgress
+}
+
+func (s *webhookSink) Name() string { return "webhook" }
+
+func (s *webhookSink) Deliver(ctx context.Context, d *Delivery) error {
+	for _, inv := range d.Invoices {
+		if s.isDone(invoiceKey(inv)) {
+			continue
+		}
+		if err := s.post(ctx, inv); err != nil {
+			return fmt.Errorf("posting %s: %w", inv.Filename, err)
+		}
+		s.markDone(invoiceKey(inv))
+		slog.Info("Posted invoice to webhook", "file", inv.Filename+".pdf")
+	}
+	return nil
+}
+
+// post sends a single invoice.
+func (s *webhookSink) post(ctx context.Context, inv ProcessedInvoice) error {
+	metadata, err := json.Marshal(inv.Metadata())
+	if err != nil {
+		return fmt.Errorf("encoding metadata: %w", err)
+	}
+	data, err := inv.readPDF()
+	if err != nil {
+		return fmt.Errorf("reading PDF: %w", err)
+	}
+	body, contentType, err := newMultipartBody(nil, []multipartFile{
+		{Field: "metadata", Filename: "metadata.json", ContentType: "application/json", Data: metadata},
+		{Field: "file", Filename: inv.Filename + ".pdf", ContentType: "application/pdf", Data: data},
+	})
+	if err != nil {
+		return err
+	}
+	signature := ""
+	if s.cfg.Secret != "" {
+		signature = webhookSignature(s.cfg.Secret, body.Bytes())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if signature != "" {
+		req.Header.Set("X-Signature-256", signature)
+	}
+	return doRequest(s.client, req, nil)
+}
+
+// webhookSignature returns the "sha256=<hex>" HMAC of body, as used by
+// GitHub-style webhook receivers.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookSink_Deliver(t *testing.T) {
+	var (
+		gotMetadata InvoiceMetadata
+		gotPDF      string
+		gotHeader   string
+		validSig    bool
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		validSig = r.Header.Get("X-Signature-256") == webhookSignature("s3cret", body)
+		gotHeader = r.Header.Get("X-Source")
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		md, _, _ := r.FormFile("metadata")
+		json.NewDecoder(md).Decode(&gotMetadata)
+		file, header, _ := r.FormFile("file")
+		data, _ := io.ReadAll(file)
+		gotPDF = header.Filename + ":" + string(data)
+	}))
+	defer srv.Close()
+
+	sink := &webhookSink{cfg: WebhookConfig{URL: srv.URL, Secret: "s3cret", Headers: map[string]string{"X-Source": "apple-invoice-pdf"}}, client: newAPIClient()}
+	inv := testProcessedInvoice()
+	inv.Filename = "invoice"
+	inv.PDF = []byte("%PDF")
+	if err := sink.Deliver(context.Background(), &Delivery{Invoices: []ProcessedInvoice{inv}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !validSig {
+		t.Error("signature header does not match body")
+	}
+	if gotHeader != "apple-invoice-pdf" {
+		t.Errorf("custom header = %q", gotHeader)
+	}
+	if gotMetadata.OrderNumber != "MXYZ123" || gotMetadata.TotalCents != 999 {
+		t.Errorf("metadata = %+v", gotMetadata)
+	}
+	if gotPDF != "invoice.pdf:%PDF" {
+		t.Errorf("file = %q", gotPDF)
+	}
+}
+
+func TestWebhookSink_ErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	sink := &webhookSink{cfg: WebhookConfig{URL: srv.URL}, client: newAPIClient()}
+	if err := sink.Deliver(context.Background(), &Delivery{Invoices: []ProcessedInvoice{testProcessedInvoice()}}); err == nil {
+		t.Error("expected error for 500 response")
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	// Example from GitHub's webhook validation docs
+	got := webhookSignature("It's a Secret to Everybody", []byte("Hello, World!"))
+	want := "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	if got != want {
+		t.Errorf("webhookSignature() = %s, want %s", got, want)
+	}
+}
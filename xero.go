@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// XeroConfig configures draft bills in Xero.
+type XeroConfig struct {
+	OAuthConfig `yaml:",inline"`
+	TenantID    string `yaml:"tenant_id"`
+	// Contact is matched by name in Xero and created if missing.
+	Contact     string `yaml:"contact"`
+	AccountCode string `yaml:"account_code"`
+	APIURL      string `yaml:"api_url"`
+	TokenURL    string `yaml:"token_url"`
+}
+
+// xeroSink creates a draft bill (ACCPAY invoice) per invoice and attaches
+// the PDF to it.
+type xeroSink struct {
+	cfg    XeroConfig
+	oauth  *oauthRefresher
+	client *http.Client
+	SinkProgress
+}
+
+// newXeroSink applies defaults and checks required settings.
+func newXeroSink(cfg XeroConfig) (*xeroSink, error) {
+	if err := cfg.OAuthConfig.validate(); err != nil {
+		return nil, fmt.Errorf("xero: %w", err)
+	}
+	if cfg.TenantID == "" {
+		return nil, fmt.Errorf("xero: tenant_id is required")
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = "https://api.xero.com/api.xro/2.0"
+	}
+	if cfg.TokenURL == "" {
+		cfg.TokenURL = "https://identity.xero.com/connect/token"
+	}
+	cfg.APIURL = strings.TrimRight(cfg.APIURL, "/")
+	client := newAPIClient()
+	return &xeroSink{
+		cfg:    cfg,
+		oauth:  &oauthRefresher{cfg: cfg.OAuthConfig, tokenURL: cfg.TokenURL, client: client},
+		client: client,
+	}, nil
+}
+
+func (s *xeroSink) Name() string { return "xero" }
+
+func (s *xeroSink) Deliver(ctx context.Context, d *Delivery) error {
+	token, err := s.oauth.accessToken(ctx)
+	if err != nil {
+		return fmt.Errorf("xero: %w", err)
+	}
+	for _, inv := range d.Invoices {
+		key := invoiceKey(inv)
+		if s.isDone(key) {
+			continue
+		}
+		if inv.Total.IsZero() {
+			slog.Info("Skipping invoice for Xero: no amount found", "file", inv.Filename+".pdf")
+			continue
+		}
+		id, ok := s.created(key)
+		if !ok {
+			if id, err = s.createBill(ctx, token, inv); err != nil {
+				return fmt.Errorf("xero: creating bill for %s: %w", inv.Filename, err)
+			}
+			s.setCreated(key, id)
+		}
+		if err := s.attach(ctx, token, id, inv); err != nil {
+			return fmt.Errorf("xero: attaching %s: %w", inv.Filename, err)
+		}
+		s.markDone(key)
+		slog.Info("Created Xero draft bill", "file", inv.Filename+".pdf")
+	}
+	return nil
+}
+
+// newXeroBill builds a draft ACCPAY invoice with tax-inclusive amounts.
+func newXeroBill(cfg XeroConfig, inv ProcessedInvoice) map[string]any {
+	description := strings.TrimSpace(inv.vendor().Title + " " + inv.OrderNumber)
+	line := map[string]any{
+		"Description": description,
+		"Quantity":    1,
+		"UnitAmount":  inv.Total.Decimal(),
+	}
+	if cfg.AccountCode != "" {
+		line["AccountCode"] = cfg.AccountCode
+	}
+	bill := map[string]any{
+		"Type":            "ACCPAY",
+		"Status":          "DRAFT",
+		"Contact":         map[string]string{"Name": counterparty(cfg.Contact, inv)},
+		"Date":            inv.Email.Date.Format("2006-01-02"),
+		"DueDate":         inv.Email.Date.Format("2006-01-02"),
+		"Reference":       inv.OrderNumber,
+		"CurrencyCode":    inv.Total.Currency,
+		"LineAmountTypes": "Inclusive",
+		"LineItems":       []map[string]any{line},
+	}
+	if inv.InvoiceNumber != "" {
+		bill["InvoiceNumber"] = inv.InvoiceNumber
+	}
+	return bill
+}
+
+// createBill creates the draft bill and returns its InvoiceID.
+func (s *xeroSink) createBill(ctx context.Context, token string, inv ProcessedInvoice) (string, error) {
+	var resp struct {
+		Invoices []struct {
+			InvoiceID string `json:"InvoiceID"`
+		} `json:"Invoices"`
+	}
+	payload := map[string]any{"Invoices": []map[string]any{newXeroBill(s.cfg, inv)}}
+	if err := doJSON(ctx, s.client, http.MethodPost, s.cfg.APIURL+"/Invoices", s.header(token), payload, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Invoices) == 0 {
+		return "", fmt.Errorf("no invoice in response")
+	}
+	return resp.Invoices[0].InvoiceID, nil
+}
+
+// attach uploads the PDF as the raw request body.
+func (s *xeroSink) attach(ctx context.Context, token, invoiceID string, inv ProcessedInvoice) error {
+	u := s.cfg.APIURL + "/Invoices/" + invoiceID + "/Attachments/" + url.PathEscape(inv.Filename+".pdf")
+	data, err := inv.readPDF()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header = s.header(token)
+	req.Header.Set("Content-Type", "application/pdf")
+	req.Header.Set("Accept", "application/json")
+	return doRequest(s.client, req, nil)
+}
+
+func (s *xeroSink) header(token string) http.Header {
+	return http.Header{
+		"Authorization":  {"Bearer " + token},
+		"Xero-Tenant-Id": {s.cfg.TenantID},
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestXeroSink_Deliver(t *testing.T) {
+	var payload struct {
+		Invoices []map[string]any `json:"Invoices"`
+	}
+	var attachment string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "at"})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer at" || r.Header.Get("Xero-Tenant-Id") != "tenant" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/Invoices":
+			json.NewDecoder(r.Body).Decode(&payload)
+			json.NewEncoder(w).Encode(map[string]any{"Invoices": []map[string]string{{"InvoiceID": "abc"}}})
+		case r.Method == http.MethodPut && r.URL.Path == "/Invoices/abc/Attachments/invoice.pdf":
+			data, _ := io.ReadAll(r.Body)
+			attachment = r.Header.Get("Content-Type") + ":" + string(data)
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	sink, err := newXeroSink(XeroConfig{
+		OAuthConfig: OAuthConfig{ClientID: "id", ClientSecret: "secret", RefreshToken: "rt", TokenFile: filepath.Join(t.TempDir(), "token")},
+		TenantID:    "tenant",
+		AccountCode: "463",
+		APIURL:      srv.URL,
+		TokenURL:    srv.URL + "/token",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	inv := testProcessedInvoice()
+	inv.Filename = "invoice"
+	inv.PDF = []byte("%PDF")
+	if err := sink.Deliver(context.Background(), &Delivery{Invoices: []ProcessedInvoice{inv}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bill := payload.Invoices[0]
+	if bill["Type"] != "ACCPAY" || bill["Status"] != "DRAFT" || bill["Date"] != "2024-05-14" || bill["InvoiceNumber"] != "DE/2024/0815" || bill["LineAmountTypes"] != "Inclusive" {
+		t.Errorf("bill = %v", bill)
+	}
+	if bill["Contact"].(map[string]any)["Name"] != "Apple" {
+		t.Errorf("contact = %v", bill["Contact"])
+	}
+	line := bill["LineItems"].([]any)[0].(map[string]any)
+	if line["UnitAmount"] != 9.99 || line["AccountCode"] != "463" {
+		t.Errorf("line = %v", line)
+	}
+	if attachment != "application/pdf:%PDF" {
+		t.Errorf("attachment = %q", attachment)
+	}
+}
+
+func TestNewXeroSink_RequiresTenant(t *testing.T) {
+	_, err := newXeroSink(XeroConfig{OAuthConfig: OAuthConfig{ClientID: "id", ClientSecret: "s", TokenFile: "/tmp/x"}})
+	if err == nil {
+		t.Error("expected error without tenant_id")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// YNABConfig configures transaction imports into YNAB.
+type YNABConfig struct {
+	Token      string `yaml:"token"`
+	BudgetID   string `yaml:"budget_id"`
+	AccountID  string `yaml:"account_id"`
+	CategoryID string `yaml:"category_id"`
+	Payee      string `yaml:"payee"`
+	// Approved books the transactions directly instead of leaving them for
+	// review in the YNAB inbox.
+	Approved bool   `yaml:"approved"`
+	APIURL   string `yaml:"api_url"`
+}
+
+// ynabSink records each invoice as an outflow. YNAB has no attachments, so
+// only the parsed data is transferred.
+type ynabSink struct {
+	cfg    YNABConfig
+	client *http.Client
+}
+
+// newYNABSink applies defaults and checks required settings.
+func newYNABSink(cfg YNABConfig) (*ynabSink, error) {
+	if cfg.AccountID == "" {
+		return nil, fmt.Errorf("ynab: account_id is required")
+	}
+	if cfg.BudgetID == "" {
+		cfg.BudgetID = "last-used"
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = "https://api.ynab.com/v1"
+	}
+	cfg.APIURL = strings.TrimRight(cfg.APIURL, "/")
+	return &ynabSink{cfg: cfg, client: newAPIClient()}, nil
+}
+
+func (s *ynabSink) Name() string { return "ynab" }
+
+// ynabTransaction is a new transaction; amounts are in milliunits.
+type ynabTransaction struct {
+	AccountID  string `json:"account_id"`
+	Date       string `json:"date"`
+	Amount     int64  `json:"amount"`
+	PayeeName  string `json:"payee_name"`
+	CategoryID string `json:"category_id,omitempty"`
+	Memo       string `json:"memo,omitempty"`
+	Cleared    string `json:"cleared"`
+	Approved   bool   `json:"approved"`
+	ImportID   string `json:"import_id,omitempty"`
+}
+
+// newYNABTransaction builds the outflow for an invoice. The import ID makes
+// YNAB ignore invoices that were already imported.
+func newYNABTransaction(cfg YNABConfig, inv ProcessedInvoice) ynabTransaction {
+	tx := ynabTransaction{
+		AccountID:  cfg.AccountID,
+		Date:       inv.Email.Date.Format("2006-01-02"),
+		Amount:     -inv.Total.Cents * 10,
+		PayeeName:  counterparty(cfg.Payee, inv),
+		CategoryID: cfg.CategoryID,
+		Memo:       strings.TrimSpace(inv.vendor().Title + " " + inv.OrderNumber),
+		Cleared:    "cleared",
+		Approved:   cfg.Approved,
+	}
+	// import_id is limited to 36 characters
+	if inv.OrderNumber != "" && len(inv.OrderNumber) <= 30 {
+		tx.ImportID = "apple:" + inv.OrderNumber
+	}
+	return tx
+}
+
+func (s *ynabSink) Deliver(ctx context.Context, d *Delivery) error {
+	var txs []ynabTransaction
+	for _, inv := range d.Invoices {
+		if inv.Total.IsZero() {
+			slog.Info("Skipping invoice for YNAB: no amount found", "file", inv.Filename+".pdf")
+			continue
+		}
+		txs = append(txs, newYNABTransaction(s.cfg, inv))
+	}
+	if len(txs) == 0 {
+		return nil
+	}
+	var resp struct {
+		Data struct {
+			TransactionIDs     []string `json:"transaction_ids"`
+			DuplicateImportIDs []string `json:"duplicate_import_ids"`
+		} `json:"data"`
+	}
+	header := http.Header{"Authorization": {"Bearer " + s.cfg.Token}}
+	u := s.cfg.APIURL + "/budgets/" + s.cfg.BudgetID + "/transactions"
+	if err := doJSON(ctx, s.client, http.MethodPost, u, header, map[string]any{"transactions": txs}, &resp); err != nil {
+		return fmt.Errorf("ynab: %w", err)
+	}
+	slog.Info("Created YNAB transactions", "count", len(resp.Data.TransactionIDs), "duplicates", len(resp.Data.DuplicateImportIDs))
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestYNABSink_Deliver(t *testing.T) {
+	var path string
+	var payload struct {
+		Transactions []ynabTransaction `json:"transactions"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"data":{"transaction_ids":["t1"],"duplicate_import_ids":[]}}`))
+	}))
+	defer srv.Close()
+
+	sink, err := newYNABSink(YNABConfig{Token: "tok", AccountID: "acc", CategoryID: "cat", APIURL: srv.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unknown := testProcessedInvoice()
+	unknown.Total = Amount{}
+	d := &Delivery{Invoices: []ProcessedInvoice{testProcessedInvoice(), unknown}}
+	if err := sink.Deliver(context.Background(), d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/budgets/last-used/transactions" {
+		t.Errorf("path = %q", path)
+	}
+	if len(payload.Transactions) != 1 {
+		t.Fatalf("got %d transactions, want 1", len(payload.Transactions))
+	}
+	want := ynabTransaction{
+		AccountID: "acc", Date: "2024-05-14", Amount: -9990, PayeeName: "Apple", CategoryID: "cat",
+		Memo: "Apple MXYZ123", Cleared: "cleared", ImportID: "apple:MXYZ123",
+	}
+	if payload.Transactions[0] != want {
+		t.Errorf("transaction = %+v, want %+v", payload.Transactions[0], want)
+	}
+}
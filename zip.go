@@ -0,0 +1,175 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// buildZIP packs the attachments into a single ZIP archive. With a password
+// every entry is encrypted with AES-256 (WinZip AE-2), which 7-Zip, WinZip,
+// Keka and libarchive can open; the legacy ZipCrypto scheme is not offered
+// since it is trivially broken.
+func buildZIP(attachments []PDFAttachment, password string, modified time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, att := range attachments {
+		data, err := att.content()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", att.Filename, err)
+		}
+		fh := &zip.FileHeader{Name: att.Filename, Method: zip.Deflate, Modified: modified}
+		if password == "" {
+			w, err := zw.CreateHeader(fh)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := w.Write(data); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := writeAESEntry(zw, fh, data, password); err != nil {
+			return nil, fmt.Errorf("encrypting %s: %w", att.Filename, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WinZip AES constants (https://www.winzip.com/en/support/aes-encryption/).
+const (
+	zipMethodAES     = 99
+	zipExtraAES      = 0x9901
+	zipAES256        = 3
+	zipAESSaltLen    = 16
+	zipAESKeyLen     = 32
+	zipAESAuthLen    = 10
+	zipAESIterations = 1000
+)
+
+// writeAESEntry deflates data, encrypts it and writes it as a raw entry.
+func writeAESEntry(zw *zip.Writer, fh *zip.FileHeader, data []byte, password string) error {
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return err
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+
+	salt := make([]byte, zipAESSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	encKey, authKey, verifier, err := deriveZIPKeys(password, salt)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := zipAESCTR(encKey, compressed.Bytes())
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha1.New, authKey)
+	mac.Write(ciphertext)
+
+	// AE-2 stores no CRC; integrity is covered by the authentication code
+	extra := binary.LittleEndian.AppendUint16(nil, zipExtraAES)
+	extra = binary.LittleEndian.AppendUint16(extra, 7)
+	extra = binary.LittleEndian.AppendUint16(extra, 2) // AE-2
+	extra = append(extra, 'A', 'E', zipAES256)
+	extra = binary.LittleEndian.AppendUint16(extra, zip.Deflate)
+
+	// CreateRaw writes the header as is, so fill in the MS-DOS timestamp
+	// that CreateHeader would derive from Modified
+	fh.ModifiedDate, fh.ModifiedTime = msDOSTime(fh.Modified)
+	fh.Method = zipMethodAES
+	fh.Flags |= 0x1 // encrypted
+	fh.Extra = extra
+	fh.CRC32 = 0
+	fh.UncompressedSize64 = uint64(len(data))
+	fh.CompressedSize64 = uint64(len(salt) + len(verifier) + len(ciphertext) + zipAESAuthLen)
+	w, err := zw.CreateRaw(fh)
+	if err != nil {
+		return err
+	}
+	for _, part := range [][]byte{salt, verifier, ciphertext, mac.Sum(nil)[:zipAESAuthLen]} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// msDOSTime converts t to the MS-DOS date and time fields of a ZIP header.
+func msDOSTime(t time.Time) (date, tm uint16) {
+	if t.IsZero() || t.Year() < 1980 {
+		return 0, 0
+	}
+	date = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	tm = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, tm
+}
+
+// deriveZIPKeys derives the encryption key, authentication key and the
+// two-byte password verifier from password and salt.
+func deriveZIPKeys(password string, salt []byte) (encKey, authKey, verifier []byte, err error) {
+	key, err := pbkdf2.Key(sha1.New, password, salt, zipAESIterations, 2*zipAESKeyLen+2)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return key[:zipAESKeyLen], key[zipAESKeyLen : 2*zipAESKeyLen], key[2*zipAESKeyLen:], nil
+}
+
+// zipAESCTR applies AES in WinZip's CTR variant, which uses a little-endian
+// block counter starting at 1 (unlike cipher.NewCTR). It both encrypts and
+// decrypts.
+func zipAESCTR(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	var counter, stream [aes.BlockSize]byte
+	for i := 0; i < len(data); i += aes.BlockSize {
+		for j := range counter {
+			counter[j]++
+			if counter[j] != 0 {
+				break
+			}
+		}
+		block.Encrypt(stream[:], counter[:])
+		for j := i; j < min(i+aes.BlockSize, len(data)); j++ {
+			out[j] = data[j] ^ stream[j-i]
+		}
+	}
+	return out, nil
+}
+
+// bundleAttachments replaces the attachments with a single ZIP named after
+// the month of the first invoice.
+func bundleAttachments(d *Delivery, password string, now time.Time) (PDFAttachment, error) {
+	date := now
+	if len(d.Invoices) > 0 {
+		date = d.Invoices[0].Email.Date
+	}
+	data, err := buildZIP(d.Attachments, password, now)
+	if err != nil {
+		return PDFAttachment{}, fmt.Errorf("building ZIP: %w", err)
+	}
+	return PDFAttachment{Filename: monthFilename(date, d.Invoices, ".zip"), Data: data}, nil
+}
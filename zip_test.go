@@ -0,0 +1,106 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBuildZIP_Plain(t *testing.T) {
+	attachments := []PDFAttachment{{Filename: "a.pdf", Data: []byte("%PDF-a")}, {Filename: "b.pdf", Data: []byte("%PDF-b")}}
+	data, err := buildZIP(attachments, "", time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("reading ZIP: %v", err)
+	}
+	if len(zr.File) != 2 {
+		t.Fatalf("got %d entries, want 2", len(zr.File))
+	}
+	for i, f := range zr.File {
+		rc, _ := f.Open()
+		got, _ := io.ReadAll(rc)
+		if f.Name != attachments[i].Filename || !bytes.Equal(got, attachments[i].Data) {
+			t.Errorf("entry %d = %s %q", i, f.Name, got)
+		}
+	}
+}
+
+func TestBuildZIP_Encrypted(t *testing.T) {
+	content := bytes.Repeat([]byte("%PDF-1.7 invoice "), 100)
+	data, err := buildZIP([]PDFAttachment{{Filename: "a.pdf", Data: content}}, "geheim", time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("reading ZIP: %v", err)
+	}
+	f := zr.File[0]
+	if f.Modified.IsZero() {
+		t.Error("modification time not set")
+	}
+	if f.Method != zipMethodAES || f.Flags&0x1 == 0 {
+		t.Fatalf("method/flags = %d/%#x", f.Method, f.Flags)
+	}
+	if binary.LittleEndian.Uint16(f.Extra) != zipExtraAES {
+		t.Fatalf("missing AES extra field: %x", f.Extra)
+	}
+	rc, _ := f.OpenRaw()
+	raw, _ := io.ReadAll(rc)
+
+	salt := raw[:zipAESSaltLen]
+	verifier := raw[zipAESSaltLen : zipAESSaltLen+2]
+	ciphertext := raw[zipAESSaltLen+2 : len(raw)-zipAESAuthLen]
+	authCode := raw[len(raw)-zipAESAuthLen:]
+
+	encKey, authKey, wantVerifier, _ := deriveZIPKeys("geheim", salt)
+	if !bytes.Equal(verifier, wantVerifier) {
+		t.Fatal("password verifier mismatch")
+	}
+	mac := hmac.New(sha1.New, authKey)
+	mac.Write(ciphertext)
+	if !bytes.Equal(authCode, mac.Sum(nil)[:zipAESAuthLen]) {
+		t.Fatal("authentication code mismatch")
+	}
+	compressed, _ := zipAESCTR(encKey, ciphertext)
+	got, err := io.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("decrypted content mismatch (err %v)", err)
+	}
+}
+
+func TestZipAESCTR_LittleEndianCounter(t *testing.T) {
+	key := make([]byte, zipAESKeyLen)
+	block, _ := aes.NewCipher(key)
+	stream, _ := zipAESCTR(key, make([]byte, 16*257))
+
+	// Block 257 uses counter 257, i.e. 01 01 00 ... little-endian
+	counter := make([]byte, 16)
+	counter[0], counter[1] = 1, 1
+	want := make([]byte, 16)
+	block.Encrypt(want, counter)
+	if !bytes.Equal(stream[16*256:], want) {
+		t.Error("keystream does not use a little-endian counter")
+	}
+}
+
+func TestBundleAttachments_Filename(t *testing.T) {
+	d := &Delivery{Invoices: []ProcessedInvoice{testProcessedInvoice()}, Attachments: []PDFAttachment{{Filename: "a.pdf", Data: []byte("x")}}}
+	att, err := bundleAttachments(d, "", time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if att.Filename != "05_2024_Rechnungen_Apple.zip" {
+		t.Errorf("filename = %q", att.Filename)
+	}
+}